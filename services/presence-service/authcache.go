@@ -0,0 +1,98 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+type authCacheEntry struct {
+	key        string
+	userID     string
+	resolvedAt time.Time
+}
+
+// authCache remembers which user a set of credentials resolved to, so
+// clients polling presence do not cost an identity round trip per request.
+// Keys are hashes of the credentials rather than the credentials themselves.
+// Entries expire after ttl and the least recently used one is evicted once
+// maxEntries is reached.
+type authCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	clock      Clock
+	order      *list.List
+	entries    map[string]*list.Element
+}
+
+func newAuthCache(ttl time.Duration, maxEntries int, clock Clock) *authCache {
+	return &authCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		clock:      clock,
+		order:      list.New(),
+		entries:    map[string]*list.Element{},
+	}
+}
+
+func authCacheKey(authHeader, cookieHeader string) string {
+	sum := sha256.Sum256([]byte(authHeader + "\x00" + cookieHeader))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *authCache) Get(authHeader, cookieHeader string) (string, bool) {
+	if c.ttl <= 0 {
+		return "", false
+	}
+
+	key := authCacheKey(authHeader, cookieHeader)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+
+	entry := element.Value.(*authCacheEntry)
+	if c.clock.Now().Sub(entry.resolvedAt) >= c.ttl {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		return "", false
+	}
+
+	c.order.MoveToFront(element)
+	return entry.userID, true
+}
+
+func (c *authCache) Put(authHeader, cookieHeader, userID string) {
+	if c.ttl <= 0 || c.maxEntries <= 0 {
+		return
+	}
+
+	key := authCacheKey(authHeader, cookieHeader)
+	now := c.clock.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*authCacheEntry)
+		entry.userID = userID
+		entry.resolvedAt = now
+		c.order.MoveToFront(element)
+		return
+	}
+
+	for c.order.Len() >= c.maxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*authCacheEntry).key)
+	}
+
+	c.entries[key] = c.order.PushFront(&authCacheEntry{key: key, userID: userID, resolvedAt: now})
+}
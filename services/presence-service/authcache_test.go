@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAuthCacheSkipsIdentityWithinTTL(t *testing.T) {
+	s, _ := newTestServer(t)
+	clock := s.clock.(*fakeClock)
+
+	var calls atomic.Int32
+	identity := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		_ = json.NewEncoder(w).Encode(meResponse{ID: "usr_1"})
+	}))
+	t.Cleanup(identity.Close)
+	s.identityServiceURL = identity.URL
+
+	for i := 0; i < 2; i++ {
+		if res := doRequest(t, s.handlePresenceMe, http.MethodGet, "/v1/presence/me", "token", ""); res.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d", i, res.Code)
+		}
+	}
+	if got := calls.Load(); got != 1 {
+		t.Fatalf("expected one identity call within the TTL, got %d", got)
+	}
+
+	clock.Advance(30 * time.Second)
+	doRequest(t, s.handlePresenceMe, http.MethodGet, "/v1/presence/me", "token", "")
+	if got := calls.Load(); got != 2 {
+		t.Fatalf("expected the entry to expire after the TTL, got %d calls", got)
+	}
+}
+
+func TestAuthCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newAuthCache(time.Minute, 2, newFakeClock())
+	cache.Put("Bearer a", "", "usr_a")
+	cache.Put("Bearer b", "", "usr_b")
+	if _, ok := cache.Get("Bearer a", ""); !ok {
+		t.Fatal("expected a to be cached")
+	}
+
+	cache.Put("Bearer c", "", "usr_c")
+	if _, ok := cache.Get("Bearer b", ""); ok {
+		t.Fatal("expected b to be evicted as least recently used")
+	}
+	if userID, ok := cache.Get("Bearer a", ""); !ok || userID != "usr_a" {
+		t.Fatalf("expected a to survive, got %q", userID)
+	}
+	if _, ok := cache.Get("Bearer a", "session=1"); ok {
+		t.Fatal("expected different cookies to resolve separately")
+	}
+}
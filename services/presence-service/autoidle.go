@@ -0,0 +1,60 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+)
+
+// applyAutoIdle turns the record's automatic online devices idle once none of
+// their updates arrived within after, and reports whether any did. Manual
+// statuses are left alone. The returned record is resolved at now; the
+// device's next update or heartbeat brings it back online.
+func applyAutoIdle(record presenceRecord, now time.Time, after time.Duration) (presenceRecord, bool) {
+	idled := false
+	devices := make(map[string]deviceRecord, len(record.Devices))
+	for id, device := range record.Devices {
+		if device.Status == StatusOnline && !device.Manual && !device.ExpiresAt.Before(now) && now.Sub(device.LastSeenAt) >= after {
+			device.Status, device.AutoIdle = StatusIdle, true
+			idled = true
+		}
+		devices[id] = device
+	}
+
+	record.Devices = devices
+	if idled {
+		record.Version++
+	}
+	return record.resolve(now), idled
+}
+
+func (s *memoryPresenceStore) AutoIdle(after time.Duration) ([]presenceChange, error) {
+	now := s.clock.Now().UTC()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var changes []presenceChange
+	for userID, stored := range s.records {
+		record, idled := applyAutoIdle(stored, now, after)
+		if !idled {
+			continue
+		}
+		s.records[userID] = record
+		if change := newPresenceChange(userID, stored.resolve(now), record, now); change.Visible || change.transition() {
+			changes = append(changes, change)
+		}
+	}
+
+	return changes, nil
+}
+
+// autoIdle idles users who have gone autoIdleAfter without an update,
+// publishes the ones other users would notice and reports the transitions.
+func (s *server) autoIdle() {
+	changes, err := s.store.AutoIdle(s.autoIdleAfter)
+	if err != nil {
+		slog.Error("presence auto-idle failed", "error", err)
+	}
+
+	s.settleChanges(changes)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestPresenceAutoIdle(t *testing.T) {
+	s, pub := newTestServer(t)
+	s.autoIdleAfter = 30 * time.Second
+	clock := s.clock.(*fakeClock)
+
+	doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{}`)
+	pub.take()
+
+	// A heartbeat before the threshold starts the wait over.
+	clock.Advance(20 * time.Second)
+	doRequest(t, s.handlePresenceHeartbeat, http.MethodPost, "/v1/presence/heartbeat", "usr_1", "")
+	clock.Advance(20 * time.Second)
+	s.autoIdle()
+	if state := mustGet(t, s.store, "usr_1"); state.Status != StatusOnline {
+		t.Fatalf("expected a recent heartbeat to keep the user online, got %s", state.Status)
+	}
+	if events := pub.take(); len(events) != 0 {
+		t.Fatalf("expected no publish before the threshold, got %+v", events)
+	}
+
+	clock.Advance(10 * time.Second)
+	s.autoIdle()
+	state := mustGet(t, s.store, "usr_1")
+	if state.Status != StatusIdle || state.Manual {
+		t.Fatalf("expected an automatic idle after the threshold, got %s (manual %v)", state.Status, state.Manual)
+	}
+	events := pub.take()
+	if len(events) != 1 || events[0].Payload.(PresenceState).Status != StatusIdle {
+		t.Fatalf("expected one idle publish, got %+v", events)
+	}
+
+	// Running again changes nothing, and the next heartbeat brings the user
+	// back.
+	s.autoIdle()
+	if events := pub.take(); len(events) != 0 {
+		t.Fatalf("expected no repeat publish, got %+v", events)
+	}
+	doRequest(t, s.handlePresenceHeartbeat, http.MethodPost, "/v1/presence/heartbeat", "usr_1", "")
+	if state := mustGet(t, s.store, "usr_1"); state.Status != StatusOnline {
+		t.Fatalf("expected a heartbeat to undo the auto-idle, got %s", state.Status)
+	}
+	if events := pub.take(); len(events) != 1 {
+		t.Fatalf("expected the return online to publish, got %d", len(events))
+	}
+}
+
+func TestPresenceAutoIdleSkipsManualStatus(t *testing.T) {
+	s, pub := newTestServer(t)
+	s.autoIdleAfter = 30 * time.Second
+	clock := s.clock.(*fakeClock)
+
+	doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"status":"dnd"}`)
+	doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_2", `{"status":"online"}`)
+	pub.take()
+
+	clock.Advance(45 * time.Second)
+	s.autoIdle()
+	for _, userID := range []string{"usr_1", "usr_2"} {
+		state := mustGet(t, s.store, userID)
+		if !state.Manual || state.Status == StatusIdle {
+			t.Fatalf("expected %s to keep the manual status, got %s (manual %v)", userID, state.Status, state.Manual)
+		}
+	}
+	if events := pub.take(); len(events) != 0 {
+		t.Fatalf("expected no publish for manual statuses, got %+v", events)
+	}
+}
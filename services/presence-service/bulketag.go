@@ -0,0 +1,33 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+)
+
+// bulkETag identifies a bulk response by each user's record version and the
+// status the viewer gets. The status covers what changes without a write:
+// a record expiring, or a friends-only user being hidden.
+func bulkETag(states []PresenceState) string {
+	hash := sha256.New()
+	for _, state := range states {
+		hash.Write([]byte(state.UserID + "\x00" + strconv.FormatInt(state.Version, 10) + "\x00" + string(state.Status) + "\n"))
+	}
+
+	return `"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header names etag. Weak
+// validators compare equal to strong ones, as RFC 9110 asks for here.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
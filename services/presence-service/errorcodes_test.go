@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// unavailableStore fails every read, as a store that lost its backend would.
+type unavailableStore struct {
+	PresenceStore
+}
+
+func (unavailableStore) Get(string) (PresenceState, error) {
+	return PresenceState{}, errors.New("connection refused")
+}
+
+func checkErrorCode(t *testing.T, res *httptest.ResponseRecorder, status int, code string) {
+	t.Helper()
+
+	var body struct {
+		Code  string `json:"code"`
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(res.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode error body %q: %v", res.Body.String(), err)
+	}
+	if res.Code != status || body.Code != code || body.Error == "" {
+		t.Fatalf("expected %d %s with a message, got %d %s", status, code, res.Code, res.Body.String())
+	}
+}
+
+func TestPresenceErrorCodes(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	checkErrorCode(t, doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "", `{}`), http.StatusUnauthorized, "UNAUTHORIZED")
+	checkErrorCode(t, doRequest(t, s.handlePresence, http.MethodDelete, "/v1/presence", "usr_1", ""), http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED")
+	checkErrorCode(t, doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"status":"away"}`), http.StatusBadRequest, "INVALID_REQUEST")
+	checkErrorCode(t, doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"status":`), http.StatusBadRequest, "INVALID_REQUEST")
+	checkErrorCode(t, doRequestWithHeaders(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{}`, map[string]string{"Content-Type": "text/plain"}), http.StatusUnsupportedMediaType, "UNSUPPORTED_MEDIA_TYPE")
+	checkErrorCode(t, doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"customText":"`+strings.Repeat("a", 2<<20)+`"}`), http.StatusRequestEntityTooLarge, "PAYLOAD_TOO_LARGE")
+
+	ids := make([]string, s.bulkMax+1)
+	for i := range ids {
+		ids[i] = fmt.Sprintf(`"usr_%d"`, i)
+	}
+	checkErrorCode(t, bulkLookup(t, s, `{"userIds":[`+strings.Join(ids, ",")+`]}`, ""), http.StatusBadRequest, codePresenceBulkTooLarge)
+
+	for i := 0; i < 5; i++ {
+		doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_2", `{"status":"`+[]string{"dnd", "online"}[i%2]+`"}`)
+	}
+	checkErrorCode(t, doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_2", `{"status":"idle"}`), http.StatusTooManyRequests, codePresenceRateLimited)
+
+	s.store = unavailableStore{s.store}
+	checkErrorCode(t, doRequest(t, s.handlePresenceByUserID, http.MethodGet, "/v1/presence/usr_3", "usr_1", ""), http.StatusServiceUnavailable, codePresenceStoreUnavailable)
+
+	s.watchers = newPresenceWatchers(0)
+	checkErrorCode(t, doRequest(t, s.handlePresenceByUserID, http.MethodGet, "/v1/presence/usr_3/watch", "usr_1", ""), http.StatusServiceUnavailable, codePresenceTooManyWatches)
+}
+
+func TestPresenceIdentityErrorCodes(t *testing.T) {
+	s, _ := newTestServer(t)
+	identity := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(identity.Close)
+	s.identityServiceURL = identity.URL
+
+	checkErrorCode(t, doRequest(t, s.handlePresenceMe, http.MethodGet, "/v1/presence/me", "usr_1", ""), http.StatusServiceUnavailable, codePresenceIdentityUnavailable)
+}
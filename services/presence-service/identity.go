@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	errIdentityUnauthorized = errors.New("Unauthorized.")
+	errIdentityUnavailable  = errors.New("Identity service unavailable.")
+)
+
+// errIdentityTransient marks an identity-service failure worth one retry:
+// the request never got an answer, or a gateway in front of the service
+// reported it down.
+var errIdentityTransient = errors.New("identity service transient failure")
+
+// lookupIdentity asks identity-service who the credentials belong to. A
+// transient failure is retried once after a jittered backoff, so one dropped
+// connection doesn't surface as a 503; a rejection is final and returned as
+// 401 straight away.
+func (s *server) lookupIdentity(ctx context.Context, authHeader, cookieHeader string) (string, int, error) {
+	userID, err := s.fetchIdentity(ctx, authHeader, cookieHeader)
+	if errors.Is(err, errIdentityTransient) && ctx.Err() == nil {
+		// Somewhere in [backoff/2, backoff*3/2), so instances that lost
+		// the same connection don't all retry at once.
+		wait := s.identityBackoff / 2
+		if s.identityBackoff > 0 {
+			wait += time.Duration(rand.Int64N(int64(s.identityBackoff)))
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+		case <-timer.C:
+			userID, err = s.fetchIdentity(ctx, authHeader, cookieHeader)
+		}
+	}
+
+	switch {
+	case err == nil:
+		return userID, http.StatusOK, nil
+	case errors.Is(err, errIdentityUnauthorized):
+		return "", http.StatusUnauthorized, errIdentityUnauthorized
+	default:
+		return "", http.StatusServiceUnavailable, errIdentityUnavailable
+	}
+}
+
+// fetchIdentity makes a single /v1/me call. 401 and 403 are rejections;
+// anything else that isn't a user is the service misbehaving.
+func (s *server) fetchIdentity(ctx context.Context, authHeader, cookieHeader string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.identityServiceURL+"/v1/me", nil)
+	if err != nil {
+		return "", err
+	}
+
+	if authHeader != "" {
+		req.Header.Set("Authorization", authHeader)
+	}
+	if cookieHeader != "" {
+		req.Header.Set("Cookie", cookieHeader)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errIdentityTransient, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return "", errIdentityUnauthorized
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return "", fmt.Errorf("%w: status %d", errIdentityTransient, resp.StatusCode)
+	default:
+		return "", fmt.Errorf("identity service returned %d", resp.StatusCode)
+	}
+
+	var me meResponse
+	if err := json.NewDecoder(resp.Body).Decode(&me); err != nil {
+		return "", fmt.Errorf("decode identity response: %w", err)
+	}
+
+	userID := strings.TrimSpace(me.ID)
+	if userID == "" {
+		return "", errIdentityUnauthorized
+	}
+
+	return userID, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newFlakyIdentity serves /v1/me, letting respond decide each call by its
+// 1-based attempt number.
+func newFlakyIdentity(t *testing.T, respond func(attempt int32, w http.ResponseWriter) bool) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+
+	var attempts atomic.Int32
+	identity := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if respond(attempts.Add(1), w) {
+			return
+		}
+		_ = json.NewEncoder(w).Encode(meResponse{ID: "usr_1"})
+	}))
+	t.Cleanup(identity.Close)
+
+	return identity, &attempts
+}
+
+func authenticateWith(t *testing.T, identityURL string) (string, int, error) {
+	t.Helper()
+
+	s, _ := newTestServer(t)
+	s.identityServiceURL = identityURL
+	s.identityBackoff = time.Millisecond
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/presence/me", nil)
+	req.Header.Set("Authorization", "Bearer usr_1")
+	return s.authenticate(req)
+}
+
+func TestIdentityRetriesTransientFailures(t *testing.T) {
+	// A dropped connection, then a gateway reporting the service down: each
+	// gets one retry.
+	dropped, attempts := newFlakyIdentity(t, func(attempt int32, w http.ResponseWriter) bool {
+		if attempt > 1 {
+			return false
+		}
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			_ = conn.Close()
+		}
+		return true
+	})
+	if userID, status, err := authenticateWith(t, dropped.URL); err != nil || userID != "usr_1" {
+		t.Fatalf("expected the retry to succeed, got %q %d %v", userID, status, err)
+	}
+	if got := attempts.Load(); got != 2 {
+		t.Fatalf("expected 2 attempts, got %d", got)
+	}
+
+	down, attempts := newFlakyIdentity(t, func(attempt int32, w http.ResponseWriter) bool {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return true
+	})
+	if _, status, err := authenticateWith(t, down.URL); status != http.StatusServiceUnavailable || err == nil {
+		t.Fatalf("expected 503 once the retry fails too, got %d %v", status, err)
+	}
+	if got := attempts.Load(); got != 2 {
+		t.Fatalf("expected exactly one retry, got %d attempts", got)
+	}
+}
+
+func TestIdentityDoesNotRetryRejections(t *testing.T) {
+	rejecting, attempts := newFlakyIdentity(t, func(attempt int32, w http.ResponseWriter) bool {
+		w.WriteHeader(http.StatusUnauthorized)
+		return true
+	})
+	if _, status, err := authenticateWith(t, rejecting.URL); status != http.StatusUnauthorized || err == nil {
+		t.Fatalf("expected 401, got %d %v", status, err)
+	}
+	if got := attempts.Load(); got != 1 {
+		t.Fatalf("expected a 401 not to be retried, got %d attempts", got)
+	}
+
+	// A broken service is unavailable, not a reason to log the user out.
+	broken, _ := newFlakyIdentity(t, func(attempt int32, w http.ResponseWriter) bool {
+		w.WriteHeader(http.StatusInternalServerError)
+		return true
+	})
+	if _, status, _ := authenticateWith(t, broken.URL); status != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 for a 500, got %d", status)
+	}
+}
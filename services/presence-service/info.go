@@ -0,0 +1,92 @@
+package main
+
+import "net/http"
+
+// infoConfig is the effective configuration, after defaults and clamping.
+// Credentials are left out: the gateway's internal key, the JWT secret, the
+// webhook secret and the Redis URL, which may carry a password. The friends
+// check and webhook URLs are reduced to whether they are set, as either may
+// carry a token.
+type infoConfig struct {
+	Port                     string `json:"port"`
+	Store                    string `json:"store"`
+	IdentityServiceURL       string `json:"identityServiceUrl"`
+	TTLSeconds               int64  `json:"ttlSeconds"`
+	TTLMinSeconds            int64  `json:"ttlMinSeconds"`
+	TTLMaxSeconds            int64  `json:"ttlMaxSeconds"`
+	LastOnlineRetentionHours int64  `json:"lastOnlineRetentionHours"`
+	AutoIdleSeconds          int64  `json:"autoIdleSeconds"`
+	BulkMax                  int    `json:"bulkMax"`
+	RateLimitBurst           int    `json:"rateLimitBurst"`
+	RateLimitWindowSeconds   int64  `json:"rateLimitWindowSeconds"`
+	TypingDedupeWindowMs     int64  `json:"typingDedupeWindowMs"`
+	AuthCacheTTLSeconds      int64  `json:"authCacheTtlSeconds"`
+	AuthCacheSize            int    `json:"authCacheSize"`
+	IdentityBackoffMs        int64  `json:"identityBackoffMs"`
+	MaxBodyBytes             int64  `json:"maxBodyBytes"`
+	MaxInFlight              int    `json:"maxInFlight"`
+	RequestTimeoutMs         int64  `json:"requestTimeoutMs"`
+	WatchTimeoutSeconds      int64  `json:"watchTimeoutSeconds"`
+	MaxWatches               int    `json:"maxWatches"`
+	JWTAuth                  bool   `json:"jwtAuth"`
+	FriendsCheck             bool   `json:"friendsCheck"`
+	Webhook                  bool   `json:"webhook"`
+}
+
+func (s *server) effectiveConfig() infoConfig {
+	config := infoConfig{
+		Port:                   s.port,
+		IdentityServiceURL:     s.identityServiceURL,
+		TTLMinSeconds:          int64(s.ttlMin.Seconds()),
+		TTLMaxSeconds:          int64(s.ttlMax.Seconds()),
+		AutoIdleSeconds:        int64(s.autoIdleAfter.Seconds()),
+		BulkMax:                s.bulkMax,
+		RateLimitBurst:         int(s.limiter.burst),
+		RateLimitWindowSeconds: int64(s.limiter.window.Seconds()),
+		TypingDedupeWindowMs:   s.typing.window.Milliseconds(),
+		AuthCacheTTLSeconds:    int64(s.authCache.ttl.Seconds()),
+		AuthCacheSize:          s.authCache.maxEntries,
+		IdentityBackoffMs:      s.identityBackoff.Milliseconds(),
+		MaxBodyBytes:           s.maxBodyBytes,
+		MaxInFlight:            s.maxInFlight,
+		RequestTimeoutMs:       s.requestTimeout.Milliseconds(),
+		WatchTimeoutSeconds:    int64(s.watchTimeout.Seconds()),
+		MaxWatches:             s.watchers.limit,
+		JWTAuth:                s.jwtVerifier != nil,
+		FriendsCheck:           s.friends != nil,
+		Webhook:                s.webhook != nil,
+	}
+
+	switch store := s.store.(type) {
+	case *memoryPresenceStore:
+		config.Store = "memory"
+		config.TTLSeconds = int64(store.ttl.Seconds())
+		config.LastOnlineRetentionHours = int64(store.lastOnlineRetention.Hours())
+	case *redisPresenceStore:
+		config.Store = "redis"
+		config.TTLSeconds = int64(store.ttl.Seconds())
+		config.LastOnlineRetentionHours = int64(store.lastOnlineRetention.Hours())
+	}
+
+	return config
+}
+
+// handleInfo serves GET /info: the build the instance runs and its
+// effective configuration, for matching behavior to a deploy. Like /health
+// it doesn't touch the store.
+func (s *server) handleInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed.")
+		return
+	}
+
+	s.respondJSON(w, http.StatusOK, struct {
+		Service string `json:"service"`
+		buildInfo
+		Config infoConfig `json:"config"`
+	}{
+		Service:   "presence-service",
+		buildInfo: currentBuildInfo(),
+		Config:    s.effectiveConfig(),
+	})
+}
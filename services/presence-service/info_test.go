@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPresenceInfo(t *testing.T) {
+	s, _ := newTestServer(t)
+	s.port = "4002"
+	s.webhook = newPresenceWebhook("https://audit.test/hook?token=webhook-t0ken", "webhook-s3cret", time.Second)
+	s.friends = newHTTPFriendsChecker("https://friends.test/check?token=friends-t0ken", time.Second)
+	verifier, err := newJWTVerifier("jwt-s3cret", "", s.clock)
+	if err != nil {
+		t.Fatalf("verifier: %v", err)
+	}
+	s.jwtVerifier = verifier
+
+	res := doRequest(t, s.handleInfo, http.MethodGet, "/info", "", "")
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", res.Code, res.Body.String())
+	}
+	for _, secret := range []string{"webhook-t0ken", "webhook-s3cret", "friends-t0ken", "jwt-s3cret"} {
+		if strings.Contains(res.Body.String(), secret) {
+			t.Fatalf("expected %s to be left out of %s", secret, res.Body.String())
+		}
+	}
+
+	var info struct {
+		Service string `json:"service"`
+		buildInfo
+		Config infoConfig `json:"config"`
+	}
+	if err := json.Unmarshal(res.Body.Bytes(), &info); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if info.Service != "presence-service" || info.Version == "" || info.GoVersion == "" || info.Dependencies == nil {
+		t.Fatalf("expected the build info, got %+v", info.buildInfo)
+	}
+	for _, field := range []string{`"gitCommit"`, `"buildTime"`} {
+		if !strings.Contains(res.Body.String(), field) {
+			t.Fatalf("expected %s in %s", field, res.Body.String())
+		}
+	}
+	config := info.Config
+	if config.Port != "4002" || config.Store != "memory" || config.TTLSeconds != int64(testPresenceTTL.Seconds()) || config.BulkMax != 100 || config.RateLimitBurst != 5 {
+		t.Fatalf("unexpected config %+v", config)
+	}
+	if !config.JWTAuth || !config.FriendsCheck || !config.Webhook {
+		t.Fatalf("expected the optional integrations to read as set, got %+v", config)
+	}
+}
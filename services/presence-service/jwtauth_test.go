@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// newJWTTestServer returns a server that verifies JWTs signed with secret and
+// an identity service that resolves every token to usr_identity.
+func newJWTTestServer(t *testing.T, secret string) (*server, *atomic.Int32) {
+	t.Helper()
+
+	s, _ := newTestServer(t)
+	verifier, err := newJWTVerifier(secret, "", s.clock)
+	if err != nil {
+		t.Fatalf("newJWTVerifier: %v", err)
+	}
+	s.jwtVerifier = verifier
+
+	var calls atomic.Int32
+	identity := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		_ = json.NewEncoder(w).Encode(meResponse{ID: "usr_identity"})
+	}))
+	t.Cleanup(identity.Close)
+	s.identityServiceURL = identity.URL
+
+	return s, &calls
+}
+
+func signTestJWT(t *testing.T, secret, subject string, expiresAt time.Time) string {
+	t.Helper()
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Subject:   subject,
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+	}).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	return token
+}
+
+func meUserID(t *testing.T, res *httptest.ResponseRecorder) string {
+	t.Helper()
+
+	var state PresenceState
+	if err := json.Unmarshal(res.Body.Bytes(), &state); err != nil {
+		t.Fatalf("decode: %v (%s)", err, res.Body.String())
+	}
+	return state.UserID
+}
+
+func TestJWTVerifiedLocally(t *testing.T) {
+	s, calls := newJWTTestServer(t, "secret")
+	token := signTestJWT(t, "secret", "usr_jwt", s.clock.Now().Add(time.Hour))
+
+	res := doRequest(t, s.handlePresenceMe, http.MethodGet, "/v1/presence/me", token, "")
+	if res.Code != http.StatusOK || meUserID(t, res) != "usr_jwt" {
+		t.Fatalf("expected the token's subject, got %d %s", res.Code, res.Body.String())
+	}
+
+	res = doRequestWithHeaders(t, s.handlePresenceMe, http.MethodGet, "/v1/presence/me", "", "", map[string]string{
+		"Cookie": "theme=dark; " + sessionCookieName + "=" + token,
+	})
+	if res.Code != http.StatusOK || meUserID(t, res) != "usr_jwt" {
+		t.Fatalf("expected the session cookie to verify too, got %d %s", res.Code, res.Body.String())
+	}
+
+	if got := calls.Load(); got != 0 {
+		t.Fatalf("expected no identity calls, got %d", got)
+	}
+}
+
+func TestJWTUnverifiableFallsBackToIdentity(t *testing.T) {
+	s, calls := newJWTTestServer(t, "secret")
+
+	for _, token := range []string{
+		signTestJWT(t, "other-secret", "usr_forged", s.clock.Now().Add(time.Hour)),
+		"opaque-session-token",
+	} {
+		res := doRequest(t, s.handlePresenceMe, http.MethodGet, "/v1/presence/me", token, "")
+		if res.Code != http.StatusOK || meUserID(t, res) != "usr_identity" {
+			t.Fatalf("expected identity to decide for %q, got %d %s", token, res.Code, res.Body.String())
+		}
+	}
+
+	if got := calls.Load(); got != 2 {
+		t.Fatalf("expected both tokens to reach identity, got %d calls", got)
+	}
+}
+
+func TestJWTExpiredIsRejected(t *testing.T) {
+	s, calls := newJWTTestServer(t, "secret")
+	token := signTestJWT(t, "secret", "usr_jwt", s.clock.Now().Add(time.Minute))
+
+	s.clock.(*fakeClock).Advance(2 * time.Minute)
+	if res := doRequest(t, s.handlePresenceMe, http.MethodGet, "/v1/presence/me", token, ""); res.Code != http.StatusUnauthorized {
+		t.Fatalf("expected an expired token to be rejected, got %d", res.Code)
+	}
+	if got := calls.Load(); got != 0 {
+		t.Fatalf("expected no identity fallback for an expired token, got %d calls", got)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestLoggingEchoesRequestID(t *testing.T) {
+	var out bytes.Buffer
+	handler := withRequestLogging(newLogger(&out, "presence-service"), http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/health", nil)
+	req.Header.Set("X-Request-Id", "req_from_caller")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if got := rec.Header().Get("X-Request-Id"); got != "req_from_caller" {
+		t.Fatalf("expected the caller's request id to be echoed, got %q", got)
+	}
+
+	var line map[string]any
+	if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+		t.Fatalf("expected a JSON log line, got %q: %v", out.String(), err)
+	}
+	for key, want := range map[string]any{
+		"service":   "presence-service",
+		"level":     "INFO",
+		"msg":       "request",
+		"method":    "GET",
+		"path":      "/health",
+		"status":    float64(http.StatusTeapot),
+		"requestId": "req_from_caller",
+	} {
+		if line[key] != want {
+			t.Fatalf("expected %s=%v, got %v in %s", key, want, line[key], out.String())
+		}
+	}
+	if _, ok := line["durationMs"]; !ok {
+		t.Fatalf("expected durationMs in %s", out.String())
+	}
+
+	out.Reset()
+	req = httptest.NewRequest(http.MethodGet, "/health", nil)
+	req.Header.Set("X-Request-Id", "has spaces")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if got := rec.Header().Get("X-Request-Id"); !strings.HasPrefix(got, "req_") || got == "has spaces" {
+		t.Fatalf("expected a generated request id, got %q", got)
+	}
+}
@@ -2,16 +2,29 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"math"
+	"mime"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
+	"unicode/utf8"
+
+	"github.com/golang-jwt/jwt/v5"
 )
 
 type PresenceStatus string
@@ -21,103 +34,559 @@ const (
 	StatusIdle    PresenceStatus = "idle"
 	StatusDnd     PresenceStatus = "dnd"
 	StatusOffline PresenceStatus = "offline"
+	// StatusInvisible is stored for the user but reported to everyone else
+	// as offline.
+	StatusInvisible PresenceStatus = "invisible"
+)
+
+const maxCustomTextRunes = 128
+
+type ActivityType string
+
+const (
+	ActivityPlaying   ActivityType = "playing"
+	ActivityListening ActivityType = "listening"
+	ActivityWatching  ActivityType = "watching"
+	ActivityCustom    ActivityType = "custom"
 )
 
+const maxActivityFieldRunes = 128
+
+type Platform string
+
+const (
+	PlatformDesktop Platform = "desktop"
+	PlatformMobile  Platform = "mobile"
+	PlatformWeb     Platform = "web"
+)
+
+const maxDeviceIDRunes = 128
+
+type PresenceActivity struct {
+	Type      ActivityType `json:"type"`
+	Name      string       `json:"name"`
+	Details   *string      `json:"details"`
+	StartedAt string       `json:"startedAt"`
+}
+
 type PresenceState struct {
-	UserID     string         `json:"userId"`
-	Status     PresenceStatus `json:"status"`
-	LastSeenAt string         `json:"lastSeenAt"`
-	ExpiresAt  *string        `json:"expiresAt"`
+	UserID     string            `json:"userId"`
+	Status     PresenceStatus    `json:"status"`
+	LastSeenAt string            `json:"lastSeenAt"`
+	ExpiresAt  *string           `json:"expiresAt"`
+	CustomText *string           `json:"customText"`
+	Activity   *PresenceActivity `json:"activity"`
+	Platforms  []Platform        `json:"platforms"`
+	// Manual reports that the status was chosen explicitly, so activity
+	// from any device will not change it.
+	Manual bool `json:"manual"`
+	// Until is when a scheduled manual status ends, nil when it has none.
+	Until *string `json:"until"`
+	// LastOnlineAt is only reported for offline users: the last time anyone
+	// could see them online, kept long after the record itself is gone.
+	LastOnlineAt *string `json:"lastOnlineAt"`
+	// Visibility is only reported in the user's own view.
+	Visibility PresenceVisibility `json:"visibility,omitempty"`
+	// Version is the record's version, zero when it is hidden from the
+	// viewer. Bulk lookups build their ETag from it.
+	Version int64 `json:"-"`
 }
 
 type updatePresenceRequest struct {
-	Status *string `json:"status"`
+	Status     *string         `json:"status"`
+	CustomText *string         `json:"customText"`
+	Activity   json.RawMessage `json:"activity"`
+	Platform   *string         `json:"platform"`
+	Visibility *string         `json:"visibility"`
+	Until      *string         `json:"until"`
+}
+
+type activityRequest struct {
+	Type      string  `json:"type"`
+	Name      string  `json:"name"`
+	Details   *string `json:"details"`
+	StartedAt *string `json:"startedAt"`
+}
+
+// presenceUpdate is a validated PUT /v1/presence body. Nil optional fields
+// leave the stored value untouched.
+type presenceUpdate struct {
+	Status     PresenceStatus
+	CustomText *string
+	// SetActivity distinguishes "leave the activity alone" from clearing it
+	// with a nil Activity.
+	SetActivity bool
+	Activity    *activityRecord
+	Platform    Platform
+	DeviceID    string
+	// KeepStatus makes the update a heartbeat: the device keeps its stored
+	// status, falling back to the user's current one and then to Status.
+	KeepStatus bool
+	// Manual marks Status as explicitly chosen. Without it the update is
+	// automatic and never replaces a manual status.
+	Manual bool
+	// Until schedules the end of a manual Status; zero keeps it until it is
+	// changed.
+	Until time.Time
+	// TTL overrides the store's TTL for this device, here and on its later
+	// updates. Zero keeps the device's previous override, if any.
+	TTL time.Duration
+	// Visibility replaces the user's visibility; empty keeps it.
+	Visibility PresenceVisibility
+}
+
+func (u presenceUpdate) device() string {
+	if u.DeviceID == "" {
+		return defaultDeviceID
+	}
+
+	return u.DeviceID
 }
 
+type typingRequest struct {
+	ChannelID string `json:"channelId"`
+}
+
+// TypingIndicator mirrors the messaging-service payload so clients handle
+// typing.updated the same way whichever service published it.
+type TypingIndicator struct {
+	ConversationID string  `json:"conversationId"`
+	DirectThreadID *string `json:"directThreadId"`
+	UserID         string  `json:"userId"`
+	IsTyping       bool    `json:"isTyping"`
+	ExpiresAt      string  `json:"expiresAt"`
+}
+
+const (
+	typingIndicatorTTL = 5 * time.Second
+	typingDedupeWindow = 2 * time.Second
+)
+
 type bulkPresenceRequest struct {
 	UserIDs []string `json:"userIds"`
 }
 
+// bulkUserIDBytes is the encoded size budgeted per entry when capping bulk
+// request bodies; it comfortably covers a quoted user id and its separator.
+const bulkUserIDBytes = 128
+
+func bulkBodyLimit(bulkMax int) int64 {
+	return int64(bulkMax)*bulkUserIDBytes + 1024
+}
+
 type meResponse struct {
 	ID string `json:"id"`
 }
 
+// presenceRecord is a user's presence across all of their devices. Status,
+// Manual, Until, RevertTo and ExpiresAt are derived from Devices; call
+// resolve before reading the first four.
 type presenceRecord struct {
 	Status     PresenceStatus
+	Manual     bool
+	Until      time.Time
+	RevertTo   PresenceStatus
+	CustomText string
+	Activity   *activityRecord
+	Devices    map[string]deviceRecord
 	LastSeenAt time.Time
 	ExpiresAt  time.Time
+	// LastVisibleAt is the last update made while not invisible; other users
+	// see it as lastSeenAt so invisible heartbeats stay hidden.
+	LastVisibleAt time.Time
+	// Visibility is who may see the real status. It is kept when the record
+	// expires, but not once CleanupExpired drops it.
+	Visibility PresenceVisibility
+	// Version goes up by one with every write. It survives expiry but starts
+	// over once the record is dropped.
+	Version int64
 }
 
-type presenceStore struct {
-	mu      sync.RWMutex
-	records map[string]presenceRecord
-	ttl     time.Duration
+// deviceRecord is one connection's presence, keyed by X-Device-Id. Each
+// device expires on its own so a closed laptop doesn't take a phone offline.
+type deviceRecord struct {
+	Status PresenceStatus
+	// Manual is set when Status was chosen through PUT /v1/presence rather
+	// than defaulted by a heartbeat or a status-less update.
+	Manual     bool
+	Platform   Platform
+	LastSeenAt time.Time
+	ExpiresAt  time.Time
+	// TTL is the client's X-Presence-TTL-Seconds, zero when it never sent
+	// one and the store's TTL applies.
+	TTL time.Duration
+	// AutoIdle is set when AutoIdle, rather than the client, made the device
+	// idle; its next update of any kind makes it online again.
+	AutoIdle bool
+	// Until ends a manual Status sent with an until; zero means it holds
+	// until changed. RevertTo is the manual status to go back to then,
+	// empty for automatic online.
+	Until    time.Time
+	RevertTo PresenceStatus
 }
 
-func newPresenceStore(ttl time.Duration) *presenceStore {
-	return &presenceStore{
-		records: map[string]presenceRecord{},
-		ttl:     ttl,
+const defaultDeviceID = "default"
+
+type activityRecord struct {
+	Type      ActivityType
+	Name      string
+	Details   string
+	StartedAt time.Time
+}
+
+func (a *activityRecord) state() *PresenceActivity {
+	if a == nil {
+		return nil
+	}
+
+	return &PresenceActivity{
+		Type:      a.Type,
+		Name:      a.Name,
+		Details:   copyOptionalText(a.Details),
+		StartedAt: a.StartedAt.UTC().Format(time.RFC3339),
 	}
 }
 
-func (s *presenceStore) Upsert(userID string, status PresenceStatus) PresenceState {
-	now := time.Now().UTC()
-	expiresAt := now.Add(s.ttl)
+func sameActivity(a, b *activityRecord) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
 
-	s.mu.Lock()
-	s.records[userID] = presenceRecord{
-		Status:     status,
-		LastSeenAt: now,
-		ExpiresAt:  expiresAt,
+	return *a == *b
+}
+
+// statusRank orders device statuses when merging: online > idle > dnd. An
+// invisible device outranks everything so another device can't reveal a user
+// who chose to hide.
+func statusRank(status PresenceStatus) int {
+	switch status {
+	case StatusInvisible:
+		return 4
+	case StatusOnline:
+		return 3
+	case StatusIdle:
+		return 2
+	case StatusDnd:
+		return 1
+	default:
+		return 0
 	}
-	s.mu.Unlock()
+}
+
+// resolve derives the effective status from the devices still live at now.
+// A manually chosen status takes priority over automatic ones, so an online
+// heartbeat from one device can't override dnd picked on another.
+func (r presenceRecord) resolve(now time.Time) presenceRecord {
+	r.Status = StatusOffline
+	r.Manual = false
+	r.Until, r.RevertTo = time.Time{}, ""
+	for _, device := range r.Devices {
+		if device.ExpiresAt.Before(now) {
+			continue
+		}
+		if r.Status != StatusOffline && device.Manual != r.Manual {
+			if device.Manual {
+				r.Status, r.Manual = device.Status, true
+				r.Until, r.RevertTo = device.Until, device.RevertTo
+			}
+			continue
+		}
+		if statusRank(device.Status) > statusRank(r.Status) {
+			r.Status, r.Manual = device.Status, device.Manual
+			r.Until, r.RevertTo = device.Until, device.RevertTo
+		}
+	}
+
+	return r
+}
 
-	expires := expiresAt.Format(time.RFC3339)
+func (r presenceRecord) state(userID string, now time.Time) PresenceState {
+	expires := r.ExpiresAt.UTC().Format(time.RFC3339)
+	var until *string
+	if !r.Until.IsZero() {
+		formatted := r.Until.UTC().Format(time.RFC3339)
+		until = &formatted
+	}
 	return PresenceState{
 		UserID:     userID,
-		Status:     status,
-		LastSeenAt: now.Format(time.RFC3339),
+		Status:     r.Status,
+		LastSeenAt: r.LastSeenAt.UTC().Format(time.RFC3339),
 		ExpiresAt:  &expires,
+		CustomText: copyOptionalText(r.CustomText),
+		Activity:   r.Activity.state(),
+		Platforms:  r.activePlatforms(now),
+		Manual:     r.Manual,
+		Until:      until,
+		Visibility: r.Visibility,
+		Version:    r.Version,
 	}
 }
 
-func (s *presenceStore) Get(userID string) PresenceState {
-	s.mu.RLock()
-	record, ok := s.records[userID]
-	s.mu.RUnlock()
-	if !ok {
-		now := time.Now().UTC()
-		return PresenceState{
-			UserID:     userID,
-			Status:     StatusOffline,
-			LastSeenAt: now.Format(time.RFC3339),
-			ExpiresAt:  nil,
+func (r presenceRecord) activePlatforms(now time.Time) []Platform {
+	platforms := make([]Platform, 0, len(r.Devices))
+	for _, device := range r.Devices {
+		if device.Platform == "" || device.ExpiresAt.Before(now) || slices.Contains(platforms, device.Platform) {
+			continue
 		}
+		platforms = append(platforms, device.Platform)
 	}
 
-	now := time.Now().UTC()
-	if record.ExpiresAt.Before(now) {
-		return PresenceState{
-			UserID:     userID,
-			Status:     StatusOffline,
-			LastSeenAt: record.LastSeenAt.UTC().Format(time.RFC3339),
-			ExpiresAt:  nil,
-		}
+	slices.Sort(platforms)
+	return platforms
+}
+
+// visible is the resolved record as other users see it: invisible collapses
+// to a bare offline record.
+func (r presenceRecord) visible() presenceRecord {
+	if r.Status != StatusInvisible {
+		return r
 	}
 
-	expires := record.ExpiresAt.UTC().Format(time.RFC3339)
-	return PresenceState{
+	return presenceRecord{
+		Status:        StatusOffline,
+		LastSeenAt:    r.LastVisibleAt,
+		LastVisibleAt: r.LastVisibleAt,
+		Visibility:    r.Visibility,
+	}
+}
+
+func offlineState(userID string, lastSeenAt, lastOnlineAt time.Time) PresenceState {
+	state := PresenceState{
 		UserID:     userID,
-		Status:     record.Status,
-		LastSeenAt: record.LastSeenAt.UTC().Format(time.RFC3339),
-		ExpiresAt:  &expires,
+		Status:     StatusOffline,
+		LastSeenAt: lastSeenAt.UTC().Format(time.RFC3339),
+		ExpiresAt:  nil,
+		Platforms:  []Platform{},
+	}
+	if !lastOnlineAt.IsZero() {
+		formatted := lastOnlineAt.UTC().Format(time.RFC3339)
+		state.LastOnlineAt = &formatted
 	}
+
+	return state
+}
+
+func copyOptionalText(value string) *string {
+	if value == "" {
+		return nil
+	}
+
+	copied := value
+	return &copied
+}
+
+// presenceChange is what a write did to a user's presence. From and To are
+// the real status before and after, invisible included.
+type presenceChange struct {
+	UserID string
+	From   PresenceStatus
+	To     PresenceStatus
+	// Visible reports whether other users would notice the change.
+	Visible bool
+}
+
+func newPresenceChange(userID string, previous, record presenceRecord, now time.Time) presenceChange {
+	return presenceChange{
+		UserID:  userID,
+		From:    previous.Status,
+		To:      record.Status,
+		Visible: visibleChange(previous, record, now),
+	}
+}
+
+// transition reports whether the status itself changed.
+func (c presenceChange) transition() bool {
+	return c.From != c.To
+}
+
+// PresenceStore holds presence records. The in-memory store suits a single
+// replica; the Redis store shares state when several replicas run behind a
+// load balancer.
+type PresenceStore interface {
+	// Upsert applies the update to one device and returns the user's own
+	// view of the merged record, plus what changed.
+	Upsert(userID string, update presenceUpdate) (PresenceState, presenceChange, error)
+	// Get returns the presence other users see.
+	Get(userID string) (PresenceState, error)
+	// GetOwn returns the user's own presence, including an invisible status.
+	GetOwn(userID string) (PresenceState, error)
+	Bulk(userIDs []string) ([]PresenceState, error)
+	CleanupExpired() error
+	// AutoIdle makes automatic online devices idle once they have gone after
+	// without an update, and returns the changes to users whose status or
+	// visible presence moved as a result.
+	AutoIdle(after time.Duration) ([]presenceChange, error)
+	// RevertScheduled ends the scheduled statuses whose until has passed and
+	// returns the changes other users would notice or that moved a status.
+	RevertScheduled() ([]presenceChange, error)
+	// Count is the number of records held, for the records gauge. It can
+	// walk the whole store, so it has no place on a hot path.
+	Count() (int, error)
+	// Ping reports whether the store can be reached, cheaply enough for a
+	// readiness probe.
+	Ping(ctx context.Context) error
 }
 
-func (s *presenceStore) Bulk(userIDs []string) []PresenceState {
-	unique := make(map[string]struct{}, len(userIDs))
-	result := make([]PresenceState, 0, len(userIDs))
+// presenceSweepInterval is how often expired records are cleaned up, devices
+// auto-idled and scheduled statuses reverted.
+const presenceSweepInterval = 30 * time.Second
+
+// presenceRetentionTTLs is how many TTLs a record is kept past expiry so
+// offline users still report a meaningful lastSeenAt.
+const presenceRetentionTTLs = 5
+
+// seenOnline reports whether everyone sees the resolved record as online,
+// idle or dnd, which is what lastOnlineAt tracks.
+func (r presenceRecord) seenOnline() bool {
+	return r.public().Status != StatusOffline
+}
+
+// Clock abstracts time.Now so TTL and expiry behavior can be tested without
+// sleeping.
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// applyPresenceUpdate merges an update into the stored record (ok reports
+// whether one existed) and returns the new record along with the previous
+// one resolved at now, for change detection. A record with no live devices
+// counts as offline with no custom text or activity, so neither outlives the
+// TTL. ttl applies to devices without an override of their own.
+func applyPresenceUpdate(previous presenceRecord, ok bool, update presenceUpdate, now time.Time, ttl time.Duration) (presenceRecord, presenceRecord) {
+	deviceID := update.device()
+
+	if !ok || previous.ExpiresAt.Before(now) {
+		previous = presenceRecord{LastVisibleAt: previous.LastVisibleAt, Visibility: previous.Visibility, Version: previous.Version}
+	}
+	previous = previous.resolve(now)
+
+	record := presenceRecord{
+		CustomText:    previous.CustomText,
+		Activity:      previous.Activity,
+		Devices:       make(map[string]deviceRecord, len(previous.Devices)+1),
+		LastSeenAt:    now,
+		LastVisibleAt: previous.LastVisibleAt,
+		Visibility:    previous.Visibility,
+		Version:       previous.Version + 1,
+	}
+	if update.Visibility != "" {
+		record.Visibility = update.Visibility
+	}
+	if record.Visibility == "" {
+		record.Visibility = VisibilityEveryone
+	}
+	if update.CustomText != nil {
+		record.CustomText = *update.CustomText
+	}
+	if update.SetActivity {
+		record.Activity = update.Activity
+	}
+
+	for id, device := range previous.Devices {
+		if !device.ExpiresAt.Before(now) {
+			record.Devices[id] = device
+		}
+	}
+	// Statuses whose until has passed end before the update applies, so it
+	// merges with what the user has now; previous keeps them for the change.
+	current := previous
+	if revertScheduled(record.Devices, now) {
+		current = record.resolve(now)
+	}
+
+	// Automatic updates keep a manual status, whether this device chose it
+	// or, for a device coming online, any other.
+	device, live := record.Devices[deviceID]
+	switch {
+	case update.Manual:
+		device = device.setManualStatus(update.Status, update.Until, live)
+	case live && device.Manual:
+	case !live && current.Manual:
+		device.Status, device.Manual = current.Status, true
+		device.Until, device.RevertTo = current.Until, current.RevertTo
+	case !update.KeepStatus:
+		device.Status, device.Manual = update.Status, false
+		device.Until, device.RevertTo = time.Time{}, ""
+	case live && device.AutoIdle:
+		device.Status = update.Status
+	case live:
+	case current.Status != StatusOffline:
+		device.Status = current.Status
+	default:
+		device.Status = update.Status
+	}
+	device.AutoIdle = false
+	if update.Platform != "" {
+		device.Platform = update.Platform
+	}
+	if update.TTL > 0 {
+		device.TTL = update.TTL
+	}
+	if device.TTL > 0 {
+		ttl = device.TTL
+	}
+	device.LastSeenAt = now
+	device.ExpiresAt = now.Add(ttl)
+	record.Devices[deviceID] = device
+
+	for _, device := range record.Devices {
+		if device.ExpiresAt.After(record.ExpiresAt) {
+			record.ExpiresAt = device.ExpiresAt
+		}
+	}
+
+	record = record.resolve(now)
+	if record.Status != StatusInvisible {
+		record.LastVisibleAt = now
+	}
+
+	return record, previous
+}
+
+// visibleChange reports whether other users would notice the difference
+// between two resolved records. Only what everyone sees counts, since that
+// is what gets published.
+func visibleChange(previous, record presenceRecord, now time.Time) bool {
+	before, after := previous.public(), record.public()
+	return before.Status != after.Status ||
+		before.CustomText != after.CustomText ||
+		!sameActivity(before.Activity, after.Activity) ||
+		!slices.Equal(before.activePlatforms(now), after.activePlatforms(now))
+}
+
+// viewRecord renders a stored record (ok reports whether one existed) as
+// either the user's own view or the view other users get. lastOnlineAt is
+// zero when the user hasn't been seen online within the retention window.
+func viewRecord(userID string, record presenceRecord, ok, own bool, lastOnlineAt, now time.Time) PresenceState {
+	if !ok {
+		return offlineState(userID, now, lastOnlineAt)
+	}
+
+	record, _ = applySchedules(record, now)
+	if !own {
+		record = record.visible()
+	}
+
+	if record.Status == StatusOffline || record.ExpiresAt.Before(now) {
+		state := offlineState(userID, record.LastSeenAt, lastOnlineAt)
+		state.Visibility = record.Visibility
+		state.Version = record.Version
+		return state
+	}
+
+	return record.state(userID, now)
+}
+
+// uniqueUserIDs trims and de-duplicates a bulk request, keeping order.
+func uniqueUserIDs(userIDs []string) []string {
+	seen := make(map[string]struct{}, len(userIDs))
+	unique := make([]string, 0, len(userIDs))
 
 	for _, userID := range userIDs {
 		id := strings.TrimSpace(userID)
@@ -125,71 +594,363 @@ func (s *presenceStore) Bulk(userIDs []string) []PresenceState {
 			continue
 		}
 
-		if _, exists := unique[id]; exists {
+		if _, exists := seen[id]; exists {
 			continue
 		}
 
-		unique[id] = struct{}{}
-		result = append(result, s.Get(id))
+		seen[id] = struct{}{}
+		unique = append(unique, id)
 	}
 
-	return result
+	return unique
+}
+
+type memoryPresenceStore struct {
+	mu      sync.RWMutex
+	records map[string]presenceRecord
+	// lastOnline outlives records: CleanupExpired only drops an entry once
+	// it is older than lastOnlineRetention.
+	lastOnline          map[string]time.Time
+	lastOnlineRetention time.Duration
+	ttl                 time.Duration
+	metrics             *presenceMetrics
+	clock               Clock
+}
+
+func newMemoryPresenceStore(ttl, lastOnlineRetention time.Duration, clock Clock, metrics *presenceMetrics) *memoryPresenceStore {
+	return &memoryPresenceStore{
+		records:             map[string]presenceRecord{},
+		lastOnline:          map[string]time.Time{},
+		lastOnlineRetention: lastOnlineRetention,
+		ttl:                 ttl,
+		metrics:             metrics,
+		clock:               clock,
+	}
 }
 
-func (s *presenceStore) CleanupExpired() {
-	now := time.Now().UTC()
+func (s *memoryPresenceStore) Upsert(userID string, update presenceUpdate) (PresenceState, presenceChange, error) {
+	now := s.clock.Now().UTC()
+
+	s.mu.Lock()
+	stored, ok := s.records[userID]
+	record, previous := applyPresenceUpdate(stored, ok, update, now, s.ttl)
+	s.records[userID] = record
+	if record.seenOnline() {
+		s.lastOnline[userID] = now
+	}
+	s.mu.Unlock()
+	s.metrics.upserts.WithLabelValues(string(record.Devices[update.device()].Status)).Inc()
+
+	return record.state(userID, now), newPresenceChange(userID, previous, record, now), nil
+}
+
+func (s *memoryPresenceStore) Get(userID string) (PresenceState, error) {
+	return s.get(userID, false), nil
+}
+
+func (s *memoryPresenceStore) GetOwn(userID string) (PresenceState, error) {
+	return s.get(userID, true), nil
+}
+
+func (s *memoryPresenceStore) get(userID string, own bool) PresenceState {
+	now := s.clock.Now().UTC()
+
+	s.mu.RLock()
+	record, ok := s.records[userID]
+	lastOnlineAt := s.lastOnline[userID]
+	s.mu.RUnlock()
+
+	return viewRecord(userID, record, ok, own, lastOnlineAt, now)
+}
+
+func (s *memoryPresenceStore) Bulk(userIDs []string) ([]PresenceState, error) {
+	unique := uniqueUserIDs(userIDs)
+	result := make([]PresenceState, 0, len(unique))
+	for _, userID := range unique {
+		result = append(result, s.get(userID, false))
+	}
+
+	return result, nil
+}
+
+func (s *memoryPresenceStore) CleanupExpired() error {
+	now := s.clock.Now().UTC()
 
 	s.mu.Lock()
 	for userID, record := range s.records {
-		if record.ExpiresAt.Before(now.Add(-5 * s.ttl)) {
+		if record.ExpiresAt.Before(now.Add(-presenceRetentionTTLs * s.ttl)) {
 			delete(s.records, userID)
+			s.metrics.expiredCleaned.Inc()
+		}
+	}
+	for userID, lastOnlineAt := range s.lastOnline {
+		if lastOnlineAt.Before(now.Add(-s.lastOnlineRetention)) {
+			delete(s.lastOnline, userID)
 		}
 	}
 	s.mu.Unlock()
+
+	return nil
+}
+
+func (s *memoryPresenceStore) Count() (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.records), nil
+}
+
+// Ping always succeeds: the records live in this process.
+func (s *memoryPresenceStore) Ping(context.Context) error {
+	return nil
+}
+
+// typingThrottle collapses repeated typing calls from the same user in the
+// same channel. It only remembers when each pair last published.
+type typingThrottle struct {
+	mu       sync.Mutex
+	window   time.Duration
+	lastSent map[string]time.Time
+}
+
+func newTypingThrottle(window time.Duration) *typingThrottle {
+	return &typingThrottle{
+		window:   window,
+		lastSent: map[string]time.Time{},
+	}
+}
+
+func (t *typingThrottle) Allow(userID, channelID string, now time.Time) bool {
+	key := userID + ":" + channelID
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if last, ok := t.lastSent[key]; ok && now.Sub(last) < t.window {
+		return false
+	}
+
+	t.lastSent[key] = now
+	return true
+}
+
+func (t *typingThrottle) CleanupExpired(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for key, last := range t.lastSent {
+		if now.Sub(last) >= t.window {
+			delete(t.lastSent, key)
+		}
+	}
 }
 
 type server struct {
-	corsOrigin         string
 	identityServiceURL string
-	store              *presenceStore
+	store              PresenceStore
+	clock              Clock
+	metrics            *presenceMetrics
 	client             *http.Client
+	publisher          publisher
+	typing             *typingThrottle
+	limiter            *presenceRateLimiter
+	bulkMax            int
+	// ttlMin and ttlMax bound the X-Presence-TTL-Seconds override.
+	ttlMin    time.Duration
+	ttlMax    time.Duration
+	authCache *authCache
+	// friends backs the friends visibility; nil hides friends-only users
+	// from everyone but themselves.
+	friends friendsChecker
+	// identityBackoff is roughly how long to wait before retrying a
+	// transient identity-service failure; the wait is jittered around it.
+	identityBackoff time.Duration
+	// jwtVerifier is nil unless a JWT secret or public key is configured.
+	jwtVerifier *jwtVerifier
+	// maxBodyBytes caps JSON request bodies other than bulk lookups, which
+	// are sized from bulkMax.
+	maxBodyBytes int64
+	// autoIdleAfter is how long an online device may go without an update
+	// before the cleanup ticker makes it idle; zero disables it.
+	autoIdleAfter time.Duration
+	// webhook receives status transitions; nil unless a URL is configured.
+	webhook *presenceWebhook
+	// watchers wakes held watches on writes; watchTimeout is how long a
+	// watch is held without one.
+	watchers     *presenceWatchers
+	watchTimeout time.Duration
+	// port, maxInFlight and requestTimeout are only reported by /info;
+	// main applies them.
+	port           string
+	maxInFlight    int
+	requestTimeout time.Duration
 }
 
 func main() {
+	logger := newLogger(os.Stderr, "presence-service")
+	slog.SetDefault(logger)
+	if err := validateConfig(os.Getenv); err != nil {
+		fatal("invalid configuration", err)
+	}
+
 	port := getEnv("PRESENCE_SERVICE_PORT", "4002")
-	corsOrigin := getEnv("CORS_ORIGIN", "*")
+	corsOrigins := parseCORSOrigins(getEnv("CORS_ORIGINS", getEnv("CORS_ORIGIN", "*")))
 	identityServiceURL := getEnv("IDENTITY_SERVICE_URL", "http://localhost:3002")
+	realtimeGatewayURL := getEnv("REALTIME_GATEWAY_URL", "http://localhost:4001")
+	realtimeGatewayInternalAPIKey := getEnv("REALTIME_GATEWAY_INTERNAL_API_KEY", "")
 	ttlSeconds := getIntEnv("PRESENCE_TTL_SECONDS", 75)
 	if ttlSeconds < 15 {
 		ttlSeconds = 15
 	}
+	ttl := time.Duration(ttlSeconds) * time.Second
+	ttlMinSeconds := getIntEnv("PRESENCE_TTL_MIN_SECONDS", 15)
+	if ttlMinSeconds < 15 {
+		ttlMinSeconds = 15
+	}
+	ttlMaxSeconds := getIntEnv("PRESENCE_TTL_MAX_SECONDS", 600)
+	if ttlMaxSeconds < ttlMinSeconds {
+		ttlMaxSeconds = ttlMinSeconds
+	}
+	shutdownGrace := time.Duration(getIntEnv("PRESENCE_SHUTDOWN_GRACE_MS", 10000)) * time.Millisecond
+	rateLimitBurst := getIntEnv("PRESENCE_RATE_LIMIT_BURST", 5)
+	rateLimitWindowSeconds := getIntEnv("PRESENCE_RATE_LIMIT_WINDOW_SECONDS", 10)
+	if rateLimitBurst < 1 {
+		rateLimitBurst = 1
+	}
+	if rateLimitWindowSeconds < 1 {
+		rateLimitWindowSeconds = 1
+	}
+	bulkMax := getIntEnv("PRESENCE_BULK_MAX", 100)
+	if bulkMax < 1 {
+		bulkMax = 1
+	}
+	authCacheTTLSeconds := getIntEnv("PRESENCE_AUTH_CACHE_TTL", 30)
+	authCacheSize := getIntEnv("PRESENCE_AUTH_CACHE_SIZE", 10000)
+	lastOnlineRetentionDays := getIntEnv("PRESENCE_LAST_ONLINE_RETENTION_DAYS", 30)
+	if lastOnlineRetentionDays < 1 {
+		lastOnlineRetentionDays = 1
+	}
+	lastOnlineRetention := time.Duration(lastOnlineRetentionDays) * 24 * time.Hour
+	friendsCheckURL := getEnv("PRESENCE_FRIENDS_CHECK_URL", "")
+	webhookURL := getEnv("PRESENCE_WEBHOOK_URL", "")
+	webhookSecret := getEnv("PRESENCE_WEBHOOK_SECRET", "")
+	identityTimeoutMs := max(getIntEnv("PRESENCE_IDENTITY_TIMEOUT_MS", 3000), 100)
+	identityBackoffMs := max(getIntEnv("PRESENCE_IDENTITY_RETRY_BACKOFF_MS", 100), 0)
+	autoIdleSeconds := max(getIntEnv("PRESENCE_AUTO_IDLE_SECONDS", 0), 0)
+	maxBodyBytes := max(getIntEnv("PRESENCE_MAX_BODY_BYTES", 1<<20), 1024)
+	maxInFlight := getIntEnv("PRESENCE_MAX_IN_FLIGHT", 1000)
+	requestTimeout := time.Duration(max(getIntEnv("PRESENCE_REQUEST_TIMEOUT_MS", 10000), 0)) * time.Millisecond
+	watchTimeoutSeconds := min(max(getIntEnv("PRESENCE_WATCH_TIMEOUT_SECONDS", 25), 1), 120)
+	maxWatches := max(getIntEnv("PRESENCE_MAX_WATCHES", 1000), 0)
+	publishQueueSize := getIntEnv("PRESENCE_PUBLISH_QUEUE_SIZE", 1024)
+
+	clock := realClock{}
+	verifier, err := newJWTVerifier(getEnv("IDENTITY_JWT_SECRET", ""), getEnv("IDENTITY_JWT_PUBLIC_KEY", ""), clock)
+	if err != nil {
+		fatal("invalid IDENTITY_JWT_PUBLIC_KEY", err)
+	}
+	metrics := newPresenceMetrics()
+	var store PresenceStore = newMemoryPresenceStore(ttl, lastOnlineRetention, clock, metrics)
+	if redisURL := getEnv("REDIS_URL", ""); redisURL != "" {
+		redisStore, err := newRedisPresenceStore(redisURL, ttl, lastOnlineRetention, clock, metrics)
+		if err != nil {
+			fatal("failed to connect presence store", err)
+		}
+		store = redisStore
+		slog.Info("using Redis presence store")
+	}
+	metrics.watchStore(store)
 
 	s := &server{
-		corsOrigin:         corsOrigin,
 		identityServiceURL: identityServiceURL,
-		store:              newPresenceStore(time.Duration(ttlSeconds) * time.Second),
-		client:             &http.Client{Timeout: 3 * time.Second},
+		store:              store,
+		clock:              clock,
+		metrics:            metrics,
+		client:             &http.Client{Timeout: time.Duration(identityTimeoutMs) * time.Millisecond},
+		publisher:          newGatewayPublisher(realtimeGatewayURL, realtimeGatewayInternalAPIKey, time.Second, publishQueueSize, metrics.publishFailures),
+		typing:             newTypingThrottle(typingDedupeWindow),
+		limiter:            newPresenceRateLimiter(rateLimitBurst, time.Duration(rateLimitWindowSeconds)*time.Second),
+		bulkMax:            bulkMax,
+		ttlMin:             time.Duration(ttlMinSeconds) * time.Second,
+		ttlMax:             time.Duration(ttlMaxSeconds) * time.Second,
+		authCache:          newAuthCache(time.Duration(authCacheTTLSeconds)*time.Second, authCacheSize, clock),
+		jwtVerifier:        verifier,
+		identityBackoff:    time.Duration(identityBackoffMs) * time.Millisecond,
+		autoIdleAfter:      time.Duration(autoIdleSeconds) * time.Second,
+		maxBodyBytes:       int64(maxBodyBytes),
+		port:               port,
+		maxInFlight:        maxInFlight,
+		requestTimeout:     requestTimeout,
+		watchers:           newPresenceWatchers(maxWatches),
+		watchTimeout:       time.Duration(watchTimeoutSeconds) * time.Second,
+	}
+	if friendsCheckURL != "" {
+		s.friends = newHTTPFriendsChecker(friendsCheckURL, 2*time.Second)
 	}
+	if webhookURL != "" {
+		if webhookSecret == "" {
+			slog.Warn("PRESENCE_WEBHOOK_URL is set without PRESENCE_WEBHOOK_SECRET; webhook bodies are signed with an empty key")
+		}
+		s.webhook = newPresenceWebhook(webhookURL, webhookSecret, 5*time.Second)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	go func() {
-		ticker := time.NewTicker(30 * time.Second)
+		ticker := time.NewTicker(presenceSweepInterval)
 		defer ticker.Stop()
-		for range ticker.C {
-			s.store.CleanupExpired()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			if err := s.store.CleanupExpired(); err != nil {
+				slog.Error("presence cleanup failed", "error", err)
+			}
+			if s.autoIdleAfter > 0 {
+				s.autoIdle()
+			}
+			s.revertScheduled()
+			s.typing.CleanupExpired(s.clock.Now().UTC())
+			s.limiter.CleanupIdle(s.clock.Now().UTC())
 		}
 	}()
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/health", s.handleHealth)
+	mux.HandleFunc("/ready", s.handleReady)
+	mux.HandleFunc("/info", s.handleInfo)
+	mux.Handle("/metrics", s.metrics.handler())
 	mux.HandleFunc("/v1/presence", s.handlePresence)
 	mux.HandleFunc("/v1/presence/me", s.handlePresenceMe)
 	mux.HandleFunc("/v1/presence/bulk", s.handlePresenceBulk)
+	mux.HandleFunc("/v1/presence/heartbeat", s.handlePresenceHeartbeat)
+	mux.HandleFunc("/v1/presence/servers/", s.handlePresenceServerCount)
 	mux.HandleFunc("/v1/presence/", s.handlePresenceByUserID)
+	mux.HandleFunc("/v1/typing", s.handleTyping)
 	mux.HandleFunc("/", s.handleRoot)
 
 	addr := ":" + port
-	log.Printf("presence-service listening on http://localhost%s", addr)
-	log.Fatal(http.ListenAndServe(addr, mux))
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		fatal("failed to listen", err)
+	}
+
+	slog.Info("listening", "addr", addr)
+	if err := serve(ctx, &http.Server{Handler: withRequestLogging(logger, withCORS(corsOrigins, withInFlightLimit(maxInFlight, isWatchRequest, withRequestTimeout(requestTimeout, isWatchRequest, mux))))}, ln, shutdownGrace); err != nil {
+		fatal("server failed", err)
+	}
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), shutdownGrace)
+	defer cancel()
+	drainPublisher(drainCtx, s.publisher)
+	if s.webhook != nil {
+		s.webhook.Drain(drainCtx)
+	}
+	slog.Info("shut down")
 }
 
 func (s *server) handleHealth(w http.ResponseWriter, _ *http.Request) {
@@ -199,6 +960,20 @@ func (s *server) handleHealth(w http.ResponseWriter, _ *http.Request) {
 	})
 }
 
+// handleReady reports whether the identity service and the presence store
+// are reachable. /health stays a cheap liveness probe.
+func (s *server) handleReady(w http.ResponseWriter, r *http.Request) {
+	statuses, ready := runReadinessChecks(r.Context(), []readinessCheck{
+		{name: "identity", probe: func(ctx context.Context) error {
+			return pingHealth(ctx, s.client, s.identityServiceURL)
+		}},
+		{name: "store", probe: s.store.Ping},
+	})
+
+	code, body := readinessResponse("presence-service", statuses, ready)
+	s.respondJSON(w, code, body)
+}
+
 func (s *server) handleRoot(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodOptions {
 		s.respondOptions(w)
@@ -209,10 +984,17 @@ func (s *server) handleRoot(w http.ResponseWriter, r *http.Request) {
 		"service": "presence-service",
 		"routes": []string{
 			"GET /health",
+			"GET /ready",
+			"GET /info",
+			"GET /metrics",
 			"PUT /v1/presence",
 			"GET /v1/presence/me",
 			"POST /v1/presence/bulk",
+			"POST /v1/presence/heartbeat",
+			"POST /v1/presence/servers/:serverId/count",
 			"GET /v1/presence/:userId",
+			"GET /v1/presence/:userId/watch?version=...",
+			"POST /v1/typing",
 		},
 	})
 }
@@ -229,27 +1011,211 @@ func (s *server) handlePresence(w http.ResponseWriter, r *http.Request) {
 
 	userID, statusCode, err := s.authenticate(r)
 	if err != nil {
-		s.respondError(w, statusCode, err.Error())
+		s.respondAuthError(w, statusCode, err)
+		return
+	}
+
+	if retryAfter, ok := s.limiter.Check(userID, s.clock.Now()); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		s.respondErrorCode(w, http.StatusTooManyRequests, codePresenceRateLimited, "Too many presence updates. Try again later.")
 		return
 	}
 
 	var body updatePresenceRequest
-	if err := decodeJSONBody(r.Body, &body); err != nil {
+	if err := decodeJSONBodyLimit(r, &body, s.maxBodyBytes); err != nil {
+		s.respondDecodeError(w, err)
+		return
+	}
+
+	deviceID := strings.TrimSpace(r.Header.Get("X-Device-Id"))
+	if utf8.RuneCountInString(deviceID) > maxDeviceIDRunes {
+		s.respondError(w, http.StatusBadRequest, fmt.Sprintf("X-Device-Id must be at most %d characters.", maxDeviceIDRunes))
+		return
+	}
+
+	ttl, err := s.parseTTLOverride(r)
+	if err != nil {
 		s.respondError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	status := StatusOnline
+	update := presenceUpdate{Status: StatusOnline, DeviceID: deviceID, TTL: ttl}
 	if body.Status != nil {
 		parsed, err := parseUpdateStatus(*body.Status)
 		if err != nil {
 			s.respondError(w, http.StatusBadRequest, err.Error())
 			return
 		}
-		status = parsed
+		update.Status = parsed
+		update.Manual = true
+	}
+
+	if body.CustomText != nil {
+		customText, err := parseCustomText(*body.CustomText)
+		if err != nil {
+			s.respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		update.CustomText = &customText
 	}
 
-	state := s.store.Upsert(userID, status)
+	if body.Platform != nil {
+		platform, err := parsePlatform(*body.Platform)
+		if err != nil {
+			s.respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		update.Platform = platform
+	}
+
+	if body.Visibility != nil {
+		visibility, err := parseVisibility(*body.Visibility)
+		if err != nil {
+			s.respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		update.Visibility = visibility
+	}
+
+	if body.Until != nil {
+		if body.Status == nil {
+			s.respondError(w, http.StatusBadRequest, "until requires status.")
+			return
+		}
+		until, err := parseStatusUntil(*body.Until, s.clock.Now().UTC())
+		if err != nil {
+			s.respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		update.Until = until
+	}
+
+	if len(body.Activity) > 0 {
+		activity, err := parseActivity(body.Activity, s.clock.Now().UTC())
+		if err != nil {
+			s.respondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		update.SetActivity = true
+		update.Activity = activity
+	}
+
+	state, change, err := s.store.Upsert(userID, update)
+	if err != nil {
+		s.respondStoreError(w, err)
+		return
+	}
+
+	s.settleUpdate(change)
+	s.respondJSON(w, http.StatusOK, state)
+}
+
+// parseTTLOverride reads X-Presence-TTL-Seconds, clamped to the configured
+// bounds. It returns zero when the header is absent.
+func (s *server) parseTTLOverride(r *http.Request) (time.Duration, error) {
+	raw := strings.TrimSpace(r.Header.Get("X-Presence-TTL-Seconds"))
+	if raw == "" {
+		return 0, nil
+	}
+
+	seconds, err := strconv.Atoi(raw)
+	if err != nil || seconds < 1 {
+		return 0, errors.New("X-Presence-TTL-Seconds must be a positive whole number of seconds.")
+	}
+
+	return min(max(time.Duration(seconds)*time.Second, s.ttlMin), s.ttlMax), nil
+}
+
+// settleUpdate charges the user's rate limit for a stored update, publishes
+// the new presence when other users would notice it, wakes watches on the
+// user and reports a status transition to the webhook.
+func (s *server) settleUpdate(change presenceChange) {
+	now := s.clock.Now()
+	cost := 1.0
+	if !change.Visible {
+		cost = refreshCost
+	}
+	s.limiter.Charge(change.UserID, cost, now)
+
+	if change.Visible {
+		s.publishPresence(change.UserID)
+	}
+	s.watchers.notify(change.UserID)
+	s.reportTransition(change, now.UTC())
+}
+
+// settleChanges publishes, wakes watches for and reports the changes a
+// background sweep made.
+func (s *server) settleChanges(changes []presenceChange) {
+	now := s.clock.Now().UTC()
+	for _, change := range changes {
+		if change.Visible {
+			s.publishPresence(change.UserID)
+		}
+		s.watchers.notify(change.UserID)
+		s.reportTransition(change, now)
+	}
+}
+
+// reportTransition sends change to the webhook when one is configured and
+// the status actually moved.
+func (s *server) reportTransition(change presenceChange, now time.Time) {
+	if s.webhook == nil || !change.transition() {
+		return
+	}
+
+	s.webhook.Send(change, now)
+}
+
+// publishPresence publishes the user's presence as everyone may see it.
+func (s *server) publishPresence(userID string) {
+	visible, err := s.store.Get(userID)
+	if err != nil {
+		slog.Error("failed to read presence for publish", "userId", userID, "error", err)
+		return
+	}
+
+	public := s.revealPresence(context.Background(), "", []PresenceState{visible})[0]
+	s.publisher.Publish(presenceTopic(userID), "presence.updated", public)
+}
+
+// handlePresenceHeartbeat keeps the caller's device online without touching
+// its status, so a client refreshing on a timer can't overwrite dnd or idle.
+func (s *server) handlePresenceHeartbeat(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		s.respondOptions(w)
+		return
+	}
+	if r.Method != http.MethodPost {
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed.")
+		return
+	}
+
+	userID, statusCode, err := s.authenticate(r)
+	if err != nil {
+		s.respondAuthError(w, statusCode, err)
+		return
+	}
+
+	if retryAfter, ok := s.limiter.Check(userID, s.clock.Now()); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		s.respondErrorCode(w, http.StatusTooManyRequests, codePresenceRateLimited, "Too many presence updates. Try again later.")
+		return
+	}
+
+	deviceID := strings.TrimSpace(r.Header.Get("X-Device-Id"))
+	if utf8.RuneCountInString(deviceID) > maxDeviceIDRunes {
+		s.respondError(w, http.StatusBadRequest, fmt.Sprintf("X-Device-Id must be at most %d characters.", maxDeviceIDRunes))
+		return
+	}
+
+	state, change, err := s.store.Upsert(userID, presenceUpdate{Status: StatusOnline, DeviceID: deviceID, KeepStatus: true})
+	if err != nil {
+		s.respondStoreError(w, err)
+		return
+	}
+
+	s.settleUpdate(change)
 	s.respondJSON(w, http.StatusOK, state)
 }
 
@@ -265,11 +1231,16 @@ func (s *server) handlePresenceMe(w http.ResponseWriter, r *http.Request) {
 
 	userID, statusCode, err := s.authenticate(r)
 	if err != nil {
-		s.respondError(w, statusCode, err.Error())
+		s.respondAuthError(w, statusCode, err)
+		return
+	}
+
+	state, err := s.store.GetOwn(userID)
+	if err != nil {
+		s.respondStoreError(w, err)
 		return
 	}
 
-	state := s.store.Get(userID)
 	s.respondJSON(w, http.StatusOK, state)
 }
 
@@ -283,27 +1254,133 @@ func (s *server) handlePresenceBulk(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, statusCode, err := s.authenticate(r)
+	viewerID, statusCode, err := s.authenticate(r)
 	if err != nil {
-		s.respondError(w, statusCode, err.Error())
+		s.respondAuthError(w, statusCode, err)
 		return
 	}
 
 	var body bulkPresenceRequest
-	if err := decodeJSONBody(r.Body, &body); err != nil {
-		s.respondError(w, http.StatusBadRequest, err.Error())
+	if err := decodeJSONBodyLimit(r, &body, bulkBodyLimit(s.bulkMax)); err != nil {
+		s.respondDecodeError(w, err)
+		return
+	}
+
+	// Checked before deduplication so the cost of a request is bounded by
+	// what the client sent.
+	if len(body.UserIDs) > s.bulkMax {
+		s.respondErrorCode(w, http.StatusBadRequest, codePresenceBulkTooLarge, fmt.Sprintf("userIds must contain at most %d entries; split larger lookups into chunks.", s.bulkMax))
 		return
 	}
 
+	s.metrics.bulkRequests.Inc()
+
 	if len(body.UserIDs) == 0 {
 		s.respondJSON(w, http.StatusOK, []PresenceState{})
 		return
 	}
 
-	states := s.store.Bulk(body.UserIDs)
+	states, err := s.store.Bulk(body.UserIDs)
+	if err != nil {
+		s.respondStoreError(w, err)
+		return
+	}
+
+	states = s.revealPresence(r.Context(), viewerID, states)
+	etag := bulkETag(states)
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		for key, value := range s.corsHeaders() {
+			w.Header().Set(key, value)
+		}
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
 	s.respondJSON(w, http.StatusOK, states)
 }
 
+// presenceCounts buckets a server's members by the status other users see,
+// so invisible members count as offline.
+type presenceCounts struct {
+	Online  int `json:"online"`
+	Idle    int `json:"idle"`
+	Dnd     int `json:"dnd"`
+	Offline int `json:"offline"`
+}
+
+func countPresence(states []PresenceState) presenceCounts {
+	var counts presenceCounts
+	for _, state := range states {
+		switch state.Status {
+		case StatusOnline:
+			counts.Online++
+		case StatusIdle:
+			counts.Idle++
+		case StatusDnd:
+			counts.Dnd++
+		default:
+			counts.Offline++
+		}
+	}
+
+	return counts
+}
+
+// handlePresenceServerCount aggregates the presence of a server's members.
+// Presence has no notion of membership, so the caller sends the member ids
+// and the same cap as the bulk lookup applies; GET is accepted too for
+// clients that send the list with one.
+func (s *server) handlePresenceServerCount(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		s.respondOptions(w)
+		return
+	}
+
+	serverID, action, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/v1/presence/servers/"), "/")
+	serverID = strings.TrimSpace(serverID)
+	if !ok || action != "count" || serverID == "" {
+		s.respondError(w, http.StatusNotFound, "Route not found.")
+		return
+	}
+	if r.Method != http.MethodPost && r.Method != http.MethodGet {
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed.")
+		return
+	}
+
+	viewerID, statusCode, err := s.authenticate(r)
+	if err != nil {
+		s.respondAuthError(w, statusCode, err)
+		return
+	}
+
+	var body bulkPresenceRequest
+	if err := decodeJSONBodyLimit(r, &body, bulkBodyLimit(s.bulkMax)); err != nil {
+		s.respondDecodeError(w, err)
+		return
+	}
+
+	if len(body.UserIDs) > s.bulkMax {
+		s.respondErrorCode(w, http.StatusBadRequest, codePresenceBulkTooLarge, fmt.Sprintf("userIds must contain at most %d entries; split larger lookups into chunks.", s.bulkMax))
+		return
+	}
+
+	states := []PresenceState{}
+	if len(body.UserIDs) > 0 {
+		states, err = s.store.Bulk(body.UserIDs)
+		if err != nil {
+			s.respondStoreError(w, err)
+			return
+		}
+	}
+
+	s.respondJSON(w, http.StatusOK, map[string]any{
+		"serverId": serverID,
+		"total":    len(states),
+		"counts":   countPresence(s.revealPresence(r.Context(), viewerID, states)),
+	})
+}
+
 func (s *server) handlePresenceByUserID(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodOptions {
 		s.respondOptions(w)
@@ -314,96 +1391,283 @@ func (s *server) handlePresenceByUserID(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	_, statusCode, err := s.authenticate(r)
+	viewerID, statusCode, err := s.authenticate(r)
 	if err != nil {
-		s.respondError(w, statusCode, err.Error())
+		s.respondAuthError(w, statusCode, err)
 		return
 	}
 
-	userID := strings.TrimPrefix(r.URL.Path, "/v1/presence/")
+	userID, watch := watchedUserID(r.URL.Path)
+	if !watch {
+		userID = strings.TrimPrefix(r.URL.Path, "/v1/presence/")
+	}
 	userID = strings.TrimSpace(userID)
 	if userID == "" {
 		s.respondError(w, http.StatusBadRequest, "userId is required.")
 		return
 	}
+	if watch {
+		s.handlePresenceWatch(w, r, viewerID, userID)
+		return
+	}
 
-	state := s.store.Get(userID)
-	s.respondJSON(w, http.StatusOK, state)
+	state, err := s.store.Get(userID)
+	if err != nil {
+		s.respondStoreError(w, err)
+		return
+	}
+
+	s.respondJSON(w, http.StatusOK, s.revealPresence(r.Context(), viewerID, []PresenceState{state})[0])
 }
 
-func (s *server) authenticate(r *http.Request) (string, int, error) {
-	authHeader := strings.TrimSpace(r.Header.Get("Authorization"))
-	cookieHeader := strings.TrimSpace(r.Header.Get("Cookie"))
-	if authHeader == "" && cookieHeader == "" {
-		return "", http.StatusUnauthorized, errors.New("Unauthorized.")
+func presenceTopic(userID string) string {
+	return "presence:" + userID
+}
+
+// handleTyping fans a short-lived typing indicator out to typing:<channelId>
+// subscribers. Nothing is stored; the gateway checks each subscriber's access
+// to the channel.
+func (s *server) handleTyping(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		s.respondOptions(w)
+		return
+	}
+	if r.Method != http.MethodPost {
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed.")
+		return
 	}
 
-	req, err := http.NewRequest(http.MethodGet, s.identityServiceURL+"/v1/me", nil)
+	userID, statusCode, err := s.authenticate(r)
 	if err != nil {
-		return "", http.StatusInternalServerError, errors.New("Failed to build identity request.")
+		s.respondAuthError(w, statusCode, err)
+		return
 	}
 
-	if authHeader != "" {
-		req.Header.Set("Authorization", authHeader)
+	var body typingRequest
+	if err := decodeJSONBodyLimit(r, &body, s.maxBodyBytes); err != nil {
+		s.respondDecodeError(w, err)
+		return
 	}
-	if cookieHeader != "" {
-		req.Header.Set("Cookie", cookieHeader)
+
+	channelID := strings.TrimSpace(body.ChannelID)
+	if channelID == "" {
+		s.respondError(w, http.StatusBadRequest, "channelId is required.")
+		return
 	}
 
-	resp, err := s.client.Do(req)
-	if err != nil {
-		return "", http.StatusServiceUnavailable, errors.New("Identity service unavailable.")
+	now := s.clock.Now().UTC()
+	indicator := TypingIndicator{
+		ConversationID: channelID,
+		UserID:         userID,
+		IsTyping:       true,
+		ExpiresAt:      now.Add(typingIndicatorTTL).Format(time.RFC3339Nano),
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return "", http.StatusUnauthorized, errors.New("Unauthorized.")
+	if s.typing.Allow(userID, channelID, now) {
+		s.publisher.Publish(typingTopic(channelID), "typing.updated", indicator)
 	}
 
-	var me meResponse
-	if err := json.NewDecoder(resp.Body).Decode(&me); err != nil {
+	s.respondJSON(w, http.StatusOK, indicator)
+}
+
+func typingTopic(channelID string) string {
+	return "typing:" + channelID
+}
+
+func (s *server) authenticate(r *http.Request) (string, int, error) {
+	authHeader := strings.TrimSpace(r.Header.Get("Authorization"))
+	cookieHeader := strings.TrimSpace(r.Header.Get("Cookie"))
+	if authHeader == "" && cookieHeader == "" {
 		return "", http.StatusUnauthorized, errors.New("Unauthorized.")
 	}
 
-	if strings.TrimSpace(me.ID) == "" {
-		return "", http.StatusUnauthorized, errors.New("Unauthorized.")
+	if s.jwtVerifier != nil {
+		if token := sessionToken(authHeader, cookieHeader); token != "" {
+			userID, err := s.jwtVerifier.verify(token)
+			if err == nil {
+				return userID, http.StatusOK, nil
+			}
+			if errors.Is(err, jwt.ErrTokenExpired) {
+				return "", http.StatusUnauthorized, errors.New("Unauthorized.")
+			}
+			// Not a token we can vouch for; identity-service may still
+			// know it.
+		}
+	}
+
+	if userID, ok := s.authCache.Get(authHeader, cookieHeader); ok {
+		return userID, http.StatusOK, nil
 	}
 
-	return strings.TrimSpace(me.ID), http.StatusOK, nil
+	userID, statusCode, err := s.lookupIdentity(r.Context(), authHeader, cookieHeader)
+	if err != nil {
+		return "", statusCode, err
+	}
+
+	s.authCache.Put(authHeader, cookieHeader, userID)
+	return userID, http.StatusOK, nil
 }
 
 func parseUpdateStatus(raw string) (PresenceStatus, error) {
 	status := PresenceStatus(strings.TrimSpace(strings.ToLower(raw)))
 	switch status {
-	case StatusOnline, StatusIdle, StatusDnd:
+	case StatusOnline, StatusIdle, StatusDnd, StatusInvisible:
 		return status, nil
+	case StatusOffline:
+		// Choosing to appear offline while connected is what invisible means.
+		return StatusInvisible, nil
+	default:
+		return "", errors.New("status must be one of: online, idle, dnd, invisible.")
+	}
+}
+
+func parsePlatform(raw string) (Platform, error) {
+	platform := Platform(strings.TrimSpace(strings.ToLower(raw)))
+	switch platform {
+	case PlatformDesktop, PlatformMobile, PlatformWeb:
+		return platform, nil
+	default:
+		return "", errors.New("platform must be one of: desktop, mobile, web.")
+	}
+}
+
+// parseCustomText trims the text; an empty result clears the stored value.
+func parseCustomText(raw string) (string, error) {
+	text := strings.TrimSpace(raw)
+	if utf8.RuneCountInString(text) > maxCustomTextRunes {
+		return "", fmt.Errorf("customText must be at most %d characters.", maxCustomTextRunes)
+	}
+
+	return text, nil
+}
+
+// parseActivity validates an activity object. A JSON null clears the activity;
+// a missing startedAt defaults to now.
+func parseActivity(raw json.RawMessage, now time.Time) (*activityRecord, error) {
+	if bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+		return nil, nil
+	}
+
+	var body activityRequest
+	if err := json.Unmarshal(raw, &body); err != nil {
+		return nil, errors.New("activity must be an object.")
+	}
+
+	activityType := ActivityType(strings.TrimSpace(strings.ToLower(body.Type)))
+	switch activityType {
+	case ActivityPlaying, ActivityListening, ActivityWatching, ActivityCustom:
 	default:
-		return "", errors.New("status must be one of: online, idle, dnd.")
+		return nil, errors.New("activity.type must be one of: playing, listening, watching, custom.")
 	}
+
+	name := strings.TrimSpace(body.Name)
+	if name == "" {
+		return nil, errors.New("activity.name is required.")
+	}
+	if utf8.RuneCountInString(name) > maxActivityFieldRunes {
+		return nil, fmt.Errorf("activity.name must be at most %d characters.", maxActivityFieldRunes)
+	}
+
+	details := ""
+	if body.Details != nil {
+		details = strings.TrimSpace(*body.Details)
+		if utf8.RuneCountInString(details) > maxActivityFieldRunes {
+			return nil, fmt.Errorf("activity.details must be at most %d characters.", maxActivityFieldRunes)
+		}
+	}
+
+	startedAt := now
+	if body.StartedAt != nil {
+		parsed, err := time.Parse(time.RFC3339, strings.TrimSpace(*body.StartedAt))
+		if err != nil {
+			return nil, errors.New("activity.startedAt must be an RFC 3339 timestamp.")
+		}
+		startedAt = parsed.UTC()
+	}
+
+	return &activityRecord{
+		Type:      activityType,
+		Name:      name,
+		Details:   details,
+		StartedAt: startedAt,
+	}, nil
 }
 
-func decodeJSONBody[T any](body io.ReadCloser, out *T) error {
-	if body == nil {
+// errBodyTooLarge is wrapped by decodeJSONBodyLimit for bodies over the
+// limit, which respondDecodeError answers with 413.
+var errBodyTooLarge = errors.New("Payload too large.")
+
+// decodeJSONBodyLimit decodes the request's JSON body into out. An empty
+// body leaves out alone and needs no Content-Type.
+func decodeJSONBodyLimit[T any](r *http.Request, out *T, maxBytes int64) error {
+	if r.Body == nil {
 		return errors.New("Invalid JSON body.")
 	}
-	defer body.Close()
+	defer r.Body.Close()
 
-	payload, err := io.ReadAll(io.LimitReader(body, 1<<20))
+	payload, err := io.ReadAll(io.LimitReader(r.Body, maxBytes+1))
 	if err != nil {
 		return errors.New("Failed to read request body.")
 	}
+	if int64(len(payload)) > maxBytes {
+		return fmt.Errorf("%w Request body must be at most %d bytes.", errBodyTooLarge, maxBytes)
+	}
 
 	if len(bytes.TrimSpace(payload)) == 0 {
 		return nil
 	}
+	if err := checkJSONContentType(r.Header); err != nil {
+		return err
+	}
+
+	return unmarshalStrict(payload, out)
+}
+
+// errUnsupportedMediaType is wrapped by checkJSONContentType, which
+// respondDecodeError answers with 415.
+var errUnsupportedMediaType = errors.New("Unsupported Media Type.")
 
-	if err := json.Unmarshal(payload, out); err != nil {
+// checkJSONContentType requires a JSON Content-Type, ignoring parameters
+// such as charset.
+func checkJSONContentType(header http.Header) error {
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		return fmt.Errorf("%w Content-Type must be application/json.", errUnsupportedMediaType)
+	}
+	return nil
+}
+
+// unmarshalStrict decodes payload into out, refusing fields out doesn't
+// have so a misspelled field fails loudly instead of being ignored.
+func unmarshalStrict[T any](payload []byte, out *T) error {
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(out); err != nil {
+		if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			return fmt.Errorf("Unknown field %s.", field)
+		}
+		return errors.New("Invalid JSON body.")
+	}
+	if _, err := decoder.Token(); err != io.EOF {
 		return errors.New("Invalid JSON body.")
 	}
 
 	return nil
 }
 
+// respondDecodeError answers a failed decodeJSONBodyLimit: 413 for a body
+// over the limit, 415 for one that isn't JSON, 400 for anything else.
+func (s *server) respondDecodeError(w http.ResponseWriter, err error) {
+	status := http.StatusBadRequest
+	switch {
+	case errors.Is(err, errBodyTooLarge):
+		status = http.StatusRequestEntityTooLarge
+	case errors.Is(err, errUnsupportedMediaType):
+		status = http.StatusUnsupportedMediaType
+	}
+	s.respondError(w, status, err.Error())
+}
+
 func (s *server) respondOptions(w http.ResponseWriter) {
 	headers := s.corsHeaders()
 	for key, value := range headers {
@@ -422,19 +1686,55 @@ func (s *server) respondJSON(w http.ResponseWriter, status int, payload any) {
 	_ = json.NewEncoder(w).Encode(payload)
 }
 
+// respondStoreError hides backend details from clients; the cause is only
+// logged.
+func (s *server) respondStoreError(w http.ResponseWriter, err error) {
+	slog.Error("presence store error", "error", err)
+	s.respondErrorCode(w, http.StatusServiceUnavailable, codePresenceStoreUnavailable, "Presence store unavailable.")
+}
+
+// respondError answers with message and the generic code for status.
 func (s *server) respondError(w http.ResponseWriter, status int, message string) {
+	s.respondErrorCode(w, status, statusErrorCode(status), message)
+}
+
+func (s *server) respondErrorCode(w http.ResponseWriter, status int, code, message string) {
 	s.respondJSON(w, status, map[string]string{
+		"code":  code,
 		"error": message,
 	})
 }
 
+// respondAuthError answers a failed authenticate, telling an identity
+// service outage apart from rejected credentials.
+func (s *server) respondAuthError(w http.ResponseWriter, status int, err error) {
+	code := statusErrorCode(status)
+	if errors.Is(err, errIdentityUnavailable) {
+		code = codePresenceIdentityUnavailable
+	}
+	s.respondErrorCode(w, status, code, err.Error())
+}
+
 func (s *server) corsHeaders() map[string]string {
 	return map[string]string{
-		"Access-Control-Allow-Origin":  s.corsOrigin,
-		"Access-Control-Allow-Methods": "GET,POST,PUT,OPTIONS",
-		"Access-Control-Allow-Headers": "Content-Type, Authorization, Cookie",
-		"Access-Control-Max-Age":       "86400",
+		"Access-Control-Allow-Methods":  "GET,POST,PUT,OPTIONS",
+		"Access-Control-Allow-Headers":  "Content-Type, Authorization, Cookie, X-Device-Id, X-Presence-TTL-Seconds, X-Request-Id, If-None-Match",
+		"Access-Control-Expose-Headers": "ETag",
+		"Access-Control-Max-Age":        "86400",
+	}
+}
+
+func randomSuffix(n int) string {
+	if n < 2 {
+		n = 2
 	}
+
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+
+	return hex.EncodeToString(buf)
 }
 
 func getEnv(key, fallback string) string {
@@ -0,0 +1,776 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type publishedEvent struct {
+	Topic     string
+	EventType string
+	Payload   any
+}
+
+type recordingPublisher struct {
+	mu     sync.Mutex
+	events []publishedEvent
+}
+
+func (p *recordingPublisher) Publish(topic, eventType string, payload any) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.events = append(p.events, publishedEvent{Topic: topic, EventType: eventType, Payload: payload})
+}
+
+func (p *recordingPublisher) take() []publishedEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	events := p.events
+	p.events = nil
+	return events
+}
+
+// newTestServer wires a presence server to a stub identity service that maps
+// "Bearer <userId>" to that user id.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+const (
+	testPresenceTTL         = time.Minute
+	testLastOnlineRetention = time.Hour
+)
+
+func newTestServer(t *testing.T) (*server, *recordingPublisher) {
+	t.Helper()
+
+	return newTestServerWithStore(t, func(clock Clock, metrics *presenceMetrics) PresenceStore {
+		return newMemoryPresenceStore(testPresenceTTL, testLastOnlineRetention, clock, metrics)
+	})
+}
+
+// newTestServerWithStore wires a server around the store built by newStore,
+// sharing one fake clock and metrics registry between them.
+func newTestServerWithStore(t *testing.T, newStore func(Clock, *presenceMetrics) PresenceStore) (*server, *recordingPublisher) {
+	t.Helper()
+
+	identity := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if userID == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(meResponse{ID: userID})
+	}))
+	t.Cleanup(identity.Close)
+
+	clock := newFakeClock()
+	metrics := newPresenceMetrics()
+	store := newStore(clock, metrics)
+	metrics.watchStore(store)
+
+	pub := &recordingPublisher{}
+	return &server{
+		identityServiceURL: identity.URL,
+		store:              store,
+		clock:              clock,
+		metrics:            metrics,
+		client:             &http.Client{Timeout: time.Second},
+		publisher:          pub,
+		typing:             newTypingThrottle(typingDedupeWindow),
+		limiter:            newPresenceRateLimiter(5, 10*time.Second),
+		bulkMax:            100,
+		ttlMin:             15 * time.Second,
+		ttlMax:             5 * time.Minute,
+		authCache:          newAuthCache(30*time.Second, 100, clock),
+		maxBodyBytes:       1 << 20,
+		watchers:           newPresenceWatchers(10),
+		watchTimeout:       time.Minute,
+	}, pub
+}
+
+func mustGet(t *testing.T, store PresenceStore, userID string) PresenceState {
+	t.Helper()
+
+	state, err := store.Get(userID)
+	if err != nil {
+		t.Fatalf("get %s: %v", userID, err)
+	}
+	return state
+}
+
+func doRequest(t *testing.T, handler http.HandlerFunc, method, path, userID, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	return doRequestWithHeaders(t, handler, method, path, userID, body, nil)
+}
+
+func doRequestWithHeaders(t *testing.T, handler http.HandlerFunc, method, path, userID, body string, headers map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if userID != "" {
+		req.Header.Set("Authorization", "Bearer "+userID)
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	recorder := httptest.NewRecorder()
+	handler(recorder, req)
+	return recorder
+}
+
+func TestPresenceUpdatePublishesOnlyOnStatusChange(t *testing.T) {
+	s, pub := newTestServer(t)
+
+	for i := 0; i < 2; i++ {
+		if res := doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"status":"dnd"}`); res.Code != http.StatusOK {
+			t.Fatalf("update %d: status %d: %s", i, res.Code, res.Body.String())
+		}
+	}
+
+	events := pub.take()
+	if len(events) != 1 {
+		t.Fatalf("expected a single publish for a repeated status, got %d", len(events))
+	}
+	if events[0].Topic != "presence:usr_1" || events[0].EventType != "presence.updated" {
+		t.Fatalf("unexpected event %s on %s", events[0].EventType, events[0].Topic)
+	}
+	if state, ok := events[0].Payload.(PresenceState); !ok || state.Status != StatusDnd {
+		t.Fatalf("unexpected payload %+v", events[0].Payload)
+	}
+
+	doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"status":"online"}`)
+	if events := pub.take(); len(events) != 1 {
+		t.Fatalf("expected a publish for a status change, got %d", len(events))
+	}
+}
+
+func TestPresenceCustomTextSetClearAndOverflow(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	res := doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"customText":"  In a meeting  "}`)
+	if res.Code != http.StatusOK {
+		t.Fatalf("set: status %d: %s", res.Code, res.Body.String())
+	}
+	if state := mustGet(t, s.store, "usr_1"); state.CustomText == nil || *state.CustomText != "In a meeting" {
+		t.Fatalf("expected trimmed custom text, got %+v", state.CustomText)
+	}
+
+	doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"status":"idle"}`)
+	if state := mustGet(t, s.store, "usr_1"); state.CustomText == nil {
+		t.Fatal("expected custom text to survive an update that omits it")
+	}
+
+	doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"customText":"   "}`)
+	if state := mustGet(t, s.store, "usr_1"); state.CustomText != nil {
+		t.Fatalf("expected whitespace to clear custom text, got %q", *state.CustomText)
+	}
+
+	overflow := `{"customText":"` + strings.Repeat("é", maxCustomTextRunes+1) + `"}`
+	if res := doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", overflow); res.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for overflowing text, got %d", res.Code)
+	}
+}
+
+func TestPresenceActivityRoundTripsAndExpires(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	body := `{"activity":{"type":"playing","name":"Mango Quest","details":"Level 3","startedAt":"2026-01-02T03:04:05Z"}}`
+	if res := doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", body); res.Code != http.StatusOK {
+		t.Fatalf("set: status %d: %s", res.Code, res.Body.String())
+	}
+
+	states, err := s.store.Bulk([]string{"usr_1"})
+	if err != nil {
+		t.Fatalf("bulk: %v", err)
+	}
+	activity := states[0].Activity
+	if activity == nil || activity.Type != ActivityPlaying || activity.Name != "Mango Quest" ||
+		activity.Details == nil || *activity.Details != "Level 3" || activity.StartedAt != "2026-01-02T03:04:05Z" {
+		t.Fatalf("unexpected activity %+v", activity)
+	}
+
+	if res := doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"activity":{"type":"sleeping","name":"zzz"}}`); res.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown activity type, got %d", res.Code)
+	}
+
+	s.clock.(*fakeClock).Advance(testPresenceTTL + time.Second)
+	if state := mustGet(t, s.store, "usr_1"); state.Activity != nil || state.Status != StatusOffline {
+		t.Fatalf("expected expired record to drop activity, got %+v", state)
+	}
+
+	doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{}`)
+	if state := mustGet(t, s.store, "usr_1"); state.Activity != nil {
+		t.Fatalf("expected activity not to be revived after expiry, got %+v", state.Activity)
+	}
+}
+
+func TestPresenceInvisibleIsOfflineToOthers(t *testing.T) {
+	s, pub := newTestServer(t)
+
+	doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"status":"online","customText":"hi"}`)
+	pub.take()
+
+	if res := doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"status":"invisible"}`); res.Code != http.StatusOK {
+		t.Fatalf("set invisible: status %d: %s", res.Code, res.Body.String())
+	}
+
+	var me PresenceState
+	res := doRequest(t, s.handlePresenceMe, http.MethodGet, "/v1/presence/me", "usr_1", "")
+	if err := json.Unmarshal(res.Body.Bytes(), &me); err != nil || me.Status != StatusInvisible {
+		t.Fatalf("expected me to show invisible, got %+v (%v)", me, err)
+	}
+
+	var bulk []PresenceState
+	res = doRequest(t, s.handlePresenceBulk, http.MethodPost, "/v1/presence/bulk", "usr_2", `{"userIds":["usr_1"]}`)
+	if err := json.Unmarshal(res.Body.Bytes(), &bulk); err != nil || len(bulk) != 1 {
+		t.Fatalf("unexpected bulk response %s (%v)", res.Body.String(), err)
+	}
+	if bulk[0].Status != StatusOffline || bulk[0].CustomText != nil || bulk[0].ExpiresAt != nil {
+		t.Fatalf("expected a bare offline state for others, got %+v", bulk[0])
+	}
+
+	events := pub.take()
+	if len(events) != 1 || events[0].Payload.(PresenceState).Status != StatusOffline {
+		t.Fatalf("expected an offline event for subscribers, got %+v", events)
+	}
+}
+
+func TestPresencePlatformsMerge(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	desktop := map[string]string{"X-Device-Id": "laptop"}
+	phone := map[string]string{"X-Device-Id": "phone"}
+
+	doRequestWithHeaders(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"platform":"desktop"}`, desktop)
+	if state := mustGet(t, s.store, "usr_1"); !slices.Equal(state.Platforms, []Platform{PlatformDesktop}) {
+		t.Fatalf("expected single desktop platform, got %v", state.Platforms)
+	}
+
+	doRequestWithHeaders(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"platform":"mobile"}`, phone)
+	doRequestWithHeaders(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{}`, desktop)
+	if state := mustGet(t, s.store, "usr_1"); !slices.Equal(state.Platforms, []Platform{PlatformDesktop, PlatformMobile}) {
+		t.Fatalf("expected merged platforms, got %v", state.Platforms)
+	}
+
+	if res := doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"platform":"fridge"}`); res.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown platform, got %d", res.Code)
+	}
+}
+
+func TestPresenceMergesDevicesWithIndependentExpiry(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	clock := s.clock.(*fakeClock)
+	phone := map[string]string{"X-Device-Id": "phone"}
+
+	doRequestWithHeaders(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"status":"dnd"}`, phone)
+	doRequestWithHeaders(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"status":"online"}`, map[string]string{"X-Device-Id": "laptop"})
+	if state := mustGet(t, s.store, "usr_1"); state.Status != StatusOnline {
+		t.Fatalf("expected online to win over dnd, got %s", state.Status)
+	}
+
+	// Only the phone keeps refreshing, so the laptop lapses first.
+	clock.Advance(testPresenceTTL / 2)
+	doRequestWithHeaders(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"status":"dnd"}`, phone)
+	clock.Advance(testPresenceTTL/2 + time.Second)
+
+	if state := mustGet(t, s.store, "usr_1"); state.Status != StatusDnd {
+		t.Fatalf("expected the remaining device's dnd once the laptop expired, got %s", state.Status)
+	}
+}
+
+func TestPresenceTTLOverrideAppliesAndSticks(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	clock := s.clock.(*fakeClock)
+	mobile := map[string]string{"X-Device-Id": "phone", "X-Presence-TTL-Seconds": "180"}
+
+	expiresIn := func(res *httptest.ResponseRecorder) time.Duration {
+		t.Helper()
+		var state PresenceState
+		if err := json.Unmarshal(res.Body.Bytes(), &state); err != nil || state.ExpiresAt == nil {
+			t.Fatalf("decode %s: %v", res.Body.String(), err)
+		}
+		expiresAt, err := time.Parse(time.RFC3339, *state.ExpiresAt)
+		if err != nil {
+			t.Fatalf("parse expiresAt: %v", err)
+		}
+		return expiresAt.Sub(clock.Now().Truncate(time.Second))
+	}
+
+	res := doRequestWithHeaders(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{}`, mobile)
+	if got := expiresIn(res); got != 3*time.Minute {
+		t.Fatalf("expected the requested TTL, got %v", got)
+	}
+
+	// The default TTL would have lapsed by now; the override keeps the
+	// device online, and a heartbeat without the header reuses it.
+	clock.Advance(2 * time.Minute)
+	if state := mustGet(t, s.store, "usr_1"); state.Status != StatusOnline {
+		t.Fatalf("expected the device to still be online, got %s", state.Status)
+	}
+	res = doRequestWithHeaders(t, s.handlePresenceHeartbeat, http.MethodPost, "/v1/presence/heartbeat", "usr_1", "", map[string]string{"X-Device-Id": "phone"})
+	if got := expiresIn(res); got != 3*time.Minute {
+		t.Fatalf("expected the heartbeat to reuse the override, got %v", got)
+	}
+
+	// Other devices keep the default.
+	res = doRequestWithHeaders(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_2", `{}`, map[string]string{"X-Device-Id": "laptop"})
+	if got := expiresIn(res); got != testPresenceTTL {
+		t.Fatalf("expected the default TTL without the header, got %v", got)
+	}
+}
+
+func TestPresenceTTLOverrideIsClamped(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	for _, tc := range []struct {
+		header string
+		want   time.Duration
+	}{
+		{"1", 15 * time.Second},
+		{"86400", 5 * time.Minute},
+	} {
+		res := doRequestWithHeaders(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{}`, map[string]string{"X-Presence-TTL-Seconds": tc.header})
+		var state PresenceState
+		if err := json.Unmarshal(res.Body.Bytes(), &state); err != nil || state.ExpiresAt == nil {
+			t.Fatalf("decode %s: %v", res.Body.String(), err)
+		}
+		want := s.clock.Now().Add(tc.want).UTC().Format(time.RFC3339)
+		if *state.ExpiresAt != want {
+			t.Fatalf("TTL %s: expected expiry %s, got %s", tc.header, want, *state.ExpiresAt)
+		}
+	}
+
+	res := doRequestWithHeaders(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{}`, map[string]string{"X-Presence-TTL-Seconds": "soon"})
+	if res.Code != http.StatusBadRequest {
+		t.Fatalf("expected a malformed TTL to be rejected, got %d", res.Code)
+	}
+}
+
+func TestPresenceHeartbeatKeepsStatus(t *testing.T) {
+	s, pub := newTestServer(t)
+	clock := s.clock.(*fakeClock)
+
+	doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"status":"dnd"}`)
+	pub.take()
+	before := mustGet(t, s.store, "usr_1")
+
+	clock.Advance(testPresenceTTL / 2)
+	res := doRequest(t, s.handlePresenceHeartbeat, http.MethodPost, "/v1/presence/heartbeat", "usr_1", "")
+	if res.Code != http.StatusOK {
+		t.Fatalf("heartbeat: status %d: %s", res.Code, res.Body.String())
+	}
+
+	after := mustGet(t, s.store, "usr_1")
+	if after.Status != StatusDnd {
+		t.Fatalf("expected the heartbeat to keep dnd, got %s", after.Status)
+	}
+	if after.ExpiresAt == nil || before.ExpiresAt == nil || *after.ExpiresAt <= *before.ExpiresAt {
+		t.Fatalf("expected the expiry to move forward, got %v then %v", before.ExpiresAt, after.ExpiresAt)
+	}
+	if events := pub.take(); len(events) != 0 {
+		t.Fatalf("expected no publish for an unchanged status, got %d", len(events))
+	}
+
+	// The original expiry has passed; only the heartbeat keeps the user dnd.
+	clock.Advance(testPresenceTTL/2 + time.Second)
+	if state := mustGet(t, s.store, "usr_1"); state.Status != StatusDnd {
+		t.Fatalf("expected the heartbeat to extend dnd, got %s", state.Status)
+	}
+}
+
+func TestPresenceHeartbeatDefaultsToOnline(t *testing.T) {
+	s, pub := newTestServer(t)
+
+	if res := doRequest(t, s.handlePresenceHeartbeat, http.MethodPost, "/v1/presence/heartbeat", "usr_1", ""); res.Code != http.StatusOK {
+		t.Fatalf("heartbeat: status %d: %s", res.Code, res.Body.String())
+	}
+	if state := mustGet(t, s.store, "usr_1"); state.Status != StatusOnline {
+		t.Fatalf("expected a first heartbeat to go online, got %s", state.Status)
+	}
+	if events := pub.take(); len(events) != 1 || events[0].Payload.(PresenceState).Status != StatusOnline {
+		t.Fatalf("expected an online event, got %+v", events)
+	}
+
+	if res := doRequest(t, s.handlePresenceHeartbeat, http.MethodPut, "/v1/presence/heartbeat", "usr_1", ""); res.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405 for PUT, got %d", res.Code)
+	}
+}
+
+func TestPresenceManualDndSurvivesAutomaticUpdates(t *testing.T) {
+	s, pub := newTestServer(t)
+	clock := s.clock.(*fakeClock)
+	laptop := map[string]string{"X-Device-Id": "laptop"}
+	phone := map[string]string{"X-Device-Id": "phone"}
+
+	doRequestWithHeaders(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"status":"dnd"}`, laptop)
+	pub.take()
+
+	// Heartbeats, a status-less update and a second device coming online
+	// are all automatic, so none of them may bring the user back online.
+	clock.Advance(testPresenceTTL / 2)
+	doRequestWithHeaders(t, s.handlePresenceHeartbeat, http.MethodPost, "/v1/presence/heartbeat", "usr_1", "", laptop)
+	doRequestWithHeaders(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"customText":"focusing"}`, laptop)
+	doRequestWithHeaders(t, s.handlePresenceHeartbeat, http.MethodPost, "/v1/presence/heartbeat", "usr_1", "", phone)
+	doRequestWithHeaders(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"platform":"mobile"}`, phone)
+
+	state := mustGet(t, s.store, "usr_1")
+	if state.Status != StatusDnd || !state.Manual {
+		t.Fatalf("expected a manual dnd, got %s (manual %v)", state.Status, state.Manual)
+	}
+	for _, event := range pub.take() {
+		if event.Payload.(PresenceState).Status != StatusDnd {
+			t.Fatalf("expected every event to stay dnd, got %+v", event.Payload)
+		}
+	}
+
+	// The laptop lapses; the phone inherited the manual dnd and keeps it.
+	clock.Advance(testPresenceTTL/2 + time.Second)
+	doRequestWithHeaders(t, s.handlePresenceHeartbeat, http.MethodPost, "/v1/presence/heartbeat", "usr_1", "", phone)
+	if state := mustGet(t, s.store, "usr_1"); state.Status != StatusDnd || !state.Manual {
+		t.Fatalf("expected dnd to outlive the laptop, got %s (manual %v)", state.Status, state.Manual)
+	}
+
+	// Only an explicit PUT changes it.
+	doRequestWithHeaders(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"status":"online"}`, phone)
+	if state := mustGet(t, s.store, "usr_1"); state.Status != StatusOnline {
+		t.Fatalf("expected an explicit PUT to change the status, got %s", state.Status)
+	}
+}
+
+func TestPresenceAutoOnlineIsUpgradable(t *testing.T) {
+	s, _ := newTestServer(t)
+	laptop := map[string]string{"X-Device-Id": "laptop"}
+	phone := map[string]string{"X-Device-Id": "phone"}
+
+	doRequestWithHeaders(t, s.handlePresenceHeartbeat, http.MethodPost, "/v1/presence/heartbeat", "usr_1", "", laptop)
+	if state := mustGet(t, s.store, "usr_1"); state.Status != StatusOnline || state.Manual {
+		t.Fatalf("expected an automatic online, got %s (manual %v)", state.Status, state.Manual)
+	}
+
+	// A manual idle on another device outranks the laptop's automatic online.
+	doRequestWithHeaders(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"status":"idle"}`, phone)
+	doRequestWithHeaders(t, s.handlePresenceHeartbeat, http.MethodPost, "/v1/presence/heartbeat", "usr_1", "", laptop)
+	if state := mustGet(t, s.store, "usr_1"); state.Status != StatusIdle || !state.Manual {
+		t.Fatalf("expected the manual idle to win, got %s (manual %v)", state.Status, state.Manual)
+	}
+}
+
+func TestPresenceLastOnlineSurvivesCleanup(t *testing.T) {
+	s, _ := newTestServer(t)
+	clock := s.clock.(*fakeClock)
+
+	doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"status":"dnd"}`)
+	if state := mustGet(t, s.store, "usr_1"); state.LastOnlineAt != nil {
+		t.Fatalf("expected no lastOnlineAt while online, got %v", *state.LastOnlineAt)
+	}
+	seen := clock.Now().UTC().Format(time.RFC3339)
+
+	// Going invisible hides the user without moving lastOnlineAt.
+	clock.Advance(10 * time.Second)
+	doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"status":"invisible"}`)
+	if state := mustGet(t, s.store, "usr_1"); state.LastOnlineAt == nil || *state.LastOnlineAt != seen {
+		t.Fatalf("expected lastOnlineAt %s while invisible, got %+v", seen, state)
+	}
+
+	clock.Advance(testPresenceTTL * (presenceRetentionTTLs + 2))
+	if err := s.store.CleanupExpired(); err != nil {
+		t.Fatalf("cleanup: %v", err)
+	}
+	if count, _ := s.store.Count(); count != 0 {
+		t.Fatalf("expected the record to be cleaned up, got %d", count)
+	}
+	if state := mustGet(t, s.store, "usr_1"); state.Status != StatusOffline || state.LastOnlineAt == nil || *state.LastOnlineAt != seen {
+		t.Fatalf("expected lastOnlineAt %s to outlive the record, got %+v", seen, state)
+	}
+
+	clock.Advance(testLastOnlineRetention)
+	if err := s.store.CleanupExpired(); err != nil {
+		t.Fatalf("cleanup: %v", err)
+	}
+	if state := mustGet(t, s.store, "usr_1"); state.LastOnlineAt != nil {
+		t.Fatalf("expected lastOnlineAt to be dropped after its retention, got %v", *state.LastOnlineAt)
+	}
+}
+
+func TestPresenceUpdatesAreRateLimited(t *testing.T) {
+	s, _ := newTestServer(t)
+	clock := s.clock.(*fakeClock)
+
+	statuses := []string{"dnd", "online"}
+	for i := 0; i < 5; i++ {
+		body := `{"status":"` + statuses[i%2] + `"}`
+		if res := doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", body); res.Code != http.StatusOK {
+			t.Fatalf("update %d: status %d: %s", i, res.Code, res.Body.String())
+		}
+	}
+
+	res := doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"status":"idle"}`)
+	if res.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 once the bucket is empty, got %d", res.Code)
+	}
+	if retryAfter := res.Header().Get("Retry-After"); retryAfter != "1" {
+		t.Fatalf("expected Retry-After 1, got %q", retryAfter)
+	}
+	if state := mustGet(t, s.store, "usr_1"); state.Status != StatusDnd {
+		t.Fatalf("expected the limited update to be dropped, got %s", state.Status)
+	}
+
+	clock.Advance(2 * time.Second)
+	if res := doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"status":"idle"}`); res.Code != http.StatusOK {
+		t.Fatalf("expected the bucket to refill, got %d", res.Code)
+	}
+}
+
+func TestPresenceRefreshesCostLessThanChanges(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	for i := 0; i < 10; i++ {
+		if res := doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"status":"online"}`); res.Code != http.StatusOK {
+			t.Fatalf("refresh %d: status %d", i, res.Code)
+		}
+	}
+
+	s.limiter.CleanupIdle(s.clock.Now().Add(10 * time.Second))
+	if len(s.limiter.buckets) != 0 {
+		t.Fatalf("expected refilled buckets to be dropped, got %d", len(s.limiter.buckets))
+	}
+}
+
+func TestPresenceBulkEnforcesLimit(t *testing.T) {
+	s, _ := newTestServer(t)
+	s.bulkMax = 3
+
+	bulkBody := func(userIDs ...string) string {
+		encoded, _ := json.Marshal(bulkPresenceRequest{UserIDs: userIDs})
+		return string(encoded)
+	}
+
+	res := doRequest(t, s.handlePresenceBulk, http.MethodPost, "/v1/presence/bulk", "usr_1", bulkBody("usr_1", "usr_2", "usr_3"))
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected a request at the limit to succeed, got %d: %s", res.Code, res.Body.String())
+	}
+
+	// Duplicates still count towards the limit.
+	res = doRequest(t, s.handlePresenceBulk, http.MethodPost, "/v1/presence/bulk", "usr_1", bulkBody("usr_1", "usr_1", "usr_1", "usr_1"))
+	if res.Code != http.StatusBadRequest || !strings.Contains(res.Body.String(), "at most 3") {
+		t.Fatalf("expected 400 naming the limit, got %d: %s", res.Code, res.Body.String())
+	}
+
+	res = doRequest(t, s.handlePresenceBulk, http.MethodPost, "/v1/presence/bulk", "usr_1", bulkBody(strings.Repeat("x", int(bulkBodyLimit(3)))))
+	if res.Code != http.StatusRequestEntityTooLarge || !strings.Contains(res.Body.String(), "too large") {
+		t.Fatalf("expected an oversized body to be rejected with 413, got %d: %s", res.Code, res.Body.String())
+	}
+}
+
+func bulkLookup(t *testing.T, s *server, body, etag string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	headers := map[string]string{}
+	if etag != "" {
+		headers["If-None-Match"] = etag
+	}
+	return doRequestWithHeaders(t, s.handlePresenceBulk, http.MethodPost, "/v1/presence/bulk", "usr_1", body, headers)
+}
+
+func TestPresenceBulkETag(t *testing.T) {
+	s, _ := newTestServer(t)
+	clock := s.clock.(*fakeClock)
+	body := `{"userIds":["usr_2","usr_3"]}`
+
+	doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_2", `{"status":"dnd"}`)
+	res := bulkLookup(t, s, body, "")
+	etag := res.Header().Get("ETag")
+	if res.Code != http.StatusOK || etag == "" {
+		t.Fatalf("expected 200 with an ETag, got %d %q", res.Code, etag)
+	}
+
+	res = bulkLookup(t, s, body, etag)
+	if res.Code != http.StatusNotModified || res.Body.Len() != 0 || res.Header().Get("ETag") != etag {
+		t.Fatalf("expected an empty 304 when nothing changed, got %d: %s", res.Code, res.Body.String())
+	}
+
+	// A heartbeat is a write even when nothing visible changes.
+	doRequest(t, s.handlePresenceHeartbeat, http.MethodPost, "/v1/presence/heartbeat", "usr_2", "")
+	res = bulkLookup(t, s, body, etag)
+	if res.Code != http.StatusOK || res.Header().Get("ETag") == etag {
+		t.Fatalf("expected a write to change the ETag, got %d", res.Code)
+	}
+
+	// Expiring changes what the viewer gets without a write.
+	etag = res.Header().Get("ETag")
+	clock.Advance(2 * testPresenceTTL)
+	if res := bulkLookup(t, s, body, etag); res.Code != http.StatusOK {
+		t.Fatalf("expected expiry to change the ETag, got %d", res.Code)
+	}
+}
+
+func TestPresenceRejectsOversizedBody(t *testing.T) {
+	s, pub := newTestServer(t)
+	s.maxBodyBytes = 64
+
+	body := `{"customText":"` + strings.Repeat("x", 64) + `"}`
+	res := doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", body)
+	if res.Code != http.StatusRequestEntityTooLarge || !strings.Contains(res.Body.String(), "at most 64 bytes") {
+		t.Fatalf("expected 413 naming the limit, got %d: %s", res.Code, res.Body.String())
+	}
+	if events := pub.take(); len(events) != 0 {
+		t.Fatalf("expected nothing to be stored or published, got %+v", events)
+	}
+
+	// Malformed bodies under the limit are still a 400.
+	res = doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"customText":`)
+	if res.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid JSON, got %d", res.Code)
+	}
+}
+
+func TestPresenceServerCountBucketsMembers(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	for userID, status := range map[string]string{
+		"usr_1": "online",
+		"usr_2": "online",
+		"usr_3": "idle",
+		"usr_4": "dnd",
+		"usr_5": "invisible",
+	} {
+		if res := doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", userID, `{"status":"`+status+`"}`); res.Code != http.StatusOK {
+			t.Fatalf("set %s %s: status %d: %s", userID, status, res.Code, res.Body.String())
+		}
+	}
+
+	body := `{"userIds":["usr_1","usr_2","usr_3","usr_4","usr_5","usr_6","usr_1"]}`
+	res := doRequest(t, s.handlePresenceServerCount, http.MethodPost, "/v1/presence/servers/srv_1/count", "usr_1", body)
+	if res.Code != http.StatusOK {
+		t.Fatalf("count: status %d: %s", res.Code, res.Body.String())
+	}
+
+	var got struct {
+		ServerID string         `json:"serverId"`
+		Total    int            `json:"total"`
+		Counts   presenceCounts `json:"counts"`
+	}
+	if err := json.Unmarshal(res.Body.Bytes(), &got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	want := presenceCounts{Online: 2, Idle: 1, Dnd: 1, Offline: 2}
+	if got.ServerID != "srv_1" || got.Total != 6 || got.Counts != want {
+		t.Fatalf("expected 6 members counted as %+v, got %+v", want, got)
+	}
+
+	res = doRequest(t, s.handlePresenceServerCount, http.MethodPost, "/v1/presence/servers/srv_1/members", "usr_1", body)
+	if res.Code != http.StatusNotFound {
+		t.Fatalf("expected unknown server routes to 404, got %d", res.Code)
+	}
+}
+
+func TestPresenceServerCountEnforcesBulkLimit(t *testing.T) {
+	s, _ := newTestServer(t)
+	s.bulkMax = 3
+
+	res := doRequest(t, s.handlePresenceServerCount, http.MethodPost, "/v1/presence/servers/srv_1/count", "usr_1", `{"userIds":["usr_1","usr_2","usr_3","usr_4"]}`)
+	if res.Code != http.StatusBadRequest || !strings.Contains(res.Body.String(), "at most 3") {
+		t.Fatalf("expected 400 naming the limit, got %d: %s", res.Code, res.Body.String())
+	}
+}
+
+func TestTypingPublishesAndCollapsesRepeats(t *testing.T) {
+	s, pub := newTestServer(t)
+
+	for i := 0; i < 3; i++ {
+		if res := doRequest(t, s.handleTyping, http.MethodPost, "/v1/typing", "usr_1", `{"channelId":"chn_1"}`); res.Code != http.StatusOK {
+			t.Fatalf("typing %d: status %d: %s", i, res.Code, res.Body.String())
+		}
+	}
+	doRequest(t, s.handleTyping, http.MethodPost, "/v1/typing", "usr_2", `{"channelId":"chn_1"}`)
+
+	events := pub.take()
+	if len(events) != 2 {
+		t.Fatalf("expected one publish per user within the window, got %d", len(events))
+	}
+	indicator, ok := events[0].Payload.(TypingIndicator)
+	if events[0].Topic != "typing:chn_1" || events[0].EventType != "typing.updated" || !ok || indicator.UserID != "usr_1" || !indicator.IsTyping {
+		t.Fatalf("unexpected event %+v", events[0])
+	}
+
+	if !s.typing.Allow("usr_1", "chn_1", s.clock.Now().Add(typingDedupeWindow)) {
+		t.Fatal("expected typing to publish again once the window passed")
+	}
+}
+
+func TestPresenceRejectsUnknownFields(t *testing.T) {
+	s, pub := newTestServer(t)
+
+	res := doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"staus":"dnd"}`)
+	if res.Code != http.StatusBadRequest || !strings.Contains(res.Body.String(), `Unknown field \"staus\"`) {
+		t.Fatalf("expected 400 naming the field, got %d: %s", res.Code, res.Body.String())
+	}
+	if events := pub.take(); len(events) != 0 {
+		t.Fatalf("expected nothing to be stored or published, got %+v", events)
+	}
+
+	res = doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"status":"dnd"}`)
+	if state := decodeState(t, res); state.Status != StatusDnd {
+		t.Fatalf("expected a valid body to still work, got %s", state.Status)
+	}
+
+	res = doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"status":"dnd"} {}`)
+	if res.Code != http.StatusBadRequest {
+		t.Fatalf("expected trailing data to be rejected, got %d", res.Code)
+	}
+}
+
+func TestPresenceRequiresJSONContentType(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	res := doRequestWithHeaders(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"status":"dnd"}`, map[string]string{"Content-Type": "text/plain"})
+	if res.Code != http.StatusUnsupportedMediaType || !strings.Contains(res.Body.String(), "application/json") {
+		t.Fatalf("expected 415 for text/plain, got %d: %s", res.Code, res.Body.String())
+	}
+
+	res = doRequestWithHeaders(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"status":"dnd"}`, map[string]string{"Content-Type": "application/json; charset=UTF-8"})
+	if state := decodeState(t, res); state.Status != StatusDnd {
+		t.Fatalf("expected a charset parameter to be accepted, got %s", state.Status)
+	}
+
+	// An empty body needs no Content-Type at all.
+	if res := doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_2", ""); res.Code != http.StatusOK {
+		t.Fatalf("expected an empty body to be exempt, got %d: %s", res.Code, res.Body.String())
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPresenceMetricsScrape(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_3", `{"status":"online"}`)
+	s.clock.(*fakeClock).Advance(7 * testPresenceTTL)
+
+	doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"status":"online"}`)
+	doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_2", `{"status":"dnd"}`)
+	doRequest(t, s.handlePresenceBulk, http.MethodPost, "/v1/presence/bulk", "usr_1", `{"userIds":["usr_2"]}`)
+	if err := s.store.CleanupExpired(); err != nil {
+		t.Fatalf("cleanup: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	s.metrics.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+
+	for _, line := range []string{
+		"presence_records_total 2",
+		`presence_upserts_total{status="online"} 2`,
+		`presence_upserts_total{status="dnd"} 1`,
+		"presence_bulk_requests_total 1",
+		"presence_expired_cleaned_total 1",
+	} {
+		if !strings.Contains(string(body), line) {
+			t.Fatalf("expected %q in scrape output:\n%s", line, body)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// PresenceVisibility is who may see a user's real presence. Everyone else
+// sees them as offline, the same as a user who was never seen.
+type PresenceVisibility string
+
+const (
+	VisibilityEveryone PresenceVisibility = "everyone"
+	VisibilityFriends  PresenceVisibility = "friends"
+	VisibilityNobody   PresenceVisibility = "nobody"
+)
+
+func parseVisibility(value string) (PresenceVisibility, error) {
+	switch visibility := PresenceVisibility(value); visibility {
+	case VisibilityEveryone, VisibilityFriends, VisibilityNobody:
+		return visibility, nil
+	default:
+		return "", errors.New("visibility must be everyone, friends or nobody.")
+	}
+}
+
+// public is the resolved record as users who may not see it get it: a
+// hidden user collapses to a bare offline record, like an invisible one.
+func (r presenceRecord) public() presenceRecord {
+	r = r.visible()
+	if r.Visibility == "" || r.Visibility == VisibilityEveryone {
+		return r
+	}
+
+	return presenceRecord{
+		Status:     StatusOffline,
+		Visibility: r.Visibility,
+	}
+}
+
+// hiddenState is what a viewer who may not see the user gets: offline with
+// nothing about when they were last around.
+func hiddenState(userID string, now time.Time) PresenceState {
+	return offlineState(userID, now, time.Time{})
+}
+
+type friendsCheckRequest struct {
+	ViewerID string   `json:"viewerId"`
+	UserIDs  []string `json:"userIds"`
+}
+
+type friendsCheckResponse struct {
+	FriendIDs []string `json:"friendIds"`
+}
+
+// friendsChecker asks another service which of userIDs count viewerID as a
+// friend.
+type friendsChecker interface {
+	Friends(ctx context.Context, viewerID string, userIDs []string) (map[string]bool, error)
+}
+
+// httpFriendsChecker posts {viewerId, userIds} to url and expects the subset
+// that are friends back as {friendIds}.
+type httpFriendsChecker struct {
+	url    string
+	client *http.Client
+}
+
+func newHTTPFriendsChecker(url string, timeout time.Duration) *httpFriendsChecker {
+	return &httpFriendsChecker{url: url, client: &http.Client{Timeout: timeout}}
+}
+
+func (c *httpFriendsChecker) Friends(ctx context.Context, viewerID string, userIDs []string) (map[string]bool, error) {
+	payload, err := json.Marshal(friendsCheckRequest{ViewerID: viewerID, UserIDs: userIDs})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("friends check returned %d", res.StatusCode)
+	}
+
+	var body friendsCheckResponse
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+
+	friends := make(map[string]bool, len(body.FriendIDs))
+	for _, id := range body.FriendIDs {
+		friends[id] = true
+	}
+	return friends, nil
+}
+
+// revealPresence rewrites states for viewerID: users hiding from the viewer
+// are reported offline. Users always see themselves, and an empty viewerID,
+// such as the subscribers of a presence topic, is nobody's friend. Friends
+// are looked up in one call; if it fails, or no checker is configured,
+// friends-only users stay hidden rather than leak.
+func (s *server) revealPresence(ctx context.Context, viewerID string, states []PresenceState) []PresenceState {
+	var friendsOnly []string
+	for _, state := range states {
+		if viewerID != "" && state.Visibility == VisibilityFriends && state.UserID != viewerID {
+			friendsOnly = append(friendsOnly, state.UserID)
+		}
+	}
+
+	var friends map[string]bool
+	if len(friendsOnly) > 0 && s.friends != nil {
+		var err error
+		friends, err = s.friends.Friends(ctx, viewerID, friendsOnly)
+		if err != nil {
+			slog.Error("friends check failed", "viewerId", viewerID, "error", err)
+		}
+	}
+
+	now := s.clock.Now().UTC()
+	revealed := make([]PresenceState, len(states))
+	for i, state := range states {
+		switch {
+		case state.UserID == viewerID:
+		case state.Visibility == VisibilityNobody,
+			state.Visibility == VisibilityFriends && !friends[state.UserID]:
+			state = hiddenState(state.UserID, now)
+		}
+		state.Visibility = ""
+		revealed[i] = state
+	}
+	return revealed
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+// newTestFriendsChecker serves the friends check from a fixed list of
+// viewer -> user friendships.
+func newTestFriendsChecker(t *testing.T, friendships map[string][]string) *httpFriendsChecker {
+	t.Helper()
+
+	friends := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body friendsCheckRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		response := friendsCheckResponse{FriendIDs: []string{}}
+		for _, userID := range body.UserIDs {
+			if slices.Contains(friendships[body.ViewerID], userID) {
+				response.FriendIDs = append(response.FriendIDs, userID)
+			}
+		}
+		_ = json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(friends.Close)
+
+	return newHTTPFriendsChecker(friends.URL, time.Second)
+}
+
+func decodeState(t *testing.T, res *httptest.ResponseRecorder) PresenceState {
+	t.Helper()
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", res.Code, res.Body.String())
+	}
+	var state PresenceState
+	if err := json.Unmarshal(res.Body.Bytes(), &state); err != nil {
+		t.Fatalf("decode %s: %v", res.Body.String(), err)
+	}
+	return state
+}
+
+func TestPresenceFriendsVisibility(t *testing.T) {
+	s, pub := newTestServer(t)
+	s.friends = newTestFriendsChecker(t, map[string][]string{"usr_friend": {"usr_1"}})
+
+	res := doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"status":"dnd","customText":"busy","visibility":"friends"}`)
+	if own := decodeState(t, res); own.Status != StatusDnd || own.Visibility != VisibilityFriends {
+		t.Fatalf("expected the own view to keep the real status and visibility, got %+v", own)
+	}
+
+	// Everyone else saw nothing before and still sees nothing.
+	if events := pub.take(); len(events) != 0 {
+		t.Fatalf("expected no publish for a user hidden from the start, got %+v", events)
+	}
+
+	friend := decodeState(t, doRequest(t, s.handlePresenceByUserID, http.MethodGet, "/v1/presence/usr_1", "usr_friend", ""))
+	if friend.Status != StatusDnd || friend.CustomText == nil || *friend.CustomText != "busy" {
+		t.Fatalf("expected a friend to see the real status, got %+v", friend)
+	}
+	if friend.Visibility != "" {
+		t.Fatalf("expected visibility to stay out of other users' views, got %q", friend.Visibility)
+	}
+
+	stranger := decodeState(t, doRequest(t, s.handlePresenceByUserID, http.MethodGet, "/v1/presence/usr_1", "usr_stranger", ""))
+	if stranger.Status != StatusOffline || stranger.CustomText != nil || stranger.LastOnlineAt != nil {
+		t.Fatalf("expected a non-friend to see a bare offline state, got %+v", stranger)
+	}
+
+	res = doRequest(t, s.handlePresenceBulk, http.MethodPost, "/v1/presence/bulk", "usr_stranger", `{"userIds":["usr_1"]}`)
+	var states []PresenceState
+	if err := json.Unmarshal(res.Body.Bytes(), &states); err != nil || len(states) != 1 {
+		t.Fatalf("decode %s: %v", res.Body.String(), err)
+	}
+	if states[0].Status != StatusOffline {
+		t.Fatalf("expected bulk to hide the user from a non-friend, got %+v", states[0])
+	}
+}
+
+func TestPresenceFriendsVisibilityFailsClosed(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"visibility":"friends"}`)
+
+	// Without a friends check nobody can be shown to be a friend.
+	state := decodeState(t, doRequest(t, s.handlePresenceByUserID, http.MethodGet, "/v1/presence/usr_1", "usr_friend", ""))
+	if state.Status != StatusOffline {
+		t.Fatalf("expected friends-only presence to stay hidden, got %s", state.Status)
+	}
+
+	self := decodeState(t, doRequest(t, s.handlePresenceByUserID, http.MethodGet, "/v1/presence/usr_1", "usr_1", ""))
+	if self.Status != StatusOnline {
+		t.Fatalf("expected users to always see themselves, got %s", self.Status)
+	}
+}
+
+func TestPresenceNobodyVisibility(t *testing.T) {
+	s, pub := newTestServer(t)
+	s.friends = newTestFriendsChecker(t, map[string][]string{"usr_friend": {"usr_1"}})
+
+	doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{}`)
+	pub.take()
+
+	res := doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"visibility":"nobody"}`)
+	if own := decodeState(t, res); own.Status != StatusOnline || own.Visibility != VisibilityNobody {
+		t.Fatalf("expected the own view to stay online, got %+v", own)
+	}
+
+	// Hiding is a change everyone notices: they see the user go offline.
+	events := pub.take()
+	if len(events) != 1 {
+		t.Fatalf("expected one publish, got %d", len(events))
+	}
+	if published := events[0].Payload.(PresenceState); published.Status != StatusOffline || published.Visibility != "" {
+		t.Fatalf("expected an offline publish, got %+v", published)
+	}
+
+	for _, viewer := range []string{"usr_friend", "usr_stranger"} {
+		state := decodeState(t, doRequest(t, s.handlePresenceByUserID, http.MethodGet, "/v1/presence/usr_1", viewer, ""))
+		if state.Status != StatusOffline {
+			t.Fatalf("expected %s to see the user offline, got %s", viewer, state.Status)
+		}
+	}
+
+	// Further updates stay private.
+	doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"status":"idle"}`)
+	if events := pub.take(); len(events) != 0 {
+		t.Fatalf("expected no publish while hidden, got %+v", events)
+	}
+
+	res = doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"visibility":"sideways"}`)
+	if res.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown visibility, got %d", res.Code)
+	}
+}
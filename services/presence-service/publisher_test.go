@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestPresenceSurvivesGatewayOutage(t *testing.T) {
+	var hits atomic.Int64
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer gateway.Close()
+
+	s, _ := newTestServer(t)
+	s.publisher = newGatewayPublisher(gateway.URL, "", time.Second, 8, s.metrics.publishFailures)
+
+	if res := doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"status":"dnd"}`); res.Code != http.StatusOK {
+		t.Fatalf("expected the update to succeed while the gateway is down, got %d: %s", res.Code, res.Body.String())
+	}
+
+	drainPublisher(context.Background(), s.publisher)
+	if hits.Load() != 1 {
+		t.Fatalf("expected one delivery attempt, got %d", hits.Load())
+	}
+	if got := testutil.ToFloat64(s.metrics.publishFailures.WithLabelValues("error")); got != 1 {
+		t.Fatalf("expected one failed publish, got %v", got)
+	}
+}
+
+func TestGatewayPublisherDropsWhenQueueFull(t *testing.T) {
+	received := make(chan struct{}, publishWorkers)
+	release := make(chan struct{})
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		received <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer gateway.Close()
+
+	metrics := newPresenceMetrics()
+	pub := newGatewayPublisher(gateway.URL, "", time.Second, 1, metrics.publishFailures)
+
+	// Tie up every worker, fill the one queue slot, then overflow it.
+	for range publishWorkers {
+		pub.Publish("presence:usr_1", "presence.updated", nil)
+		<-received
+	}
+	pub.Publish("presence:usr_1", "presence.updated", nil)
+	pub.Publish("presence:usr_1", "presence.updated", nil)
+	if got := testutil.ToFloat64(metrics.publishFailures.WithLabelValues("dropped")); got != 1 {
+		t.Fatalf("expected one dropped event, got %v", got)
+	}
+
+	close(release)
+	drainPublisher(context.Background(), pub)
+	if got := testutil.ToFloat64(metrics.publishFailures.WithLabelValues("error")); got != 0 {
+		t.Fatalf("expected the queued events to be delivered, got %v failures", got)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// refreshCost is what an update that leaves the visible presence unchanged
+// costs, so clients refreshing their TTL on a timer keep most of their
+// budget for real status changes.
+const refreshCost = 0.2
+
+type rateBucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+// presenceRateLimiter is a per-user token bucket: burst tokens that refill
+// evenly over window. Updates are checked before they run and charged after,
+// once it is known whether they changed anything.
+type presenceRateLimiter struct {
+	mu      sync.Mutex
+	burst   float64
+	window  time.Duration
+	buckets map[string]*rateBucket
+}
+
+func newPresenceRateLimiter(burst int, window time.Duration) *presenceRateLimiter {
+	return &presenceRateLimiter{
+		burst:   float64(burst),
+		window:  window,
+		buckets: map[string]*rateBucket{},
+	}
+}
+
+func (l *presenceRateLimiter) refillLocked(userID string, now time.Time) *rateBucket {
+	bucket, ok := l.buckets[userID]
+	if !ok {
+		bucket = &rateBucket{tokens: l.burst, updatedAt: now}
+		l.buckets[userID] = bucket
+		return bucket
+	}
+
+	elapsed := now.Sub(bucket.updatedAt)
+	if elapsed > 0 {
+		bucket.tokens = math.Min(l.burst, bucket.tokens+l.burst*elapsed.Seconds()/l.window.Seconds())
+		bucket.updatedAt = now
+	}
+	return bucket
+}
+
+// Check reports whether userID may make another update. When it may not, it
+// returns how long until enough tokens have refilled.
+func (l *presenceRateLimiter) Check(userID string, now time.Time) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	bucket := l.refillLocked(userID, now)
+	if bucket.tokens >= refreshCost {
+		return 0, true
+	}
+
+	missing := refreshCost - bucket.tokens
+	return time.Duration(missing / l.burst * float64(l.window)), false
+}
+
+// Charge takes cost tokens from userID's bucket. The balance may dip below
+// zero when a full-cost update was admitted on a partial token.
+func (l *presenceRateLimiter) Charge(userID string, cost float64, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refillLocked(userID, now).tokens -= cost
+}
+
+// CleanupIdle drops buckets that have refilled completely; a fresh bucket
+// behaves the same.
+func (l *presenceRateLimiter) CleanupIdle(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for userID := range l.buckets {
+		if l.refillLocked(userID, now).tokens >= l.burst {
+			delete(l.buckets, userID)
+		}
+	}
+}
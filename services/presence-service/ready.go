@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// readinessTimeout bounds each dependency probe so a hung dependency reports
+// as not ready instead of stalling the probe.
+const readinessTimeout = 2 * time.Second
+
+// readinessCheck probes one dependency; a nil error means it is usable.
+type readinessCheck struct {
+	name  string
+	probe func(ctx context.Context) error
+}
+
+// runReadinessChecks runs every check and reports each one's status ("ok" or
+// the error) plus whether all of them passed.
+func runReadinessChecks(ctx context.Context, checks []readinessCheck) (map[string]string, bool) {
+	statuses := make(map[string]string, len(checks))
+	ready := true
+	for _, check := range checks {
+		checkCtx, cancel := context.WithTimeout(ctx, readinessTimeout)
+		err := check.probe(checkCtx)
+		cancel()
+
+		if err != nil {
+			statuses[check.name] = err.Error()
+			ready = false
+			continue
+		}
+		statuses[check.name] = "ok"
+	}
+	return statuses, ready
+}
+
+// readinessResponse is the /ready status code and body for the results of
+// runReadinessChecks.
+func readinessResponse(service string, statuses map[string]string, ready bool) (int, map[string]any) {
+	status, code := "ready", http.StatusOK
+	if !ready {
+		status, code = "not_ready", http.StatusServiceUnavailable
+	}
+	return code, map[string]any{
+		"service": service,
+		"status":  status,
+		"checks":  statuses,
+	}
+}
+
+// pingHealth expects a 2xx from baseURL's /health endpoint.
+func pingHealth(ctx context.Context, client *http.Client, baseURL string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/health", nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("health check returned %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestReadyChecksIdentityService(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	var healthy atomic.Bool
+	healthy.Store(true)
+	identity := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" || !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(identity.Close)
+	s.identityServiceURL = identity.URL
+
+	var body struct {
+		Status string            `json:"status"`
+		Checks map[string]string `json:"checks"`
+	}
+
+	res := doRequest(t, s.handleReady, http.MethodGet, "/ready", "", "")
+	if err := json.Unmarshal(res.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if res.Code != http.StatusOK || body.Status != "ready" || body.Checks["identity"] != "ok" || body.Checks["store"] != "ok" {
+		t.Fatalf("expected ready, got %d %+v", res.Code, body)
+	}
+
+	healthy.Store(false)
+	res = doRequest(t, s.handleReady, http.MethodGet, "/ready", "", "")
+	if err := json.Unmarshal(res.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if res.Code != http.StatusServiceUnavailable || body.Status != "not_ready" || body.Checks["identity"] == "ok" || body.Checks["store"] != "ok" {
+		t.Fatalf("expected the identity failure to be reported, got %d %+v", res.Code, body)
+	}
+
+	// /health stays a liveness probe regardless of dependencies.
+	if res := doRequest(t, s.handleHealth, http.MethodGet, "/health", "", ""); res.Code != http.StatusOK {
+		t.Fatalf("expected /health to stay ok, got %d", res.Code)
+	}
+}
+
+// unreachableStore fails its ping and must not be counted, as a probe over a
+// large Redis keyspace would time out.
+type unreachableStore struct {
+	PresenceStore
+}
+
+func (unreachableStore) Ping(context.Context) error {
+	return errors.New("connection refused")
+}
+
+func (unreachableStore) Count() (int, error) {
+	panic("readiness must not count records")
+}
+
+func TestReadyPingsStore(t *testing.T) {
+	s, _ := newTestServer(t)
+	identity := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(identity.Close)
+	s.identityServiceURL = identity.URL
+	s.store = unreachableStore{PresenceStore: s.store}
+
+	var body struct {
+		Status string            `json:"status"`
+		Checks map[string]string `json:"checks"`
+	}
+	res := doRequest(t, s.handleReady, http.MethodGet, "/ready", "", "")
+	if err := json.Unmarshal(res.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if res.Code != http.StatusServiceUnavailable || body.Checks["identity"] != "ok" || body.Checks["store"] == "ok" {
+		t.Fatalf("expected the store failure to be reported, got %d %+v", res.Code, body)
+	}
+}
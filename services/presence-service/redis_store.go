@@ -0,0 +1,402 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	redisPresenceKeyPrefix = "presence:user:"
+	redisLastOnlinePrefix  = "presence:lastonline:"
+	redisSweepPrefix       = "presence:sweep:"
+	redisOpTimeout         = 2 * time.Second
+	redisUpsertAttempts    = 5
+)
+
+var errPresenceConflict = errors.New("presence update kept conflicting with concurrent writers")
+
+// redisPresenceStore keeps one hash per user, plus a separate lastOnlineAt
+// key that outlives it. Keys carry a native expiry, so Redis drops stale
+// records itself and CleanupExpired has nothing to do.
+type redisPresenceStore struct {
+	client              *redis.Client
+	ttl                 time.Duration
+	lastOnlineRetention time.Duration
+	metrics             *presenceMetrics
+	clock               Clock
+	// instanceID marks the sweep locks this replica holds.
+	instanceID string
+}
+
+func newRedisPresenceStore(redisURL string, ttl, lastOnlineRetention time.Duration, clock Clock, metrics *presenceMetrics) (*redisPresenceStore, error) {
+	options, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+	}
+
+	client := redis.NewClient(options)
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("failed to reach Redis: %w", err)
+	}
+
+	return &redisPresenceStore{
+		client:              client,
+		ttl:                 ttl,
+		lastOnlineRetention: lastOnlineRetention,
+		metrics:             metrics,
+		clock:               clock,
+		instanceID:          "prs_" + randomSuffix(8),
+	}, nil
+}
+
+func redisPresenceKey(userID string) string {
+	return redisPresenceKeyPrefix + userID
+}
+
+func redisLastOnlineKey(userID string) string {
+	return redisLastOnlinePrefix + userID
+}
+
+// decodeLastOnline reads a lastOnlineAt key; a missing key is the zero time.
+func decodeLastOnline(cmd *redis.StringCmd) (time.Time, error) {
+	raw, err := cmd.Result()
+	if errors.Is(err, redis.Nil) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	parsed, err := time.Parse(time.RFC3339Nano, raw)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("corrupt presence lastOnlineAt: %w", err)
+	}
+	return parsed, nil
+}
+
+func encodeRedisRecord(record presenceRecord) (map[string]any, error) {
+	devices, err := json.Marshal(record.Devices)
+	if err != nil {
+		return nil, err
+	}
+
+	activity := ""
+	if record.Activity != nil {
+		encoded, err := json.Marshal(record.Activity)
+		if err != nil {
+			return nil, err
+		}
+		activity = string(encoded)
+	}
+
+	return map[string]any{
+		"customText":    record.CustomText,
+		"activity":      activity,
+		"devices":       string(devices),
+		"lastSeenAt":    record.LastSeenAt.UTC().Format(time.RFC3339Nano),
+		"expiresAt":     record.ExpiresAt.UTC().Format(time.RFC3339Nano),
+		"lastVisibleAt": record.LastVisibleAt.UTC().Format(time.RFC3339Nano),
+		"visibility":    string(record.Visibility),
+		"version":       record.Version,
+	}, nil
+}
+
+func decodeRedisRecord(fields map[string]string) (presenceRecord, bool, error) {
+	if len(fields) == 0 {
+		return presenceRecord{}, false, nil
+	}
+
+	record := presenceRecord{
+		CustomText: fields["customText"],
+		Visibility: PresenceVisibility(fields["visibility"]),
+	}
+	if err := json.Unmarshal([]byte(fields["devices"]), &record.Devices); err != nil {
+		return presenceRecord{}, false, fmt.Errorf("corrupt presence devices: %w", err)
+	}
+	if raw := fields["activity"]; raw != "" {
+		record.Activity = &activityRecord{}
+		if err := json.Unmarshal([]byte(raw), record.Activity); err != nil {
+			return presenceRecord{}, false, fmt.Errorf("corrupt presence activity: %w", err)
+		}
+	}
+
+	// Records written before versions were stored start at zero.
+	if raw := fields["version"]; raw != "" {
+		version, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return presenceRecord{}, false, fmt.Errorf("corrupt presence version: %w", err)
+		}
+		record.Version = version
+	}
+
+	for name, target := range map[string]*time.Time{
+		"lastSeenAt":    &record.LastSeenAt,
+		"expiresAt":     &record.ExpiresAt,
+		"lastVisibleAt": &record.LastVisibleAt,
+	} {
+		parsed, err := time.Parse(time.RFC3339Nano, fields[name])
+		if err != nil {
+			return presenceRecord{}, false, fmt.Errorf("corrupt presence %s: %w", name, err)
+		}
+		*target = parsed
+	}
+
+	return record, true, nil
+}
+
+// Upsert runs the same merge as the in-memory store inside a WATCH/MULTI
+// transaction, retrying when another replica writes the key concurrently.
+func (s *redisPresenceStore) Upsert(userID string, update presenceUpdate) (PresenceState, presenceChange, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+
+	key := redisPresenceKey(userID)
+	var record, previous presenceRecord
+	var now time.Time
+
+	txn := func(tx *redis.Tx) error {
+		fields, err := tx.HGetAll(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+		stored, ok, err := decodeRedisRecord(fields)
+		if err != nil {
+			return err
+		}
+
+		now = s.clock.Now().UTC()
+		record, previous = applyPresenceUpdate(stored, ok, update, now, s.ttl)
+		encoded, err := encodeRedisRecord(record)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, key)
+			pipe.HSet(ctx, key, encoded)
+			pipe.PExpireAt(ctx, key, record.ExpiresAt.Add(presenceRetentionTTLs*s.ttl))
+			if record.seenOnline() {
+				pipe.Set(ctx, redisLastOnlineKey(userID), now.Format(time.RFC3339Nano), s.lastOnlineRetention)
+			}
+			return nil
+		})
+		return err
+	}
+
+	for attempt := 0; attempt < redisUpsertAttempts; attempt++ {
+		err := s.client.Watch(ctx, txn, key)
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		if err != nil {
+			return PresenceState{}, presenceChange{}, err
+		}
+
+		s.metrics.upserts.WithLabelValues(string(record.Devices[update.device()].Status)).Inc()
+		return record.state(userID, now), newPresenceChange(userID, previous, record, now), nil
+	}
+
+	return PresenceState{}, presenceChange{}, errPresenceConflict
+}
+
+func (s *redisPresenceStore) Get(userID string) (PresenceState, error) {
+	return s.get(userID, false)
+}
+
+func (s *redisPresenceStore) GetOwn(userID string) (PresenceState, error) {
+	return s.get(userID, true)
+}
+
+func (s *redisPresenceStore) get(userID string, own bool) (PresenceState, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+
+	pipe := s.client.Pipeline()
+	fields := pipe.HGetAll(ctx, redisPresenceKey(userID))
+	lastOnline := pipe.Get(ctx, redisLastOnlineKey(userID))
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return PresenceState{}, err
+	}
+
+	record, ok, err := decodeRedisRecord(fields.Val())
+	if err != nil {
+		return PresenceState{}, err
+	}
+	lastOnlineAt, err := decodeLastOnline(lastOnline)
+	if err != nil {
+		return PresenceState{}, err
+	}
+
+	return viewRecord(userID, record, ok, own, lastOnlineAt, s.clock.Now().UTC()), nil
+}
+
+// Bulk fetches every hash and lastOnlineAt in one pipelined round trip.
+func (s *redisPresenceStore) Bulk(userIDs []string) ([]PresenceState, error) {
+	unique := uniqueUserIDs(userIDs)
+	if len(unique) == 0 {
+		return []PresenceState{}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+
+	pipe := s.client.Pipeline()
+	commands := make([]*redis.MapStringStringCmd, len(unique))
+	lastOnline := make([]*redis.StringCmd, len(unique))
+	for i, userID := range unique {
+		commands[i] = pipe.HGetAll(ctx, redisPresenceKey(userID))
+		lastOnline[i] = pipe.Get(ctx, redisLastOnlineKey(userID))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+
+	now := s.clock.Now().UTC()
+	result := make([]PresenceState, 0, len(unique))
+	for i, userID := range unique {
+		record, ok, err := decodeRedisRecord(commands[i].Val())
+		if err != nil {
+			return nil, err
+		}
+		lastOnlineAt, err := decodeLastOnline(lastOnline[i])
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, viewRecord(userID, record, ok, false, lastOnlineAt, now))
+	}
+
+	return result, nil
+}
+
+func (s *redisPresenceStore) CleanupExpired() error {
+	return nil
+}
+
+// AutoIdle idles every record through rewriteDevices.
+func (s *redisPresenceStore) AutoIdle(after time.Duration) ([]presenceChange, error) {
+	return s.rewriteDevices("autoidle", func(record presenceRecord, now time.Time) (presenceRecord, bool) {
+		return applyAutoIdle(record, now, after)
+	})
+}
+
+// RevertScheduled ends due schedules through rewriteDevices.
+func (s *redisPresenceStore) RevertScheduled() ([]presenceChange, error) {
+	return s.rewriteDevices("schedules", applySchedules)
+}
+
+// acquireSweep reports whether this replica should run the named sweep now.
+// The lock lapses just before the next tick, so only one replica runs each
+// sweep per interval.
+func (s *redisPresenceStore) acquireSweep(name string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+
+	return s.client.SetNX(ctx, redisSweepPrefix+name, s.instanceID, presenceSweepInterval-presenceSweepInterval/10).Result()
+}
+
+// rewriteDevices scans every record and applies apply to each in its own
+// WATCH transaction, storing the devices and version when it reports a
+// change. Only the replica holding the sweep lock for name runs it. Each key
+// gets its own timeout, and a record that can't be read or written is
+// logged and skipped, so one bad key never stops the rest of the sweep; a
+// record another replica writes meanwhile is left for the next run.
+func (s *redisPresenceStore) rewriteDevices(name string, apply func(presenceRecord, time.Time) (presenceRecord, bool)) ([]presenceChange, error) {
+	acquired, err := s.acquireSweep(name)
+	if err != nil || !acquired {
+		return nil, err
+	}
+
+	// The scan itself runs without a deadline: it can take a while over a
+	// large keyspace, and each page is still bounded by the client's read
+	// timeout.
+	ctx := context.Background()
+	var changes []presenceChange
+	iter := s.client.Scan(ctx, 0, redisPresenceKeyPrefix+"*", 500).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		userID := strings.TrimPrefix(key, redisPresenceKeyPrefix)
+		change, changed, err := s.rewriteRecord(key, userID, apply)
+		if err != nil {
+			slog.Warn("presence sweep skipped a record", "sweep", name, "userId", userID, "error", err)
+			continue
+		}
+		if changed && (change.Visible || change.transition()) {
+			changes = append(changes, change)
+		}
+	}
+
+	return changes, iter.Err()
+}
+
+// rewriteRecord is one key of rewriteDevices.
+func (s *redisPresenceStore) rewriteRecord(key, userID string, apply func(presenceRecord, time.Time) (presenceRecord, bool)) (presenceChange, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+
+	var change presenceChange
+	var changed bool
+	err := s.client.Watch(ctx, func(tx *redis.Tx) error {
+		fields, err := tx.HGetAll(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+		stored, ok, err := decodeRedisRecord(fields)
+		if err != nil || !ok {
+			return err
+		}
+
+		now := s.clock.Now().UTC()
+		var record presenceRecord
+		record, changed = apply(stored, now)
+		if !changed {
+			return nil
+		}
+		devices, err := json.Marshal(record.Devices)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, key, "devices", string(devices), "version", record.Version)
+			return nil
+		})
+		change = newPresenceChange(userID, stored.resolve(now), record, now)
+		return err
+	}, key)
+	if errors.Is(err, redis.TxFailedErr) {
+		return presenceChange{}, false, nil
+	}
+	if err != nil {
+		return presenceChange{}, false, err
+	}
+
+	return change, changed, nil
+}
+
+func (s *redisPresenceStore) Count() (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisOpTimeout)
+	defer cancel()
+
+	count := 0
+	iter := s.client.Scan(ctx, 0, redisPresenceKeyPrefix+"*", 500).Iterator()
+	for iter.Next(ctx) {
+		count++
+	}
+
+	return count, iter.Err()
+}
+
+func (s *redisPresenceStore) Ping(ctx context.Context) error {
+	return s.client.Ping(ctx).Err()
+}
@@ -0,0 +1,262 @@
+//go:build redis
+
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// Run with: go test -tags redis ./...
+
+func newRedisTestServer(t *testing.T, mr *miniredis.Miniredis) (*server, *recordingPublisher) {
+	t.Helper()
+
+	return newTestServerWithStore(t, func(clock Clock, metrics *presenceMetrics) PresenceStore {
+		mr.SetTime(clock.Now())
+		store, err := newRedisPresenceStore("redis://"+mr.Addr(), testPresenceTTL, testLastOnlineRetention, clock, metrics)
+		if err != nil {
+			t.Fatalf("newRedisPresenceStore: %v", err)
+		}
+		t.Cleanup(func() { _ = store.client.Close() })
+		return store
+	})
+}
+
+func TestRedisPresenceRoundTrip(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s, pub := newRedisTestServer(t, mr)
+
+	body := `{"status":"dnd","customText":"Heads down","activity":{"type":"playing","name":"Mango Quest"},"platform":"desktop"}`
+	if res := doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", body); res.Code != http.StatusOK {
+		t.Fatalf("set: status %d: %s", res.Code, res.Body.String())
+	}
+	if events := pub.take(); len(events) != 1 {
+		t.Fatalf("expected one publish, got %d", len(events))
+	}
+
+	state := mustGet(t, s.store, "usr_1")
+	if state.Status != StatusDnd || state.CustomText == nil || *state.CustomText != "Heads down" ||
+		state.Activity == nil || state.Activity.Name != "Mango Quest" || len(state.Platforms) != 1 {
+		t.Fatalf("unexpected state %+v", state)
+	}
+
+	doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_2", `{"status":"invisible"}`)
+	states, err := s.store.Bulk([]string{"usr_1", "usr_2", "usr_1", "usr_3"})
+	if err != nil {
+		t.Fatalf("bulk: %v", err)
+	}
+	if len(states) != 3 || states[0].Status != StatusDnd || states[1].Status != StatusOffline || states[2].Status != StatusOffline {
+		t.Fatalf("unexpected bulk result %+v", states)
+	}
+	if own, err := s.store.GetOwn("usr_2"); err != nil || own.Status != StatusInvisible {
+		t.Fatalf("expected own view to stay invisible, got %+v (%v)", own, err)
+	}
+
+	if count, err := s.store.Count(); err != nil || count != 2 {
+		t.Fatalf("expected 2 records, got %d (%v)", count, err)
+	}
+}
+
+func TestRedisPresenceExpiresNatively(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s, _ := newRedisTestServer(t, mr)
+	clock := s.clock.(*fakeClock)
+
+	doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"status":"online"}`)
+	key := redisPresenceKey("usr_1")
+	if ttl := mr.TTL(key); ttl != testPresenceTTL*(presenceRetentionTTLs+1) {
+		t.Fatalf("expected key TTL to cover expiry plus retention, got %v", ttl)
+	}
+
+	clock.Advance(testPresenceTTL + time.Second)
+	if state := mustGet(t, s.store, "usr_1"); state.Status != StatusOffline {
+		t.Fatalf("expected offline after the TTL, got %s", state.Status)
+	}
+
+	mr.FastForward(testPresenceTTL * (presenceRetentionTTLs + 1))
+	if mr.Exists(key) {
+		t.Fatal("expected Redis to drop the key once retention passed")
+	}
+}
+
+func TestRedisPresenceSharedAcrossReplicas(t *testing.T) {
+	mr := miniredis.RunT(t)
+	first, _ := newRedisTestServer(t, mr)
+	second, _ := newRedisTestServer(t, mr)
+
+	doRequestWithHeaders(t, first.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"status":"dnd","platform":"mobile"}`, map[string]string{"X-Device-Id": "phone"})
+	doRequestWithHeaders(t, second.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"status":"online","platform":"desktop"}`, map[string]string{"X-Device-Id": "laptop"})
+
+	for _, s := range []*server{first, second} {
+		state := mustGet(t, s.store, "usr_1")
+		if state.Status != StatusOnline || len(state.Platforms) != 2 {
+			t.Fatalf("expected both replicas to see the merged record, got %+v", state)
+		}
+	}
+
+	if keys := mr.Keys(); len(keys) != 2 || keys[0] != redisLastOnlineKey("usr_1") || keys[1] != redisPresenceKey("usr_1") {
+		t.Fatalf("expected a single hash and lastOnlineAt key per user, got %v", keys)
+	}
+}
+
+func TestRedisPresenceLastOnlineOutlivesRecord(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s, _ := newRedisTestServer(t, mr)
+	clock := s.clock.(*fakeClock)
+
+	doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"status":"online"}`)
+	wentOnline := clock.Now().UTC().Format(time.RFC3339)
+
+	retention := testPresenceTTL * (presenceRetentionTTLs + 1)
+	clock.Advance(retention + time.Second)
+	mr.FastForward(retention + time.Second)
+	if mr.Exists(redisPresenceKey("usr_1")) {
+		t.Fatal("expected the record itself to be gone")
+	}
+
+	states, err := s.store.Bulk([]string{"usr_1", "usr_2"})
+	if err != nil {
+		t.Fatalf("bulk: %v", err)
+	}
+	if states[0].Status != StatusOffline || states[0].LastOnlineAt == nil || *states[0].LastOnlineAt != wentOnline {
+		t.Fatalf("expected lastOnlineAt %s to survive, got %+v", wentOnline, states[0])
+	}
+	if states[1].LastOnlineAt != nil {
+		t.Fatalf("expected no lastOnlineAt for a user never seen, got %v", *states[1].LastOnlineAt)
+	}
+
+	mr.FastForward(testLastOnlineRetention)
+	if state := mustGet(t, s.store, "usr_1"); state.LastOnlineAt != nil {
+		t.Fatalf("expected lastOnlineAt to lapse after its retention, got %v", *state.LastOnlineAt)
+	}
+}
+
+func TestRedisPresenceAutoIdle(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s, pub := newRedisTestServer(t, mr)
+	s.autoIdleAfter = 30 * time.Second
+	clock := s.clock.(*fakeClock)
+
+	doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{}`)
+	doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_2", `{"status":"dnd"}`)
+	pub.take()
+
+	clock.Advance(45 * time.Second)
+	mr.SetTime(clock.Now())
+	s.autoIdle()
+	if state := mustGet(t, s.store, "usr_1"); state.Status != StatusIdle {
+		t.Fatalf("expected usr_1 to go idle, got %s", state.Status)
+	}
+	if state := mustGet(t, s.store, "usr_2"); state.Status != StatusDnd {
+		t.Fatalf("expected the manual dnd to stay, got %s", state.Status)
+	}
+	if events := pub.take(); len(events) != 1 || events[0].Topic != presenceTopic("usr_1") {
+		t.Fatalf("expected one publish for usr_1, got %+v", events)
+	}
+
+	doRequest(t, s.handlePresenceHeartbeat, http.MethodPost, "/v1/presence/heartbeat", "usr_1", "")
+	if state := mustGet(t, s.store, "usr_1"); state.Status != StatusOnline {
+		t.Fatalf("expected a heartbeat to undo the auto-idle, got %s", state.Status)
+	}
+}
+
+func TestRedisPresenceScheduledStatusReverts(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s, pub := newRedisTestServer(t, mr)
+	clock := s.clock.(*fakeClock)
+	until := clock.Now().Add(30 * time.Second).Format(time.RFC3339)
+
+	doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"status":"dnd","until":"`+until+`"}`)
+	pub.take()
+
+	clock.Advance(31 * time.Second)
+	mr.SetTime(clock.Now())
+	s.revertScheduled()
+	if state := mustGet(t, s.store, "usr_1"); state.Status != StatusOnline || state.Until != nil {
+		t.Fatalf("expected usr_1 back online, got %s until %v", state.Status, state.Until)
+	}
+	if events := pub.take(); len(events) != 1 || events[0].Topic != presenceTopic("usr_1") {
+		t.Fatalf("expected one publish for usr_1, got %+v", events)
+	}
+	mr.FastForward(presenceSweepInterval)
+	s.revertScheduled()
+	if events := pub.take(); len(events) != 0 {
+		t.Fatalf("expected the stored reversion not to publish again, got %+v", events)
+	}
+}
+
+func TestRedisPresenceSweepSkipsCorruptRecords(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s, pub := newRedisTestServer(t, mr)
+	s.autoIdleAfter = 30 * time.Second
+	clock := s.clock.(*fakeClock)
+
+	// SCAN returns keys in order here, so the corrupt one comes first.
+	mr.HSet(redisPresenceKey("usr_0"), "devices", "not json")
+	doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{}`)
+	pub.take()
+
+	clock.Advance(45 * time.Second)
+	mr.SetTime(clock.Now())
+	s.autoIdle()
+	if state := mustGet(t, s.store, "usr_1"); state.Status != StatusIdle {
+		t.Fatalf("expected the sweep to get past the corrupt record, got %s", state.Status)
+	}
+	if events := pub.take(); len(events) != 1 {
+		t.Fatalf("expected one publish for usr_1, got %+v", events)
+	}
+}
+
+func TestRedisPresenceSweepRunsOnOneReplica(t *testing.T) {
+	mr := miniredis.RunT(t)
+	first, firstPub := newRedisTestServer(t, mr)
+	second, secondPub := newRedisTestServer(t, mr)
+	for _, s := range []*server{first, second} {
+		s.autoIdleAfter = 30 * time.Second
+	}
+
+	doRequest(t, first.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{}`)
+	firstPub.take()
+	for _, s := range []*server{first, second} {
+		s.clock.(*fakeClock).Advance(45 * time.Second)
+	}
+	mr.SetTime(first.clock.Now())
+
+	second.autoIdle()
+	first.autoIdle()
+	if events := secondPub.take(); len(events) != 1 {
+		t.Fatalf("expected the replica holding the lock to publish once, got %+v", events)
+	}
+	if events := firstPub.take(); len(events) != 0 {
+		t.Fatalf("expected the other replica to skip the sweep, got %+v", events)
+	}
+	if state := mustGet(t, first.store, "usr_1"); state.Status != StatusIdle {
+		t.Fatalf("expected usr_1 to be idle everywhere, got %s", state.Status)
+	}
+
+	mr.FastForward(presenceSweepInterval)
+	if acquired, err := first.store.(*redisPresenceStore).acquireSweep("autoidle"); err != nil || !acquired {
+		t.Fatalf("expected the lock to lapse by the next tick, got %v (%v)", acquired, err)
+	}
+}
+
+func TestRedisPresenceBulkETag(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s, _ := newRedisTestServer(t, mr)
+	body := `{"userIds":["usr_2"]}`
+
+	doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_2", `{"status":"dnd"}`)
+	etag := bulkLookup(t, s, body, "").Header().Get("ETag")
+	if res := bulkLookup(t, s, body, etag); res.Code != http.StatusNotModified {
+		t.Fatalf("expected 304 when nothing changed, got %d", res.Code)
+	}
+
+	doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_2", `{"status":"dnd"}`)
+	if res := bulkLookup(t, s, body, etag); res.Code != http.StatusOK {
+		t.Fatalf("expected the stored version to move on with the write, got %d", res.Code)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// parseStatusUntil reads the until of a PUT /v1/presence body, which must lie
+// in the future.
+func parseStatusUntil(raw string, now time.Time) (time.Time, error) {
+	until, err := time.Parse(time.RFC3339, strings.TrimSpace(raw))
+	if err != nil {
+		return time.Time{}, errors.New("until must be an RFC 3339 timestamp.")
+	}
+	if !until.After(now) {
+		return time.Time{}, errors.New("until must be in the future.")
+	}
+
+	return until.UTC(), nil
+}
+
+// setManualStatus records a status chosen through PUT /v1/presence. A status
+// with an until falls back once it passes to the manual status the device
+// had before, or to automatic online when it had none. Scheduling over a
+// schedule keeps the status the first one would have gone back to.
+func (d deviceRecord) setManualStatus(status PresenceStatus, until time.Time, live bool) deviceRecord {
+	switch {
+	case until.IsZero() || !live || !d.Manual:
+		d.RevertTo = ""
+	case d.Until.IsZero():
+		d.RevertTo = d.Status
+	}
+
+	d.Status, d.Manual, d.Until = status, true, until
+	return d
+}
+
+// revertScheduled ends the schedules in devices that are due at now, in
+// place, and reports whether any were.
+func revertScheduled(devices map[string]deviceRecord, now time.Time) bool {
+	reverted := false
+	for id, device := range devices {
+		if device.Until.IsZero() || device.Until.After(now) {
+			continue
+		}
+
+		device.Status, device.Manual = StatusOnline, false
+		if device.RevertTo != "" {
+			device.Status, device.Manual = device.RevertTo, true
+		}
+		device.Until, device.RevertTo = time.Time{}, ""
+		devices[id] = device
+		reverted = true
+	}
+
+	return reverted
+}
+
+// applySchedules returns the record with every due schedule reverted,
+// resolved at now, and reports whether anything was. Reads apply it too, so
+// a status never outlives its until; the cleanup ticker stores and publishes
+// the reversion.
+func applySchedules(record presenceRecord, now time.Time) (presenceRecord, bool) {
+	devices := make(map[string]deviceRecord, len(record.Devices))
+	for id, device := range record.Devices {
+		devices[id] = device
+	}
+
+	record.Devices = devices
+	reverted := revertScheduled(devices, now)
+	if reverted {
+		record.Version++
+	}
+	return record.resolve(now), reverted
+}
+
+func (s *memoryPresenceStore) RevertScheduled() ([]presenceChange, error) {
+	now := s.clock.Now().UTC()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var changes []presenceChange
+	for userID, stored := range s.records {
+		record, reverted := applySchedules(stored, now)
+		if !reverted {
+			continue
+		}
+		s.records[userID] = record
+		if change := newPresenceChange(userID, stored.resolve(now), record, now); change.Visible || change.transition() {
+			changes = append(changes, change)
+		}
+	}
+
+	return changes, nil
+}
+
+// revertScheduled stores the reversion of every status whose until has
+// passed and settles the changes like autoIdle does.
+func (s *server) revertScheduled() {
+	changes, err := s.store.RevertScheduled()
+	if err != nil {
+		slog.Error("presence schedule reversion failed", "error", err)
+	}
+
+	s.settleChanges(changes)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestPresenceScheduledStatusReverts(t *testing.T) {
+	s, pub := newTestServer(t)
+	clock := s.clock.(*fakeClock)
+	until := clock.Now().Add(30 * time.Second).Format(time.RFC3339)
+
+	res := doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"status":"dnd","until":"`+until+`"}`)
+	if res.Code != http.StatusOK {
+		t.Fatalf("expected the scheduled status to be accepted, got %d: %s", res.Code, res.Body.String())
+	}
+	state := mustGet(t, s.store, "usr_1")
+	if state.Status != StatusDnd || !state.Manual || state.Until == nil || *state.Until != until {
+		t.Fatalf("expected dnd until %s, got %s until %v", until, state.Status, state.Until)
+	}
+
+	// A heartbeat during the schedule keeps it.
+	clock.Advance(20 * time.Second)
+	doRequest(t, s.handlePresenceHeartbeat, http.MethodPost, "/v1/presence/heartbeat", "usr_1", "")
+	if state := mustGet(t, s.store, "usr_1"); state.Status != StatusDnd || state.Until == nil {
+		t.Fatalf("expected the heartbeat to keep the schedule, got %s until %v", state.Status, state.Until)
+	}
+	pub.take()
+
+	// Reads past until already see the reversion.
+	clock.Advance(15 * time.Second)
+	state = mustGet(t, s.store, "usr_1")
+	if state.Status != StatusOnline || state.Manual || state.Until != nil {
+		t.Fatalf("expected an automatic online once until passed, got %s (manual %v, until %v)", state.Status, state.Manual, state.Until)
+	}
+
+	s.revertScheduled()
+	events := pub.take()
+	if len(events) != 1 || events[0].Payload.(PresenceState).Status != StatusOnline {
+		t.Fatalf("expected one online publish from the sweep, got %+v", events)
+	}
+	s.revertScheduled()
+	if events := pub.take(); len(events) != 0 {
+		t.Fatalf("expected no repeat publish, got %+v", events)
+	}
+}
+
+func TestPresenceScheduledStatusRestoresManualStatus(t *testing.T) {
+	s, pub := newTestServer(t)
+	clock := s.clock.(*fakeClock)
+
+	doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"status":"idle"}`)
+	until := clock.Now().Add(30 * time.Second).Format(time.RFC3339)
+	doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"status":"dnd","until":"`+until+`"}`)
+	pub.take()
+
+	clock.Advance(31 * time.Second)
+	s.revertScheduled()
+	state := mustGet(t, s.store, "usr_1")
+	if state.Status != StatusIdle || !state.Manual || state.Until != nil {
+		t.Fatalf("expected the manual idle back, got %s (manual %v, until %v)", state.Status, state.Manual, state.Until)
+	}
+	if events := pub.take(); len(events) != 1 {
+		t.Fatalf("expected the reversion to publish, got %d", len(events))
+	}
+
+	// A plain manual status has no schedule to revert.
+	doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"status":"dnd"}`)
+	clock.Advance(31 * time.Second)
+	s.revertScheduled()
+	if state := mustGet(t, s.store, "usr_1"); state.Status != StatusDnd || state.Until != nil {
+		t.Fatalf("expected dnd to stay without an until, got %s until %v", state.Status, state.Until)
+	}
+}
+
+func TestPresenceScheduledStatusValidation(t *testing.T) {
+	s, _ := newTestServer(t)
+	past := s.clock.Now().Add(-time.Minute).Format(time.RFC3339)
+	future := s.clock.Now().Add(time.Minute).Format(time.RFC3339)
+
+	for _, body := range []string{
+		`{"until":"` + future + `"}`,
+		`{"status":"dnd","until":"` + past + `"}`,
+		`{"status":"dnd","until":"3pm"}`,
+	} {
+		if res := doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", body); res.Code != http.StatusBadRequest {
+			t.Fatalf("expected %s to be rejected, got %d", body, res.Code)
+		}
+	}
+}
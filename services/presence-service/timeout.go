@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// withRequestTimeout gives each request a deadline of timeout on its context,
+// which downstream calls made with it inherit, and answers 503 once the
+// deadline passes whether or not the handler has returned. The handler's
+// response is buffered until then, so requests exempt reports true for,
+// which stream or hold the connection open, bypass it; a nil exempt bypasses
+// none. A timeout of zero or less disables it.
+func withRequestTimeout(timeout time.Duration, exempt func(*http.Request) bool, next http.Handler) http.Handler {
+	if timeout <= 0 {
+		return next
+	}
+
+	bounded := http.TimeoutHandler(next, timeout, `{"code":"REQUEST_TIMEOUT","error":"Request timed out."}`+"\n")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if exempt != nil && exempt(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// TimeoutHandler writes its body without a Content-Type; a
+		// handler that finishes in time replaces this with its own.
+		w.Header().Set("Content-Type", "application/json")
+		bounded.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// allowInsecureDefaultsEnv lets a local setup start with settings no
+// deployment should keep, such as an unsigned webhook.
+const allowInsecureDefaultsEnv = "ALLOW_INSECURE_DEFAULTS"
+
+// configCheck collects everything wrong with the environment, so a failed
+// start names every problem instead of the first. Values are read raw:
+// main's fallbacks and clamps would otherwise hide a typo.
+type configCheck struct {
+	getenv   func(string) string
+	invalid  []string
+	insecure []string
+}
+
+func (c *configCheck) value(key string) string {
+	return strings.TrimSpace(c.getenv(key))
+}
+
+func (c *configCheck) fail(key, format string, args ...any) {
+	c.invalid = append(c.invalid, key+": "+fmt.Sprintf(format, args...))
+}
+
+// int checks that key, when set, is an integer no lower than least.
+func (c *configCheck) int(key string, least int) (int, bool) {
+	raw := c.value(key)
+	if raw == "" {
+		return 0, false
+	}
+
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		c.fail(key, "%q is not an integer", raw)
+		return 0, false
+	}
+	if value < least {
+		c.fail(key, "%d is below the minimum of %d", value, least)
+		return 0, false
+	}
+	return value, true
+}
+
+func (c *configCheck) ints(least int, keys ...string) {
+	for _, key := range keys {
+		c.int(key, least)
+	}
+}
+
+func (c *configCheck) port(key string) {
+	if raw := c.value(key); raw != "" {
+		if port, err := strconv.Atoi(raw); err != nil || port < 1 || port > 65535 {
+			c.fail(key, "%q is not a port number", raw)
+		}
+	}
+}
+
+func (c *configCheck) bool(key string) {
+	if raw := c.value(key); raw != "" && !strings.EqualFold(raw, "true") && !strings.EqualFold(raw, "false") {
+		c.fail(key, "%q is neither true nor false", raw)
+	}
+}
+
+// url checks that key, when set, is an absolute URL with one of schemes.
+func (c *configCheck) url(key string, schemes ...string) {
+	if raw := c.value(key); raw != "" {
+		c.urlValue(key, raw, schemes...)
+	}
+}
+
+func (c *configCheck) urlValue(key, raw string, schemes ...string) {
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.Host == "" || !slices.Contains(schemes, strings.ToLower(parsed.Scheme)) {
+		c.fail(key, "%q is not a %s URL", raw, strings.Join(schemes, "/"))
+	}
+}
+
+func (c *configCheck) insecureDefault(key, reason string) {
+	c.insecure = append(c.insecure, key+": "+reason)
+}
+
+// err reports the invalid settings, and the insecure ones unless the
+// environment allows them, in which case they are only logged.
+func (c *configCheck) err() error {
+	problems := c.invalid
+	if strings.EqualFold(c.value(allowInsecureDefaultsEnv), "true") {
+		if len(c.insecure) > 0 {
+			slog.Warn("starting with insecure defaults", "problems", c.insecure)
+		}
+	} else {
+		problems = append(problems, c.insecure...)
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d problem(s): %s", len(problems), strings.Join(problems, "; "))
+}
+
+// validateConfig checks the environment main reads before anything starts.
+// Insecure defaults only pass with ALLOW_INSECURE_DEFAULTS=true.
+func validateConfig(getenv func(string) string) error {
+	c := &configCheck{getenv: getenv}
+
+	c.port("PRESENCE_SERVICE_PORT")
+	c.url("IDENTITY_SERVICE_URL", "http", "https")
+	c.url("REALTIME_GATEWAY_URL", "http", "https")
+	c.url("PRESENCE_FRIENDS_CHECK_URL", "http", "https")
+	c.url("PRESENCE_WEBHOOK_URL", "http", "https")
+	c.url("REDIS_URL", "redis", "rediss", "unix")
+
+	c.ints(1,
+		"PRESENCE_TTL_SECONDS",
+		"PRESENCE_RATE_LIMIT_BURST",
+		"PRESENCE_RATE_LIMIT_WINDOW_SECONDS",
+		"PRESENCE_BULK_MAX",
+		"PRESENCE_LAST_ONLINE_RETENTION_DAYS",
+		"PRESENCE_IDENTITY_TIMEOUT_MS",
+		"PRESENCE_WATCH_TIMEOUT_SECONDS",
+	)
+	c.ints(0,
+		"PRESENCE_SHUTDOWN_GRACE_MS",
+		"PRESENCE_AUTH_CACHE_TTL",
+		"PRESENCE_AUTH_CACHE_SIZE",
+		"PRESENCE_IDENTITY_RETRY_BACKOFF_MS",
+		"PRESENCE_AUTO_IDLE_SECONDS",
+		"PRESENCE_MAX_BODY_BYTES",
+		"PRESENCE_MAX_IN_FLIGHT",
+		"PRESENCE_REQUEST_TIMEOUT_MS",
+		"PRESENCE_MAX_WATCHES",
+		"PRESENCE_PUBLISH_QUEUE_SIZE",
+	)
+	minTTL, minSet := c.int("PRESENCE_TTL_MIN_SECONDS", 1)
+	maxTTL, maxSet := c.int("PRESENCE_TTL_MAX_SECONDS", 1)
+	if minSet && maxSet && maxTTL < minTTL {
+		c.fail("PRESENCE_TTL_MAX_SECONDS", "%d is below PRESENCE_TTL_MIN_SECONDS (%d)", maxTTL, minTTL)
+	}
+
+	if _, err := newJWTVerifier(c.value("IDENTITY_JWT_SECRET"), c.value("IDENTITY_JWT_PUBLIC_KEY"), realClock{}); err != nil {
+		c.fail("IDENTITY_JWT_PUBLIC_KEY", "%v", err)
+	}
+	if c.value("PRESENCE_WEBHOOK_URL") != "" && c.value("PRESENCE_WEBHOOK_SECRET") == "" {
+		c.insecureDefault("PRESENCE_WEBHOOK_SECRET", "unset, so anyone can forge the webhook signature")
+	}
+
+	return c.err()
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// envMap stands in for os.Getenv.
+type envMap map[string]string
+
+func (env envMap) get(key string) string {
+	return env[key]
+}
+
+func TestValidateConfigPasses(t *testing.T) {
+	env := envMap{
+		"PRESENCE_SERVICE_PORT":    "4002",
+		"IDENTITY_SERVICE_URL":     "http://identity:3002",
+		"REALTIME_GATEWAY_URL":     "http://gateway:4001",
+		"PRESENCE_WEBHOOK_URL":     "https://audit.example.com/presence",
+		"PRESENCE_WEBHOOK_SECRET":  "s3cret",
+		"PRESENCE_TTL_SECONDS":     "90",
+		"PRESENCE_TTL_MIN_SECONDS": "30",
+		"PRESENCE_TTL_MAX_SECONDS": "300",
+		"PRESENCE_MAX_IN_FLIGHT":   "0",
+		"IDENTITY_JWT_SECRET":      "jwt-s3cret",
+	}
+	if err := validateConfig(env.get); err != nil {
+		t.Fatalf("expected a valid config, got %v", err)
+	}
+}
+
+func TestValidateConfigInsecureDefaults(t *testing.T) {
+	env := envMap{"PRESENCE_WEBHOOK_URL": "https://audit.example.com/presence"}
+	err := validateConfig(env.get)
+	if err == nil || !strings.Contains(err.Error(), "PRESENCE_WEBHOOK_SECRET") {
+		t.Fatalf("expected a webhook without a secret to be refused, got %v", err)
+	}
+
+	env[allowInsecureDefaultsEnv] = "true"
+	if err := validateConfig(env.get); err != nil {
+		t.Fatalf("expected ALLOW_INSECURE_DEFAULTS to let it through, got %v", err)
+	}
+}
+
+func TestValidateConfigNamesEachProblem(t *testing.T) {
+	env := envMap{
+		allowInsecureDefaultsEnv:   "true",
+		"PRESENCE_SERVICE_PORT":    "70000",
+		"IDENTITY_SERVICE_URL":     "identity:3002",
+		"REDIS_URL":                "localhost:6379",
+		"PRESENCE_TTL_SECONDS":     "-75",
+		"PRESENCE_BULK_MAX":        "100 ids",
+		"PRESENCE_TTL_MIN_SECONDS": "60",
+		"PRESENCE_TTL_MAX_SECONDS": "30",
+		"IDENTITY_JWT_PUBLIC_KEY":  "not a key",
+	}
+
+	// The escape hatch only covers insecure defaults, not broken values.
+	err := validateConfig(env.get)
+	if err == nil {
+		t.Fatal("expected the config to be refused")
+	}
+	for _, want := range []string{
+		`PRESENCE_SERVICE_PORT: "70000" is not a port number`,
+		`IDENTITY_SERVICE_URL: "identity:3002" is not a http/https URL`,
+		`REDIS_URL: "localhost:6379" is not a redis/rediss/unix URL`,
+		"PRESENCE_TTL_SECONDS: -75 is below the minimum of 1",
+		`PRESENCE_BULK_MAX: "100 ids" is not an integer`,
+		"PRESENCE_TTL_MAX_SECONDS: 30 is below PRESENCE_TTL_MIN_SECONDS (60)",
+		"IDENTITY_JWT_PUBLIC_KEY: unsupported public key",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q in %v", want, err)
+		}
+	}
+	if !strings.HasPrefix(err.Error(), "7 problem(s): ") {
+		t.Fatalf("expected seven problems, got %v", err)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// watchRecheckInterval bounds how long a watch can miss a change it wasn't
+// woken for: one written through another replica sharing the Redis store.
+const watchRecheckInterval = 5 * time.Second
+
+// presenceWatchResponse answers GET /v1/presence/:userId/watch. Version is
+// passed back as ?version= on the next watch.
+type presenceWatchResponse struct {
+	Version  string        `json:"version"`
+	Changed  bool          `json:"changed"`
+	Presence PresenceState `json:"presence"`
+}
+
+// watchVersion identifies what the viewer sees of a user's presence. The
+// timestamps are left out, so heartbeats that only move them don't count as
+// a change.
+func watchVersion(state PresenceState) string {
+	encoded, _ := json.Marshal(struct {
+		Status     PresenceStatus    `json:"status"`
+		CustomText *string           `json:"customText"`
+		Activity   *PresenceActivity `json:"activity"`
+		Platforms  []Platform        `json:"platforms"`
+	}{state.Status, state.CustomText, state.Activity, state.Platforms})
+
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:8])
+}
+
+type presenceWatch struct {
+	changed chan struct{}
+	waiters int
+}
+
+// presenceWatchers wakes held watches when the user they watch is written.
+// It is per instance, like the typing throttle: a write through another
+// replica is only picked up by the periodic recheck.
+type presenceWatchers struct {
+	mu     sync.Mutex
+	limit  int
+	active int
+	byUser map[string]*presenceWatch
+}
+
+// newPresenceWatchers returns watchers allowing limit watches at once.
+func newPresenceWatchers(limit int) *presenceWatchers {
+	return &presenceWatchers{limit: limit, byUser: map[string]*presenceWatch{}}
+}
+
+// acquire takes a watch slot, reporting false when all are taken.
+func (w *presenceWatchers) acquire() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.active >= w.limit {
+		return false
+	}
+	w.active++
+	return true
+}
+
+func (w *presenceWatchers) release() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.active--
+}
+
+// subscribe returns a channel closed on the next write to userID, and a
+// function to call once no longer waiting on it.
+func (w *presenceWatchers) subscribe(userID string) (<-chan struct{}, func()) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	watch, ok := w.byUser[userID]
+	if !ok {
+		watch = &presenceWatch{changed: make(chan struct{})}
+		w.byUser[userID] = watch
+	}
+	watch.waiters++
+
+	return watch.changed, func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+
+		watch.waiters--
+		if watch.waiters == 0 && w.byUser[userID] == watch {
+			delete(w.byUser, userID)
+		}
+	}
+}
+
+// notify wakes every watch on userID.
+func (w *presenceWatchers) notify(userID string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if watch, ok := w.byUser[userID]; ok {
+		close(watch.changed)
+		delete(w.byUser, userID)
+	}
+}
+
+// isWatchRequest reports whether r is a watch, which holds its connection
+// open past the request timeout.
+func isWatchRequest(r *http.Request) bool {
+	_, ok := watchedUserID(r.URL.Path)
+	return ok
+}
+
+func watchedUserID(path string) (string, bool) {
+	rest, ok := strings.CutPrefix(path, "/v1/presence/")
+	if !ok {
+		return "", false
+	}
+	return strings.CutSuffix(rest, "/watch")
+}
+
+// handlePresenceWatch serves GET /v1/presence/:userId/watch?version=. It
+// answers straight away when the presence the viewer sees no longer matches
+// version, or there is none, and otherwise holds the request until it
+// changes or watchTimeout passes, answering with the current presence
+// either way.
+func (s *server) handlePresenceWatch(w http.ResponseWriter, r *http.Request, viewerID, userID string) {
+	if !s.watchers.acquire() {
+		w.Header().Set("Retry-After", "1")
+		s.respondErrorCode(w, http.StatusServiceUnavailable, codePresenceTooManyWatches, "Too many presence watches.")
+		return
+	}
+	defer s.watchers.release()
+
+	since := r.URL.Query().Get("version")
+	timeout := time.NewTimer(s.watchTimeout)
+	defer timeout.Stop()
+	recheck := time.NewTicker(watchRecheckInterval)
+	defer recheck.Stop()
+
+	for {
+		// Subscribe before reading, so a write landing in between still
+		// wakes this watch.
+		changed, unsubscribe := s.watchers.subscribe(userID)
+		state, err := s.store.Get(userID)
+		if err != nil {
+			unsubscribe()
+			s.respondStoreError(w, err)
+			return
+		}
+		state = s.revealPresence(r.Context(), viewerID, []PresenceState{state})[0]
+		version := watchVersion(state)
+		if version != since {
+			unsubscribe()
+			s.respondJSON(w, http.StatusOK, presenceWatchResponse{Version: version, Changed: true, Presence: state})
+			return
+		}
+
+		// Expiry isn't a write, so wake for it as well.
+		var expiry *time.Timer
+		var expired <-chan time.Time
+		if expiresAt, ok := parseExpiresAt(state); ok {
+			expiry = time.NewTimer(max(expiresAt.Sub(s.clock.Now()), 0) + time.Second)
+			expired = expiry.C
+		}
+
+		timedOut := false
+		select {
+		case <-changed:
+		case <-expired:
+		case <-recheck.C:
+		case <-timeout.C:
+			timedOut = true
+		case <-r.Context().Done():
+		}
+		unsubscribe()
+		if expiry != nil {
+			expiry.Stop()
+		}
+
+		if r.Context().Err() != nil {
+			return
+		}
+		if timedOut {
+			s.respondJSON(w, http.StatusOK, presenceWatchResponse{Version: version, Presence: state})
+			return
+		}
+	}
+}
+
+func parseExpiresAt(state PresenceState) (time.Time, bool) {
+	if state.ExpiresAt == nil {
+		return time.Time{}, false
+	}
+
+	expiresAt, err := time.Parse(time.RFC3339, *state.ExpiresAt)
+	return expiresAt, err == nil
+}
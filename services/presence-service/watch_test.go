@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func watchPresence(t *testing.T, s *server, userID, viewerID, version string) presenceWatchResponse {
+	t.Helper()
+
+	return decodeWatch(t, doRequest(t, s.handlePresenceByUserID, http.MethodGet, "/v1/presence/"+userID+"/watch?version="+version, viewerID, ""))
+}
+
+func decodeWatch(t *testing.T, res *httptest.ResponseRecorder) presenceWatchResponse {
+	t.Helper()
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("watch: expected 200, got %d: %s", res.Code, res.Body.String())
+	}
+
+	var watched presenceWatchResponse
+	if err := json.Unmarshal(res.Body.Bytes(), &watched); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	return watched
+}
+
+func TestPresenceWatchReturnsImmediately(t *testing.T) {
+	s, _ := newTestServer(t)
+	doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"status":"dnd"}`)
+
+	// Without a version, and with a stale one, there is nothing to wait for.
+	first := watchPresence(t, s, "usr_1", "usr_2", "")
+	if !first.Changed || first.Version == "" || first.Presence.Status != StatusDnd {
+		t.Fatalf("expected the current presence straight away, got %+v", first)
+	}
+	if stale := watchPresence(t, s, "usr_1", "usr_2", "0123456789abcdef"); !stale.Changed || stale.Version != first.Version {
+		t.Fatalf("expected a stale version to answer straight away, got %+v", stale)
+	}
+
+	// A current version is held until the watch times out.
+	s.watchTimeout = 20 * time.Millisecond
+	held := watchPresence(t, s, "usr_1", "usr_2", first.Version)
+	if held.Changed || held.Version != first.Version || held.Presence.Status != StatusDnd {
+		t.Fatalf("expected an unchanged answer after the timeout, got %+v", held)
+	}
+}
+
+func TestPresenceWatchWakesOnChange(t *testing.T) {
+	s, _ := newTestServer(t)
+	doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"status":"online"}`)
+	current := watchPresence(t, s, "usr_1", "usr_2", "")
+
+	result := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		result <- doRequest(t, s.handlePresenceByUserID, http.MethodGet, "/v1/presence/usr_1/watch?version="+current.Version, "usr_2", "")
+	}()
+	waitForWatch := func() {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for {
+			s.watchers.mu.Lock()
+			_, waiting := s.watchers.byUser["usr_1"]
+			s.watchers.mu.Unlock()
+			if waiting {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("expected the watch to be held")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	waitForWatch()
+
+	// A heartbeat wakes the watch without changing what it sees, so it
+	// goes back to waiting; the status change ends it.
+	doRequest(t, s.handlePresenceHeartbeat, http.MethodPost, "/v1/presence/heartbeat", "usr_1", "")
+	waitForWatch()
+	select {
+	case res := <-result:
+		t.Fatalf("expected a heartbeat not to end the watch, got %d: %s", res.Code, res.Body.String())
+	default:
+	}
+
+	doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"status":"idle"}`)
+	select {
+	case res := <-result:
+		if got := decodeWatch(t, res); !got.Changed || got.Presence.Status != StatusIdle || got.Version == current.Version {
+			t.Fatalf("expected the change to be returned, got %+v", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the update to wake the watch")
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// webhookSignatureHeader carries "sha256=" and the hex HMAC-SHA256 of the
+// body under the shared secret.
+const webhookSignatureHeader = "X-Presence-Signature"
+
+// webhookEvent is the body posted for one status transition.
+type webhookEvent struct {
+	UserID string         `json:"userId"`
+	From   PresenceStatus `json:"from"`
+	To     PresenceStatus `json:"to"`
+	At     string         `json:"at"`
+}
+
+// presenceWebhook posts status transitions to an audit endpoint. Each
+// delivery runs in its own goroutine and is retried with a doubling backoff;
+// one that still fails after the last attempt is logged as a dead letter,
+// body included, so it can be replayed by hand.
+type presenceWebhook struct {
+	url      string
+	secret   []byte
+	client   *http.Client
+	attempts int
+	backoff  time.Duration
+	inFlight sync.WaitGroup
+}
+
+// newPresenceWebhook returns a webhook posting to url, or nil with no url.
+func newPresenceWebhook(url, secret string, timeout time.Duration) *presenceWebhook {
+	url = strings.TrimSpace(url)
+	if url == "" {
+		return nil
+	}
+
+	return &presenceWebhook{
+		url:      url,
+		secret:   []byte(secret),
+		client:   &http.Client{Timeout: timeout},
+		attempts: 3,
+		backoff:  500 * time.Millisecond,
+	}
+}
+
+// Send delivers change, which happened at at, in the background.
+func (h *presenceWebhook) Send(change presenceChange, at time.Time) {
+	body, err := json.Marshal(webhookEvent{
+		UserID: change.UserID,
+		From:   change.From,
+		To:     change.To,
+		At:     at.UTC().Format(time.RFC3339Nano),
+	})
+	if err != nil {
+		slog.Error("failed to encode presence webhook", "userId", change.UserID, "error", err)
+		return
+	}
+
+	h.inFlight.Add(1)
+	go func() {
+		defer h.inFlight.Done()
+		h.deliver(body)
+	}()
+}
+
+func (h *presenceWebhook) deliver(body []byte) {
+	wait := h.backoff
+	for attempt := 1; ; attempt++ {
+		err := h.post(body)
+		if err == nil {
+			return
+		}
+		if attempt >= h.attempts {
+			slog.Error("presence webhook dead letter", "attempts", attempt, "error", err, "body", string(body))
+			return
+		}
+
+		slog.Warn("presence webhook failed, retrying", "attempt", attempt, "error", err)
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+// post makes one delivery attempt.
+func (h *presenceWebhook) post(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookSignatureHeader, signWebhook(h.secret, body))
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Drain waits for deliveries still running, giving up when ctx ends.
+func (h *presenceWebhook) Drain(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		h.inFlight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
+func signWebhook(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type webhookDelivery struct {
+	event     webhookEvent
+	body      []byte
+	signature string
+}
+
+// webhookRecorder is an audit endpoint that fails the first failures
+// requests with a 500 and records the rest.
+type webhookRecorder struct {
+	mu         sync.Mutex
+	failures   int
+	attempts   int
+	deliveries []webhookDelivery
+}
+
+func (rec *webhookRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.attempts++
+	if rec.attempts <= rec.failures {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var event webhookEvent
+	_ = json.Unmarshal(body, &event)
+	rec.deliveries = append(rec.deliveries, webhookDelivery{event: event, body: body, signature: r.Header.Get(webhookSignatureHeader)})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (rec *webhookRecorder) take() []webhookDelivery {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	deliveries := rec.deliveries
+	rec.deliveries = nil
+	return deliveries
+}
+
+func newTestWebhook(t *testing.T, s *server, rec *webhookRecorder) {
+	t.Helper()
+
+	endpoint := httptest.NewServer(rec)
+	t.Cleanup(endpoint.Close)
+	s.webhook = newPresenceWebhook(endpoint.URL, "s3cret", time.Second)
+	s.webhook.backoff = time.Millisecond
+}
+
+func TestPresenceWebhookFiresOnTransitions(t *testing.T) {
+	s, _ := newTestServer(t)
+	rec := &webhookRecorder{}
+	newTestWebhook(t, s, rec)
+	put := func(body string) {
+		t.Helper()
+		if res := doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", body); res.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", res.Code, res.Body.String())
+		}
+		s.webhook.Drain(context.Background())
+	}
+
+	put(`{"status":"online"}`)
+	deliveries := rec.take()
+	if len(deliveries) != 1 {
+		t.Fatalf("expected one delivery for coming online, got %d", len(deliveries))
+	}
+	got := deliveries[0]
+	if got.event.UserID != "usr_1" || got.event.From != StatusOffline || got.event.To != StatusOnline {
+		t.Fatalf("unexpected webhook body %s", got.body)
+	}
+	if at, err := time.Parse(time.RFC3339Nano, got.event.At); err != nil || !at.Equal(s.clock.Now()) {
+		t.Fatalf("expected the transition time, got %q (%v)", got.event.At, err)
+	}
+	if want := signWebhook([]byte("s3cret"), got.body); got.signature != want {
+		t.Fatalf("expected signature %s, got %q", want, got.signature)
+	}
+
+	// Heartbeats and updates that leave the status alone don't fire, even
+	// when other users would notice them.
+	doRequest(t, s.handlePresenceHeartbeat, http.MethodPost, "/v1/presence/heartbeat", "usr_1", "")
+	put(`{"status":"online","customText":"lunch"}`)
+	if deliveries := rec.take(); len(deliveries) != 0 {
+		t.Fatalf("expected no delivery without a transition, got %d", len(deliveries))
+	}
+
+	// Going invisible is a transition even though others see it as offline.
+	put(`{"status":"dnd"}`)
+	put(`{"status":"invisible"}`)
+	deliveries = rec.take()
+	if len(deliveries) != 2 {
+		t.Fatalf("expected two deliveries, got %d", len(deliveries))
+	}
+	for i, want := range [][2]PresenceStatus{{StatusOnline, StatusDnd}, {StatusDnd, StatusInvisible}} {
+		if event := deliveries[i].event; event.From != want[0] || event.To != want[1] {
+			t.Fatalf("delivery %d: expected %s to %s, got %s to %s", i, want[0], want[1], event.From, event.To)
+		}
+	}
+}
+
+func TestPresenceWebhookAutoIdle(t *testing.T) {
+	s, _ := newTestServer(t)
+	s.autoIdleAfter = 30 * time.Second
+	rec := &webhookRecorder{}
+	newTestWebhook(t, s, rec)
+
+	doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{}`)
+	s.webhook.Drain(context.Background())
+	rec.take()
+
+	s.clock.(*fakeClock).Advance(30 * time.Second)
+	s.autoIdle()
+	s.webhook.Drain(context.Background())
+
+	deliveries := rec.take()
+	if len(deliveries) != 1 || deliveries[0].event.From != StatusOnline || deliveries[0].event.To != StatusIdle {
+		t.Fatalf("expected the auto-idle to be reported, got %+v", deliveries)
+	}
+}
+
+func TestPresenceWebhookRetries(t *testing.T) {
+	s, _ := newTestServer(t)
+	rec := &webhookRecorder{failures: 2}
+	newTestWebhook(t, s, rec)
+
+	doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"status":"dnd"}`)
+	s.webhook.Drain(context.Background())
+	if deliveries := rec.take(); len(deliveries) != 1 || rec.attempts != 3 {
+		t.Fatalf("expected delivery on the third attempt, got %d after %d attempts", len(deliveries), rec.attempts)
+	}
+
+	// Past the last attempt the event is dropped to the dead-letter log.
+	rec.failures = rec.attempts + 3
+	doRequest(t, s.handlePresence, http.MethodPut, "/v1/presence", "usr_1", `{"status":"online"}`)
+	s.webhook.Drain(context.Background())
+	if deliveries := rec.take(); len(deliveries) != 0 || rec.attempts != 6 {
+		t.Fatalf("expected three failed attempts and no delivery, got %d after %d attempts", len(deliveries), rec.attempts)
+	}
+}
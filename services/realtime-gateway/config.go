@@ -10,30 +10,44 @@ import (
 type config struct {
 	ServiceName          string
 	Port                 string
-	CorsOrigin           string
+	CorsOrigins          corsPolicy
 	IdentityServiceURL   string
 	MessagingServiceURL  string
+	PresenceServiceURL   string
+	PresenceBulkMax      int
+	VoiceSignalingURL    string
+	UserStateTimeout     time.Duration
 	InternalAPIKey       string
 	RequestTimeout       time.Duration
 	MaxPayloadBytes      int64
 	WebSocketReadLimit   int64
 	WebSocketWriteWait   time.Duration
+	WebSocketSendBuffer  int
+	ResumeGrace          time.Duration
 	WebSocketPongTimeout time.Duration
+	ShutdownGrace        time.Duration
 }
 
 func loadConfig() config {
 	return config{
 		ServiceName:          "realtime-gateway",
 		Port:                 getEnv("REALTIME_GATEWAY_PORT", "4001"),
-		CorsOrigin:           getEnv("CORS_ORIGIN", "*"),
+		CorsOrigins:          parseCORSOrigins(getEnv("CORS_ORIGINS", getEnv("CORS_ORIGIN", "*"))),
 		IdentityServiceURL:   strings.TrimRight(getEnv("IDENTITY_SERVICE_URL", "http://localhost:3002"), "/"),
 		MessagingServiceURL:  strings.TrimRight(getEnv("MESSAGING_SERVICE_URL", "http://localhost:3004"), "/"),
+		PresenceServiceURL:   strings.TrimRight(getEnv("PRESENCE_SERVICE_URL", "http://localhost:4002"), "/"),
+		PresenceBulkMax:      max(getIntEnv("PRESENCE_BULK_MAX", 100), 1),
+		VoiceSignalingURL:    strings.TrimRight(getEnv("VOICE_SIGNALING_URL", "http://localhost:4003"), "/"),
+		UserStateTimeout:     time.Duration(getIntEnv("REALTIME_GATEWAY_USER_STATE_TIMEOUT_MS", 1_000)) * time.Millisecond,
 		InternalAPIKey:       getEnv("REALTIME_GATEWAY_INTERNAL_API_KEY", ""),
 		RequestTimeout:       time.Duration(getIntEnv("REALTIME_GATEWAY_REQUEST_TIMEOUT_MS", 3_000)) * time.Millisecond,
 		MaxPayloadBytes:      int64(getIntEnv("REALTIME_GATEWAY_MAX_PAYLOAD_BYTES", 1_048_576)),
 		WebSocketReadLimit:   int64(getIntEnv("REALTIME_GATEWAY_WS_READ_LIMIT_BYTES", 65_536)),
 		WebSocketWriteWait:   time.Duration(getIntEnv("REALTIME_GATEWAY_WS_WRITE_TIMEOUT_MS", 5_000)) * time.Millisecond,
+		WebSocketSendBuffer:  max(getIntEnv("REALTIME_GATEWAY_WS_SEND_BUFFER", defaultSendBuffer), 1),
+		ResumeGrace:          time.Duration(getIntEnv("REALTIME_GATEWAY_RESUME_GRACE_MS", 30_000)) * time.Millisecond,
 		WebSocketPongTimeout: time.Duration(getIntEnv("REALTIME_GATEWAY_WS_PONG_TIMEOUT_MS", 60_000)) * time.Millisecond,
+		ShutdownGrace:        time.Duration(getIntEnv("REALTIME_GATEWAY_SHUTDOWN_GRACE_MS", 10_000)) * time.Millisecond,
 	}
 }
 
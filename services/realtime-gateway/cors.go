@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// corsPolicy is the parsed CORS_ORIGINS allow-list. Listed origins are echoed
+// back with credentials allowed; "*" lets any other origin in without them.
+type corsPolicy struct {
+	anyOrigin bool
+	origins   map[string]bool
+}
+
+// parseCORSOrigins reads a comma-separated list of origins such as
+// "https://app.example.com,https://admin.example.com".
+func parseCORSOrigins(raw string) corsPolicy {
+	policy := corsPolicy{origins: map[string]bool{}}
+	for _, origin := range strings.Split(raw, ",") {
+		origin = strings.TrimRight(strings.TrimSpace(origin), "/")
+		switch origin {
+		case "":
+		case "*":
+			policy.anyOrigin = true
+		default:
+			policy.origins[origin] = true
+		}
+	}
+
+	return policy
+}
+
+// originHeaders returns the headers granting origin access to a response.
+// Origins outside the allow-list get no Access-Control-Allow-Origin at all.
+func (p corsPolicy) originHeaders(origin string) map[string]string {
+	if origin != "" && p.origins[origin] {
+		return map[string]string{
+			"Access-Control-Allow-Origin":      origin,
+			"Access-Control-Allow-Credentials": "true",
+			"Vary":                             "Origin",
+		}
+	}
+	if p.anyOrigin {
+		return map[string]string{"Access-Control-Allow-Origin": "*"}
+	}
+
+	return map[string]string{"Vary": "Origin"}
+}
+
+// allowsCredentials reports whether a request from origin may be
+// authenticated by the browser's cookies. Requests without an Origin don't
+// come from a web page and are let through.
+func (p corsPolicy) allowsCredentials(origin string) bool {
+	return origin == "" || p.origins[origin]
+}
+
+// allowsOrigin reports whether a page on origin may open a socket at all.
+func (p corsPolicy) allowsOrigin(origin string) bool {
+	return p.anyOrigin || p.allowsCredentials(origin)
+}
+
+// withCORS sets the origin-dependent CORS headers before the handler runs;
+// the handler adds the fixed ones from corsHeaders when it responds.
+func withCORS(policy corsPolicy, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for key, value := range policy.originHeaders(r.Header.Get("Origin")) {
+			w.Header().Set(key, value)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
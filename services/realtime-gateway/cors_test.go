@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORSOriginAllowList(t *testing.T) {
+	handler := withCORS(parseCORSOrigins("https://app.example.com, https://admin.example.com/"), http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	request := func(origin string) http.Header {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/health", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Header()
+	}
+
+	for _, origin := range []string{"https://app.example.com", "https://admin.example.com"} {
+		headers := request(origin)
+		if got := headers.Get("Access-Control-Allow-Origin"); got != origin {
+			t.Fatalf("expected %s to be echoed, got %q", origin, got)
+		}
+		if headers.Get("Access-Control-Allow-Credentials") != "true" || headers.Get("Vary") != "Origin" {
+			t.Fatalf("expected credentials and Vary for %s, got %v", origin, headers)
+		}
+	}
+
+	for _, origin := range []string{"https://evil.example.com", ""} {
+		headers := request(origin)
+		if _, ok := headers["Access-Control-Allow-Origin"]; ok {
+			t.Fatalf("expected no allow-origin for %q, got %v", origin, headers)
+		}
+		if _, ok := headers["Access-Control-Allow-Credentials"]; ok {
+			t.Fatalf("expected no credentials for %q", origin)
+		}
+	}
+}
+
+func TestCORSWildcard(t *testing.T) {
+	handler := withCORS(parseCORSOrigins("*"), http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/health", nil)
+	req.Header.Set("Origin", "https://anywhere.example.com")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Fatalf("expected wildcard, got %q", got)
+	}
+	if _, ok := rec.Header()["Access-Control-Allow-Credentials"]; ok {
+		t.Fatal("expected no credentials in wildcard mode")
+	}
+}
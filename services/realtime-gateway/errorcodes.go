@@ -0,0 +1,39 @@
+package main
+
+import "net/http"
+
+// Error responses carry a stable code next to the message, so clients can
+// branch on it without matching text. Messages may be reworded; codes may
+// not.
+const codeRealtimeUserStateUnavailable = "REALTIME_USER_STATE_UNAVAILABLE"
+
+// statusErrorCode is the code for errors nothing more specific is known
+// about.
+func statusErrorCode(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return "INVALID_REQUEST"
+	case http.StatusUnauthorized:
+		return "UNAUTHORIZED"
+	case http.StatusForbidden:
+		return "FORBIDDEN"
+	case http.StatusNotFound:
+		return "NOT_FOUND"
+	case http.StatusMethodNotAllowed:
+		return "METHOD_NOT_ALLOWED"
+	case http.StatusConflict:
+		return "CONFLICT"
+	case http.StatusRequestEntityTooLarge:
+		return "PAYLOAD_TOO_LARGE"
+	case http.StatusUnsupportedMediaType:
+		return "UNSUPPORTED_MEDIA_TYPE"
+	case http.StatusTooManyRequests:
+		return "RATE_LIMITED"
+	case http.StatusBadGateway:
+		return "BAD_GATEWAY"
+	case http.StatusServiceUnavailable:
+		return "UNAVAILABLE"
+	}
+
+	return "INTERNAL"
+}
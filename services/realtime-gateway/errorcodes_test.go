@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestGatewayErrorCodes(t *testing.T) {
+	failing := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	gateway := newUserStateGateway(t, failing, failing)
+
+	cases := []struct {
+		method string
+		token  string
+		status int
+		code   string
+	}{
+		{http.MethodGet, "", http.StatusUnauthorized, "UNAUTHORIZED"},
+		{http.MethodPost, "good", http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED"},
+		{http.MethodGet, "good", http.StatusServiceUnavailable, codeRealtimeUserStateUnavailable},
+	}
+	for _, tc := range cases {
+		req, _ := http.NewRequest(tc.method, gateway.URL+"/v1/user-state/usr_2", nil)
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s user state: %v", tc.method, err)
+		}
+		var body struct {
+			Code  string `json:"code"`
+			Error string `json:"error"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+
+		if resp.StatusCode != tc.status || body.Code != tc.code || body.Error == "" {
+			t.Fatalf("%s with token %q: expected %d %s, got %d %+v", tc.method, tc.token, tc.status, tc.code, resp.StatusCode, body)
+		}
+	}
+}
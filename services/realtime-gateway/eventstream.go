@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	eventStreamPath = "/v1/events/stream"
+	// eventStreamKeepAlive is how often an idle stream gets a comment, so
+	// proxies don't time it out.
+	eventStreamKeepAlive = 15 * time.Second
+	// maxEventStreamTopics caps the topics query parameter.
+	maxEventStreamTopics = 100
+)
+
+var errEventStreamEnded = errors.New("event stream ended")
+
+// eventStream writes a client's messages as server-sent events, for clients
+// behind proxies that block WebSockets. Each message is one event with the
+// same JSON a WebSocket client would get as its data.
+type eventStream struct {
+	mu         sync.Mutex
+	w          http.ResponseWriter
+	controller *http.ResponseController
+	writeWait  time.Duration
+	ended      bool
+	done       chan struct{}
+}
+
+func newEventStream(w http.ResponseWriter, writeWait time.Duration) *eventStream {
+	return &eventStream{
+		w:          w,
+		controller: http.NewResponseController(w),
+		writeWait:  writeWait,
+		done:       make(chan struct{}),
+	}
+}
+
+// newEventStreamClient wraps stream as a hub client. It can't send anything
+// back, so its topics are fixed when it connects.
+func newEventStreamClient(stream *eventStream, userID string, credentials clientCredentials, sendBuffer int) *websocketClient {
+	return &websocketClient{
+		id:              "sse_" + randomSuffix(8),
+		stream:          stream,
+		userID:          userID,
+		credentials:     credentials,
+		subscriptions:   map[string]struct{}{},
+		presenceServers: map[string][]string{},
+		send:            make(chan []byte, sendBuffer),
+		done:            make(chan struct{}),
+	}
+}
+
+// writeFrame writes one frame and flushes it. Once the stream has ended the
+// handler has returned and the ResponseWriter must not be touched.
+func (e *eventStream) writeFrame(frame string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.ended {
+		return errEventStreamEnded
+	}
+	if e.writeWait > 0 {
+		_ = e.controller.SetWriteDeadline(time.Now().Add(e.writeWait))
+	}
+	if _, err := io.WriteString(e.w, frame); err != nil {
+		return err
+	}
+
+	return e.controller.Flush()
+}
+
+func (e *eventStream) write(payload []byte) error {
+	// A data line can't hold a newline; the client joins the lines back up.
+	data := strings.ReplaceAll(string(payload), "\n", "\ndata: ")
+	return e.writeFrame("data: " + data + "\n\n")
+}
+
+func (e *eventStream) keepAlive() error {
+	return e.writeFrame(": keep-alive\n\n")
+}
+
+// closeWithCode sends a close event carrying the same code and reason a
+// WebSocket client would get in its close frame, then ends the stream.
+func (e *eventStream) closeWithCode(code int, reason string) {
+	encoded, err := json.Marshal(map[string]any{"code": code, "reason": reason})
+	if err == nil {
+		_ = e.writeFrame("event: close\ndata: " + string(encoded) + "\n\n")
+	}
+
+	e.end()
+}
+
+// end stops the stream; the handler returns once it notices.
+func (e *eventStream) end() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !e.ended {
+		e.ended = true
+		close(e.done)
+	}
+}
+
+// eventStreamTopics reads the topics query parameter: comma separated,
+// repeatable, de-duplicated in order.
+func eventStreamTopics(r *http.Request) []string {
+	seen := map[string]struct{}{}
+	topics := []string{}
+	for _, value := range r.URL.Query()["topics"] {
+		for _, topic := range strings.Split(value, ",") {
+			topic = strings.TrimSpace(topic)
+			if topic == "" {
+				continue
+			}
+			if _, ok := seen[topic]; ok {
+				continue
+			}
+			seen[topic] = struct{}{}
+			topics = append(topics, topic)
+		}
+	}
+
+	return topics
+}
+
+// handleEventStream serves GET /v1/events/stream, the SSE fallback to the
+// WebSocket. It authenticates the same way, subscribes to the topics it was
+// given as a WebSocket subscribe would, and then streams what the hub
+// publishes until the client goes away.
+func (s *server) handleEventStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		s.respondOptions(w)
+		return
+	}
+	if r.Method != http.MethodGet {
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed.")
+		return
+	}
+
+	credentials := readWebSocketCredentials(r)
+	if credentials.empty() {
+		s.respondError(w, http.StatusUnauthorized, "Missing auth token.")
+		return
+	}
+
+	userID, statusCode, err := s.authenticate(credentials)
+	if err != nil {
+		s.respondError(w, statusCode, err.Error())
+		return
+	}
+
+	topics := eventStreamTopics(r)
+	if len(topics) > maxEventStreamTopics {
+		s.respondError(w, http.StatusBadRequest, "Too many topics.")
+		return
+	}
+
+	stream := newEventStream(w, s.cfg.WebSocketWriteWait)
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := stream.controller.Flush(); err != nil {
+		slog.Warn("event stream flush unsupported", "error", err)
+		return
+	}
+
+	client := newEventStreamClient(stream, userID, credentials, s.cfg.WebSocketSendBuffer)
+	client.trusted = s.trustedClient(r)
+	s.hub.register(client)
+	defer func() {
+		s.hub.unregister(client)
+		stream.end()
+	}()
+
+	if err := client.sendJSON(map[string]any{
+		"type":         "ready",
+		"userId":       userID,
+		"connectionId": client.id,
+	}); err != nil {
+		return
+	}
+	for _, topic := range topics {
+		s.subscribeTopic(client, topic)
+	}
+
+	ticker := time.NewTicker(eventStreamKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-stream.done:
+			return
+		case <-ticker.C:
+			if err := stream.keepAlive(); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readEvent reads one server-sent event and returns its data line, failing
+// the test on anything else.
+func readEvent(t *testing.T, reader *bufio.Reader) map[string]any {
+	t.Helper()
+
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatalf("read event: %v", err)
+	}
+	data, ok := strings.CutPrefix(line, "data: ")
+	if !ok {
+		t.Fatalf("expected a data line, got %q", line)
+	}
+	if blank, err := reader.ReadString('\n'); err != nil || blank != "\n" {
+		t.Fatalf("expected a blank line ending the event, got %q (%v)", blank, err)
+	}
+
+	var message map[string]any
+	if err := json.Unmarshal([]byte(data), &message); err != nil {
+		t.Fatalf("decode %q: %v", data, err)
+	}
+	return message
+}
+
+func TestEventStreamDeliversPublishedEvents(t *testing.T) {
+	identity := newTestIdentityServer(t, "good")
+	s, gateway := newTestGateway(t, testConfig(identity.URL))
+
+	res, err := http.Get(gateway.URL + eventStreamPath + "?token=bad")
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a bad token, got %d", res.StatusCode)
+	}
+
+	res, err = http.Get(gateway.URL + eventStreamPath + "?token=good&topics=presence:usr_2,presence:usr_2&topics=bogus")
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(func() { _ = res.Body.Close() })
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("expected an event stream, got %d %q", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+
+	reader := bufio.NewReader(res.Body)
+	if ready := readEvent(t, reader); ready["type"] != "ready" || ready["userId"] != "usr_1" {
+		t.Fatalf("expected ready first, got %+v", ready)
+	}
+	if subscribed := readEvent(t, reader); subscribed["type"] != "subscribed" || subscribed["topic"] != "presence:usr_2" {
+		t.Fatalf("expected the topic subscription, got %+v", subscribed)
+	}
+	if rejected := readEvent(t, reader); rejected["type"] != "error" || rejected["error"] != "Unsupported topic." {
+		t.Fatalf("expected the bad topic to be rejected, got %+v", rejected)
+	}
+
+	publish, err := http.Post(gateway.URL+internalTopicPublishPath, "application/json",
+		strings.NewReader(`{"topic":"presence:usr_2","type":"presence.updated","payload":{"userId":"usr_2","status":"idle"}}`))
+	if err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	_ = publish.Body.Close()
+
+	event := readEvent(t, reader)
+	payload, _ := event["payload"].(map[string]any)
+	if event["type"] != "presence.updated" || event["topic"] != "presence:usr_2" || payload["status"] != "idle" {
+		t.Fatalf("unexpected event %+v", event)
+	}
+
+	// Closing the stream unregisters the client like a closed socket.
+	_ = res.Body.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for s.hub.connectionCount() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the stream's client to be unregistered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
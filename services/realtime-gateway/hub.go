@@ -1,31 +1,74 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
-	"log"
+	"fmt"
+	"log/slog"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+// defaultSendBuffer is how many published events may wait for a connection's
+// writer before the connection counts as too slow and is dropped.
+const defaultSendBuffer = 256
+
 type websocketClient struct {
+	id string
+	// sessionID outlives the connection: reconnecting with it restores the
+	// subscriptions (see resumableSessions).
+	sessionID     string
 	conn          *websocket.Conn
 	userID        string
-	authToken     string
+	credentials   clientCredentials
 	subscriptions map[string]struct{}
-	writeMu       sync.Mutex
-	writeWait     time.Duration
+	// presenceServers holds the member list of each presence.subscribeServer,
+	// so the next one for that server can be diffed against it. Only the
+	// connection's read loop touches it.
+	presenceServers map[string][]string
+	writeMu         sync.Mutex
+	writeWait       time.Duration
+	// send queues published events for writeLoop so a slow reader never
+	// holds up the publisher; done stops writeLoop once the hub lets go.
+	send     chan []byte
+	done     chan struct{}
+	stopOnce sync.Once
+	// stream replaces conn for clients on the server-sent events fallback.
+	stream *eventStream
+	// trusted is set when the client connected with the internal API key,
+	// which wildcard subscriptions require.
+	trusted bool
 }
 
-func newWebSocketClient(conn *websocket.Conn, userID, authToken string, writeWait time.Duration) *websocketClient {
+func newWebSocketClient(conn *websocket.Conn, userID string, credentials clientCredentials, writeWait time.Duration, sendBuffer int) *websocketClient {
 	return &websocketClient{
-		conn:          conn,
-		userID:        userID,
-		authToken:     authToken,
-		subscriptions: map[string]struct{}{},
-		writeWait:     writeWait,
+		id:              "ws_" + randomSuffix(8),
+		conn:            conn,
+		userID:          userID,
+		credentials:     credentials,
+		subscriptions:   map[string]struct{}{},
+		presenceServers: map[string][]string{},
+		writeWait:       writeWait,
+		send:            make(chan []byte, sendBuffer),
+		done:            make(chan struct{}),
+	}
+}
+
+func randomSuffix(n int) string {
+	if n < 2 {
+		n = 2
+	}
+
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
 	}
+
+	return hex.EncodeToString(buf)
 }
 
 func (c *websocketClient) sendJSON(payload any) error {
@@ -41,6 +84,10 @@ func (c *websocketClient) sendRaw(payload []byte) error {
 	c.writeMu.Lock()
 	defer c.writeMu.Unlock()
 
+	if c.stream != nil {
+		return c.stream.write(payload)
+	}
+
 	if c.writeWait > 0 {
 		_ = c.conn.SetWriteDeadline(time.Now().Add(c.writeWait))
 	}
@@ -48,23 +95,150 @@ func (c *websocketClient) sendRaw(payload []byte) error {
 	return c.conn.WriteMessage(websocket.TextMessage, payload)
 }
 
+// enqueue hands a published event to writeLoop without blocking. It reports
+// false when the send buffer is full.
+func (c *websocketClient) enqueue(payload []byte) bool {
+	select {
+	case c.send <- payload:
+		return true
+	default:
+		return false
+	}
+}
+
+// writeLoop writes queued events until the client is stopped. A failed write
+// closes the connection, which ends the read loop and unregisters the client.
+func (c *websocketClient) writeLoop() {
+	for {
+		select {
+		case <-c.done:
+			return
+		case payload := <-c.send:
+			if err := c.sendRaw(payload); err != nil {
+				slog.Warn("publish send failed", "userId", c.userID, "connectionId", c.id, "error", err)
+				c.closeTransport()
+				return
+			}
+		}
+	}
+}
+
+// stop ends writeLoop. It reports whether this call was the one that stopped
+// the client, so eviction is only counted once.
+func (c *websocketClient) stop() bool {
+	stopped := false
+	c.stopOnce.Do(func() {
+		close(c.done)
+		stopped = true
+	})
+
+	return stopped
+}
+
+func (c *websocketClient) sendPing() error {
+	deadline := time.Now().Add(c.writeWait)
+	if c.writeWait <= 0 {
+		deadline = time.Now().Add(5 * time.Second)
+	}
+
+	return c.conn.WriteControl(websocket.PingMessage, nil, deadline)
+}
+
+// closeTransport drops the connection without saying why.
+func (c *websocketClient) closeTransport() {
+	if c.stream != nil {
+		c.stream.end()
+		return
+	}
+
+	_ = c.conn.Close()
+}
+
+func (c *websocketClient) closeWithCode(code int, reason string) {
+	if c.stream != nil {
+		c.stream.closeWithCode(code, reason)
+		return
+	}
+
+	deadline := time.Now().Add(c.writeWait)
+	if c.writeWait <= 0 {
+		deadline = time.Now().Add(5 * time.Second)
+	}
+
+	_ = c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), deadline)
+	_ = c.conn.Close()
+}
+
+// realtimeHub routes payloads to connections by user and by topic. A topic is
+// an opaque string such as "conversation:<id>" that connections subscribe to
+// explicitly; user routing needs no subscription. A subscription ending in
+// ":*", such as "voice:channel:*", is a pattern matching every topic under
+// that prefix.
 type realtimeHub struct {
-	mu                  sync.RWMutex
-	userClients         map[string]map[*websocketClient]struct{}
-	conversationClients map[string]map[*websocketClient]struct{}
+	mu           sync.RWMutex
+	clients      map[string]*websocketClient
+	userClients  map[string]map[*websocketClient]struct{}
+	topicClients map[string]map[*websocketClient]struct{}
+	// patternClients holds pattern subscribers by prefix, kept apart so
+	// exact topics are found with one lookup and patterns are only walked
+	// when someone holds one.
+	patternClients map[string]map[*websocketClient]struct{}
+	metrics        *gatewayMetrics
+	// sessions receives each unregistered client's topics; nil disables
+	// resuming.
+	sessions *resumableSessions
 }
 
-func newRealtimeHub() *realtimeHub {
+func newRealtimeHub(metrics *gatewayMetrics, sessions *resumableSessions) *realtimeHub {
 	return &realtimeHub{
-		userClients:         map[string]map[*websocketClient]struct{}{},
-		conversationClients: map[string]map[*websocketClient]struct{}{},
+		clients:        map[string]*websocketClient{},
+		userClients:    map[string]map[*websocketClient]struct{}{},
+		topicClients:   map[string]map[*websocketClient]struct{}{},
+		patternClients: map[string]map[*websocketClient]struct{}{},
+		metrics:        metrics,
+		sessions:       sessions,
+	}
+}
+
+// topicPattern returns the prefix a pattern topic matches, reporting false
+// for plain topics. Only a trailing ":*" is a wildcard.
+func topicPattern(topic string) (string, bool) {
+	if !strings.HasSuffix(topic, ":*") {
+		return "", false
 	}
+
+	return strings.TrimSuffix(topic, "*"), true
 }
 
+// subscribers returns the index topic is kept in, under its key there.
+func (h *realtimeHub) subscribers(topic string) (map[string]map[*websocketClient]struct{}, string) {
+	if prefix, ok := topicPattern(topic); ok {
+		return h.patternClients, prefix
+	}
+
+	return h.topicClients, topic
+}
+
+func conversationTopic(conversationID string) string {
+	return "conversation:" + conversationID
+}
+
+func (h *realtimeHub) connectionCount() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return len(h.clients)
+}
+
+// register starts routing to client and starts its writer.
 func (h *realtimeHub) register(client *websocketClient) {
+	go client.writeLoop()
+
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
+	h.clients[client.id] = client
+
 	clients, ok := h.userClients[client.userID]
 	if !ok {
 		clients = map[*websocketClient]struct{}{}
@@ -74,37 +248,52 @@ func (h *realtimeHub) register(client *websocketClient) {
 	clients[client] = struct{}{}
 }
 
-func (h *realtimeHub) addSubscription(client *websocketClient, conversationID string) {
+func (h *realtimeHub) subscribe(topic string, client *websocketClient) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
-	clients, ok := h.conversationClients[conversationID]
+	index, key := h.subscribers(topic)
+	clients, ok := index[key]
 	if !ok {
 		clients = map[*websocketClient]struct{}{}
-		h.conversationClients[conversationID] = clients
+		index[key] = clients
 	}
 
 	clients[client] = struct{}{}
-	client.subscriptions[conversationID] = struct{}{}
+	client.subscriptions[topic] = struct{}{}
 }
 
-func (h *realtimeHub) removeSubscription(client *websocketClient, conversationID string) {
+func (h *realtimeHub) unsubscribe(topic string, client *websocketClient) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
-	if clients, ok := h.conversationClients[conversationID]; ok {
+	h.unsubscribeLocked(topic, client)
+}
+
+func (h *realtimeHub) unsubscribeLocked(topic string, client *websocketClient) {
+	index, key := h.subscribers(topic)
+	if clients, ok := index[key]; ok {
 		delete(clients, client)
 		if len(clients) == 0 {
-			delete(h.conversationClients, conversationID)
+			delete(index, key)
 		}
 	}
 
-	delete(client.subscriptions, conversationID)
+	delete(client.subscriptions, topic)
 }
 
+// unregister stops routing to client and, when it has a session id, leaves
+// its topics with the hub's sessions for a later resume. Unregistering twice
+// is harmless.
 func (h *realtimeHub) unregister(client *websocketClient) {
+	client.stop()
+
 	h.mu.Lock()
-	defer h.mu.Unlock()
+	if _, ok := h.clients[client.id]; !ok {
+		h.mu.Unlock()
+		return
+	}
+	delete(h.clients, client.id)
 
 	if clients, ok := h.userClients[client.userID]; ok {
 		delete(clients, client)
@@ -113,26 +302,49 @@ func (h *realtimeHub) unregister(client *websocketClient) {
 		}
 	}
 
-	for conversationID := range client.subscriptions {
-		if clients, ok := h.conversationClients[conversationID]; ok {
-			delete(clients, client)
-			if len(clients) == 0 {
-				delete(h.conversationClients, conversationID)
-			}
-		}
+	topics := make([]string, 0, len(client.subscriptions))
+	for topic := range client.subscriptions {
+		topics = append(topics, topic)
+		h.unsubscribeLocked(topic, client)
 	}
+	h.mu.Unlock()
 
-	client.subscriptions = map[string]struct{}{}
+	if h.sessions != nil && client.sessionID != "" {
+		h.sessions.save(client.sessionID, client.userID, topics, time.Now())
+	}
 }
 
-func (h *realtimeHub) collectTargets(conversationID string, recipientUserIDs []string) []*websocketClient {
+// closeAll closes every connection with code. Read loops notice the closed
+// connections and unregister them.
+func (h *realtimeHub) closeAll(code int, reason string) {
+	h.mu.RLock()
+	clients := make([]*websocketClient, 0, len(h.clients))
+	for _, client := range h.clients {
+		clients = append(clients, client)
+	}
+	h.mu.RUnlock()
+
+	for _, client := range clients {
+		client.closeWithCode(code, reason)
+	}
+}
+
+func (h *realtimeHub) collectTargets(topic string, recipientUserIDs []string) []*websocketClient {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
 	targets := map[*websocketClient]struct{}{}
 
-	if conversationID != "" {
-		if clients, ok := h.conversationClients[conversationID]; ok {
+	if topic != "" {
+		if clients, ok := h.topicClients[topic]; ok {
+			for client := range clients {
+				targets[client] = struct{}{}
+			}
+		}
+		for prefix, clients := range h.patternClients {
+			if !strings.HasPrefix(topic, prefix) {
+				continue
+			}
 			for client := range clients {
 				targets[client] = struct{}{}
 			}
@@ -155,22 +367,39 @@ func (h *realtimeHub) collectTargets(conversationID string, recipientUserIDs []s
 	return result
 }
 
+// publish delivers a conversation event to the conversation's subscribers and
+// to every connection of the listed recipients, each at most once.
 func (h *realtimeHub) publish(conversationID string, recipientUserIDs []string, payload []byte) int {
-	targets := h.collectTargets(conversationID, recipientUserIDs)
-	if len(targets) == 0 {
-		return 0
+	topic := ""
+	if conversationID != "" {
+		topic = conversationTopic(conversationID)
 	}
 
+	return h.deliver(h.collectTargets(topic, recipientUserIDs), payload)
+}
+
+func (h *realtimeHub) publishTopic(topic string, payload []byte) int {
+	return h.deliver(h.collectTargets(topic, nil), payload)
+}
+
+// deliver queues payload for each target and never waits on one. A client
+// whose buffer is full has fallen too far behind: it is unregistered and
+// closed with 1011 rather than left to buffer without bound.
+func (h *realtimeHub) deliver(targets []*websocketClient, payload []byte) int {
 	delivered := 0
 	for _, client := range targets {
-		if err := client.sendRaw(payload); err != nil {
-			log.Printf("[realtime-gateway] publish send failed (user: %s): %v", client.userID, err)
-			h.unregister(client)
-			_ = client.conn.Close()
+		if client.enqueue(payload) {
+			delivered += 1
 			continue
 		}
 
-		delivered += 1
+		if client.stop() {
+			slog.Warn("dropping slow client", "userId", client.userID, "connectionId", client.id)
+			h.metrics.droppedSlowClients.Inc()
+			h.unregister(client)
+			// The close frame can itself wait on the stalled connection.
+			go client.closeWithCode(websocket.CloseInternalServerErr, "Client too slow.")
+		}
 	}
 
 	return delivered
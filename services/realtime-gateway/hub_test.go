@@ -0,0 +1,202 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// newTestClientPair returns a hub-side client and the remote end that observes
+// what the hub writes to it.
+func newTestClientPair(t *testing.T, userID string) (*websocketClient, *websocket.Conn) {
+	t.Helper()
+
+	accepted := make(chan *websocket.Conn, 1)
+	upgrader := websocket.Upgrader{}
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		accepted <- conn
+	}))
+	t.Cleanup(peer.Close)
+
+	remote, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(peer.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { _ = remote.Close() })
+
+	local := <-accepted
+	t.Cleanup(func() { _ = local.Close() })
+
+	return newWebSocketClient(local, userID, clientCredentials{}, time.Second, defaultSendBuffer), remote
+}
+
+func readWithin(conn *websocket.Conn, wait time.Duration) (string, error) {
+	_ = conn.SetReadDeadline(time.Now().Add(wait))
+	_, payload, err := conn.ReadMessage()
+	return string(payload), err
+}
+
+func TestHubPublishTopicFansOutOncePerSubscriber(t *testing.T) {
+	hub := newRealtimeHub(newGatewayMetrics(), nil)
+	first, firstRemote := newTestClientPair(t, "usr_1")
+	second, secondRemote := newTestClientPair(t, "usr_2")
+	outsider, outsiderRemote := newTestClientPair(t, "usr_3")
+	for _, client := range []*websocketClient{first, second, outsider} {
+		hub.register(client)
+	}
+
+	hub.subscribe("voice:channel:chn_1", first)
+	hub.subscribe("voice:channel:chn_1", first)
+	hub.subscribe("voice:channel:chn_1", second)
+	hub.subscribe("voice:channel:chn_2", outsider)
+
+	if delivered := hub.publishTopic("voice:channel:chn_1", []byte(`{"type":"test"}`)); delivered != 2 {
+		t.Fatalf("expected 2 deliveries, got %d", delivered)
+	}
+
+	for _, remote := range []*websocket.Conn{firstRemote, secondRemote} {
+		if payload, err := readWithin(remote, time.Second); err != nil || payload != `{"type":"test"}` {
+			t.Fatalf("expected published payload, got %q (%v)", payload, err)
+		}
+		if payload, err := readWithin(remote, 50*time.Millisecond); err == nil {
+			t.Fatalf("expected exactly one message, got extra %q", payload)
+		}
+	}
+
+	if payload, err := readWithin(outsiderRemote, 50*time.Millisecond); err == nil {
+		t.Fatalf("non-subscriber received %q", payload)
+	}
+}
+
+func TestHubPatternSubscriptions(t *testing.T) {
+	hub := newRealtimeHub(newGatewayMetrics(), nil)
+	exact, exactRemote := newTestClientPair(t, "usr_1")
+	dashboard, dashboardRemote := newTestClientPair(t, "usr_2")
+	outsider, outsiderRemote := newTestClientPair(t, "usr_3")
+	for _, client := range []*websocketClient{exact, dashboard, outsider} {
+		hub.register(client)
+	}
+
+	hub.subscribe("voice:channel:chn_1", exact)
+	hub.subscribe("voice:channel:*", dashboard)
+	hub.subscribe("voice:channel:chn_1", dashboard)
+	hub.subscribe("voice:direct_thread:*", outsider)
+
+	expect := func(remote *websocket.Conn, want string) {
+		t.Helper()
+		if payload, err := readWithin(remote, time.Second); err != nil || payload != want {
+			t.Fatalf("expected %s, got %q (%v)", want, payload, err)
+		}
+	}
+
+	// The dashboard matches both ways but gets the event once; the next
+	// message it reads is the second event.
+	if delivered := hub.publishTopic("voice:channel:chn_1", []byte(`{"n":1}`)); delivered != 2 {
+		t.Fatalf("expected 2 deliveries, got %d", delivered)
+	}
+	expect(exactRemote, `{"n":1}`)
+	expect(dashboardRemote, `{"n":1}`)
+
+	if delivered := hub.publishTopic("voice:channel:chn_2", []byte(`{"n":2}`)); delivered != 1 {
+		t.Fatalf("expected only the pattern to match, got %d deliveries", delivered)
+	}
+	expect(dashboardRemote, `{"n":2}`)
+
+	for _, topic := range []string{"voice:channels:chn_1", "voice:channel", "conversation:chn_1"} {
+		if delivered := hub.publishTopic(topic, []byte(`{}`)); delivered != 0 {
+			t.Fatalf("expected %s to match nothing, got %d deliveries", topic, delivered)
+		}
+	}
+
+	hub.unsubscribe("voice:channel:*", dashboard)
+	if len(hub.patternClients["voice:channel:"]) != 0 {
+		t.Fatalf("expected the pattern to be dropped, got %v", hub.patternClients)
+	}
+	if delivered := hub.publishTopic("voice:channel:chn_2", []byte(`{}`)); delivered != 0 {
+		t.Fatalf("expected no deliveries after unsubscribing, got %d", delivered)
+	}
+
+	for _, remote := range []*websocket.Conn{exactRemote, dashboardRemote, outsiderRemote} {
+		if payload, err := readWithin(remote, 50*time.Millisecond); err == nil {
+			t.Fatalf("expected no further delivery, got %q", payload)
+		}
+	}
+}
+
+func TestHubUnregisterDropsTopicSubscriptions(t *testing.T) {
+	hub := newRealtimeHub(newGatewayMetrics(), nil)
+	client, _ := newTestClientPair(t, "usr_1")
+	hub.register(client)
+	hub.subscribe("conversation:chn_1", client)
+	hub.subscribe("voice:channel:chn_1", client)
+
+	hub.unregister(client)
+
+	if len(hub.topicClients) != 0 || len(client.subscriptions) != 0 {
+		t.Fatalf("expected subscriptions to be cleared, got hub=%v client=%v", hub.topicClients, client.subscriptions)
+	}
+	if delivered := hub.publishTopic("voice:channel:chn_1", []byte(`{}`)); delivered != 0 {
+		t.Fatalf("expected no deliveries after unregister, got %d", delivered)
+	}
+}
+
+func TestHubDropsClientThatStopsReading(t *testing.T) {
+	metrics := newGatewayMetrics()
+	hub := newRealtimeHub(metrics, nil)
+	slow, slowRemote := newTestClientPair(t, "usr_slow")
+	slow.send = make(chan []byte, 4)
+	fast, fastRemote := newTestClientPair(t, "usr_fast")
+	hub.register(slow)
+	hub.register(fast)
+	hub.subscribe("conversation:chn_1", fast)
+
+	// The slow remote never reads, so once the socket buffers fill the writer
+	// stalls and the queue backs up. Publishing must not stall with it.
+	payload := []byte(`"` + strings.Repeat("x", 64<<10) + `"`)
+	started := time.Now()
+	for i := 0; hub.connectionCount() == 2; i++ {
+		if i == 10_000 {
+			t.Fatal("expected the slow client to be dropped")
+		}
+		hub.publish("", []string{"usr_slow"}, payload)
+	}
+	if elapsed := time.Since(started); elapsed > 2*time.Second {
+		t.Fatalf("expected publishing to skip the slow client, took %v", elapsed)
+	}
+
+	if got := testutil.ToFloat64(metrics.droppedSlowClients); got != 1 {
+		t.Fatalf("expected one dropped client, got %v", got)
+	}
+	if len(slow.subscriptions) != 0 || hub.publish("", []string{"usr_slow"}, payload) != 0 {
+		t.Fatal("expected the slow client to be unregistered")
+	}
+
+	if delivered := hub.publishTopic("conversation:chn_1", []byte(`{"type":"test"}`)); delivered != 1 {
+		t.Fatalf("expected the healthy client to keep receiving, got %d", delivered)
+	}
+	if message, err := readWithin(fastRemote, time.Second); err != nil || message != `{"type":"test"}` {
+		t.Fatalf("expected the healthy client's event, got %q (%v)", message, err)
+	}
+
+	// Draining the backlog reaches the close frame.
+	for {
+		if _, err := readWithin(slowRemote, 2*time.Second); err != nil {
+			var closeErr *websocket.CloseError
+			if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseInternalServerErr {
+				t.Fatalf("expected close %d, got %v", websocket.CloseInternalServerErr, err)
+			}
+			break
+		}
+	}
+}
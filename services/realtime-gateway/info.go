@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+)
+
+// infoConfig is the effective configuration, after defaults and clamping.
+// The internal API key is left out; only whether one is set is shown.
+type infoConfig struct {
+	Port                     string   `json:"port"`
+	CorsOrigins              []string `json:"corsOrigins"`
+	IdentityServiceURL       string   `json:"identityServiceUrl"`
+	MessagingServiceURL      string   `json:"messagingServiceUrl"`
+	PresenceServiceURL       string   `json:"presenceServiceUrl"`
+	PresenceBulkMax          int      `json:"presenceBulkMax"`
+	VoiceSignalingURL        string   `json:"voiceSignalingUrl"`
+	UserStateTimeoutMs       int64    `json:"userStateTimeoutMs"`
+	RequestTimeoutMs         int64    `json:"requestTimeoutMs"`
+	MaxPayloadBytes          int64    `json:"maxPayloadBytes"`
+	WebSocketReadLimitBytes  int64    `json:"webSocketReadLimitBytes"`
+	WebSocketWriteTimeoutMs  int64    `json:"webSocketWriteTimeoutMs"`
+	WebSocketSendBuffer      int      `json:"webSocketSendBuffer"`
+	WebSocketPongTimeoutMs   int64    `json:"webSocketPongTimeoutMs"`
+	ResumeGraceMs            int64    `json:"resumeGraceMs"`
+	ShutdownGraceMs          int64    `json:"shutdownGraceMs"`
+	InternalAPIKeyConfigured bool     `json:"internalApiKeyConfigured"`
+}
+
+func (s *server) effectiveConfig() infoConfig {
+	cfg := s.cfg
+	origins := []string{"*"}
+	if !cfg.CorsOrigins.anyOrigin {
+		origins = make([]string, 0, len(cfg.CorsOrigins.origins))
+		for origin := range cfg.CorsOrigins.origins {
+			origins = append(origins, origin)
+		}
+		sort.Strings(origins)
+	}
+
+	return infoConfig{
+		Port:                     cfg.Port,
+		CorsOrigins:              origins,
+		IdentityServiceURL:       cfg.IdentityServiceURL,
+		MessagingServiceURL:      cfg.MessagingServiceURL,
+		PresenceServiceURL:       cfg.PresenceServiceURL,
+		PresenceBulkMax:          cfg.PresenceBulkMax,
+		VoiceSignalingURL:        cfg.VoiceSignalingURL,
+		UserStateTimeoutMs:       cfg.UserStateTimeout.Milliseconds(),
+		RequestTimeoutMs:         cfg.RequestTimeout.Milliseconds(),
+		MaxPayloadBytes:          cfg.MaxPayloadBytes,
+		WebSocketReadLimitBytes:  cfg.WebSocketReadLimit,
+		WebSocketWriteTimeoutMs:  cfg.WebSocketWriteWait.Milliseconds(),
+		WebSocketSendBuffer:      cfg.WebSocketSendBuffer,
+		WebSocketPongTimeoutMs:   cfg.WebSocketPongTimeout.Milliseconds(),
+		ResumeGraceMs:            cfg.ResumeGrace.Milliseconds(),
+		ShutdownGraceMs:          cfg.ShutdownGrace.Milliseconds(),
+		InternalAPIKeyConfigured: cfg.InternalAPIKey != "",
+	}
+}
+
+// handleInfo serves GET /info: the build the instance runs and its
+// effective configuration, for matching behavior to a deploy.
+func (s *server) handleInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed.")
+		return
+	}
+
+	s.respondJSON(w, http.StatusOK, struct {
+		Service string `json:"service"`
+		buildInfo
+		Config infoConfig `json:"config"`
+	}{
+		Service:   s.cfg.ServiceName,
+		buildInfo: currentBuildInfo(),
+		Config:    s.effectiveConfig(),
+	})
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestGatewayInfo(t *testing.T) {
+	cfg := testConfig("http://identity.test")
+	cfg.Port = "4001"
+	cfg.InternalAPIKey = "internal-s3cret"
+	cfg.CorsOrigins = parseCORSOrigins("https://b.example.com,https://a.example.com")
+	_, gateway := newTestGateway(t, cfg)
+
+	res, err := http.Get(gateway.URL + "/info")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", res.StatusCode, body)
+	}
+	if strings.Contains(string(body), "internal-s3cret") {
+		t.Fatalf("expected the internal key to be left out of %s", body)
+	}
+
+	var info struct {
+		Service string `json:"service"`
+		buildInfo
+		Config infoConfig `json:"config"`
+	}
+	if err := json.Unmarshal(body, &info); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if info.Service != "realtime-gateway" || info.Version == "" || info.GoVersion == "" || info.Dependencies == nil {
+		t.Fatalf("expected the build info, got %+v", info.buildInfo)
+	}
+	for _, field := range []string{`"gitCommit"`, `"buildTime"`} {
+		if !strings.Contains(string(body), field) {
+			t.Fatalf("expected %s in %s", field, body)
+		}
+	}
+	config := info.Config
+	if config.Port != "4001" || config.IdentityServiceURL != "http://identity.test" || !config.InternalAPIKeyConfigured {
+		t.Fatalf("unexpected config %+v", config)
+	}
+	if len(config.CorsOrigins) != 2 || config.CorsOrigins[0] != "https://a.example.com" {
+		t.Fatalf("expected the sorted origins, got %v", config.CorsOrigins)
+	}
+}
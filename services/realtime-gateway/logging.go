@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	requestIDHeader   = "X-Request-Id"
+	maxRequestIDBytes = 128
+)
+
+// newLogger returns a logger that writes one JSON object per line, tagged
+// with the service name.
+func newLogger(w io.Writer, service string) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(w, nil)).With("service", service)
+}
+
+// fatal logs err and exits. log.Fatal would record it at info level once slog
+// is the default handler.
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// requestID honours a caller's X-Request-Id so a request can be followed
+// across services, and generates one otherwise.
+func requestID(r *http.Request) string {
+	id := strings.TrimSpace(r.Header.Get(requestIDHeader))
+	if id == "" || len(id) > maxRequestIDBytes || strings.ContainsFunc(id, func(c rune) bool { return c < 0x21 || c > 0x7e }) {
+		return "req_" + randomSuffix(8)
+	}
+
+	return id
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(body []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(body)
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Hijack lets websocket upgrades through the logging middleware.
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+
+	r.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
+// withRequestLogging echoes a request id on every response and logs one line
+// per request once it completes.
+func withRequestLogging(logger *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		id := requestID(r)
+		w.Header().Set(requestIDHeader, id)
+
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+
+		status := recorder.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		logger.Info("request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", status,
+			"durationMs", time.Since(started).Milliseconds(),
+			"requestId", id,
+		)
+	})
+}
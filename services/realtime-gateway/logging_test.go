@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestRequestLoggingEchoesRequestID(t *testing.T) {
+	var out bytes.Buffer
+	handler := withRequestLogging(newLogger(&out, "realtime-gateway"), http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/health", nil)
+	req.Header.Set("X-Request-Id", "req_from_caller")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if got := rec.Header().Get("X-Request-Id"); got != "req_from_caller" {
+		t.Fatalf("expected the caller's request id to be echoed, got %q", got)
+	}
+
+	var line map[string]any
+	if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+		t.Fatalf("expected a JSON log line, got %q: %v", out.String(), err)
+	}
+	for key, want := range map[string]any{
+		"service":   "realtime-gateway",
+		"level":     "INFO",
+		"msg":       "request",
+		"method":    "GET",
+		"path":      "/health",
+		"status":    float64(http.StatusTeapot),
+		"requestId": "req_from_caller",
+	} {
+		if line[key] != want {
+			t.Fatalf("expected %s=%v, got %v in %s", key, want, line[key], out.String())
+		}
+	}
+	if _, ok := line["durationMs"]; !ok {
+		t.Fatalf("expected durationMs in %s", out.String())
+	}
+
+	out.Reset()
+	req = httptest.NewRequest(http.MethodGet, "/health", nil)
+	req.Header.Set("X-Request-Id", "has spaces")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if got := rec.Header().Get("X-Request-Id"); !strings.HasPrefix(got, "req_") || got == "has spaces" {
+		t.Fatalf("expected a generated request id, got %q", got)
+	}
+}
+
+func TestRequestLoggingAllowsWebSocketUpgrades(t *testing.T) {
+	identity := newTestIdentityServer(t, "good")
+	s := newServer(testConfig(identity.URL))
+	mux := http.NewServeMux()
+	s.registerRoutes(mux)
+
+	var out bytes.Buffer
+	gateway := httptest.NewServer(withRequestLogging(newLogger(&out, "realtime-gateway"), mux))
+	t.Cleanup(gateway.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial(webSocketURL(gateway.URL, "?token=good"), nil)
+	if err != nil {
+		t.Fatalf("dial through the logging middleware: %v", err)
+	}
+	defer conn.Close()
+
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, _, err := conn.ReadMessage(); err != nil {
+		t.Fatalf("read ready: %v", err)
+	}
+}
@@ -1,18 +1,63 @@
 package main
 
 import (
-	"log"
+	"context"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
 )
 
 func main() {
 	cfg := loadConfig()
+	logger := newLogger(os.Stderr, cfg.ServiceName)
+	slog.SetDefault(logger)
+	if err := validateConfig(os.Getenv); err != nil {
+		fatal("invalid configuration", err)
+	}
+
 	server := newServer(cfg)
 
 	mux := http.NewServeMux()
 	server.registerRoutes(mux)
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		ticker := time.NewTicker(10 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				server.sessions.cleanupExpired(now)
+			}
+		}
+	}()
+
 	addr := ":" + cfg.Port
-	log.Printf("%s listening on http://localhost%s", cfg.ServiceName, addr)
-	log.Fatal(http.ListenAndServe(addr, mux))
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		fatal("failed to listen", err)
+	}
+
+	// Shutdown does not track hijacked connections, so websockets are told to
+	// reconnect elsewhere explicitly.
+	httpServer := &http.Server{Handler: withRequestLogging(logger, withCORS(cfg.CorsOrigins, mux))}
+	httpServer.RegisterOnShutdown(func() {
+		server.hub.closeAll(websocket.CloseGoingAway, "Server shutting down.")
+	})
+
+	slog.Info("listening", "addr", addr)
+	if err := serve(ctx, httpServer, ln, cfg.ShutdownGrace); err != nil {
+		fatal("server failed", err)
+	}
+	slog.Info("shut down")
 }
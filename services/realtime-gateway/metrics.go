@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// gatewayMetrics uses its own registry rather than the global default so each
+// hub (and each test) gets an isolated set of series.
+type gatewayMetrics struct {
+	registry           *prometheus.Registry
+	droppedSlowClients prometheus.Counter
+}
+
+func newGatewayMetrics() *gatewayMetrics {
+	m := &gatewayMetrics{
+		registry: prometheus.NewRegistry(),
+		droppedSlowClients: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "realtime_dropped_slow_clients_total",
+			Help: "Connections closed because their send buffer filled up.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.droppedSlowClients,
+	)
+
+	return m
+}
+
+func (m *gatewayMetrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
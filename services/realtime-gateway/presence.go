@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+func presenceTopic(userID string) string {
+	return "presence:" + userID
+}
+
+// subscribePresence handles presence.subscribe: it subscribes the connection
+// to presence:<userId> for every requested user and sends their current
+// presence as one presence.snapshot. Topics are subscribed before the
+// snapshot is fetched so no update can fall between the two; a client may
+// therefore see a delta just ahead of a snapshot that already includes it.
+func (s *server) subscribePresence(client *websocketClient, rawUserIDs []string) {
+	// Checked before normalizing, like presence-service's bulk endpoint, so
+	// the cost of a message is bounded by what the client sent.
+	if len(rawUserIDs) > s.cfg.PresenceBulkMax {
+		_ = client.sendJSON(map[string]any{
+			"type":  "error",
+			"error": fmt.Sprintf("userIds must contain at most %d entries.", s.cfg.PresenceBulkMax),
+		})
+		return
+	}
+
+	userIDs := normalizeIDs(rawUserIDs)
+	if len(userIDs) == 0 {
+		_ = client.sendJSON(map[string]any{
+			"type":  "error",
+			"error": "userIds is required.",
+		})
+		return
+	}
+
+	for _, userID := range userIDs {
+		s.hub.subscribe(presenceTopic(userID), client)
+	}
+
+	snapshot, err := s.fetchPresence(client.credentials, userIDs)
+	if err != nil {
+		for _, userID := range userIDs {
+			s.hub.unsubscribe(presenceTopic(userID), client)
+		}
+		_ = client.sendJSON(map[string]any{
+			"type":  "error",
+			"error": "Presence service unavailable.",
+		})
+		slog.Error("presence snapshot failed", "userId", client.userID, "error", err)
+		return
+	}
+
+	_ = client.sendJSON(map[string]any{
+		"type":    "presence.snapshot",
+		"payload": snapshot,
+	})
+}
+
+// subscribeServerPresence handles presence.subscribeServer: the connection
+// follows the presence of a server's members, sent as userIds. Repeating it
+// for the same server replaces the member list, so only the difference
+// changes: members who left are unsubscribed, new members are subscribed and
+// the presence.snapshot covers just them. An empty list stops following the
+// server. Members still followed through another server stay subscribed,
+// but one also followed through presence.subscribe does not.
+func (s *server) subscribeServerPresence(client *websocketClient, rawServerID string, rawUserIDs []string) {
+	serverID := strings.TrimSpace(rawServerID)
+	if serverID == "" {
+		_ = client.sendJSON(map[string]any{
+			"type":  "error",
+			"error": "serverId is required.",
+		})
+		return
+	}
+	if len(rawUserIDs) > s.cfg.PresenceBulkMax {
+		_ = client.sendJSON(map[string]any{
+			"type":  "error",
+			"error": fmt.Sprintf("userIds must contain at most %d entries.", s.cfg.PresenceBulkMax),
+		})
+		return
+	}
+
+	members := normalizeIDs(rawUserIDs)
+	previous := client.presenceServers[serverID]
+	followedElsewhere := func(userID string) bool {
+		for id, others := range client.presenceServers {
+			if id != serverID && slices.Contains(others, userID) {
+				return true
+			}
+		}
+		return false
+	}
+
+	added := make([]string, 0)
+	for _, userID := range members {
+		if !slices.Contains(previous, userID) {
+			added = append(added, userID)
+			s.hub.subscribe(presenceTopic(userID), client)
+		}
+	}
+
+	var snapshot json.RawMessage
+	if len(added) > 0 {
+		var err error
+		snapshot, err = s.fetchPresence(client.credentials, added)
+		if err != nil {
+			for _, userID := range added {
+				if !followedElsewhere(userID) {
+					s.hub.unsubscribe(presenceTopic(userID), client)
+				}
+			}
+			_ = client.sendJSON(map[string]any{
+				"type":  "error",
+				"error": "Presence service unavailable.",
+			})
+			slog.Error("presence snapshot failed", "userId", client.userID, "serverId", serverID, "error", err)
+			return
+		}
+	}
+
+	for _, userID := range previous {
+		if !slices.Contains(members, userID) && !followedElsewhere(userID) {
+			s.hub.unsubscribe(presenceTopic(userID), client)
+		}
+	}
+	if len(members) == 0 {
+		delete(client.presenceServers, serverID)
+	} else {
+		client.presenceServers[serverID] = members
+	}
+
+	if snapshot != nil {
+		_ = client.sendJSON(map[string]any{
+			"type":     "presence.snapshot",
+			"serverId": serverID,
+			"payload":  snapshot,
+		})
+	}
+}
+
+// fetchPresence reads the current presence of userIDs from presence-service
+// on the connection's behalf. The response is relayed to the client as is.
+func (s *server) fetchPresence(credentials clientCredentials, userIDs []string) (json.RawMessage, error) {
+	body, err := json.Marshal(map[string]any{"userIds": userIDs})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.cfg.PresenceServiceURL+"/v1/presence/bulk", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	credentials.apply(req)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("presence bulk returned %d", resp.StatusCode)
+	}
+
+	payload, err := io.ReadAll(io.LimitReader(resp.Body, s.cfg.MaxPayloadBytes))
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid(payload) {
+		return nil, errors.New("presence bulk returned invalid JSON")
+	}
+
+	return json.RawMessage(payload), nil
+}
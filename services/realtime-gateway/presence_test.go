@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func newTestPresenceServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	presence := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/presence/bulk" || r.Header.Get("Authorization") != "Bearer good" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var body struct {
+			UserIDs []string `json:"userIds"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		states := make([]map[string]string, 0, len(body.UserIDs))
+		for _, userID := range body.UserIDs {
+			states = append(states, map[string]string{"userId": userID, "status": "online"})
+		}
+		_ = json.NewEncoder(w).Encode(states)
+	}))
+	t.Cleanup(presence.Close)
+
+	return presence
+}
+
+func dialReady(t *testing.T, gatewayURL string) *websocket.Conn {
+	t.Helper()
+
+	conn, _, err := websocket.DefaultDialer.Dial(webSocketURL(gatewayURL, "?token=good"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	var ready map[string]any
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err := conn.ReadJSON(&ready); err != nil || ready["type"] != "ready" {
+		t.Fatalf("expected ready message, got %v (%v)", ready, err)
+	}
+
+	return conn
+}
+
+func TestPresenceSubscribeSendsSnapshotThenDeltas(t *testing.T) {
+	identity := newTestIdentityServer(t, "good")
+	cfg := testConfig(identity.URL)
+	cfg.PresenceServiceURL = newTestPresenceServer(t).URL
+	_, gateway := newTestGateway(t, cfg)
+	conn := dialReady(t, gateway.URL)
+
+	if err := conn.WriteJSON(map[string]any{
+		"type": "presence.subscribe",
+		"data": map[string]any{"userIds": []string{"usr_2", " usr_3 ", "usr_2"}},
+	}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	var snapshot struct {
+		Type    string              `json:"type"`
+		Payload []map[string]string `json:"payload"`
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err := conn.ReadJSON(&snapshot); err != nil {
+		t.Fatalf("read snapshot: %v", err)
+	}
+	if snapshot.Type != "presence.snapshot" || len(snapshot.Payload) != 2 ||
+		snapshot.Payload[0]["userId"] != "usr_2" || snapshot.Payload[1]["userId"] != "usr_3" {
+		t.Fatalf("unexpected snapshot %+v", snapshot)
+	}
+
+	res, err := http.Post(gateway.URL+internalTopicPublishPath, "application/json",
+		strings.NewReader(`{"topic":"presence:usr_3","type":"presence.updated","payload":{"userId":"usr_3","status":"dnd"}}`))
+	if err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	_ = res.Body.Close()
+
+	var delta map[string]any
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err := conn.ReadJSON(&delta); err != nil {
+		t.Fatalf("read delta: %v", err)
+	}
+	if delta["type"] != "presence.updated" || delta["topic"] != "presence:usr_3" {
+		t.Fatalf("unexpected delta %+v", delta)
+	}
+}
+
+func TestPresenceSubscribeEnforcesBulkCap(t *testing.T) {
+	identity := newTestIdentityServer(t, "good")
+	cfg := testConfig(identity.URL)
+	cfg.PresenceServiceURL = newTestPresenceServer(t).URL
+	cfg.PresenceBulkMax = 2
+	s, gateway := newTestGateway(t, cfg)
+	conn := dialReady(t, gateway.URL)
+
+	for _, userIDs := range [][]string{{"usr_1", "usr_2", "usr_3"}, {" "}} {
+		if err := conn.WriteJSON(map[string]any{"type": "presence.subscribe", "userIds": userIDs}); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+
+		var reply map[string]any
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if err := conn.ReadJSON(&reply); err != nil || reply["type"] != "error" {
+			t.Fatalf("expected an error for %v, got %v (%v)", userIDs, reply, err)
+		}
+	}
+
+	s.hub.mu.RLock()
+	defer s.hub.mu.RUnlock()
+	if len(s.hub.topicClients) != 0 {
+		t.Fatalf("expected no subscriptions, got %v", s.hub.topicClients)
+	}
+}
+
+func TestPresenceSubscribeServerDiffsMembers(t *testing.T) {
+	identity := newTestIdentityServer(t, "good")
+	cfg := testConfig(identity.URL)
+	cfg.PresenceServiceURL = newTestPresenceServer(t).URL
+	s, gateway := newTestGateway(t, cfg)
+	conn := dialReady(t, gateway.URL)
+
+	subscribeServer := func(userIDs ...string) []string {
+		t.Helper()
+		if err := conn.WriteJSON(map[string]any{
+			"type": "presence.subscribeServer",
+			"data": map[string]any{"serverId": "srv_1", "userIds": userIDs},
+		}); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+
+		var snapshot struct {
+			Type     string              `json:"type"`
+			ServerID string              `json:"serverId"`
+			Payload  []map[string]string `json:"payload"`
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if err := conn.ReadJSON(&snapshot); err != nil {
+			t.Fatalf("read snapshot: %v", err)
+		}
+		if snapshot.Type != "presence.snapshot" || snapshot.ServerID != "srv_1" {
+			t.Fatalf("unexpected snapshot %+v", snapshot)
+		}
+		userIDs = make([]string, 0, len(snapshot.Payload))
+		for _, state := range snapshot.Payload {
+			userIDs = append(userIDs, state["userId"])
+		}
+		return userIDs
+	}
+	publish := func(userID string) {
+		t.Helper()
+		res, err := http.Post(gateway.URL+internalTopicPublishPath, "application/json",
+			strings.NewReader(`{"topic":"presence:`+userID+`","type":"presence.updated","payload":{"userId":"`+userID+`","status":"idle"}}`))
+		if err != nil {
+			t.Fatalf("publish: %v", err)
+		}
+		_ = res.Body.Close()
+	}
+	readDelta := func() string {
+		t.Helper()
+		var delta map[string]any
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if err := conn.ReadJSON(&delta); err != nil || delta["type"] != "presence.updated" {
+			t.Fatalf("expected a presence delta, got %v (%v)", delta, err)
+		}
+		return delta["topic"].(string)
+	}
+
+	if got := subscribeServer("usr_2", "usr_3"); !slices.Equal(got, []string{"usr_2", "usr_3"}) {
+		t.Fatalf("expected a snapshot of both members, got %v", got)
+	}
+	publish("usr_2")
+	if topic := readDelta(); topic != "presence:usr_2" {
+		t.Fatalf("expected usr_2's delta, got %s", topic)
+	}
+
+	// usr_2 leaves and usr_4 joins: only usr_4 is snapshotted, and usr_2's
+	// updates stop arriving.
+	if got := subscribeServer("usr_3", "usr_4"); !slices.Equal(got, []string{"usr_4"}) {
+		t.Fatalf("expected a snapshot of only the new member, got %v", got)
+	}
+	publish("usr_2")
+	publish("usr_4")
+	if topic := readDelta(); topic != "presence:usr_4" {
+		t.Fatalf("expected usr_4's delta and none for usr_2, got %s", topic)
+	}
+
+	s.hub.mu.RLock()
+	defer s.hub.mu.RUnlock()
+	for _, userID := range []string{"usr_3", "usr_4"} {
+		if len(s.hub.topicClients[presenceTopic(userID)]) != 1 {
+			t.Fatalf("expected %s to stay subscribed, got %v", userID, s.hub.topicClients)
+		}
+	}
+	if _, ok := s.hub.topicClients[presenceTopic("usr_2")]; ok {
+		t.Fatalf("expected usr_2 to be unsubscribed, got %v", s.hub.topicClients)
+	}
+}
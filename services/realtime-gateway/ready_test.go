@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadyChecksUpstreamServices(t *testing.T) {
+	healthy := func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) }
+	identity := httptest.NewServer(http.HandlerFunc(healthy))
+	t.Cleanup(identity.Close)
+	messaging := httptest.NewServer(http.HandlerFunc(healthy))
+
+	cfg := testConfig(identity.URL)
+	cfg.MessagingServiceURL = messaging.URL
+	_, gateway := newTestGateway(t, cfg)
+
+	ready := func() (int, map[string]string) {
+		t.Helper()
+		res, err := http.Get(gateway.URL + "/ready")
+		if err != nil {
+			t.Fatalf("get /ready: %v", err)
+		}
+		defer res.Body.Close()
+
+		var body struct {
+			Checks map[string]string `json:"checks"`
+		}
+		if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return res.StatusCode, body.Checks
+	}
+
+	if code, checks := ready(); code != http.StatusOK || checks["identity"] != "ok" || checks["messaging"] != "ok" {
+		t.Fatalf("expected ready, got %d %v", code, checks)
+	}
+
+	messaging.Close()
+	if code, checks := ready(); code != http.StatusServiceUnavailable || checks["identity"] != "ok" || checks["messaging"] == "ok" {
+		t.Fatalf("expected the messaging failure to be reported, got %d %v", code, checks)
+	}
+}
@@ -2,26 +2,34 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
 const (
-	internalPublishPath = "/internal/realtime/events"
-	webSocketPath       = "/v1/ws"
+	internalPublishPath      = "/internal/realtime/events"
+	internalTopicPublishPath = "/internal/publish"
+	webSocketPath            = "/v1/ws"
+	userStatePath            = "/v1/user-state/"
+
+	closeCodeUnauthorized = 4401
 )
 
 type server struct {
 	cfg      config
 	hub      *realtimeHub
+	sessions *resumableSessions
+	metrics  *gatewayMetrics
 	client   *http.Client
 	upgrader websocket.Upgrader
 }
@@ -30,10 +38,30 @@ type meResponse struct {
 	ID string `json:"id"`
 }
 
+// clientEnvelope is the common shape of every client-to-gateway message. Data
+// carries the type-specific body; older clients send the fields at the top
+// level instead, which realtimeClientMessage still accepts.
+type clientEnvelope struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+type clientCredentials struct {
+	Authorization string
+	Cookie        string
+}
+
 type realtimeClientMessage struct {
 	Type           string `json:"type"`
 	ChannelID      string `json:"channelId"`
 	ConversationID string `json:"conversationId"`
+	Topic          string `json:"topic"`
+	// UserIDs and ServerID are only read by presence.subscribe and
+	// presence.subscribeServer.
+	UserIDs  []string `json:"userIds"`
+	ServerID string   `json:"serverId"`
+	// T is the client's clock on a ping, echoed back untouched.
+	T json.Number `json:"t"`
 }
 
 type realtimePublishRequest struct {
@@ -43,14 +71,24 @@ type realtimePublishRequest struct {
 	RecipientUserIDs []string        `json:"recipientUserIds"`
 }
 
+type realtimeTopicPublishRequest struct {
+	Topic   string          `json:"topic"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+}
+
 func newServer(cfg config) *server {
+	metrics := newGatewayMetrics()
+	sessions := newResumableSessions(cfg.ResumeGrace)
 	return &server{
-		cfg:    cfg,
-		hub:    newRealtimeHub(),
-		client: &http.Client{Timeout: cfg.RequestTimeout},
+		cfg:      cfg,
+		hub:      newRealtimeHub(metrics, sessions),
+		sessions: sessions,
+		metrics:  metrics,
+		client:   &http.Client{Timeout: cfg.RequestTimeout},
 		upgrader: websocket.Upgrader{
-			CheckOrigin: func(_ *http.Request) bool {
-				return true
+			CheckOrigin: func(r *http.Request) bool {
+				return cfg.CorsOrigins.allowsOrigin(r.Header.Get("Origin"))
 			},
 		},
 	}
@@ -58,16 +96,39 @@ func newServer(cfg config) *server {
 
 func (s *server) registerRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("/health", s.handleHealth)
+	mux.HandleFunc("/ready", s.handleReady)
+	mux.HandleFunc("/info", s.handleInfo)
+	mux.Handle("/metrics", s.metrics.handler())
 	mux.HandleFunc(webSocketPath, s.handleWebSocket)
 	mux.HandleFunc(internalPublishPath, s.handleInternalPublish)
+	mux.HandleFunc(internalTopicPublishPath, s.handleInternalTopicPublish)
+	mux.HandleFunc(userStatePath, s.handleUserState)
+	mux.HandleFunc(eventStreamPath, s.handleEventStream)
 	mux.HandleFunc("/", s.handleRoot)
 }
 
 func (s *server) handleHealth(w http.ResponseWriter, _ *http.Request) {
-	s.respondJSON(w, http.StatusOK, map[string]string{
-		"service": s.cfg.ServiceName,
-		"status":  "ok",
+	s.respondJSON(w, http.StatusOK, map[string]any{
+		"service":     s.cfg.ServiceName,
+		"status":      "ok",
+		"connections": s.hub.connectionCount(),
+	})
+}
+
+// handleReady reports whether the services the gateway authorizes against are
+// reachable. /health stays a cheap liveness probe.
+func (s *server) handleReady(w http.ResponseWriter, r *http.Request) {
+	statuses, ready := runReadinessChecks(r.Context(), []readinessCheck{
+		{name: "identity", probe: func(ctx context.Context) error {
+			return pingHealth(ctx, s.client, s.cfg.IdentityServiceURL)
+		}},
+		{name: "messaging", probe: func(ctx context.Context) error {
+			return pingHealth(ctx, s.client, s.cfg.MessagingServiceURL)
+		}},
 	})
+
+	code, body := readinessResponse(s.cfg.ServiceName, statuses, ready)
+	s.respondJSON(w, code, body)
 }
 
 func (s *server) handleRoot(w http.ResponseWriter, r *http.Request) {
@@ -80,8 +141,14 @@ func (s *server) handleRoot(w http.ResponseWriter, r *http.Request) {
 		"service": s.cfg.ServiceName,
 		"routes": []string{
 			"GET /health",
-			"GET /v1/ws?token=...",
+			"GET /ready",
+			"GET /info",
+			"GET /metrics",
+			"GET /v1/ws?token=...&resume=...",
+			"GET /v1/events/stream?token=...&topics=...",
+			"GET /v1/user-state/:userId",
 			"POST /internal/realtime/events",
+			"POST /internal/publish",
 		},
 	})
 }
@@ -97,33 +164,69 @@ func (s *server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token := readWebSocketAuthToken(r)
-	if token == "" {
-		s.respondError(w, http.StatusUnauthorized, "Missing websocket auth token.")
+	credentials := readWebSocketCredentials(r)
+	// Browsers attach cookies to sockets any page opens, so they only count
+	// from origins CORS_ORIGINS lists.
+	if credentials.Cookie != "" && !s.cfg.CorsOrigins.allowsCredentials(r.Header.Get("Origin")) {
+		credentials.Cookie = ""
+		if credentials.empty() {
+			s.rejectWebSocket(w, r, http.StatusForbidden, "Origin not allowed to authenticate with cookies.")
+			return
+		}
+	}
+	if credentials.empty() {
+		s.rejectWebSocket(w, r, http.StatusUnauthorized, "Missing websocket auth token.")
 		return
 	}
 
-	userID, statusCode, err := s.authenticateToken(token)
+	userID, statusCode, err := s.authenticate(credentials)
 	if err != nil {
-		s.respondError(w, statusCode, err.Error())
+		s.rejectWebSocket(w, r, statusCode, err.Error())
 		return
 	}
 
 	conn, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("[realtime-gateway] websocket upgrade failed: %v", err)
+		slog.Warn("websocket upgrade failed", "error", err)
 		return
 	}
 
-	client := newWebSocketClient(conn, userID, token, s.cfg.WebSocketWriteWait)
+	client := newWebSocketClient(conn, userID, credentials, s.cfg.WebSocketWriteWait, s.cfg.WebSocketSendBuffer)
 	client.conn.SetReadLimit(s.cfg.WebSocketReadLimit)
+	client.sessionID = newSessionID()
+	client.trusted = s.trustedClient(r)
+
+	// A session left by an earlier connection brings its subscriptions back;
+	// an unknown or expired one silently starts fresh.
+	topics := []string{}
+	resumed := false
+	if sessionID := strings.TrimSpace(r.URL.Query().Get("resume")); sessionID != "" {
+		if saved, ok := s.sessions.take(sessionID, userID, time.Now()); ok {
+			client.sessionID = sessionID
+			// Patterns only come back to a connection that may hold them.
+			for _, topic := range saved {
+				if _, pattern := topicPattern(topic); !pattern || client.trusted {
+					topics = append(topics, topic)
+				}
+			}
+			resumed = true
+		}
+	}
+
 	s.hub.register(client)
+	for _, topic := range topics {
+		s.hub.subscribe(topic, client)
+	}
 
 	if err := client.sendJSON(map[string]any{
-		"type":   "ready",
-		"userId": userID,
+		"type":          "ready",
+		"userId":        userID,
+		"connectionId":  client.id,
+		"sessionId":     client.sessionID,
+		"resumed":       resumed,
+		"subscriptions": topics,
 	}); err != nil {
-		log.Printf("[realtime-gateway] websocket ready send failed (user: %s): %v", userID, err)
+		slog.Warn("websocket ready send failed", "userId", userID, "error", err)
 		s.hub.unregister(client)
 		_ = client.conn.Close()
 		return
@@ -132,12 +235,42 @@ func (s *server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	go s.readWebSocketLoop(client)
 }
 
+// rejectWebSocket reports an authentication failure. Browsers cannot read the
+// status of a failed handshake, so upgrade requests that are unauthorized get
+// a 4401 close frame instead of a plain HTTP error.
+func (s *server) rejectWebSocket(w http.ResponseWriter, r *http.Request, status int, message string) {
+	if status != http.StatusUnauthorized || !websocket.IsWebSocketUpgrade(r) {
+		s.respondError(w, status, message)
+		return
+	}
+
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		slog.Warn("websocket upgrade failed", "error", err)
+		return
+	}
+
+	client := newWebSocketClient(conn, "", clientCredentials{}, s.cfg.WebSocketWriteWait, 0)
+	client.closeWithCode(closeCodeUnauthorized, message)
+}
+
 func (s *server) readWebSocketLoop(client *websocketClient) {
+	done := make(chan struct{})
 	defer func() {
+		close(done)
 		s.hub.unregister(client)
 		_ = client.conn.Close()
 	}()
 
+	if s.cfg.WebSocketPongTimeout > 0 {
+		_ = client.conn.SetReadDeadline(time.Now().Add(s.cfg.WebSocketPongTimeout))
+		client.conn.SetPongHandler(func(string) error {
+			return client.conn.SetReadDeadline(time.Now().Add(s.cfg.WebSocketPongTimeout))
+		})
+
+		go s.pingWebSocketLoop(client, done)
+	}
+
 	for {
 		_, payload, err := client.conn.ReadMessage()
 		if err != nil {
@@ -147,7 +280,7 @@ func (s *server) readWebSocketLoop(client *websocketClient) {
 				websocket.CloseGoingAway,
 				websocket.CloseNoStatusReceived,
 			) {
-				log.Printf("[realtime-gateway] websocket read failed (user: %s): %v", client.userID, err)
+				slog.Warn("websocket read failed", "userId", client.userID, "connectionId", client.id, "error", err)
 			}
 			return
 		}
@@ -156,9 +289,30 @@ func (s *server) readWebSocketLoop(client *websocketClient) {
 	}
 }
 
+// pingWebSocketLoop sends protocol-level pings often enough that a healthy
+// client's pong always lands before the read deadline expires.
+func (s *server) pingWebSocketLoop(client *websocketClient, done <-chan struct{}) {
+	ticker := time.NewTicker(s.cfg.WebSocketPongTimeout * 9 / 10)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := client.sendPing(); err != nil {
+				slog.Warn("websocket ping failed", "userId", client.userID, "connectionId", client.id, "error", err)
+				_ = client.conn.Close()
+				return
+			}
+		}
+	}
+}
+
 func (s *server) handleClientMessage(client *websocketClient, payload []byte) {
+	var envelope clientEnvelope
 	var parsed realtimeClientMessage
-	if err := json.Unmarshal(payload, &parsed); err != nil {
+	if err := json.Unmarshal(payload, &envelope); err != nil {
 		_ = client.sendJSON(map[string]any{
 			"type":  "error",
 			"error": "Invalid JSON message.",
@@ -166,14 +320,38 @@ func (s *server) handleClientMessage(client *websocketClient, payload []byte) {
 		return
 	}
 
-	switch strings.TrimSpace(parsed.Type) {
+	_ = json.Unmarshal(payload, &parsed)
+	if data := bytes.TrimSpace(envelope.Data); len(data) > 0 && !bytes.Equal(data, []byte("null")) {
+		if err := json.Unmarshal(data, &parsed); err != nil {
+			_ = client.sendJSON(map[string]any{
+				"type":  "error",
+				"error": "Invalid message data.",
+			})
+			return
+		}
+	}
+
+	switch strings.TrimSpace(envelope.Type) {
 	case "ping":
-		_ = client.sendJSON(map[string]any{
-			"type": "pong",
-		})
+		// An application-level probe, unlike the protocol pings that keep
+		// the socket alive: with t echoed back and serverT (Unix ms) the
+		// client can work out its round trip and clock offset.
+		pong := map[string]any{
+			"type":    "pong",
+			"serverT": time.Now().UnixMilli(),
+		}
+		if parsed.T != "" {
+			pong["t"] = parsed.T
+		}
+		_ = client.sendJSON(pong)
 		return
 
 	case "subscribe":
+		if topic := strings.TrimSpace(parsed.Topic); topic != "" {
+			s.subscribeTopic(client, topic)
+			return
+		}
+
 		conversationID := normalizedConversationID(parsed)
 		if conversationID == "" {
 			_ = client.sendJSON(map[string]any{
@@ -183,13 +361,13 @@ func (s *server) handleClientMessage(client *websocketClient, payload []byte) {
 			return
 		}
 
-		allowed, statusCode, err := s.authorizeConversation(client.authToken, conversationID)
+		allowed, statusCode, err := s.authorizeConversation(client.credentials, conversationID)
 		if err != nil {
 			_ = client.sendJSON(map[string]any{
 				"type":  "error",
 				"error": "Authorization service unavailable.",
 			})
-			log.Printf("[realtime-gateway] subscribe authorization failed: %v", err)
+			slog.Error("subscribe authorization failed", "userId", client.userID, "error", err)
 			return
 		}
 
@@ -206,14 +384,31 @@ func (s *server) handleClientMessage(client *websocketClient, payload []byte) {
 			return
 		}
 
-		s.hub.addSubscription(client, conversationID)
+		s.hub.subscribe(conversationTopic(conversationID), client)
 		_ = client.sendJSON(map[string]any{
 			"type":      "subscribed",
 			"channelId": conversationID,
 		})
 		return
 
+	case "presence.subscribe":
+		s.subscribePresence(client, parsed.UserIDs)
+		return
+
+	case "presence.subscribeServer":
+		s.subscribeServerPresence(client, parsed.ServerID, parsed.UserIDs)
+		return
+
 	case "unsubscribe":
+		if topic := strings.TrimSpace(parsed.Topic); topic != "" {
+			s.hub.unsubscribe(topic, client)
+			_ = client.sendJSON(map[string]any{
+				"type":  "unsubscribed",
+				"topic": topic,
+			})
+			return
+		}
+
 		conversationID := normalizedConversationID(parsed)
 		if conversationID == "" {
 			_ = client.sendJSON(map[string]any{
@@ -223,7 +418,7 @@ func (s *server) handleClientMessage(client *websocketClient, payload []byte) {
 			return
 		}
 
-		s.hub.removeSubscription(client, conversationID)
+		s.hub.unsubscribe(conversationTopic(conversationID), client)
 		_ = client.sendJSON(map[string]any{
 			"type":      "unsubscribed",
 			"channelId": conversationID,
@@ -238,6 +433,40 @@ func (s *server) handleClientMessage(client *websocketClient, payload []byte) {
 	}
 }
 
+func (s *server) subscribeTopic(client *websocketClient, topic string) {
+	allowed, statusCode, err := s.authorizeSubscription(client, topic)
+	if err != nil {
+		_ = client.sendJSON(map[string]any{
+			"type":  "error",
+			"error": "Authorization service unavailable.",
+		})
+		slog.Error("topic subscribe authorization failed", "userId", client.userID, "topic", topic, "error", err)
+		return
+	}
+
+	if !allowed {
+		message := "Not authorized for this topic."
+		switch statusCode {
+		case http.StatusNotFound:
+			message = "Topic not found."
+		case http.StatusBadRequest:
+			message = "Unsupported topic."
+		}
+
+		_ = client.sendJSON(map[string]any{
+			"type":  "error",
+			"error": message,
+		})
+		return
+	}
+
+	s.hub.subscribe(topic, client)
+	_ = client.sendJSON(map[string]any{
+		"type":  "subscribed",
+		"topic": topic,
+	})
+}
+
 func (s *server) handleInternalPublish(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodOptions {
 		s.respondOptions(w)
@@ -260,39 +489,96 @@ func (s *server) handleInternalPublish(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	eventType := strings.TrimSpace(body.Type)
-	if eventType == "" {
-		s.respondError(w, http.StatusBadRequest, "type is required.")
+	encodedMessage, statusCode, err := encodeRealtimeEvent(body.Type, body.Payload, nil)
+	if err != nil {
+		s.respondError(w, statusCode, err.Error())
 		return
 	}
 
-	encodedPayload := bytes.TrimSpace(body.Payload)
-	if len(encodedPayload) == 0 {
-		encodedPayload = []byte("null")
+	conversationID := strings.TrimSpace(body.ConversationID)
+	recipients := normalizeIDs(body.RecipientUserIDs)
+	delivered := s.hub.publish(conversationID, recipients, encodedMessage)
+	s.respondJSON(w, http.StatusAccepted, map[string]any{
+		"accepted":  true,
+		"delivered": delivered,
+	})
+}
+
+func (s *server) handleInternalTopicPublish(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		s.respondOptions(w)
+		return
 	}
-	if !json.Valid(encodedPayload) {
-		s.respondError(w, http.StatusBadRequest, "payload must be valid JSON.")
+
+	if r.Method != http.MethodPost {
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed.")
 		return
 	}
 
-	encodedMessage, err := json.Marshal(map[string]any{
-		"type":    eventType,
-		"payload": json.RawMessage(encodedPayload),
+	if !s.validInternalAPIKey(r.Header.Get("X-Realtime-Internal-Key")) {
+		s.respondError(w, http.StatusUnauthorized, "Unauthorized.")
+		return
+	}
+
+	var body realtimeTopicPublishRequest
+	if err := decodeJSONBody(r.Body, s.cfg.MaxPayloadBytes, &body); err != nil {
+		s.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	topic := strings.TrimSpace(body.Topic)
+	if topic == "" {
+		s.respondError(w, http.StatusBadRequest, "topic is required.")
+		return
+	}
+
+	encodedMessage, statusCode, err := encodeRealtimeEvent(body.Type, body.Payload, map[string]any{
+		"topic": topic,
 	})
 	if err != nil {
-		s.respondError(w, http.StatusInternalServerError, "Failed to encode publish payload.")
+		s.respondError(w, statusCode, err.Error())
 		return
 	}
 
-	conversationID := strings.TrimSpace(body.ConversationID)
-	recipients := normalizeIDs(body.RecipientUserIDs)
-	delivered := s.hub.publish(conversationID, recipients, encodedMessage)
+	delivered := s.hub.publishTopic(topic, encodedMessage)
 	s.respondJSON(w, http.StatusAccepted, map[string]any{
 		"accepted":  true,
 		"delivered": delivered,
 	})
 }
 
+// encodeRealtimeEvent builds the {type, payload} frame sent to clients, adding
+// any extra top-level fields. The returned status applies when err is non-nil.
+func encodeRealtimeEvent(rawType string, payload json.RawMessage, extra map[string]any) ([]byte, int, error) {
+	eventType := strings.TrimSpace(rawType)
+	if eventType == "" {
+		return nil, http.StatusBadRequest, errors.New("type is required.")
+	}
+
+	encodedPayload := bytes.TrimSpace(payload)
+	if len(encodedPayload) == 0 {
+		encodedPayload = []byte("null")
+	}
+	if !json.Valid(encodedPayload) {
+		return nil, http.StatusBadRequest, errors.New("payload must be valid JSON.")
+	}
+
+	message := map[string]any{
+		"type":    eventType,
+		"payload": json.RawMessage(encodedPayload),
+	}
+	for key, value := range extra {
+		message[key] = value
+	}
+
+	encodedMessage, err := json.Marshal(message)
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("Failed to encode publish payload.")
+	}
+
+	return encodedMessage, http.StatusOK, nil
+}
+
 func (s *server) validInternalAPIKey(provided string) bool {
 	configured := strings.TrimSpace(s.cfg.InternalAPIKey)
 	if configured == "" {
@@ -307,13 +593,13 @@ func (s *server) validInternalAPIKey(provided string) bool {
 	return subtle.ConstantTimeCompare([]byte(configured), []byte(actual)) == 1
 }
 
-func (s *server) authenticateToken(token string) (string, int, error) {
+func (s *server) authenticate(credentials clientCredentials) (string, int, error) {
 	req, err := http.NewRequest(http.MethodGet, s.cfg.IdentityServiceURL+"/v1/me", nil)
 	if err != nil {
 		return "", http.StatusInternalServerError, errors.New("Failed to build identity request.")
 	}
 
-	req.Header.Set("Authorization", "Bearer "+token)
+	credentials.apply(req)
 
 	resp, err := s.client.Do(req)
 	if err != nil {
@@ -338,8 +624,8 @@ func (s *server) authenticateToken(token string) (string, int, error) {
 	return userID, http.StatusOK, nil
 }
 
-func (s *server) authorizeConversation(token string, conversationID string) (bool, int, error) {
-	channelStatus, err := s.messagingReadStatus(token, "/v1/channels/"+url.PathEscape(conversationID)+"/messages?limit=1")
+func (s *server) authorizeConversation(credentials clientCredentials, conversationID string) (bool, int, error) {
+	channelStatus, err := s.messagingReadStatus(credentials, "/v1/channels/"+url.PathEscape(conversationID)+"/messages?limit=1")
 	if err != nil {
 		return false, http.StatusServiceUnavailable, err
 	}
@@ -357,7 +643,7 @@ func (s *server) authorizeConversation(token string, conversationID string) (boo
 		return false, http.StatusBadRequest, nil
 	}
 
-	directThreadStatus, err := s.messagingReadStatus(token, "/v1/direct-threads/"+url.PathEscape(conversationID)+"/messages?limit=1")
+	directThreadStatus, err := s.messagingReadStatus(credentials, "/v1/direct-threads/"+url.PathEscape(conversationID)+"/messages?limit=1")
 	if err != nil {
 		return false, http.StatusServiceUnavailable, err
 	}
@@ -377,13 +663,60 @@ func (s *server) authorizeConversation(token string, conversationID string) (boo
 	}
 }
 
-func (s *server) messagingReadStatus(token string, path string) (int, error) {
+// trustedClient reports whether a connecting client presented the internal
+// API key, as backend tools such as a moderation dashboard do.
+func (s *server) trustedClient(r *http.Request) bool {
+	provided := r.Header.Get("X-Realtime-Internal-Key")
+	return strings.TrimSpace(provided) != "" && s.validInternalAPIKey(provided)
+}
+
+// authorizeSubscription decides whether client may subscribe to topic. A
+// pattern spans targets the user could never be checked against one by one,
+// so only trusted clients may hold one.
+func (s *server) authorizeSubscription(client *websocketClient, topic string) (bool, int, error) {
+	if _, pattern := topicPattern(topic); pattern {
+		return client.trusted, http.StatusForbidden, nil
+	}
+
+	return s.authorizeTopic(client.credentials, topic)
+}
+
+// authorizeTopic decides whether a connection may subscribe to a topic. Topics
+// are "<kind>:<id>"; unknown kinds are rejected with StatusBadRequest.
+func (s *server) authorizeTopic(credentials clientCredentials, topic string) (bool, int, error) {
+	kind, id, ok := strings.Cut(topic, ":")
+	id = strings.TrimSpace(id)
+	if !ok || id == "" {
+		return false, http.StatusBadRequest, nil
+	}
+
+	switch kind {
+	case "conversation", "typing":
+		return s.authorizeConversation(credentials, id)
+	case "voice":
+		// Voice topics are "voice:<targetKind>:<targetId>"; both channel and
+		// direct-thread calls follow the conversation's read access.
+		_, targetID, ok := strings.Cut(id, ":")
+		if !ok || strings.TrimSpace(targetID) == "" {
+			return false, http.StatusBadRequest, nil
+		}
+		return s.authorizeConversation(credentials, strings.TrimSpace(targetID))
+	case "presence":
+		// Presence is readable by any authenticated user, matching
+		// presence-service's own GET /v1/presence/:userId.
+		return true, http.StatusOK, nil
+	default:
+		return false, http.StatusBadRequest, nil
+	}
+}
+
+func (s *server) messagingReadStatus(credentials clientCredentials, path string) (int, error) {
 	req, err := http.NewRequest(http.MethodGet, s.cfg.MessagingServiceURL+path, nil)
 	if err != nil {
 		return http.StatusInternalServerError, err
 	}
 
-	req.Header.Set("Authorization", "Bearer "+token)
+	credentials.apply(req)
 	resp, err := s.client.Do(req)
 	if err != nil {
 		return http.StatusServiceUnavailable, err
@@ -411,6 +744,31 @@ func readWebSocketAuthToken(r *http.Request) string {
 	return readBearerToken(r.Header.Get("Authorization"))
 }
 
+func readWebSocketCredentials(r *http.Request) clientCredentials {
+	credentials := clientCredentials{
+		Cookie: strings.TrimSpace(r.Header.Get("Cookie")),
+	}
+
+	if token := readWebSocketAuthToken(r); token != "" {
+		credentials.Authorization = "Bearer " + token
+	}
+
+	return credentials
+}
+
+func (c clientCredentials) empty() bool {
+	return c.Authorization == "" && c.Cookie == ""
+}
+
+func (c clientCredentials) apply(req *http.Request) {
+	if c.Authorization != "" {
+		req.Header.Set("Authorization", c.Authorization)
+	}
+	if c.Cookie != "" {
+		req.Header.Set("Cookie", c.Cookie)
+	}
+}
+
 func readBearerToken(header string) string {
 	raw := strings.TrimSpace(header)
 	if raw == "" {
@@ -488,17 +846,22 @@ func (s *server) respondJSON(w http.ResponseWriter, status int, payload any) {
 	_ = json.NewEncoder(w).Encode(payload)
 }
 
+// respondError answers with message and the generic code for status.
 func (s *server) respondError(w http.ResponseWriter, status int, message string) {
+	s.respondErrorCode(w, status, statusErrorCode(status), message)
+}
+
+func (s *server) respondErrorCode(w http.ResponseWriter, status int, code, message string) {
 	s.respondJSON(w, status, map[string]string{
+		"code":  code,
 		"error": message,
 	})
 }
 
 func (s *server) corsHeaders() map[string]string {
 	return map[string]string{
-		"Access-Control-Allow-Origin":  s.cfg.CorsOrigin,
 		"Access-Control-Allow-Methods": "GET,POST,OPTIONS",
-		"Access-Control-Allow-Headers": "Content-Type, Authorization, Cookie, X-Realtime-Internal-Key",
+		"Access-Control-Allow-Headers": "Content-Type, Authorization, Cookie, X-Realtime-Internal-Key, X-Request-Id",
 		"Access-Control-Max-Age":       "86400",
 	}
 }
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func newTestIdentityServer(t *testing.T, validToken string) *httptest.Server {
+	t.Helper()
+
+	identity := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+validToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(meResponse{ID: "usr_1"})
+	}))
+	t.Cleanup(identity.Close)
+
+	return identity
+}
+
+func newTestGateway(t *testing.T, cfg config) (*server, *httptest.Server) {
+	t.Helper()
+
+	s := newServer(cfg)
+	mux := http.NewServeMux()
+	s.registerRoutes(mux)
+
+	gateway := httptest.NewServer(mux)
+	t.Cleanup(gateway.Close)
+
+	return s, gateway
+}
+
+func testConfig(identityURL string) config {
+	return config{
+		ServiceName:          "realtime-gateway",
+		CorsOrigins:          parseCORSOrigins("*"),
+		IdentityServiceURL:   identityURL,
+		PresenceBulkMax:      100,
+		UserStateTimeout:     time.Second,
+		RequestTimeout:       time.Second,
+		MaxPayloadBytes:      1 << 20,
+		WebSocketReadLimit:   1 << 16,
+		WebSocketWriteWait:   time.Second,
+		WebSocketSendBuffer:  defaultSendBuffer,
+		ResumeGrace:          time.Minute,
+		WebSocketPongTimeout: time.Minute,
+	}
+}
+
+func webSocketURL(base string, query string) string {
+	return "ws" + strings.TrimPrefix(base, "http") + webSocketPath + query
+}
+
+func TestWebSocketRejectsUnauthorizedWith4401(t *testing.T) {
+	identity := newTestIdentityServer(t, "good")
+	_, gateway := newTestGateway(t, testConfig(identity.URL))
+
+	for _, query := range []string{"", "?token=bad"} {
+		conn, _, err := websocket.DefaultDialer.Dial(webSocketURL(gateway.URL, query), nil)
+		if err != nil {
+			t.Fatalf("dial %q: %v", query, err)
+		}
+
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, _, err = conn.ReadMessage()
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) || closeErr.Code != closeCodeUnauthorized {
+			t.Fatalf("query %q: expected close %d, got %v", query, closeCodeUnauthorized, err)
+		}
+		_ = conn.Close()
+	}
+}
+
+func TestWebSocketCookieAuthChecksOrigin(t *testing.T) {
+	identity := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Cookie") != "session=good" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(meResponse{ID: "usr_1"})
+	}))
+	t.Cleanup(identity.Close)
+
+	cfg := testConfig(identity.URL)
+	cfg.CorsOrigins = parseCORSOrigins("*,https://app.example.com")
+	_, gateway := newTestGateway(t, cfg)
+	dial := func(origin string) (*websocket.Conn, *http.Response, error) {
+		header := http.Header{"Cookie": {"session=good"}}
+		if origin != "" {
+			header.Set("Origin", origin)
+		}
+		return websocket.DefaultDialer.Dial(webSocketURL(gateway.URL, ""), header)
+	}
+
+	conn, res, err := dial("https://evil.example.com")
+	if err == nil {
+		_ = conn.Close()
+		t.Fatal("expected a cookie-authenticated socket from another site to be refused")
+	}
+	if res == nil || res.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected 403, got %+v (%v)", res, err)
+	}
+
+	for _, origin := range []string{"https://app.example.com", ""} {
+		conn, _, err := dial(origin)
+		if err != nil {
+			t.Fatalf("dial from %q: %v", origin, err)
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var ready map[string]any
+		if err := conn.ReadJSON(&ready); err != nil || ready["type"] != "ready" {
+			t.Fatalf("expected %q to be let in, got %v (%v)", origin, ready, err)
+		}
+		_ = conn.Close()
+	}
+}
+
+func TestWebSocketSendsPeriodicPings(t *testing.T) {
+	identity := newTestIdentityServer(t, "good")
+	cfg := testConfig(identity.URL)
+	cfg.WebSocketPongTimeout = 100 * time.Millisecond
+	s, gateway := newTestGateway(t, cfg)
+
+	conn, _, err := websocket.DefaultDialer.Dial(webSocketURL(gateway.URL, "?token=good"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	pings := make(chan struct{}, 8)
+	conn.SetPingHandler(func(data string) error {
+		pings <- struct{}{}
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+
+	var ready map[string]any
+	if err := conn.ReadJSON(&ready); err != nil || ready["type"] != "ready" {
+		t.Fatalf("expected ready message, got %v (%v)", ready, err)
+	}
+
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-pings:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("expected ping %d", i+1)
+		}
+	}
+
+	if count := s.hub.connectionCount(); count != 1 {
+		t.Fatalf("expected the answering client to stay connected, got %d connections", count)
+	}
+}
+
+func TestHubCloseAllSendsGoingAway(t *testing.T) {
+	identity := newTestIdentityServer(t, "good")
+	s, gateway := newTestGateway(t, testConfig(identity.URL))
+
+	conn, _, err := websocket.DefaultDialer.Dial(webSocketURL(gateway.URL, "?token=good"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, _, err := conn.ReadMessage(); err != nil {
+		t.Fatalf("read ready: %v", err)
+	}
+
+	s.hub.closeAll(websocket.CloseGoingAway, "Server shutting down.")
+	_, _, err = conn.ReadMessage()
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseGoingAway {
+		t.Fatalf("expected close %d, got %v", websocket.CloseGoingAway, err)
+	}
+}
+
+func TestPingEchoesClientTime(t *testing.T) {
+	identity := newTestIdentityServer(t, "good")
+	_, gateway := newTestGateway(t, testConfig(identity.URL))
+	conn := dialReady(t, gateway.URL)
+
+	readPong := func() map[string]json.RawMessage {
+		t.Helper()
+		var pong map[string]json.RawMessage
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if err := conn.ReadJSON(&pong); err != nil || string(pong["type"]) != `"pong"` {
+			t.Fatalf("expected pong, got %v (%v)", pong, err)
+		}
+		return pong
+	}
+
+	before := time.Now().UnixMilli()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"ping","t":1760000000123.25}`)); err != nil {
+		t.Fatalf("write ping: %v", err)
+	}
+	pong := readPong()
+	if string(pong["t"]) != "1760000000123.25" {
+		t.Fatalf("expected the client time echoed exactly, got %s", pong["t"])
+	}
+	var serverT int64
+	if err := json.Unmarshal(pong["serverT"], &serverT); err != nil || serverT < before || serverT > time.Now().UnixMilli() {
+		t.Fatalf("expected serverT to be the gateway's time in ms, got %s (%v)", pong["serverT"], err)
+	}
+
+	// Plain pings still get a pong, just without t.
+	if err := conn.WriteJSON(map[string]any{"type": "ping"}); err != nil {
+		t.Fatalf("write ping: %v", err)
+	}
+	if pong := readPong(); pong["t"] != nil || pong["serverT"] == nil {
+		t.Fatalf("expected only serverT without a client time, got %v", pong)
+	}
+}
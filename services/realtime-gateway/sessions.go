@@ -0,0 +1,81 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// resumableSession is what a disconnected connection leaves behind so the
+// same user can pick its subscriptions back up by reconnecting with
+// ?resume=<sessionId>.
+type resumableSession struct {
+	userID    string
+	topics    []string
+	expiresAt time.Time
+}
+
+// resumableSessions remembers subscription sets for a short grace window
+// after a connection closes. They live in this gateway instance only, so a
+// client that reconnects to another instance starts fresh.
+type resumableSessions struct {
+	mu       sync.Mutex
+	grace    time.Duration
+	sessions map[string]resumableSession
+}
+
+func newResumableSessions(grace time.Duration) *resumableSessions {
+	return &resumableSessions{
+		grace:    grace,
+		sessions: map[string]resumableSession{},
+	}
+}
+
+func newSessionID() string {
+	return "wss_" + randomSuffix(12)
+}
+
+// save keeps a closed connection's topics until the grace window passes.
+func (s *resumableSessions) save(sessionID, userID string, topics []string, now time.Time) {
+	if s.grace <= 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessions[sessionID] = resumableSession{
+		userID:    userID,
+		topics:    topics,
+		expiresAt: now.Add(s.grace),
+	}
+}
+
+// take hands a saved session's topics to a reconnecting user, at most once.
+// Sessions belonging to someone else are left alone.
+func (s *resumableSessions) take(sessionID, userID string, now time.Time) ([]string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[sessionID]
+	if !ok || session.userID != userID {
+		return nil, false
+	}
+
+	delete(s.sessions, sessionID)
+	if !session.expiresAt.After(now) {
+		return nil, false
+	}
+
+	return session.topics, true
+}
+
+func (s *resumableSessions) cleanupExpired(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for sessionID, session := range s.sessions {
+		if !session.expiresAt.After(now) {
+			delete(s.sessions, sessionID)
+		}
+	}
+}
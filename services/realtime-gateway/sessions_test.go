@@ -0,0 +1,189 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type readyMessage struct {
+	Type          string   `json:"type"`
+	SessionID     string   `json:"sessionId"`
+	Resumed       bool     `json:"resumed"`
+	Subscriptions []string `json:"subscriptions"`
+}
+
+func dialSession(t *testing.T, gatewayURL, resume string) (*websocket.Conn, readyMessage) {
+	t.Helper()
+
+	query := "?token=good"
+	if resume != "" {
+		query += "&resume=" + resume
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(webSocketURL(gatewayURL, query), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	var ready readyMessage
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err := conn.ReadJSON(&ready); err != nil || ready.Type != "ready" || ready.SessionID == "" {
+		t.Fatalf("expected ready with a session id, got %+v (%v)", ready, err)
+	}
+
+	return conn, ready
+}
+
+// disconnect closes conn and waits for the gateway to notice.
+func disconnect(t *testing.T, s *server, conn *websocket.Conn) {
+	t.Helper()
+
+	_ = conn.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for s.hub.connectionCount() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the gateway to drop the connection")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func publishTopic(t *testing.T, gatewayURL, topic string) {
+	t.Helper()
+
+	res, err := http.Post(gatewayURL+internalTopicPublishPath, "application/json",
+		strings.NewReader(`{"topic":"`+topic+`","type":"presence.updated","payload":{}}`))
+	if err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	_ = res.Body.Close()
+}
+
+func TestWebSocketResumeRestoresSubscriptions(t *testing.T) {
+	identity := newTestIdentityServer(t, "good")
+	s, gateway := newTestGateway(t, testConfig(identity.URL))
+
+	conn, first := dialSession(t, gateway.URL, "")
+	if first.Resumed {
+		t.Fatal("expected a fresh connection not to be resumed")
+	}
+	if err := conn.WriteJSON(map[string]any{"type": "subscribe", "topic": "presence:usr_2"}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	var subscribed map[string]any
+	if err := conn.ReadJSON(&subscribed); err != nil || subscribed["type"] != "subscribed" {
+		t.Fatalf("expected subscribed, got %v (%v)", subscribed, err)
+	}
+	disconnect(t, s, conn)
+
+	conn, second := dialSession(t, gateway.URL, first.SessionID)
+	if !second.Resumed || second.SessionID != first.SessionID ||
+		len(second.Subscriptions) != 1 || second.Subscriptions[0] != "presence:usr_2" {
+		t.Fatalf("expected the session to resume with its subscription, got %+v", second)
+	}
+
+	publishTopic(t, gateway.URL, "presence:usr_2")
+	var event map[string]any
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err := conn.ReadJSON(&event); err != nil || event["topic"] != "presence:usr_2" {
+		t.Fatalf("expected the restored subscription to deliver, got %v (%v)", event, err)
+	}
+
+	// A session resumes once; presenting it again starts fresh.
+	disconnect(t, s, conn)
+	if _, third := dialSession(t, gateway.URL, "wss_unknown"); third.Resumed || third.SessionID == "wss_unknown" {
+		t.Fatalf("expected an unknown session to start fresh, got %+v", third)
+	}
+}
+
+func TestWebSocketResumeAfterGraceStartsFresh(t *testing.T) {
+	identity := newTestIdentityServer(t, "good")
+	cfg := testConfig(identity.URL)
+	cfg.ResumeGrace = 50 * time.Millisecond
+	s, gateway := newTestGateway(t, cfg)
+
+	conn, first := dialSession(t, gateway.URL, "")
+	if err := conn.WriteJSON(map[string]any{"type": "subscribe", "topic": "presence:usr_2"}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	var subscribed map[string]any
+	if err := conn.ReadJSON(&subscribed); err != nil || subscribed["type"] != "subscribed" {
+		t.Fatalf("expected subscribed, got %v (%v)", subscribed, err)
+	}
+	disconnect(t, s, conn)
+	time.Sleep(2 * cfg.ResumeGrace)
+
+	_, second := dialSession(t, gateway.URL, first.SessionID)
+	if second.Resumed || second.SessionID == first.SessionID || len(second.Subscriptions) != 0 {
+		t.Fatalf("expected an expired session to start fresh, got %+v", second)
+	}
+	if delivered := s.hub.publishTopic("presence:usr_2", []byte(`{}`)); delivered != 0 {
+		t.Fatalf("expected no restored subscriptions, got %d deliveries", delivered)
+	}
+}
+
+func TestResumableSessionsCleanupExpired(t *testing.T) {
+	sessions := newResumableSessions(time.Minute)
+	now := time.Now()
+	sessions.save("wss_1", "usr_1", []string{"presence:usr_2"}, now)
+	sessions.save("wss_2", "usr_1", nil, now.Add(30*time.Second))
+
+	sessions.cleanupExpired(now.Add(time.Minute))
+	if _, ok := sessions.sessions["wss_1"]; ok {
+		t.Fatal("expected the expired session to be removed")
+	}
+	if _, ok := sessions.take("wss_2", "usr_2", now); ok {
+		t.Fatal("expected another user's session to stay out of reach")
+	}
+	if topics, ok := sessions.take("wss_2", "usr_1", now.Add(time.Minute)); !ok || len(topics) != 0 {
+		t.Fatalf("expected the live session to resume, got %v %v", topics, ok)
+	}
+}
+
+func TestWebSocketPatternSubscriptionsNeedInternalKey(t *testing.T) {
+	identity := newTestIdentityServer(t, "good")
+	cfg := testConfig(identity.URL)
+	cfg.InternalAPIKey = "internal"
+	_, gateway := newTestGateway(t, cfg)
+
+	subscribe := func(header http.Header) map[string]any {
+		t.Helper()
+		conn, _, err := websocket.DefaultDialer.Dial(webSocketURL(gateway.URL, "?token=good"), header)
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		defer conn.Close()
+
+		var ready map[string]any
+		if err := conn.ReadJSON(&ready); err != nil {
+			t.Fatalf("ready: %v", err)
+		}
+		if err := conn.WriteJSON(map[string]any{"type": "subscribe", "topic": "voice:channel:*"}); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		var reply map[string]any
+		if err := conn.ReadJSON(&reply); err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		return reply
+	}
+
+	for _, key := range []string{"", "wrong"} {
+		header := http.Header{}
+		if key != "" {
+			header.Set("X-Realtime-Internal-Key", key)
+		}
+		if reply := subscribe(header); reply["type"] != "error" {
+			t.Fatalf("expected a pattern to be refused with key %q, got %v", key, reply)
+		}
+	}
+
+	header := http.Header{"X-Realtime-Internal-Key": {"internal"}}
+	if reply := subscribe(header); reply["type"] != "subscribed" || reply["topic"] != "voice:channel:*" {
+		t.Fatalf("expected the trusted client to subscribe, got %v", reply)
+	}
+}
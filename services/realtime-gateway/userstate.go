@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// userStateResponse combines a user's presence with whether they are in a
+// voice call, for profile cards. Either half is null when its service did
+// not answer in time, and Unavailable names which.
+type userStateResponse struct {
+	UserID      string          `json:"userId"`
+	Presence    json.RawMessage `json:"presence"`
+	Voice       json.RawMessage `json:"voice"`
+	Unavailable []string        `json:"unavailable"`
+}
+
+// userVoiceState mirrors voice-signaling's GET /v1/voice/users/:userId.
+type userVoiceState struct {
+	InCall     bool    `json:"inCall"`
+	TargetKind *string `json:"targetKind"`
+	TargetID   *string `json:"targetId"`
+}
+
+// handleUserState serves GET /v1/user-state/:userId. Presence is read with
+// the caller's credentials, so the presence service's visibility rules
+// apply; voice-signaling trusts the authenticated user id instead, so
+// revealVoice holds the voice half to the same rules.
+func (s *server) handleUserState(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		s.respondOptions(w)
+		return
+	}
+	if r.Method != http.MethodGet {
+		s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed.")
+		return
+	}
+
+	userID := strings.TrimSpace(strings.TrimPrefix(r.URL.Path, userStatePath))
+	if userID == "" || strings.Contains(userID, "/") {
+		s.respondError(w, http.StatusNotFound, "Route not found.")
+		return
+	}
+
+	credentials := readWebSocketCredentials(r)
+	if credentials.empty() {
+		s.respondError(w, http.StatusUnauthorized, "Missing auth token.")
+		return
+	}
+
+	viewerID, statusCode, err := s.authenticate(credentials)
+	if err != nil {
+		s.respondError(w, statusCode, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.cfg.UserStateTimeout)
+	defer cancel()
+
+	response := userStateResponse{UserID: userID, Unavailable: []string{}}
+	var presenceErr, voiceErr error
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		response.Presence, presenceErr = s.fetchUpstreamJSON(ctx, s.cfg.PresenceServiceURL+"/v1/presence/"+url.PathEscape(userID), credentials.apply)
+	}()
+	go func() {
+		defer wg.Done()
+		response.Voice, voiceErr = s.fetchUpstreamJSON(ctx, s.cfg.VoiceSignalingURL+"/v1/voice/users/"+url.PathEscape(userID), func(req *http.Request) {
+			req.Header.Set("X-Voice-User-Id", viewerID)
+		})
+	}()
+	wg.Wait()
+
+	if voiceErr == nil && userID != viewerID {
+		response.Voice, voiceErr = s.revealVoice(credentials, response.Presence, presenceErr, response.Voice)
+	}
+
+	if presenceErr != nil {
+		slog.Warn("user state presence lookup failed", "userId", userID, "error", presenceErr)
+		response.Unavailable = append(response.Unavailable, "presence")
+	}
+	if voiceErr != nil {
+		slog.Warn("user state voice lookup failed", "userId", userID, "error", voiceErr)
+		response.Unavailable = append(response.Unavailable, "voice")
+	}
+	if presenceErr != nil && voiceErr != nil {
+		s.respondErrorCode(w, http.StatusServiceUnavailable, codeRealtimeUserStateUnavailable, "User state unavailable.")
+		return
+	}
+
+	s.respondJSON(w, http.StatusOK, response)
+}
+
+// revealVoice trims another user's voice state to what the viewer may know.
+// A user the viewer sees as offline, whether invisible or hidden by their
+// visibility setting, is shown as not in a call; when presence could not be
+// read, neither can the voice state be. A channel id is only kept for
+// viewers who can read the channel.
+func (s *server) revealVoice(credentials clientCredentials, presence json.RawMessage, presenceErr error, voice json.RawMessage) (json.RawMessage, error) {
+	if presenceErr != nil {
+		return nil, errors.New("presence is needed to reveal voice state")
+	}
+
+	var seen struct {
+		Status string `json:"status"`
+	}
+	if err := json.Unmarshal(presence, &seen); err != nil {
+		return nil, err
+	}
+	if seen.Status == "" || seen.Status == "offline" {
+		return json.Marshal(userVoiceState{})
+	}
+
+	var state userVoiceState
+	if err := json.Unmarshal(voice, &state); err != nil {
+		return nil, err
+	}
+	if state.TargetKind != nil && *state.TargetKind == "channel" && state.TargetID != nil {
+		allowed, _, err := s.authorizeConversation(credentials, *state.TargetID)
+		if err != nil || !allowed {
+			state.TargetID = nil
+		}
+	}
+
+	return json.Marshal(state)
+}
+
+// fetchUpstreamJSON GETs a JSON document from another service, with
+// prepare adding its credentials.
+func (s *server) fetchUpstreamJSON(ctx context.Context, target string, prepare func(*http.Request)) (json.RawMessage, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	prepare(req)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %d", req.URL.Path, resp.StatusCode)
+	}
+
+	payload, err := io.ReadAll(io.LimitReader(resp.Body, s.cfg.MaxPayloadBytes))
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid(payload) {
+		return nil, errors.New(req.URL.Path + " returned invalid JSON")
+	}
+
+	return json.RawMessage(payload), nil
+}
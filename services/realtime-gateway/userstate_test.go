@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newUserStateGateway stubs presence and voice-signaling with the given
+// handlers behind a gateway. Messaging lets the viewer read chn_1 only.
+func newUserStateGateway(t *testing.T, presence, voice http.HandlerFunc) *httptest.Server {
+	t.Helper()
+
+	presenceServer := httptest.NewServer(presence)
+	t.Cleanup(presenceServer.Close)
+	voiceServer := httptest.NewServer(voice)
+	t.Cleanup(voiceServer.Close)
+	messaging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/channels/chn_1/messages" || r.Header.Get("Authorization") != "Bearer good" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	t.Cleanup(messaging.Close)
+
+	cfg := testConfig(newTestIdentityServer(t, "good").URL)
+	cfg.PresenceServiceURL = presenceServer.URL
+	cfg.VoiceSignalingURL = voiceServer.URL
+	cfg.MessagingServiceURL = messaging.URL
+	cfg.UserStateTimeout = 200 * time.Millisecond
+	_, gateway := newTestGateway(t, cfg)
+	return gateway
+}
+
+func stubPresence(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/presence/usr_2" || r.Header.Get("Authorization") != "Bearer good" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	_, _ = w.Write([]byte(`{"userId":"usr_2","status":"dnd"}`))
+}
+
+func stubVoice(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/voice/users/usr_2" || r.Header.Get("X-Voice-User-Id") != "usr_1" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	_, _ = w.Write([]byte(`{"inCall":true,"targetKind":"channel","targetId":"chn_1"}`))
+}
+
+func getUserState(t *testing.T, gateway *httptest.Server) (int, userStateResponse) {
+	t.Helper()
+
+	req, _ := http.NewRequest(http.MethodGet, gateway.URL+"/v1/user-state/usr_2", nil)
+	req.Header.Set("Authorization", "Bearer good")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("get user state: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var body userStateResponse
+	_ = json.NewDecoder(resp.Body).Decode(&body)
+	return resp.StatusCode, body
+}
+
+func TestUserStateCombinesPresenceAndVoice(t *testing.T) {
+	gateway := newUserStateGateway(t, stubPresence, stubVoice)
+
+	code, body := getUserState(t, gateway)
+	if code != http.StatusOK || len(body.Unavailable) != 0 {
+		t.Fatalf("expected both halves, got %d %+v", code, body)
+	}
+	var presence struct {
+		Status string `json:"status"`
+	}
+	var voice struct {
+		InCall   bool   `json:"inCall"`
+		TargetID string `json:"targetId"`
+	}
+	if err := json.Unmarshal(body.Presence, &presence); err != nil || presence.Status != "dnd" {
+		t.Fatalf("expected the presence state, got %s (%v)", body.Presence, err)
+	}
+	if err := json.Unmarshal(body.Voice, &voice); err != nil || !voice.InCall || voice.TargetID != "chn_1" {
+		t.Fatalf("expected the voice state, got %s (%v)", body.Voice, err)
+	}
+}
+
+func TestUserStateDegradesWhenAnUpstreamFails(t *testing.T) {
+	// Voice hangs past the timeout; presence still comes back.
+	hang := func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}
+	gateway := newUserStateGateway(t, stubPresence, hang)
+
+	started := time.Now()
+	code, body := getUserState(t, gateway)
+	if code != http.StatusOK || body.Presence == nil || string(body.Voice) != "null" {
+		t.Fatalf("expected presence alone, got %d %+v", code, body)
+	}
+	if len(body.Unavailable) != 1 || body.Unavailable[0] != "voice" {
+		t.Fatalf("expected voice to be reported unavailable, got %v", body.Unavailable)
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Fatalf("expected the timeout to cut the lookup short, took %v", elapsed)
+	}
+
+	failing := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	// Without presence the viewer's right to see the voice state can't be
+	// checked, so it is withheld too.
+	gateway = newUserStateGateway(t, failing, stubVoice)
+	if code, body := getUserState(t, gateway); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected voice to be withheld without presence, got %d %+v", code, body)
+	}
+
+	gateway = newUserStateGateway(t, failing, failing)
+	if code, _ := getUserState(t, gateway); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 with both upstreams down, got %d", code)
+	}
+}
+
+// decodeUserVoice reads the voice half of a user state response.
+func decodeUserVoice(t *testing.T, raw json.RawMessage) userVoiceState {
+	t.Helper()
+
+	var voice userVoiceState
+	if err := json.Unmarshal(raw, &voice); err != nil {
+		t.Fatalf("decode voice %s: %v", raw, err)
+	}
+	return voice
+}
+
+func TestUserStateHidesVoiceOfUsersSeenOffline(t *testing.T) {
+	// Presence answers offline for invisible users and those hidden by their
+	// visibility setting alike.
+	hidden := func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"userId":"usr_2","status":"offline"}`))
+	}
+	gateway := newUserStateGateway(t, hidden, stubVoice)
+
+	code, body := getUserState(t, gateway)
+	if code != http.StatusOK || len(body.Unavailable) != 0 {
+		t.Fatalf("expected a full response, got %d %+v", code, body)
+	}
+	if voice := decodeUserVoice(t, body.Voice); voice.InCall || voice.TargetKind != nil || voice.TargetID != nil {
+		t.Fatalf("expected a hidden user to show as not in a call, got %s", body.Voice)
+	}
+}
+
+func TestUserStateDropsChannelsTheViewerCannotRead(t *testing.T) {
+	private := func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"inCall":true,"targetKind":"channel","targetId":"chn_private"}`))
+	}
+	gateway := newUserStateGateway(t, stubPresence, private)
+
+	code, body := getUserState(t, gateway)
+	if code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	voice := decodeUserVoice(t, body.Voice)
+	if !voice.InCall || voice.TargetKind == nil || *voice.TargetKind != "channel" || voice.TargetID != nil {
+		t.Fatalf("expected the call without its channel id, got %s", body.Voice)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// envMap stands in for os.Getenv.
+type envMap map[string]string
+
+func (env envMap) get(key string) string {
+	return env[key]
+}
+
+func TestValidateConfigPasses(t *testing.T) {
+	env := envMap{
+		"REALTIME_GATEWAY_PORT":                  "4001",
+		"IDENTITY_SERVICE_URL":                   "http://identity:3002",
+		"PRESENCE_SERVICE_URL":                   "http://presence:4002",
+		"REALTIME_GATEWAY_INTERNAL_API_KEY":      "internal-s3cret",
+		"REALTIME_GATEWAY_USER_STATE_TIMEOUT_MS": "500",
+		"REALTIME_GATEWAY_WS_PONG_TIMEOUT_MS":    "0",
+	}
+	if err := validateConfig(env.get); err != nil {
+		t.Fatalf("expected a valid config, got %v", err)
+	}
+}
+
+func TestValidateConfigInsecureDefaults(t *testing.T) {
+	err := validateConfig(envMap{}.get)
+	if err == nil || !strings.Contains(err.Error(), "REALTIME_GATEWAY_INTERNAL_API_KEY") {
+		t.Fatalf("expected open internal routes to be refused, got %v", err)
+	}
+
+	if err := validateConfig(envMap{allowInsecureDefaultsEnv: "true"}.get); err != nil {
+		t.Fatalf("expected ALLOW_INSECURE_DEFAULTS to let them through, got %v", err)
+	}
+}
+
+func TestValidateConfigNamesEachProblem(t *testing.T) {
+	env := envMap{
+		allowInsecureDefaultsEnv:                 "true",
+		"REALTIME_GATEWAY_PORT":                  "0",
+		"VOICE_SIGNALING_URL":                    "ftp://voice:4003",
+		"REALTIME_GATEWAY_USER_STATE_TIMEOUT_MS": "0",
+		"REALTIME_GATEWAY_RESUME_GRACE_MS":       "-1",
+		"REALTIME_GATEWAY_WS_SEND_BUFFER":        "256k",
+	}
+
+	// The escape hatch only covers insecure defaults, not broken values.
+	err := validateConfig(env.get)
+	if err == nil {
+		t.Fatal("expected the config to be refused")
+	}
+	for _, want := range []string{
+		`REALTIME_GATEWAY_PORT: "0" is not a port number`,
+		`VOICE_SIGNALING_URL: "ftp://voice:4003" is not a http/https URL`,
+		"REALTIME_GATEWAY_USER_STATE_TIMEOUT_MS: 0 is below the minimum of 1",
+		"REALTIME_GATEWAY_RESUME_GRACE_MS: -1 is below the minimum of 0",
+		`REALTIME_GATEWAY_WS_SEND_BUFFER: "256k" is not an integer`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q in %v", want, err)
+		}
+	}
+	if !strings.HasPrefix(err.Error(), "5 problem(s): ") {
+		t.Fatalf("expected five problems, got %v", err)
+	}
+}
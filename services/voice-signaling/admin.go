@@ -0,0 +1,201 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const adminSessionsPath = "/v1/voice/admin/sessions/"
+
+// adminSessionView is everything stored about a session, for support and
+// debugging. Participant tokens are left out so the view can be pasted into
+// a ticket; LiveKit identities are included to match the SFU's logs.
+type adminSessionView struct {
+	ID                 string                 `json:"id"`
+	TargetKind         voiceTargetKind        `json:"targetKind"`
+	TargetID           string                 `json:"targetId"`
+	ServerID           *string                `json:"serverId"`
+	RoomName           string                 `json:"roomName"`
+	StartedAt          string                 `json:"startedAt"`
+	UpdatedAt          string                 `json:"updatedAt"`
+	Seq                int64                  `json:"seq"`
+	CreatedBy          string                 `json:"createdBy"`
+	StartReason        *string                `json:"startReason"`
+	Locked             bool                   `json:"locked"`
+	ReconnectGraceMs   int64                  `json:"reconnectGraceMs"`
+	MaxParticipants    *int                   `json:"maxParticipants"`
+	SignalingURL       string                 `json:"signalingUrl"`
+	Metadata           map[string]string      `json:"metadata"`
+	Recording          bool                   `json:"recording"`
+	RecordingStartedAt *string                `json:"recordingStartedAt"`
+	RecordingID        string                 `json:"recordingId"`
+	Participants       []adminParticipantView `json:"participants"`
+}
+
+// adminParticipantView extends the public participant state with what only
+// the store sees. Its connections replace the public ones.
+type adminParticipantView struct {
+	voiceParticipantState
+	Identity          string                `json:"identity"`
+	TokenExpiresAt    *string               `json:"tokenExpiresAt"`
+	LastSpokeAt       *string               `json:"lastSpokeAt"`
+	SpeakingSince     *string               `json:"speakingSince"`
+	MutedBeforeDeafen bool                  `json:"mutedBeforeDeafen"`
+	Metadata          json.RawMessage       `json:"metadata"`
+	Connections       []adminConnectionView `json:"connections"`
+}
+
+type adminConnectionView struct {
+	ConnectionID   string  `json:"connectionId"`
+	Identity       string  `json:"identity"`
+	TokenExpiresAt *string `json:"tokenExpiresAt"`
+	JoinedAt       string  `json:"joinedAt"`
+	LastSeenAt     string  `json:"lastSeenAt"`
+}
+
+// adminTime formats an optional time, with the zero time as nil.
+func adminTime(t time.Time) *string {
+	if t.IsZero() {
+		return nil
+	}
+
+	formatted := t.UTC().Format(time.RFC3339Nano)
+	return &formatted
+}
+
+// AdminSession returns the admin view of the session at kind and targetID.
+func (s *voiceStore) AdminSession(kind voiceTargetKind, targetID string) (adminSessionView, error) {
+	var view adminSessionView
+	err := s.backend.view(func(st voiceState) error {
+		record, err := st.session(targetKey(kind, targetID))
+		if err != nil {
+			return err
+		}
+		if record == nil {
+			return errVoiceSessionNotFound
+		}
+
+		view = s.buildAdminSession(record)
+		return nil
+	})
+
+	return view, err
+}
+
+func (s *voiceStore) buildAdminSession(record *sessionRecord) adminSessionView {
+	now := s.clock.Now().UTC()
+	states := make(map[string]voiceParticipantState, len(record.Participants))
+	for _, state := range participantStates(record, now) {
+		states[state.UserID] = state
+	}
+
+	participants := make([]adminParticipantView, 0, len(record.Participants))
+	for userID, participant := range record.Participants {
+		var speakingSince *string
+		if participant.SpeakingSince != nil {
+			speakingSince = adminTime(*participant.SpeakingSince)
+		}
+
+		connections := make([]adminConnectionView, 0, len(participant.Connections))
+		for connectionID, connection := range participant.Connections {
+			connections = append(connections, adminConnectionView{
+				ConnectionID:   connectionID,
+				Identity:       userID + "_" + connection.IdentitySuffix,
+				TokenExpiresAt: adminTime(connection.TokenExpiresAt),
+				JoinedAt:       connection.JoinedAt.UTC().Format(time.RFC3339Nano),
+				LastSeenAt:     connection.LastSeenAt.UTC().Format(time.RFC3339Nano),
+			})
+		}
+		sort.Slice(connections, func(i, j int) bool {
+			return connections[i].ConnectionID < connections[j].ConnectionID
+		})
+
+		participants = append(participants, adminParticipantView{
+			voiceParticipantState: states[userID],
+			Identity:              userID + "_" + participant.IdentitySuffix,
+			TokenExpiresAt:        adminTime(participant.TokenExpiresAt),
+			LastSpokeAt:           adminTime(participant.LastSpokeAt),
+			SpeakingSince:         speakingSince,
+			MutedBeforeDeafen:     participant.MutedBeforeDeafen,
+			Metadata:              participantProfile(participant.Metadata),
+			Connections:           connections,
+		})
+	}
+	sort.Slice(participants, func(i, j int) bool {
+		return participants[i].UserID < participants[j].UserID
+	})
+
+	return adminSessionView{
+		ID:                 record.ID,
+		TargetKind:         record.TargetKind,
+		TargetID:           record.TargetID,
+		ServerID:           record.ServerID,
+		RoomName:           roomName(record.TargetKind, record.TargetID),
+		StartedAt:          record.StartedAt.UTC().Format(time.RFC3339Nano),
+		UpdatedAt:          record.UpdatedAt.UTC().Format(time.RFC3339Nano),
+		Seq:                record.Seq,
+		CreatedBy:          record.CreatedBy,
+		StartReason:        copyStringPtr(record.StartReason),
+		Locked:             record.Locked,
+		ReconnectGraceMs:   s.sessionReconnectGrace(record).Milliseconds(),
+		MaxParticipants:    s.sessionMaxParticipants(),
+		SignalingURL:       s.sessionSignalingURL(record),
+		Metadata:           sessionMetadata(record),
+		Recording:          record.Recording,
+		RecordingStartedAt: recordingStartedAt(record),
+		RecordingID:        record.RecordingID,
+		Participants:       participants,
+	}
+}
+
+// validAdminSecret reports whether provided matches the configured admin
+// secret. With none configured the admin routes are closed to everyone.
+func (s *server) validAdminSecret(provided string) bool {
+	configured := strings.TrimSpace(s.adminSecret)
+	actual := strings.TrimSpace(provided)
+	if configured == "" || actual == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(configured), []byte(actual)) == 1
+}
+
+// handleAdminSessions serves GET /v1/voice/admin/sessions/:kind/:id, where
+// kind is channel or direct_thread, to callers holding the admin secret.
+func (s *server) handleAdminSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.respondError(w, http.StatusNotFound, "Route not found.")
+		return
+	}
+	if !s.validAdminSecret(r.Header.Get("X-Voice-Admin-Secret")) {
+		s.respondError(w, http.StatusUnauthorized, "Invalid admin secret.")
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, adminSessionsPath), "/"), "/")
+	if len(parts) != 2 {
+		s.respondError(w, http.StatusNotFound, "Route not found.")
+		return
+	}
+	kind := voiceTargetKind(parts[0])
+	if kind != targetChannel && kind != targetDirectThread {
+		s.respondError(w, http.StatusNotFound, "Route not found.")
+		return
+	}
+	if !validID(parts[1]) {
+		s.respondError(w, http.StatusBadRequest, "Invalid target id.")
+		return
+	}
+
+	view, err := s.store.AdminSession(kind, parts[1])
+	if err != nil {
+		s.respondSessionError(w, err)
+		return
+	}
+
+	s.respondJSON(w, http.StatusOK, view)
+}
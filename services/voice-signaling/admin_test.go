@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func getAdminSession(s *server, path, secret string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, adminSessionsPath+path, nil)
+	if secret != "" {
+		req.Header.Set("X-Voice-Admin-Secret", secret)
+	}
+	rec := httptest.NewRecorder()
+	s.handleAdminSessions(rec, req)
+	return rec
+}
+
+func TestVoiceAdminSessionView(t *testing.T) {
+	store := newTestVoiceStore(noopPublisher{})
+	s := &server{store: store, adminSecret: "s3cret"}
+
+	serverID := "srv_1"
+	joined, err := store.Join(targetChannel, "chn_1", "usr_1", &serverID, true, joinVoiceRequest{Muted: boolPtr(true)})
+	if err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	laptop, err := store.Join(targetChannel, "chn_1", "usr_1", &serverID, true, joinVoiceRequest{ConnectionID: "laptop"})
+	if err != nil {
+		t.Fatalf("join laptop: %v", err)
+	}
+
+	rec := getAdminSession(s, "channel/chn_1", "s3cret")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	for _, token := range []string{joined.Signaling.ParticipantToken, laptop.Signaling.ParticipantToken} {
+		if strings.Contains(rec.Body.String(), token) {
+			t.Fatal("expected participant tokens to be left out of the admin view")
+		}
+	}
+
+	var view adminSessionView
+	if err := json.Unmarshal(rec.Body.Bytes(), &view); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if view.ID != joined.ID || view.ServerID == nil || *view.ServerID != serverID || view.Seq != 2 || view.RoomName != roomName(targetChannel, "chn_1") {
+		t.Fatalf("unexpected session fields %+v", view)
+	}
+	if len(view.Participants) != 1 {
+		t.Fatalf("expected one participant, got %+v", view.Participants)
+	}
+
+	participant := view.Participants[0]
+	own := parseParticipantToken(t, joined.Signaling.ParticipantToken, jwt.SigningMethodHS256, []byte("secret"))
+	if participant.UserID != "usr_1" || !participant.Muted || participant.Identity != own.Subject || participant.TokenExpiresAt == nil {
+		t.Fatalf("unexpected participant %+v", participant)
+	}
+	connection := parseParticipantToken(t, laptop.Signaling.ParticipantToken, jwt.SigningMethodHS256, []byte("secret"))
+	if len(participant.Connections) != 1 || participant.Connections[0].ConnectionID != "laptop" || participant.Connections[0].Identity != connection.Subject {
+		t.Fatalf("expected the laptop connection with its identity, got %+v", participant.Connections)
+	}
+
+	if rec := getAdminSession(s, "channel/chn_2", "s3cret"); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown session, got %d", rec.Code)
+	}
+	if rec := getAdminSession(s, "voice/chn_1", "s3cret"); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown kind, got %d", rec.Code)
+	}
+}
+
+func TestVoiceAdminSessionRequiresSecret(t *testing.T) {
+	store := newTestVoiceStore(noopPublisher{})
+	if _, err := store.Join(targetChannel, "chn_1", "usr_1", nil, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+
+	s := &server{store: store, adminSecret: "s3cret"}
+	for _, secret := range []string{"", "wrong"} {
+		if rec := getAdminSession(s, "channel/chn_1", secret); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("expected 401 with secret %q, got %d", secret, rec.Code)
+		}
+	}
+
+	// Without a configured secret the route is closed, whatever is sent.
+	s.adminSecret = ""
+	if rec := getAdminSession(s, "channel/chn_1", "s3cret"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 with no secret configured, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// voiceState is the session data a single store operation works against.
+// Records are only valid for the duration of the operation: the Redis
+// backend hands out decoded copies and writes back whatever changed.
+type voiceState interface {
+	session(key string) (*sessionRecord, error)
+	// sessions lists every session for reading. An update that changes one
+	// of them fetches it again through session first, so listing stays cheap
+	// and the memory backend only keeps copies of what it may have to undo.
+	sessions() ([]*sessionRecord, error)
+	// saveSession stores a newly created record. Changes to records obtained
+	// from session are picked up without it.
+	saveSession(record *sessionRecord)
+	deleteSession(key string)
+	userTarget(userID string) (string, error)
+	setUserTarget(userID, key string)
+	deleteUserTarget(userID string)
+	// afterCommit defers a side effect (a publish, a counter) until the
+	// operation's writes are durable, so a retried attempt does not repeat
+	// it.
+	afterCommit(fn func())
+}
+
+// voiceBackend is where voice sessions live. The in-memory backend suits a
+// single instance; the Redis backend lets several instances share rooms.
+type voiceBackend interface {
+	// update runs fn with a consistent view of the state and commits its
+	// changes if fn returns nil. fn may be retried, so it must not have side
+	// effects outside the state other than through afterCommit.
+	update(fn func(voiceState) error) error
+	// view runs fn against the state without committing anything.
+	view(fn func(voiceState) error) error
+	// acquireSweep reports whether this instance should run the named
+	// periodic sweep now, so only one instance does it per interval.
+	acquireSweep(name string, interval time.Duration) (bool, error)
+	// ban keeps userID out of the session at key until the given time. Bans
+	// live outside session records so they survive the room emptying.
+	ban(key, userID string, until, now time.Time) error
+	// banned reports whether userID is banned from key at now.
+	banned(key, userID string, now time.Time) (bool, error)
+}
+
+type memoryVoiceBackend struct {
+	mu               sync.RWMutex
+	sessionsByTarget map[string]*sessionRecord
+	targetByUserID   map[string]string
+	bansByTarget     map[string]map[string]time.Time
+}
+
+func newMemoryVoiceBackend() *memoryVoiceBackend {
+	return &memoryVoiceBackend{
+		sessionsByTarget: map[string]*sessionRecord{},
+		targetByUserID:   map[string]string{},
+		bansByTarget:     map[string]map[string]time.Time{},
+	}
+}
+
+func (b *memoryVoiceBackend) update(fn func(voiceState) error) error {
+	b.mu.Lock()
+	state := &memoryVoiceState{
+		backend:        b,
+		sessionsBefore: map[string]*sessionRecord{},
+		usersBefore:    map[string]string{},
+	}
+	err := fn(state)
+	if err != nil {
+		state.rollback()
+	}
+	b.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	for _, pending := range state.pending {
+		pending()
+	}
+	return nil
+}
+
+func (b *memoryVoiceBackend) view(fn func(voiceState) error) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return fn(&memoryVoiceState{backend: b})
+}
+
+func (b *memoryVoiceBackend) acquireSweep(string, time.Duration) (bool, error) {
+	return true, nil
+}
+
+func (b *memoryVoiceBackend) ban(key, userID string, until, now time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Bans are rare, so expired ones are dropped whenever a new one is added.
+	for target, bans := range b.bansByTarget {
+		for bannedID, expiresAt := range bans {
+			if !now.Before(expiresAt) {
+				delete(bans, bannedID)
+			}
+		}
+		if len(bans) == 0 {
+			delete(b.bansByTarget, target)
+		}
+	}
+
+	bans, ok := b.bansByTarget[key]
+	if !ok {
+		bans = map[string]time.Time{}
+		b.bansByTarget[key] = bans
+	}
+	bans[userID] = until
+	return nil
+}
+
+func (b *memoryVoiceBackend) banned(key, userID string, now time.Time) (bool, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	expiresAt, ok := b.bansByTarget[key][userID]
+	return ok && now.Before(expiresAt), nil
+}
+
+// memoryVoiceState works on the backend's maps directly; the backend lock is
+// held for as long as it is in use. During an update it keeps a copy of
+// every session it hands out through session, saves or deletes, so a failed
+// update can be undone instead of leaving half its writes behind.
+type memoryVoiceState struct {
+	backend *memoryVoiceBackend
+	pending []func()
+	// sessionsBefore and usersBefore map keys to their values before the
+	// update touched them; nil and "" mean absent. Both are nil in views.
+	sessionsBefore map[string]*sessionRecord
+	usersBefore    map[string]string
+}
+
+func (s *memoryVoiceState) rememberSession(key string) {
+	if s.sessionsBefore == nil {
+		return
+	}
+	if _, ok := s.sessionsBefore[key]; ok {
+		return
+	}
+	s.sessionsBefore[key] = cloneSessionRecord(s.backend.sessionsByTarget[key])
+}
+
+func (s *memoryVoiceState) rememberUser(userID string) {
+	if s.usersBefore == nil {
+		return
+	}
+	if _, ok := s.usersBefore[userID]; ok {
+		return
+	}
+	s.usersBefore[userID] = s.backend.targetByUserID[userID]
+}
+
+// rollback restores everything the update touched.
+func (s *memoryVoiceState) rollback() {
+	for key, record := range s.sessionsBefore {
+		if record == nil {
+			delete(s.backend.sessionsByTarget, key)
+		} else {
+			s.backend.sessionsByTarget[key] = record
+		}
+	}
+	for userID, key := range s.usersBefore {
+		if key == "" {
+			delete(s.backend.targetByUserID, userID)
+		} else {
+			s.backend.targetByUserID[userID] = key
+		}
+	}
+}
+
+// cloneSessionRecord copies a record deeply enough that changes made through
+// the original don't show through. Pointer fields inside participants are
+// always replaced rather than written through, so they can be shared.
+func cloneSessionRecord(record *sessionRecord) *sessionRecord {
+	if record == nil {
+		return nil
+	}
+
+	cloned := *record
+	cloned.Participants = make(map[string]*participantRecord, len(record.Participants))
+	for userID, participant := range record.Participants {
+		copied := *participant
+		if participant.Connections != nil {
+			copied.Connections = make(map[string]*participantConnection, len(participant.Connections))
+			for connectionID, connection := range participant.Connections {
+				connectionCopy := *connection
+				copied.Connections[connectionID] = &connectionCopy
+			}
+		}
+		cloned.Participants[userID] = &copied
+	}
+	if record.Metadata != nil {
+		cloned.Metadata = make(map[string]string, len(record.Metadata))
+		for key, value := range record.Metadata {
+			cloned.Metadata[key] = value
+		}
+	}
+	return &cloned
+}
+
+func (s *memoryVoiceState) session(key string) (*sessionRecord, error) {
+	s.rememberSession(key)
+	return s.backend.sessionsByTarget[key], nil
+}
+
+func (s *memoryVoiceState) sessions() ([]*sessionRecord, error) {
+	records := make([]*sessionRecord, 0, len(s.backend.sessionsByTarget))
+	for _, record := range s.backend.sessionsByTarget {
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func (s *memoryVoiceState) saveSession(record *sessionRecord) {
+	key := targetKey(record.TargetKind, record.TargetID)
+	s.rememberSession(key)
+	s.backend.sessionsByTarget[key] = record
+}
+
+func (s *memoryVoiceState) deleteSession(key string) {
+	s.rememberSession(key)
+	delete(s.backend.sessionsByTarget, key)
+}
+
+func (s *memoryVoiceState) userTarget(userID string) (string, error) {
+	return s.backend.targetByUserID[userID], nil
+}
+
+func (s *memoryVoiceState) setUserTarget(userID, key string) {
+	s.rememberUser(userID)
+	s.backend.targetByUserID[userID] = key
+}
+
+func (s *memoryVoiceState) deleteUserTarget(userID string) {
+	s.rememberUser(userID)
+	delete(s.backend.targetByUserID, userID)
+}
+
+func (s *memoryVoiceState) afterCommit(fn func()) {
+	s.pending = append(s.pending, fn)
+}
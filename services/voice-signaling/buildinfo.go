@@ -0,0 +1,61 @@
+package main
+
+import (
+	"runtime/debug"
+	"sync"
+)
+
+// Set at build time with
+//
+//	go build -ldflags "-X main.version=1.4.0 -X main.gitCommit=$(git rev-parse HEAD) -X main.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// A plain go build leaves them empty, and the VCS stamp Go embeds fills in
+// the commit and time where it can.
+var (
+	version   string
+	gitCommit string
+	buildTime string
+)
+
+// buildInfo is what an /info response says about the running binary.
+type buildInfo struct {
+	Version   string `json:"version"`
+	GitCommit string `json:"gitCommit"`
+	BuildTime string `json:"buildTime"`
+	GoVersion string `json:"goVersion"`
+	// Dependencies maps each module compiled in to its version.
+	Dependencies map[string]string `json:"dependencies"`
+}
+
+var currentBuildInfo = sync.OnceValue(func() buildInfo {
+	info := buildInfo{
+		Version:      version,
+		GitCommit:    gitCommit,
+		BuildTime:    buildTime,
+		Dependencies: map[string]string{},
+	}
+	if info.Version == "" {
+		info.Version = "dev"
+	}
+
+	embedded, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	info.GoVersion = embedded.GoVersion
+	for _, setting := range embedded.Settings {
+		switch {
+		case setting.Key == "vcs.revision" && info.GitCommit == "":
+			info.GitCommit = setting.Value
+		case setting.Key == "vcs.time" && info.BuildTime == "":
+			info.BuildTime = setting.Value
+		}
+	}
+	for _, dep := range embedded.Deps {
+		if dep.Replace != nil {
+			dep = dep.Replace
+		}
+		info.Dependencies[dep.Path] = dep.Version
+	}
+	return info
+})
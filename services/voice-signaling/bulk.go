@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// maxBulkSessionTargets caps POST /v1/voice/sessions/bulk. It is checked
+// before de-duplicating, so the work per request is bounded by what the
+// client sent.
+const maxBulkSessionTargets = 100
+
+type bulkSessionTarget struct {
+	Kind voiceTargetKind `json:"kind"`
+	ID   string          `json:"id"`
+}
+
+type bulkSessionsRequest struct {
+	Targets []bulkSessionTarget `json:"targets"`
+}
+
+// voiceSessionOccupancy is the token-free call state shown in sidebars. An
+// inactive target has no participants.
+type voiceSessionOccupancy struct {
+	ParticipantCount   int      `json:"participantCount"`
+	ParticipantUserIDs []string `json:"participantUserIds"`
+}
+
+// BulkOccupancy returns the occupancy of every target, keyed by
+// "<kind>:<id>", with user ids sorted. Targets without a session are
+// included with no participants.
+func (s *voiceStore) BulkOccupancy(targets []bulkSessionTarget) (map[string]voiceSessionOccupancy, error) {
+	occupancy := make(map[string]voiceSessionOccupancy, len(targets))
+	err := s.backend.view(func(st voiceState) error {
+		for _, target := range targets {
+			key := targetKey(target.Kind, target.ID)
+			if _, ok := occupancy[key]; ok {
+				continue
+			}
+
+			record, err := st.session(key)
+			if err != nil {
+				return err
+			}
+
+			userIDs := make([]string, 0)
+			if record != nil {
+				for userID := range record.Participants {
+					userIDs = append(userIDs, userID)
+				}
+				sort.Strings(userIDs)
+			}
+			occupancy[key] = voiceSessionOccupancy{
+				ParticipantCount:   len(userIDs),
+				ParticipantUserIDs: userIDs,
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return occupancy, nil
+}
+
+func (s *server) handleVoiceSessionsBulk(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		s.respondOptions(w)
+		return
+	}
+	if r.Method != http.MethodPost {
+		s.respondError(w, http.StatusNotFound, "Route not found.")
+		return
+	}
+
+	if _, ok := s.requestUserID(w, r); !ok {
+		return
+	}
+
+	var body bulkSessionsRequest
+	if err := decodeJSONBody(r, s.bodyLimit(), &body); err != nil {
+		s.respondDecodeError(w, err)
+		return
+	}
+
+	if len(body.Targets) == 0 {
+		s.respondError(w, http.StatusBadRequest, "targets is required.")
+		return
+	}
+	if len(body.Targets) > maxBulkSessionTargets {
+		s.respondError(w, http.StatusBadRequest, fmt.Sprintf("targets must contain at most %d entries.", maxBulkSessionTargets))
+		return
+	}
+
+	for i, target := range body.Targets {
+		target.ID = strings.TrimSpace(target.ID)
+		if (target.Kind != targetChannel && target.Kind != targetDirectThread) || !validID(target.ID) {
+			s.respondError(w, http.StatusBadRequest, "Each target needs a kind of channel or direct_thread and a valid id.")
+			return
+		}
+		body.Targets[i] = target
+	}
+
+	sessions, err := s.store.BulkOccupancy(body.Targets)
+	if err != nil {
+		s.respondSessionError(w, err)
+		return
+	}
+
+	s.respondJSON(w, http.StatusOK, map[string]any{
+		"sessions": sessions,
+	})
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func postBulkSessions(t *testing.T, s *server, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/voice/sessions/bulk", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Voice-User-Id", "usr_viewer")
+	rec := httptest.NewRecorder()
+	s.handleVoiceSessionsBulk(rec, req)
+	return rec
+}
+
+func TestVoiceSessionsBulkMixesActiveAndInactiveTargets(t *testing.T) {
+	store := newTestVoiceStore(noopPublisher{})
+	s := &server{store: store}
+	for _, userID := range []string{"usr_1", "usr_2"} {
+		if _, err := store.Join(targetChannel, "chn_1", userID, nil, true, joinVoiceRequest{}); err != nil {
+			t.Fatalf("join %s: %v", userID, err)
+		}
+	}
+	if _, err := store.Join(targetDirectThread, "dm_1", "usr_3", nil, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+
+	rec := postBulkSessions(t, s, `{"targets":[
+		{"kind":"channel","id":"chn_1"},
+		{"kind":"channel","id":"chn_idle"},
+		{"kind":"direct_thread","id":"dm_1"},
+		{"kind":"channel","id":"chn_1"}
+	]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if strings.Contains(rec.Body.String(), "participantToken") {
+		t.Fatal("expected no tokens in the bulk response")
+	}
+
+	var body struct {
+		Sessions map[string]voiceSessionOccupancy `json:"sessions"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(body.Sessions) != 3 {
+		t.Fatalf("expected duplicates to collapse into 3 entries, got %+v", body.Sessions)
+	}
+	active := body.Sessions["channel:chn_1"]
+	if active.ParticipantCount != 2 || !slices.Equal(active.ParticipantUserIDs, []string{"usr_1", "usr_2"}) {
+		t.Fatalf("unexpected occupancy for chn_1: %+v", active)
+	}
+	if idle := body.Sessions["channel:chn_idle"]; idle.ParticipantCount != 0 || idle.ParticipantUserIDs == nil {
+		t.Fatalf("expected an empty entry for an inactive target, got %+v", idle)
+	}
+	if dm := body.Sessions["direct_thread:dm_1"]; dm.ParticipantCount != 1 || dm.ParticipantUserIDs[0] != "usr_3" {
+		t.Fatalf("unexpected occupancy for dm_1: %+v", dm)
+	}
+}
+
+func TestVoiceSessionsBulkValidatesTargets(t *testing.T) {
+	s := &server{store: newTestVoiceStore(noopPublisher{})}
+
+	targets := make([]string, maxBulkSessionTargets+1)
+	for i := range targets {
+		targets[i] = fmt.Sprintf(`{"kind":"channel","id":"chn_%d"}`, i)
+	}
+	if rec := postBulkSessions(t, s, `{"targets":[`+strings.Join(targets, ",")+`]}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected lists over the cap to be rejected, got %d", rec.Code)
+	}
+	if rec := postBulkSessions(t, s, `{"targets":[`+strings.Join(targets[:maxBulkSessionTargets], ",")+`]}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected a list at the cap to pass, got %d", rec.Code)
+	}
+
+	for _, body := range []string{
+		`{"targets":[]}`,
+		`{"targets":[{"kind":"server","id":"srv_1"}]}`,
+		`{"targets":[{"kind":"channel","id":"chn.1"}]}`,
+	} {
+		if rec := postBulkSessions(t, s, body); rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", body, rec.Code)
+		}
+	}
+}
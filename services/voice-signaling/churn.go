@@ -0,0 +1,95 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type churnBucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+// churnLimiter is a per-user token bucket for joins and leaves: burst
+// operations that refill evenly over window. It guards the SFU and the event
+// stream against clients stuck reconnecting in a loop, so heartbeats and
+// state updates never draw from it. Like reactions it is per instance.
+type churnLimiter struct {
+	mu       sync.Mutex
+	burst    float64
+	window   time.Duration
+	buckets  map[string]*churnBucket
+	prunedAt time.Time
+}
+
+// newChurnLimiter returns a limiter allowing burst operations per window; a
+// non-positive burst or window disables it.
+func newChurnLimiter(burst int, window time.Duration) *churnLimiter {
+	return &churnLimiter{
+		burst:   float64(burst),
+		window:  window,
+		buckets: map[string]*churnBucket{},
+	}
+}
+
+func (l *churnLimiter) refillLocked(userID string, now time.Time) *churnBucket {
+	bucket, ok := l.buckets[userID]
+	if !ok {
+		bucket = &churnBucket{tokens: l.burst, updatedAt: now}
+		l.buckets[userID] = bucket
+		return bucket
+	}
+
+	elapsed := now.Sub(bucket.updatedAt)
+	if elapsed > 0 {
+		bucket.tokens = math.Min(l.burst, bucket.tokens+l.burst*elapsed.Seconds()/l.window.Seconds())
+		bucket.updatedAt = now
+	}
+	return bucket
+}
+
+// Allow takes one operation from userID's bucket. When it is empty, it
+// returns how long until the next operation is allowed.
+func (l *churnLimiter) Allow(userID string, now time.Time) (time.Duration, bool) {
+	if l.burst <= 0 || l.window <= 0 {
+		return 0, true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Full buckets behave like missing ones, so drop them every so often
+	// rather than keeping one for everyone who ever joined.
+	if now.Sub(l.prunedAt) >= l.window {
+		for id := range l.buckets {
+			if l.refillLocked(id, now).tokens >= l.burst {
+				delete(l.buckets, id)
+			}
+		}
+		l.prunedAt = now
+	}
+
+	bucket := l.refillLocked(userID, now)
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return 0, true
+	}
+
+	missing := 1 - bucket.tokens
+	return time.Duration(missing / l.burst * float64(l.window)), false
+}
+
+// allowChurn charges a join or leave to userID's churn bucket, answering 429
+// when it is empty. Handlers call it once the request has passed validation,
+// right before the store, so malformed requests never use up the budget.
+func (s *server) allowChurn(w http.ResponseWriter, userID string) bool {
+	retryAfter, ok := s.store.churn.Allow(userID, s.store.clock.Now())
+	if !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		s.respondErrorCode(w, http.StatusTooManyRequests, codeVoiceChurnRateLimited, "Joining and leaving too quickly. Try again later.")
+	}
+	return ok
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVoiceChurnLimit(t *testing.T) {
+	cfg := testVoiceStoreConfig(noopPublisher{})
+	cfg.ChurnBurst = 4
+	cfg.ChurnWindow = 30 * time.Second
+	store := newVoiceStore(cfg)
+	clock := store.clock.(*fakeClock)
+	s := &server{store: store}
+
+	for i := range 2 {
+		if rec := postVoiceAction(t, s, "chn_1/join", "usr_1", `{}`); rec.Code != http.StatusOK {
+			t.Fatalf("join %d: expected 200, got %d", i, rec.Code)
+		}
+		if rec := postVoiceAction(t, s, "chn_1/leave", "usr_1", `{}`); rec.Code != http.StatusOK {
+			t.Fatalf("leave %d: expected 200, got %d", i, rec.Code)
+		}
+	}
+
+	rec := postVoiceAction(t, s, "chn_1/join", "usr_1", `{}`)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected the fifth operation to be limited, got %d", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") != "8" {
+		t.Fatalf("expected Retry-After of one refill interval, got %q", rec.Header().Get("Retry-After"))
+	}
+	if rec := postVoiceAction(t, s, "chn_1/join", "usr_2", `{}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected other users to be unaffected, got %d", rec.Code)
+	}
+
+	clock.Advance(8 * time.Second)
+	if rec := postVoiceAction(t, s, "chn_1/join", "usr_1", `{}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected a refilled token to allow a join, got %d", rec.Code)
+	}
+}
+
+func TestVoiceChurnLimitIgnoresHeartbeats(t *testing.T) {
+	cfg := testVoiceStoreConfig(noopPublisher{})
+	cfg.ChurnBurst = 1
+	cfg.ChurnWindow = time.Minute
+	s := &server{store: newVoiceStore(cfg)}
+
+	if rec := postVoiceAction(t, s, "chn_1/join", "usr_1", `{}`); rec.Code != http.StatusOK {
+		t.Fatalf("join: expected 200, got %d", rec.Code)
+	}
+	for i := range 20 {
+		if rec := postVoiceAction(t, s, "chn_1/heartbeat", "usr_1", `{"speaking":true}`); rec.Code != http.StatusOK {
+			t.Fatalf("heartbeat %d: expected 200, got %d", i, rec.Code)
+		}
+		if rec := postVoiceAction(t, s, "chn_1/state", "usr_1", `{"muted":true}`); rec.Code != http.StatusOK {
+			t.Fatalf("state %d: expected 200, got %d", i, rec.Code)
+		}
+	}
+
+	if rec := postVoiceAction(t, s, "chn_1/leave", "usr_1", `{}`); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected the leave to hit the exhausted bucket, got %d", rec.Code)
+	}
+}
+
+func TestVoiceChurnLimitIgnoresInvalidRequests(t *testing.T) {
+	cfg := testVoiceStoreConfig(noopPublisher{})
+	cfg.ChurnBurst = 1
+	cfg.ChurnWindow = time.Minute
+	s := &server{store: newVoiceStore(cfg)}
+
+	for i := range 5 {
+		if rec := postVoiceAction(t, s, "chn_1/join", "usr_1", `{"muted":`); rec.Code != http.StatusBadRequest {
+			t.Fatalf("malformed join %d: expected 400, got %d", i, rec.Code)
+		}
+
+		req := httptest.NewRequest(http.MethodPost, "/v1/voice/channels/chn_1/join", strings.NewReader(`{}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Voice-User-Id", "usr_1")
+		req.Header.Set("X-Voice-Join-Mode", "stage")
+		rec := httptest.NewRecorder()
+		s.handleVoiceChannels(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("join with a bad mode %d: expected 400, got %d", i, rec.Code)
+		}
+	}
+
+	if rec := postVoiceAction(t, s, "chn_1/join", "usr_1", `{}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected the first valid join to be allowed, got %d", rec.Code)
+	}
+	if rec := postVoiceAction(t, s, "chn_1/leave", "usr_1", `{}`); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected valid operations to still draw from the bucket, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// participantConnection is one of a user's devices in a session, joined
+// with X-Voice-Connection-Id. Each has its own LiveKit identity and token,
+// so a user can be in the room from a phone and a laptop at once. Clients
+// that send no connection id use the participant's own identity instead.
+type participantConnection struct {
+	IdentitySuffix string
+	Token          string
+	TokenExpiresAt time.Time
+	JoinedAt       time.Time
+	LastSeenAt     time.Time
+}
+
+type voiceConnectionState struct {
+	ConnectionID string `json:"connectionId"`
+	JoinedAt     string `json:"joinedAt"`
+	LastSeenAt   string `json:"lastSeenAt"`
+}
+
+// connectionStates lists the participant's named connections by id.
+func connectionStates(participant *participantRecord) []voiceConnectionState {
+	states := make([]voiceConnectionState, 0, len(participant.Connections))
+	for connectionID, connection := range participant.Connections {
+		states = append(states, voiceConnectionState{
+			ConnectionID: connectionID,
+			JoinedAt:     connection.JoinedAt.UTC().Format(time.RFC3339Nano),
+			LastSeenAt:   connection.LastSeenAt.UTC().Format(time.RFC3339Nano),
+		})
+	}
+
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].ConnectionID < states[j].ConnectionID
+	})
+	return states
+}
+
+// touchConnection marks connectionID as seen, adding it when it is new. An
+// empty id is the participant's own identity, which has no entry.
+func (p *participantRecord) touchConnection(connectionID string, now time.Time) {
+	if connectionID == "" {
+		return
+	}
+
+	connection, ok := p.Connections[connectionID]
+	if !ok {
+		if p.Connections == nil {
+			p.Connections = map[string]*participantConnection{}
+		}
+		connection = &participantConnection{
+			IdentitySuffix: randomSuffix(6),
+			JoinedAt:       now,
+		}
+		p.Connections[connectionID] = connection
+	}
+	connection.LastSeenAt = now
+}
+
+// clearTokens drops every cached token, for changes to what tokens carry.
+func (p *participantRecord) clearTokens() {
+	p.Token = ""
+	for _, connection := range p.Connections {
+		connection.Token = ""
+	}
+}
+
+// pruneConnections drops named connections not seen within grace. It
+// reports whether the participant had connections and none are left, in
+// which case the participant has expired as a whole.
+func (p *participantRecord) pruneConnections(now time.Time, grace time.Duration) (changed, expired bool) {
+	if len(p.Connections) == 0 {
+		return false, false
+	}
+
+	for connectionID, connection := range p.Connections {
+		if now.Sub(connection.LastSeenAt) > grace {
+			delete(p.Connections, connectionID)
+			changed = true
+		}
+	}
+	return changed, len(p.Connections) == 0
+}
+
+// LeaveConnection removes one of the user's connections. The user stays in
+// the session while other named connections remain and leaves with the
+// last; an empty connectionID leaves outright, as Leave does.
+func (s *voiceStore) LeaveConnection(kind voiceTargetKind, targetID, userID, connectionID string) (voiceSession, error) {
+	now := s.clock.Now().UTC()
+	key := targetKey(kind, targetID)
+
+	var session voiceSession
+	err := s.backend.update(func(st voiceState) error {
+		record, participant, err := connectedParticipant(st, key, userID)
+		if err != nil {
+			return err
+		}
+
+		delete(participant.Connections, connectionID)
+		if connectionID != "" && len(participant.Connections) > 0 {
+			record.UpdatedAt = now
+		} else if record, err = s.leaveByKey(st, key, userID, now); err != nil {
+			return err
+		}
+
+		s.publishSession(st, record)
+		session, err = s.buildConnectionSession(record, userID, connectionID)
+		return err
+	})
+
+	return session, err
+}
+
+// participantConnectionID finds which of the participant's connections holds
+// identity: "" for the participant's own identity.
+func participantConnectionID(participant *participantRecord, identity string) (string, bool) {
+	if identity == participant.UserID+"_"+participant.IdentitySuffix {
+		return "", true
+	}
+	for connectionID, connection := range participant.Connections {
+		if identity == participant.UserID+"_"+connection.IdentitySuffix {
+			return connectionID, true
+		}
+	}
+	return "", false
+}
+
+// movedConnections carries the participant's connections into another room.
+// Identities are kept, but tokens are for the old room and are dropped.
+func movedConnections(participant *participantRecord, now time.Time) map[string]*participantConnection {
+	if len(participant.Connections) == 0 {
+		return nil
+	}
+
+	moved := make(map[string]*participantConnection, len(participant.Connections))
+	for connectionID, connection := range participant.Connections {
+		moved[connectionID] = &participantConnection{
+			IdentitySuffix: connection.IdentitySuffix,
+			JoinedAt:       now,
+			LastSeenAt:     now,
+		}
+	}
+	return moved
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func postConnectionAction(t *testing.T, s *server, path, connectionID string) voiceSession {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/voice/channels/"+path, strings.NewReader(`{}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Voice-User-Id", "usr_1")
+	req.Header.Set("X-Voice-Connection-Id", connectionID)
+	rec := httptest.NewRecorder()
+	s.handleVoiceChannels(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("%s as %s: expected 200, got %d: %s", path, connectionID, rec.Code, rec.Body.String())
+	}
+
+	var session voiceSession
+	if err := json.Unmarshal(rec.Body.Bytes(), &session); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	return session
+}
+
+func connectionIDs(session voiceSession, userID string) []string {
+	for _, participant := range session.Participants {
+		if participant.UserID != userID {
+			continue
+		}
+		ids := make([]string, 0, len(participant.Connections))
+		for _, connection := range participant.Connections {
+			ids = append(ids, connection.ConnectionID)
+		}
+		return ids
+	}
+	return nil
+}
+
+func TestVoiceConnectionsShareOneParticipant(t *testing.T) {
+	store := newTestVoiceStore(noopPublisher{})
+	s := &server{store: store}
+
+	phone := postConnectionAction(t, s, "chn_1/join", "phone")
+	laptop := postConnectionAction(t, s, "chn_1/join", "laptop")
+	if laptop.ParticipantCount != 1 || strings.Join(connectionIDs(laptop, "usr_1"), ",") != "laptop,phone" {
+		t.Fatalf("expected one participant with both connections, got %d %v", laptop.ParticipantCount, connectionIDs(laptop, "usr_1"))
+	}
+
+	phoneClaims := parseParticipantToken(t, phone.Signaling.ParticipantToken, jwt.SigningMethodHS256, []byte("secret"))
+	laptopClaims := parseParticipantToken(t, laptop.Signaling.ParticipantToken, jwt.SigningMethodHS256, []byte("secret"))
+	if phoneClaims.Subject == laptopClaims.Subject || !strings.HasPrefix(phoneClaims.Subject, "usr_1_") {
+		t.Fatalf("expected each connection to get its own identity, got %s and %s", phoneClaims.Subject, laptopClaims.Subject)
+	}
+	refreshed, err := store.RefreshToken(targetChannel, "chn_1", "usr_1", "phone")
+	if err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if claims := parseParticipantToken(t, refreshed.ParticipantToken, jwt.SigningMethodHS256, []byte("secret")); claims.Subject != phoneClaims.Subject {
+		t.Fatalf("expected a refresh to keep the connection's identity, got %s", claims.Subject)
+	}
+
+	session := postConnectionAction(t, s, "chn_1/leave", "phone")
+	if session.ParticipantCount != 1 || strings.Join(connectionIDs(session, "usr_1"), ",") != "laptop" {
+		t.Fatalf("expected the user to stay on the laptop, got %d %v", session.ParticipantCount, connectionIDs(session, "usr_1"))
+	}
+	if _, ok := memoryOf(store).targetByUserID["usr_1"]; !ok {
+		t.Fatal("expected the user to still be in the session")
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/voice/channels/chn_1/join", strings.NewReader(`{}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Voice-User-Id", "usr_1")
+	req.Header.Set("X-Voice-Connection-Id", "my phone")
+	rec := httptest.NewRecorder()
+	s.handleVoiceChannels(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected a malformed connection id to be rejected, got %d", rec.Code)
+	}
+
+	postConnectionAction(t, s, "chn_1/leave", "laptop")
+	if _, ok := memoryOf(store).sessionsByTarget[targetKey(targetChannel, "chn_1")]; ok {
+		t.Fatal("expected the last connection to end the session")
+	}
+}
+
+func TestVoiceConnectionsExpireIndividually(t *testing.T) {
+	pub := &recordingPublisher{}
+	store := newVoiceStore(testVoiceStoreConfig(pub))
+	clock := store.clock.(*fakeClock)
+	s := &server{store: store}
+
+	postConnectionAction(t, s, "chn_1/join", "phone")
+	session := postConnectionAction(t, s, "chn_1/join", "laptop")
+	laptopIdentity := parseParticipantToken(t, session.Signaling.ParticipantToken, jwt.SigningMethodHS256, []byte("secret")).Subject
+
+	// Only the laptop keeps sending heartbeats.
+	for range 2 {
+		clock.Advance(20 * time.Second)
+		postConnectionAction(t, s, "chn_1/heartbeat", "laptop")
+	}
+	if err := store.CleanupExpired(); err != nil {
+		t.Fatalf("cleanup: %v", err)
+	}
+	participant := memoryOf(store).sessionsByTarget[targetKey(targetChannel, "chn_1")].Participants["usr_1"]
+	if participant == nil || len(participant.Connections) != 1 || participant.Connections["laptop"] == nil {
+		t.Fatalf("expected only the phone to expire, got %+v", participant)
+	}
+
+	// LiveKit reporting the laptop gone removes the last connection.
+	handled, err := store.ParticipantLeft(targetChannel, "chn_1", laptopIdentity)
+	if err != nil || !handled {
+		t.Fatalf("expected the laptop's identity to be recognized, got %v (%v)", handled, err)
+	}
+	if _, ok := memoryOf(store).targetByUserID["usr_1"]; ok {
+		t.Fatal("expected the user to leave with their last connection")
+	}
+}
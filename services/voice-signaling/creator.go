@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	errVoiceNotCreator     = errors.New("only the session's creator can do that")
+	errVoiceTransferTarget = errors.New("ownership can only move to another participant in this session")
+)
+
+type transferSessionRequest struct {
+	UserID string `json:"userId"`
+}
+
+// voiceCreatorEvent announces a new creator. TransferredBy is the previous
+// creator for an explicit transfer, and empty when the creator left and the
+// role moved on by itself.
+type voiceCreatorEvent struct {
+	SessionID         string          `json:"sessionId"`
+	TargetKind        voiceTargetKind `json:"targetKind"`
+	TargetID          string          `json:"targetId"`
+	CreatedBy         string          `json:"createdBy"`
+	PreviousCreatedBy string          `json:"previousCreatedBy"`
+	TransferredBy     string          `json:"transferredBy"`
+}
+
+// setCreator makes userID the session's creator. The change is announced by
+// the publishSession that every caller follows up with, so clients see the
+// participants update first.
+func setCreator(record *sessionRecord, userID, transferredBy string) {
+	record.creatorChange = &voiceCreatorEvent{
+		SessionID:         record.ID,
+		TargetKind:        record.TargetKind,
+		TargetID:          record.TargetID,
+		CreatedBy:         userID,
+		PreviousCreatedBy: record.CreatedBy,
+		TransferredBy:     transferredBy,
+	}
+	record.CreatedBy = userID
+}
+
+// reassignCreator hands the creator role to the earliest-joined remaining
+// participant once the creator is no longer in the session. Empty sessions
+// are left alone, as they are about to be deleted.
+func reassignCreator(record *sessionRecord) {
+	if _, ok := record.Participants[record.CreatedBy]; ok {
+		return
+	}
+
+	var next *participantRecord
+	for _, participant := range record.Participants {
+		if next == nil || participant.JoinedAt.Before(next.JoinedAt) ||
+			(participant.JoinedAt.Equal(next.JoinedAt) && participant.UserID < next.UserID) {
+			next = participant
+		}
+	}
+	if next == nil {
+		return
+	}
+
+	setCreator(record, next.UserID, "")
+}
+
+// TransferCreator lets the session's creator hand the role to another
+// participant.
+func (s *voiceStore) TransferCreator(kind voiceTargetKind, targetID, userID, toUserID string) (voiceSession, error) {
+	now := s.clock.Now().UTC()
+	key := targetKey(kind, targetID)
+	toUserID = strings.TrimSpace(toUserID)
+
+	var session voiceSession
+	err := s.backend.update(func(st voiceState) error {
+		record, _, err := connectedParticipant(st, key, userID)
+		if err != nil {
+			return err
+		}
+		if record.CreatedBy != userID {
+			return errVoiceNotCreator
+		}
+		if _, ok := record.Participants[toUserID]; !ok || toUserID == userID {
+			return errVoiceTransferTarget
+		}
+
+		setCreator(record, toUserID, userID)
+		record.UpdatedAt = now
+		s.publishSession(st, record)
+
+		session, err = s.buildSession(record, userID)
+		return err
+	})
+
+	return session, err
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// creatorEvents returns the creator changes among events.
+func creatorEvents(events []publishedEvent) []voiceCreatorEvent {
+	changes := make([]voiceCreatorEvent, 0)
+	for _, event := range events {
+		if event.EventType == "voice.session.creator_changed" {
+			changes = append(changes, event.Payload.(voiceCreatorEvent))
+		}
+	}
+	return changes
+}
+
+func TestVoiceCreatorReassignedWhenCreatorLeaves(t *testing.T) {
+	pub := &recordingPublisher{}
+	store := newTestVoiceStore(pub)
+	clock := store.clock.(*fakeClock)
+	for _, userID := range []string{"usr_1", "usr_3", "usr_2"} {
+		if _, err := store.Join(targetChannel, "chn_1", userID, nil, true, joinVoiceRequest{}); err != nil {
+			t.Fatalf("join %s: %v", userID, err)
+		}
+		clock.Advance(time.Second)
+	}
+	pub.take()
+
+	// A non-creator leaving changes nothing.
+	session, err := store.Leave(targetChannel, "chn_1", "usr_2")
+	if err != nil {
+		t.Fatalf("leave usr_2: %v", err)
+	}
+	if session.CreatedBy != "usr_1" || len(creatorEvents(pub.take())) != 0 {
+		t.Fatalf("expected usr_1 to stay creator, got %q", session.CreatedBy)
+	}
+
+	session, err = store.Leave(targetChannel, "chn_1", "usr_1")
+	if err != nil {
+		t.Fatalf("leave usr_1: %v", err)
+	}
+	if session.CreatedBy != "usr_3" {
+		t.Fatalf("expected the earliest remaining joiner to take over, got %q", session.CreatedBy)
+	}
+	changes := creatorEvents(pub.take())
+	if len(changes) != 1 || changes[0].CreatedBy != "usr_3" || changes[0].PreviousCreatedBy != "usr_1" || changes[0].TransferredBy != "" {
+		t.Fatalf("expected one automatic creator change, got %+v", changes)
+	}
+}
+
+func TestVoiceCreatorTransfer(t *testing.T) {
+	pub := &recordingPublisher{}
+	store := newTestVoiceStore(pub)
+	s := &server{store: store}
+	for _, userID := range []string{"usr_1", "usr_2"} {
+		if _, err := store.Join(targetChannel, "chn_1", userID, nil, true, joinVoiceRequest{}); err != nil {
+			t.Fatalf("join %s: %v", userID, err)
+		}
+	}
+	pub.take()
+
+	if rec := postVoiceAction(t, s, "chn_1/transfer", "usr_2", `{"userId":"usr_2"}`); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected a non-creator transfer to be forbidden, got %d", rec.Code)
+	}
+	if rec := postVoiceAction(t, s, "chn_1/transfer", "usr_1", `{"userId":"usr_9"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected a transfer to a non-participant to be rejected, got %d", rec.Code)
+	}
+	if rec := postVoiceAction(t, s, "chn_1/transfer", "usr_1", `{}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected a missing userId to be rejected, got %d", rec.Code)
+	}
+	pub.take()
+
+	rec := postVoiceAction(t, s, "chn_1/transfer", "usr_1", `{"userId":"usr_2"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected the creator's transfer to succeed, got %d", rec.Code)
+	}
+	session, err := store.Get(targetChannel, "chn_1", "usr_1")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if session.CreatedBy != "usr_2" {
+		t.Fatalf("expected usr_2 to be creator, got %q", session.CreatedBy)
+	}
+	changes := creatorEvents(pub.take())
+	if len(changes) != 1 || changes[0].CreatedBy != "usr_2" || changes[0].TransferredBy != "usr_1" {
+		t.Fatalf("expected one explicit creator change, got %+v", changes)
+	}
+
+	if _, err := store.TransferCreator(targetChannel, "chn_1", "usr_1", "usr_2"); err != errVoiceNotCreator {
+		t.Fatalf("expected the previous creator to lose the role, got %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Error responses carry a stable code next to the message, so clients can
+// branch on it without matching text. Messages may be reworded; codes may
+// not.
+const (
+	codeVoiceSessionNotFound      = "VOICE_SESSION_NOT_FOUND"
+	codeVoiceNotConnected         = "VOICE_NOT_CONNECTED"
+	codeVoiceModeratorMuted       = "VOICE_MODERATOR_MUTED"
+	codeVoiceSelfModeration       = "VOICE_SELF_MODERATION"
+	codeVoiceConflict             = "VOICE_CONFLICT"
+	codeVoiceSessionLocked        = "VOICE_SESSION_LOCKED"
+	codeVoiceBanned               = "VOICE_BANNED"
+	codeVoiceSessionFull          = "VOICE_SESSION_FULL"
+	codeVoiceServerFull           = "VOICE_SERVER_FULL"
+	codeVoiceScreenShareFull      = "VOICE_SCREEN_SHARE_FULL"
+	codeVoiceInvalidCursor        = "VOICE_INVALID_CURSOR"
+	codeVoiceInvalidMetadata      = "VOICE_INVALID_METADATA"
+	codeVoiceInvalidWhisperTarget = "VOICE_INVALID_WHISPER_TARGET"
+	codeVoiceNotCreator           = "VOICE_CREATOR_REQUIRED"
+	codeVoiceInvalidTransfer      = "VOICE_INVALID_TRANSFER_TARGET"
+	codeVoiceAlreadyRecording     = "VOICE_ALREADY_RECORDING"
+	codeVoiceNotRecording         = "VOICE_NOT_RECORDING"
+	codeVoiceRecordingUnavailable = "VOICE_RECORDING_UNAVAILABLE"
+	codeVoiceReactionRateLimited  = "VOICE_REACTION_RATE_LIMITED"
+	codeVoiceChurnRateLimited     = "VOICE_CHURN_RATE_LIMITED"
+	codeVoiceModeratorRequired    = "VOICE_MODERATOR_REQUIRED"
+	codeVoiceScreenShareDisabled  = "VOICE_SCREEN_SHARE_DISABLED"
+	codeVoiceVideoDisabled        = "VOICE_VIDEO_DISABLED"
+	codeVoiceInvalidSignature     = "VOICE_INVALID_WEBHOOK_SIGNATURE"
+)
+
+// voiceErrorCodes maps the store's errors to their codes; the first match
+// wins.
+var voiceErrorCodes = []struct {
+	err  error
+	code string
+}{
+	{errVoiceSessionNotFound, codeVoiceSessionNotFound},
+	{errVoiceNotConnected, codeVoiceNotConnected},
+	{errVoiceModeratorMuted, codeVoiceModeratorMuted},
+	{errVoiceSelfModeration, codeVoiceSelfModeration},
+	{errVoiceConflict, codeVoiceConflict},
+	{errVoiceSessionLocked, codeVoiceSessionLocked},
+	{errVoiceBanned, codeVoiceBanned},
+	{errVoiceSessionFull, codeVoiceSessionFull},
+	{errVoiceServerFull, codeVoiceServerFull},
+	{errVoiceScreenShareFull, codeVoiceScreenShareFull},
+	{errInvalidSessionCursor, codeVoiceInvalidCursor},
+	{errVoiceMetadataKey, codeVoiceInvalidMetadata},
+	{errVoiceMetadataKeys, codeVoiceInvalidMetadata},
+	{errVoiceMetadataSize, codeVoiceInvalidMetadata},
+	{errVoiceWhisperTarget, codeVoiceInvalidWhisperTarget},
+	{errVoiceNotCreator, codeVoiceNotCreator},
+	{errVoiceTransferTarget, codeVoiceInvalidTransfer},
+	{errVoiceAlreadyRecording, codeVoiceAlreadyRecording},
+	{errVoiceNotRecording, codeVoiceNotRecording},
+	{errVoiceRecordingUnavailable, codeVoiceRecordingUnavailable},
+	{errVoiceReactionRateLimited, codeVoiceReactionRateLimited},
+}
+
+// sessionErrorCode returns the code for a store error, falling back to the
+// generic one for its status.
+func sessionErrorCode(err error) string {
+	for _, mapping := range voiceErrorCodes {
+		if errors.Is(err, mapping.err) {
+			return mapping.code
+		}
+	}
+
+	return statusErrorCode(sessionErrorStatus(err))
+}
+
+// statusErrorCode is the code for errors nothing more specific is known
+// about.
+func statusErrorCode(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return "INVALID_REQUEST"
+	case http.StatusUnauthorized:
+		return "UNAUTHORIZED"
+	case http.StatusForbidden:
+		return "FORBIDDEN"
+	case http.StatusNotFound:
+		return "NOT_FOUND"
+	case http.StatusMethodNotAllowed:
+		return "METHOD_NOT_ALLOWED"
+	case http.StatusConflict:
+		return "CONFLICT"
+	case http.StatusRequestEntityTooLarge:
+		return "PAYLOAD_TOO_LARGE"
+	case http.StatusUnsupportedMediaType:
+		return "UNSUPPORTED_MEDIA_TYPE"
+	case http.StatusTooManyRequests:
+		return "RATE_LIMITED"
+	case http.StatusBadGateway:
+		return "BAD_GATEWAY"
+	case http.StatusServiceUnavailable:
+		return "UNAVAILABLE"
+	}
+
+	return "INTERNAL"
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func errorCodeOf(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+
+	var body struct {
+		Code  string `json:"code"`
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode error body %q: %v", rec.Body.String(), err)
+	}
+	if body.Error == "" {
+		t.Fatalf("expected a message next to the code, got %s", rec.Body.String())
+	}
+	return body.Code
+}
+
+func TestVoiceSessionErrorCodes(t *testing.T) {
+	s := &server{store: newTestVoiceStore(noopPublisher{})}
+
+	for _, mapping := range voiceErrorCodes {
+		rec := httptest.NewRecorder()
+		s.respondSessionError(rec, fmt.Errorf("wrapped: %w", mapping.err))
+		if rec.Code != sessionErrorStatus(mapping.err) {
+			t.Fatalf("%v: expected status %d, got %d", mapping.err, sessionErrorStatus(mapping.err), rec.Code)
+		}
+		if code := errorCodeOf(t, rec); code != mapping.code {
+			t.Fatalf("%v: expected %s, got %s", mapping.err, mapping.code, code)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	s.respondSessionError(rec, errors.New("disk on fire"))
+	if rec.Code != http.StatusInternalServerError || errorCodeOf(t, rec) != "INTERNAL" {
+		t.Fatalf("expected an unknown error to be INTERNAL, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestVoiceErrorCodesOverHTTP(t *testing.T) {
+	cfg := testVoiceStoreConfig(noopPublisher{})
+	cfg.MaxSpeakers = 1
+	cfg.EnableScreenShare = false
+	s := &server{store: newVoiceStore(cfg)}
+
+	check := func(rec *httptest.ResponseRecorder, status int, code string) {
+		t.Helper()
+		if rec.Code != status {
+			t.Fatalf("expected %d, got %d: %s", status, rec.Code, rec.Body.String())
+		}
+		if got := errorCodeOf(t, rec); got != code {
+			t.Fatalf("expected code %s, got %s", code, got)
+		}
+	}
+
+	check(postVoiceAction(t, s, "chn_1/leave", "usr_1", ""), http.StatusNotFound, codeVoiceSessionNotFound)
+	if rec := postModeratorAction(t, s, "chn_1/join", "usr_mod", ""); rec.Code != http.StatusOK {
+		t.Fatalf("join: %d %s", rec.Code, rec.Body.String())
+	}
+	check(postVoiceAction(t, s, "chn_1/join", "usr_1", ""), http.StatusConflict, codeVoiceSessionFull)
+	check(postVoiceAction(t, s, "chn_1/lock", "usr_1", `{"locked":true}`), http.StatusForbidden, codeVoiceModeratorRequired)
+	check(postVoiceAction(t, s, "chn_1/screen-share", "usr_mod", `{"screenSharing":true}`), http.StatusNotFound, codeVoiceScreenShareDisabled)
+	check(postModeratorAction(t, s, "chn_1/participants/usr_mod/kick", "usr_mod", ""), http.StatusBadRequest, codeVoiceSelfModeration)
+
+	check(postModeratorAction(t, s, "chn_1/lock", "usr_mod", `{"locked":`), http.StatusBadRequest, "INVALID_REQUEST")
+	req := httptest.NewRequest(http.MethodPost, "/v1/voice/channels/chn_1/lock", strings.NewReader("locked"))
+	req.Header.Set("X-Voice-User-Id", "usr_mod")
+	req.Header.Set("X-Voice-Moderator", "true")
+	req.Header.Set("Content-Type", "text/plain")
+	rec := httptest.NewRecorder()
+	s.handleVoiceChannels(rec, req)
+	check(rec, http.StatusUnsupportedMediaType, "UNSUPPORTED_MEDIA_TYPE")
+
+	rec = httptest.NewRecorder()
+	s.handleVoiceChannels(rec, httptest.NewRequest(http.MethodPost, "/v1/voice/channels/chn_1/nope", nil))
+	check(rec, http.StatusUnauthorized, "UNAUTHORIZED")
+	check(postVoiceAction(t, s, "chn_1/nope", "usr_1", ""), http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED")
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// maxIdempotencyKeyLength bounds the Idempotency-Key header, since keys are
+// held in memory for the replay window.
+const maxIdempotencyKeyLength = 255
+
+type joinReplay struct {
+	session   voiceSession
+	expiresAt time.Time
+}
+
+// joinReplayCache remembers join responses by Idempotency-Key, so a client
+// retrying a join over a flaky network gets the first response back instead
+// of moving itself out of and into the session again. Like the churn limiter
+// it is per instance: a retry landing on another replica joins again.
+type joinReplayCache struct {
+	mu      sync.Mutex
+	window  time.Duration
+	entries map[string]joinReplay
+}
+
+// newJoinReplayCache returns a cache keeping responses for window; a
+// non-positive window disables it.
+func newJoinReplayCache(window time.Duration) *joinReplayCache {
+	return &joinReplayCache{
+		window:  window,
+		entries: map[string]joinReplay{},
+	}
+}
+
+// joinReplayKey scopes a client's key to the user, the target and the
+// connection, so the same key reused for another call never returns the
+// wrong session or another device's token.
+func joinReplayKey(userID string, kind voiceTargetKind, targetID, connectionID, key string) string {
+	return userID + "\x00" + targetKey(kind, targetID) + "\x00" + connectionID + "\x00" + key
+}
+
+// Get returns the response stored under key, if it is still in the window.
+func (c *joinReplayCache) Get(key string, now time.Time) (voiceSession, bool) {
+	if c.window <= 0 {
+		return voiceSession{}, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	replay, ok := c.entries[key]
+	if !ok || !now.Before(replay.expiresAt) {
+		return voiceSession{}, false
+	}
+	return replay.session, true
+}
+
+func (c *joinReplayCache) Put(key string, session voiceSession, now time.Time) {
+	if c.window <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[key] = joinReplay{session: session, expiresAt: now.Add(c.window)}
+}
+
+// Evict drops every response that has left the window.
+func (c *joinReplayCache) Evict(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, replay := range c.entries {
+		if !now.Before(replay.expiresAt) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// runEviction calls Evict every interval until ctx is cancelled. Every
+// instance runs it, as each keeps its own cache.
+func (c *joinReplayCache) runEviction(ctx context.Context, interval time.Duration, clock Clock) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		c.Evict(clock.Now())
+	}
+}
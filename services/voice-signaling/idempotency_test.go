@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func postJoinWithKey(t *testing.T, s *server, path, userID, key string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/voice/channels/"+path, strings.NewReader(`{}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Voice-User-Id", userID)
+	req.Header.Set("Idempotency-Key", key)
+	rec := httptest.NewRecorder()
+	s.handleVoiceChannels(rec, req)
+	return rec
+}
+
+func TestVoiceJoinIdempotencyKeyReplays(t *testing.T) {
+	pub := &recordingPublisher{}
+	cfg := testVoiceStoreConfig(pub)
+	cfg.JoinReplayWindow = 10 * time.Second
+	store := newVoiceStore(cfg)
+	clock := store.clock.(*fakeClock)
+	s := &server{store: store}
+
+	first := postJoinWithKey(t, s, "chn_1/join", "usr_1", "key_1")
+	if first.Code != http.StatusOK {
+		t.Fatalf("join: expected 200, got %d: %s", first.Code, first.Body.String())
+	}
+	if events := pub.take(); len(events) == 0 {
+		t.Fatal("expected the first join to publish")
+	}
+
+	// Moving to another channel in between would be undone by a second
+	// join; the replay must not touch state.
+	if rec := postVoiceAction(t, s, "chn_2/join", "usr_1", `{}`); rec.Code != http.StatusOK {
+		t.Fatalf("join chn_2: expected 200, got %d", rec.Code)
+	}
+	pub.take()
+
+	replay := postJoinWithKey(t, s, "chn_1/join", "usr_1", "key_1")
+	if replay.Code != http.StatusOK || replay.Body.String() != first.Body.String() {
+		t.Fatalf("expected the cached response, got %d: %s", replay.Code, replay.Body.String())
+	}
+	if events := pub.take(); len(events) != 0 {
+		t.Fatalf("expected a replay to publish nothing, got %+v", events)
+	}
+	if _, ok := memoryOf(store).sessionsByTarget[targetKey(targetChannel, "chn_2")].Participants["usr_1"]; !ok {
+		t.Fatal("expected the replay to leave the user in chn_2")
+	}
+
+	// The key is scoped to the user, and expires with the window.
+	if rec := postJoinWithKey(t, s, "chn_1/join", "usr_2", "key_1"); rec.Code != http.StatusOK || len(pub.take()) == 0 {
+		t.Fatalf("expected another user's key to join, got %d", rec.Code)
+	}
+	clock.Advance(10 * time.Second)
+	store.joinReplays.Evict(clock.Now())
+	if len(store.joinReplays.entries) != 0 {
+		t.Fatalf("expected expired keys to be evicted, got %d", len(store.joinReplays.entries))
+	}
+	if rec := postJoinWithKey(t, s, "chn_1/join", "usr_1", "key_1"); rec.Code != http.StatusOK || len(pub.take()) == 0 {
+		t.Fatalf("expected an expired key to join again, got %d", rec.Code)
+	}
+}
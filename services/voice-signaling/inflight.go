@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+)
+
+// withInFlightLimit answers with 503 and Retry-After once limit requests are
+// already being served, instead of queueing more work on an overloaded
+// process. /health is exempt so a busy instance isn't restarted for being
+// busy, as are requests exempt reports true for, which are held open and
+// limited on their own; a nil exempt adds none. A limit of zero or less
+// disables it.
+func withInFlightLimit(limit int, exempt func(*http.Request) bool, next http.Handler) http.Handler {
+	if limit <= 0 {
+		return next
+	}
+
+	slots := make(chan struct{}, limit)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" || exempt != nil && exempt(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+			next.ServeHTTP(w, r)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(`{"code":"OVERLOADED","error":"Too many requests in flight."}` + "\n"))
+		}
+	})
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestInFlightLimit(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	handler := withInFlightLimit(2, nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			entered <- struct{}{}
+			<-release
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	serve := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			serve("/slow")
+		}()
+		<-entered
+	}
+
+	rec := serve("/fast")
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected 503 with Retry-After while saturated, got %d %v", rec.Code, rec.Header())
+	}
+	if rec := serve("/health"); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected /health to bypass the limit, got %d", rec.Code)
+	}
+
+	close(release)
+	wg.Wait()
+	if rec := serve("/fast"); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected requests to go through once slots free up, got %d", rec.Code)
+	}
+}
+
+func TestInFlightLimitDisabled(t *testing.T) {
+	release := make(chan struct{})
+	handler := withInFlightLimit(0, nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-release
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil))
+	}()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/fast", nil))
+	close(release)
+	<-done
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected no limit with a zero max, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import "net/http"
+
+// infoConfig is the effective configuration, after defaults and clamping.
+// Credentials are left out: the LiveKit secret and signing key, the admin
+// secret, the gateway's internal key and the Redis URL, which may carry a
+// password. Only whether the admin routes are open is shown.
+type infoConfig struct {
+	Port                     string            `json:"port"`
+	Backend                  string            `json:"backend"`
+	SignalingURL             string            `json:"signalingUrl"`
+	RegionURLs               map[string]string `json:"regionUrls"`
+	TokenTTLSeconds          int64             `json:"tokenTtlSeconds"`
+	TokenAudience            string            `json:"tokenAudience"`
+	TokenSkewSeconds         int64             `json:"tokenSkewSeconds"`
+	ReconnectGraceMs         int64             `json:"reconnectGraceMs"`
+	MinReconnectGraceMs      int64             `json:"minReconnectGraceMs"`
+	MaxReconnectGraceMs      int64             `json:"maxReconnectGraceMs"`
+	SpeakingTimeoutMs        int64             `json:"speakingTimeoutMs"`
+	IdleTimeoutMs            int64             `json:"idleTimeoutMs"`
+	BanDurationSeconds       int64             `json:"banDurationSeconds"`
+	EnableScreenShare        bool              `json:"enableScreenShare"`
+	EnableVideo              bool              `json:"enableVideo"`
+	DeafenImpliesMute        bool              `json:"deafenImpliesMute"`
+	MaxSpeakers              int               `json:"maxSpeakers"`
+	MaxSpectators            int               `json:"maxSpectators"`
+	MaxServerSessions        int               `json:"maxServerSessions"`
+	MaxScreenShares          int               `json:"maxScreenShares"`
+	ChurnBurst               float64           `json:"churnBurst"`
+	ChurnWindowSeconds       int64             `json:"churnWindowSeconds"`
+	IdempotencyWindowSeconds int64             `json:"idempotencyWindowSeconds"`
+	JoinHoldSeconds          int64             `json:"joinHoldSeconds"`
+	MaxBodyBytes             int64             `json:"maxBodyBytes"`
+	MaxInFlight              int               `json:"maxInFlight"`
+	RequestTimeoutMs         int64             `json:"requestTimeoutMs"`
+	AdminRoutesEnabled       bool              `json:"adminRoutesEnabled"`
+}
+
+func (s *server) effectiveConfig() infoConfig {
+	store := s.store
+	backend := "memory"
+	if _, ok := store.backend.(*redisVoiceBackend); ok {
+		backend = "redis"
+	}
+	maxBodyBytes := s.maxBodyBytes
+	if maxBodyBytes <= 0 {
+		maxBodyBytes = defaultMaxBodyBytes
+	}
+
+	return infoConfig{
+		Port:                     s.port,
+		Backend:                  backend,
+		SignalingURL:             store.signalingURL,
+		RegionURLs:               store.regionURLs,
+		TokenTTLSeconds:          int64(store.tokenTTL.Seconds()),
+		TokenAudience:            store.tokenAudience,
+		TokenSkewSeconds:         int64(store.tokenSkew.Seconds()),
+		ReconnectGraceMs:         store.reconnectGrace.Milliseconds(),
+		MinReconnectGraceMs:      store.minReconnectGrace.Milliseconds(),
+		MaxReconnectGraceMs:      store.maxReconnectGrace.Milliseconds(),
+		SpeakingTimeoutMs:        store.speakingTimeout.Milliseconds(),
+		IdleTimeoutMs:            store.idleTimeout.Milliseconds(),
+		BanDurationSeconds:       int64(store.banDuration.Seconds()),
+		EnableScreenShare:        store.enableScreenShare,
+		EnableVideo:              store.enableVideo,
+		DeafenImpliesMute:        store.deafenImpliesMute,
+		MaxSpeakers:              store.maxSpeakers,
+		MaxSpectators:            store.maxSpectators,
+		MaxServerSessions:        store.maxServerSessions,
+		MaxScreenShares:          store.maxScreenShares,
+		ChurnBurst:               store.churn.burst,
+		ChurnWindowSeconds:       int64(store.churn.window.Seconds()),
+		IdempotencyWindowSeconds: int64(store.joinReplays.window.Seconds()),
+		JoinHoldSeconds:          int64(store.holds.ttl.Seconds()),
+		MaxBodyBytes:             maxBodyBytes,
+		MaxInFlight:              s.maxInFlight,
+		RequestTimeoutMs:         s.requestTimeout.Milliseconds(),
+		AdminRoutesEnabled:       s.adminSecret != "",
+	}
+}
+
+// handleInfo serves GET /info: the build the instance runs and its
+// effective configuration, for matching behavior to a deploy. Like /health
+// it doesn't touch the backend.
+func (s *server) handleInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.respondError(w, http.StatusNotFound, "Route not found.")
+		return
+	}
+
+	s.respondJSON(w, http.StatusOK, struct {
+		Service string `json:"service"`
+		buildInfo
+		Config infoConfig `json:"config"`
+	}{
+		Service:   "voice-signaling",
+		buildInfo: currentBuildInfo(),
+		Config:    s.effectiveConfig(),
+	})
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVoiceInfo(t *testing.T) {
+	cfg := testVoiceStoreConfig(noopPublisher{})
+	cfg.Signer, _ = newLivekitSigner("devkey", "livekit-s3cret", "")
+	cfg.JoinHoldTTL = 10 * time.Second
+	cfg.RegionURLs = map[string]string{"us": "wss://us.livekit.test", "eu": "wss://eu.livekit.test"}
+	s := &server{store: newVoiceStore(cfg), adminSecret: "admin-s3cret", port: "4003", maxInFlight: 1000}
+
+	rec := httptest.NewRecorder()
+	s.handleInfo(rec, httptest.NewRequest(http.MethodGet, "/info", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	for _, secret := range []string{"livekit-s3cret", "admin-s3cret"} {
+		if strings.Contains(rec.Body.String(), secret) {
+			t.Fatalf("expected %s to be left out of %s", secret, rec.Body.String())
+		}
+	}
+
+	var info struct {
+		Service string `json:"service"`
+		buildInfo
+		Config infoConfig `json:"config"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if info.Service != "voice-signaling" || info.Version == "" || info.GoVersion == "" || info.Dependencies == nil {
+		t.Fatalf("expected the build info, got %+v", info.buildInfo)
+	}
+	for _, field := range []string{`"gitCommit"`, `"buildTime"`} {
+		if !strings.Contains(rec.Body.String(), field) {
+			t.Fatalf("expected %s in %s", field, rec.Body.String())
+		}
+	}
+	config := info.Config
+	if config.Port != "4003" || config.Backend != "memory" || config.TokenTTLSeconds != 3600 || config.JoinHoldSeconds != 10 || !config.AdminRoutesEnabled {
+		t.Fatalf("unexpected config %+v", config)
+	}
+	if len(config.RegionURLs) != 2 || config.RegionURLs["eu"] != "wss://eu.livekit.test" {
+		t.Fatalf("expected the region URLs, got %v", config.RegionURLs)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// livekitSigner holds the credential used to sign participant tokens. HS256
+// with the shared API secret is the default; deployments using asymmetric
+// keys configure an RSA private key instead. The API secret is kept either
+// way because LiveKit signs its webhooks with it.
+type livekitSigner struct {
+	apiKey    string
+	apiSecret []byte
+	method    jwt.SigningMethod
+	key       any
+}
+
+func newLivekitSigner(apiKey, apiSecret, privateKeyPEM string) (*livekitSigner, error) {
+	apiKey = strings.TrimSpace(apiKey)
+	secret := []byte(strings.TrimSpace(apiSecret))
+	privateKeyPEM = strings.TrimSpace(privateKeyPEM)
+
+	if privateKeyPEM == "" {
+		return &livekitSigner{
+			apiKey:    apiKey,
+			apiSecret: secret,
+			method:    jwt.SigningMethodHS256,
+			key:       secret,
+		}, nil
+	}
+
+	// Env files commonly carry the PEM on one line with escaped newlines.
+	privateKeyPEM = strings.ReplaceAll(privateKeyPEM, `\n`, "\n")
+	privateKey, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(privateKeyPEM))
+	if err != nil {
+		return nil, fmt.Errorf("invalid LiveKit RSA private key: %w", err)
+	}
+
+	return &livekitSigner{
+		apiKey:    apiKey,
+		apiSecret: secret,
+		method:    jwt.SigningMethodRS256,
+		key:       privateKey,
+	}, nil
+}
+
+func (s *livekitSigner) sign(claims jwt.Claims) (string, error) {
+	if s.apiKey == "" {
+		return "", errors.New("LiveKit API credentials are not configured")
+	}
+	if secret, ok := s.key.([]byte); ok && len(secret) == 0 {
+		return "", errors.New("LiveKit API credentials are not configured")
+	}
+
+	signedToken, err := jwt.NewWithClaims(s.method, claims).SignedString(s.key)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign LiveKit participant token: %w", err)
+	}
+
+	return signedToken, nil
+}
+
+var errInvalidWebhookSignature = errors.New("invalid webhook signature")
+
+type livekitWebhookClaims struct {
+	Sha256 string `json:"sha256"`
+	jwt.RegisteredClaims
+}
+
+type livekitWebhookEvent struct {
+	Event string `json:"event"`
+	Room  *struct {
+		Name string `json:"name"`
+	} `json:"room"`
+	Participant *struct {
+		Identity string `json:"identity"`
+	} `json:"participant"`
+}
+
+// verifyWebhook checks the Authorization JWT LiveKit attaches to webhook
+// deliveries: it must be signed with our API secret, issued for our API key,
+// and carry the base64 SHA-256 of the exact body it was sent with.
+func (s *livekitSigner) verifyWebhook(authorization string, body []byte) error {
+	if len(s.apiSecret) == 0 {
+		return errInvalidWebhookSignature
+	}
+
+	token := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(authorization), "Bearer "))
+	if token == "" {
+		return errInvalidWebhookSignature
+	}
+
+	claims := &livekitWebhookClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (any, error) {
+		return s.apiSecret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithIssuer(s.apiKey))
+	if err != nil {
+		return errInvalidWebhookSignature
+	}
+
+	sum := sha256.Sum256(body)
+	expected := base64.StdEncoding.EncodeToString(sum[:])
+	if subtle.ConstantTimeCompare([]byte(claims.Sha256), []byte(expected)) != 1 {
+		return errInvalidWebhookSignature
+	}
+
+	return nil
+}
+
+// parseRoomName reverses roomName. Direct thread kinds contain an underscore
+// themselves, so the known kinds are matched as prefixes.
+func parseRoomName(name string) (voiceTargetKind, string, bool) {
+	rest, ok := strings.CutPrefix(name, "mango_")
+	if !ok {
+		return "", "", false
+	}
+
+	for _, kind := range []voiceTargetKind{targetDirectThread, targetChannel} {
+		if targetID, ok := strings.CutPrefix(rest, string(kind)+"_"); ok && targetID != "" {
+			return kind, targetID, true
+		}
+	}
+
+	return "", "", false
+}
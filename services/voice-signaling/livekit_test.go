@@ -0,0 +1,474 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func parseParticipantToken(t *testing.T, token string, method jwt.SigningMethod, key any) *livekitTokenClaims {
+	t.Helper()
+
+	claims := &livekitTokenClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (any, error) {
+		return key, nil
+	}, jwt.WithValidMethods([]string{method.Alg()}))
+	if err != nil {
+		t.Fatalf("parse token: %v", err)
+	}
+	return claims
+}
+
+func TestParticipantTokenHS256(t *testing.T) {
+	store := newTestVoiceStore(noopPublisher{})
+
+	token, _, err := store.participantToken("usr_1", "abc123", targetChannel, "chn_1", true, true, livekitParticipantMetadata{}, "")
+	if err != nil {
+		t.Fatalf("participantToken: %v", err)
+	}
+
+	claims := parseParticipantToken(t, token, jwt.SigningMethodHS256, []byte("secret"))
+	if claims.Issuer != "devkey" || claims.Video.Room != roomName(targetChannel, "chn_1") {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+}
+
+func TestParticipantTokenNotBeforeSkew(t *testing.T) {
+	for _, skew := range []time.Duration{0, 30 * time.Second, 2 * time.Minute} {
+		cfg := testVoiceStoreConfig(noopPublisher{})
+		cfg.TokenSkew = skew
+		store := newVoiceStore(cfg)
+
+		token, _, err := store.participantToken("usr_1", "abc123", targetChannel, "chn_1", true, true, livekitParticipantMetadata{}, "")
+		if err != nil {
+			t.Fatalf("participantToken: %v", err)
+		}
+
+		claims := parseParticipantToken(t, token, jwt.SigningMethodHS256, []byte("secret"))
+		if got := claims.IssuedAt.Sub(claims.NotBefore.Time); got != skew {
+			t.Fatalf("expected nbf %v before iat, got %v", skew, got)
+		}
+	}
+}
+
+func TestParticipantTokenRS256(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	block := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})
+
+	// Escaped newlines, as they usually appear in env files.
+	signer, err := newLivekitSigner("devkey", "", strings.ReplaceAll(string(block), "\n", `\n`))
+	if err != nil {
+		t.Fatalf("newLivekitSigner: %v", err)
+	}
+	cfg := testVoiceStoreConfig(noopPublisher{})
+	cfg.Signer = signer
+	cfg.Clock = realClock{}
+	store := newVoiceStore(cfg)
+
+	token, _, err := store.participantToken("usr_1", "abc123", targetChannel, "chn_1", true, true, livekitParticipantMetadata{}, "")
+	if err != nil {
+		t.Fatalf("participantToken: %v", err)
+	}
+
+	claims := parseParticipantToken(t, token, jwt.SigningMethodRS256, &privateKey.PublicKey)
+	if claims.Issuer != "devkey" || claims.Subject != "usr_1_abc123" {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+
+	if _, err := newLivekitSigner("devkey", "", "not a pem"); err == nil {
+		t.Fatal("expected malformed PEM to be rejected")
+	}
+}
+
+func TestParticipantTokenAudienceAndMetadata(t *testing.T) {
+	cfg := testVoiceStoreConfig(noopPublisher{})
+	cfg.TokenAudience = "livekit.example"
+	store := newVoiceStore(cfg)
+	s := &server{store: store}
+
+	join := func(metadata string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/voice/channels/chn_1/join", strings.NewReader(`{}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Voice-User-Id", "usr_1")
+		req.Header.Set("X-Voice-Participant-Metadata", metadata)
+		rec := httptest.NewRecorder()
+		s.handleVoiceChannels(rec, req)
+		return rec
+	}
+	for _, metadata := range []string{`{"displayName":`, `["Ada"]`, `{"avatar":"` + strings.Repeat("x", maxParticipantMetadataBytes) + `"}`} {
+		if rec := join(metadata); rec.Code != http.StatusBadRequest {
+			t.Fatalf("expected %.20q to be rejected, got %d", metadata, rec.Code)
+		}
+	}
+
+	rec := join(`{"displayName":"Ada","avatarUrl":"https://cdn.example/ada.png"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("join: expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var session voiceSession
+	if err := json.Unmarshal(rec.Body.Bytes(), &session); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+
+	claims := parseParticipantToken(t, session.Signaling.ParticipantToken, jwt.SigningMethodHS256, []byte("secret"))
+	if len(claims.Audience) != 1 || claims.Audience[0] != "livekit.example" {
+		t.Fatalf("expected the configured audience, got %v", claims.Audience)
+	}
+	var metadata struct {
+		Profile struct {
+			DisplayName string `json:"displayName"`
+			AvatarURL   string `json:"avatarUrl"`
+		} `json:"profile"`
+	}
+	if err := json.Unmarshal([]byte(claims.Metadata), &metadata); err != nil || metadata.Profile.DisplayName != "Ada" || metadata.Profile.AvatarURL != "https://cdn.example/ada.png" {
+		t.Fatalf("expected the participant metadata in the token, got %q (%v)", claims.Metadata, err)
+	}
+
+	// Re-signed tokens keep it, and without an audience none is set.
+	refreshed, err := store.RefreshToken(targetChannel, "chn_1", "usr_1", "")
+	if err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if claims := parseParticipantToken(t, refreshed.ParticipantToken, jwt.SigningMethodHS256, []byte("secret")); !strings.Contains(claims.Metadata, `"displayName":"Ada"`) {
+		t.Fatalf("expected the refreshed token to keep the metadata, got %q", claims.Metadata)
+	}
+	token, _, err := newTestVoiceStore(noopPublisher{}).participantToken("usr_1", "abc123", targetChannel, "chn_1", true, true, livekitParticipantMetadata{}, "")
+	if err != nil {
+		t.Fatalf("participantToken: %v", err)
+	}
+	if claims := parseParticipantToken(t, token, jwt.SigningMethodHS256, []byte("secret")); claims.Audience != nil || claims.Metadata != "" {
+		t.Fatalf("expected no audience or metadata by default, got %+v", claims)
+	}
+}
+
+func TestRefreshTokenReusesIdentity(t *testing.T) {
+	store := newTestVoiceStore(noopPublisher{})
+
+	if _, err := store.RefreshToken(targetChannel, "chn_1", "usr_1", ""); !errors.Is(err, errVoiceNotConnected) || sessionErrorStatus(err) != 404 {
+		t.Fatalf("expected 404 when not connected, got %v", err)
+	}
+
+	if _, err := store.Join(targetChannel, "chn_1", "usr_1", nil, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	identity := "usr_1_" + memoryOf(store).sessionsByTarget[targetKey(targetChannel, "chn_1")].Participants["usr_1"].IdentitySuffix
+
+	for range 2 {
+		signaling, err := store.RefreshToken(targetChannel, "chn_1", "usr_1", "")
+		if err != nil {
+			t.Fatalf("refresh: %v", err)
+		}
+		if signaling.RoomName != roomName(targetChannel, "chn_1") || signaling.URL != "ws://livekit.test" {
+			t.Fatalf("unexpected signaling info %+v", signaling)
+		}
+
+		claims := parseParticipantToken(t, signaling.ParticipantToken, jwt.SigningMethodHS256, []byte("secret"))
+		if claims.Subject != identity {
+			t.Fatalf("expected identity %q, got %q", identity, claims.Subject)
+		}
+	}
+
+	if _, err := store.RefreshToken(targetChannel, "chn_1", "usr_2", ""); !errors.Is(err, errVoiceNotConnected) {
+		t.Fatalf("expected non-member refresh to fail, got %v", err)
+	}
+}
+
+func TestPrioritySpeakerIsExclusiveAndSigned(t *testing.T) {
+	store := newTestVoiceStore(noopPublisher{})
+	for _, userID := range []string{"usr_mod", "usr_1", "usr_2"} {
+		if _, err := store.Join(targetChannel, "chn_1", userID, nil, true, joinVoiceRequest{}); err != nil {
+			t.Fatalf("join %s: %v", userID, err)
+		}
+	}
+	metadata := func(userID string) string {
+		t.Helper()
+		signaling, err := store.RefreshToken(targetChannel, "chn_1", userID, "")
+		if err != nil {
+			t.Fatalf("refresh %s: %v", userID, err)
+		}
+		return parseParticipantToken(t, signaling.ParticipantToken, jwt.SigningMethodHS256, []byte("secret")).Metadata
+	}
+
+	if _, err := store.SetPrioritySpeaker(targetChannel, "chn_1", "usr_mod", "usr_1", true); err != nil {
+		t.Fatalf("set usr_1: %v", err)
+	}
+	if got := metadata("usr_1"); got != `{"prioritySpeaker":true}` {
+		t.Fatalf("expected the grant metadata to mark usr_1, got %q", got)
+	}
+
+	// The token cached on the record carries the role too, not only a
+	// freshly refreshed one.
+	cached := memoryOf(store).sessionsByTarget[targetKey(targetChannel, "chn_1")].Participants["usr_1"].Token
+	if claims := parseParticipantToken(t, cached, jwt.SigningMethodHS256, []byte("secret")); claims.Metadata == "" {
+		t.Fatal("expected the cached token to be re-signed with the metadata")
+	}
+
+	session, err := store.SetPrioritySpeaker(targetChannel, "chn_1", "usr_mod", "usr_2", true)
+	if err != nil {
+		t.Fatalf("set usr_2: %v", err)
+	}
+	if !findParticipant(t, session.Participants, "usr_2").PrioritySpeaker || findParticipant(t, session.Participants, "usr_1").PrioritySpeaker {
+		t.Fatalf("expected usr_2 to replace usr_1, got %+v", session.Participants)
+	}
+	if got := metadata("usr_1"); got != "" {
+		t.Fatalf("expected usr_1's metadata to be cleared, got %q", got)
+	}
+
+	session, err = store.SetPrioritySpeaker(targetChannel, "chn_1", "usr_mod", "usr_2", false)
+	if err != nil {
+		t.Fatalf("clear usr_2: %v", err)
+	}
+	for _, participant := range session.Participants {
+		if participant.PrioritySpeaker {
+			t.Fatalf("expected no priority speaker, got %+v", participant)
+		}
+	}
+}
+
+func TestParticipantIdentityStableAcrossReads(t *testing.T) {
+	store := newTestVoiceStore(noopPublisher{})
+	subject := func(session voiceSession) string {
+		t.Helper()
+		return parseParticipantToken(t, session.Signaling.ParticipantToken, jwt.SigningMethodHS256, []byte("secret")).Subject
+	}
+
+	joined, err := store.Join(targetChannel, "chn_1", "usr_1", nil, true, joinVoiceRequest{})
+	if err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	identity := subject(joined)
+
+	fetched, err := store.Get(targetChannel, "chn_1", "usr_1")
+	if err != nil || fetched == nil {
+		t.Fatalf("get: %v", err)
+	}
+	updated, err := store.UpdateState(targetChannel, "chn_1", "usr_1", updateVoiceStateRequest{Muted: boolPtr(true)})
+	if err != nil {
+		t.Fatalf("update state: %v", err)
+	}
+	rejoined, err := store.Join(targetChannel, "chn_1", "usr_1", nil, true, joinVoiceRequest{})
+	if err != nil {
+		t.Fatalf("rejoin: %v", err)
+	}
+
+	for _, session := range []voiceSession{*fetched, updated, rejoined} {
+		if got := subject(session); got != identity {
+			t.Fatalf("expected identity %q, got %q", identity, got)
+		}
+	}
+
+	if _, err := store.Leave(targetChannel, "chn_1", "usr_1"); err != nil {
+		t.Fatalf("leave: %v", err)
+	}
+	fresh, err := store.Join(targetChannel, "chn_1", "usr_1", nil, true, joinVoiceRequest{})
+	if err != nil {
+		t.Fatalf("join after leave: %v", err)
+	}
+	if subject(fresh) == identity {
+		t.Fatal("expected a new identity after leaving and joining again")
+	}
+}
+
+func TestParticipantTokenCachedUntilNearExpiry(t *testing.T) {
+	store := newTestVoiceStore(noopPublisher{})
+	if _, err := store.Join(targetChannel, "chn_1", "usr_1", nil, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+
+	first, err := store.Get(targetChannel, "chn_1", "usr_1")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	second, err := store.Get(targetChannel, "chn_1", "usr_1")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if first.Signaling.ParticipantToken != second.Signaling.ParticipantToken {
+		t.Fatal("expected the cached token to be reused")
+	}
+
+	clock := store.clock.(*fakeClock)
+	clock.Advance(time.Hour - 30*time.Second)
+
+	refreshed, err := store.Get(targetChannel, "chn_1", "usr_1")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if refreshed.Signaling.ParticipantToken == first.Signaling.ParticipantToken {
+		t.Fatal("expected a new token near expiry")
+	}
+	participant := memoryOf(store).sessionsByTarget[targetKey(targetChannel, "chn_1")].Participants["usr_1"]
+	if !participant.TokenExpiresAt.After(clock.Now().Add(time.Hour - time.Minute)) {
+		t.Fatalf("expected cached expiry to move forward, got %v", participant.TokenExpiresAt)
+	}
+}
+
+func TestListenOnlyJoin(t *testing.T) {
+	store := newTestVoiceStore(noopPublisher{})
+	grants := func(session voiceSession) livekitVideoGrant {
+		t.Helper()
+		return parseParticipantToken(t, session.Signaling.ParticipantToken, jwt.SigningMethodHS256, []byte("secret")).Video
+	}
+
+	joined, err := store.Join(targetChannel, "chn_1", "usr_1", nil, false, joinVoiceRequest{})
+	if err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	if video := grants(joined); video.CanPublish || video.CanPublishData || !video.CanSubscribe {
+		t.Fatalf("expected a listen-only grant, got %+v", video)
+	}
+	if joined.Participants[0].CanPublish {
+		t.Fatal("expected participant state to report canPublish=false")
+	}
+
+	// Force a re-sign so the heartbeat token is built from the persisted permission.
+	memoryOf(store).sessionsByTarget[targetKey(targetChannel, "chn_1")].Participants["usr_1"].Token = ""
+	heartbeat, err := store.Heartbeat(targetChannel, "chn_1", "usr_1", heartbeatRequest{})
+	if err != nil {
+		t.Fatalf("heartbeat: %v", err)
+	}
+	if video := grants(heartbeat); video.CanPublish || video.CanPublishData {
+		t.Fatalf("expected heartbeat token to stay listen-only, got %+v", video)
+	}
+	if heartbeat.Participants[0].CanPublish {
+		t.Fatal("expected permission to survive a heartbeat")
+	}
+
+	promoted, err := store.Join(targetChannel, "chn_1", "usr_1", nil, true, joinVoiceRequest{})
+	if err != nil {
+		t.Fatalf("rejoin: %v", err)
+	}
+	if video := grants(promoted); !video.CanPublish {
+		t.Fatalf("expected rejoin with publish permission to re-sign, got %+v", video)
+	}
+}
+
+func signWebhook(t *testing.T, body, secret string) string {
+	t.Helper()
+
+	sum := sha256.Sum256([]byte(body))
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, livekitWebhookClaims{
+		Sha256: base64.StdEncoding.EncodeToString(sum[:]),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "devkey",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+	}).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatalf("sign webhook: %v", err)
+	}
+	return token
+}
+
+func postWebhook(t *testing.T, s *server, body, authorization string) int {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/voice/livekit/webhook", strings.NewReader(body))
+	req.Header.Set("Authorization", authorization)
+	rec := httptest.NewRecorder()
+	s.handleLivekitWebhook(rec, req)
+	return rec.Code
+}
+
+func TestLivekitWebhook(t *testing.T) {
+	store := newTestVoiceStore(noopPublisher{})
+	s := &server{store: store}
+	for _, userID := range []string{"usr_1", "usr_2"} {
+		if _, err := store.Join(targetChannel, "chn_1", userID, nil, true, joinVoiceRequest{}); err != nil {
+			t.Fatalf("join: %v", err)
+		}
+	}
+	if _, err := store.Join(targetDirectThread, "dth_1", "usr_3", nil, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	identity := "usr_1_" + memoryOf(store).sessionsByTarget[targetKey(targetChannel, "chn_1")].Participants["usr_1"].IdentitySuffix
+
+	left := `{"event":"participant_left","room":{"name":"mango_channel_chn_1"},"participant":{"identity":"` + identity + `"}}`
+	if code := postWebhook(t, s, left, signWebhook(t, left, "wrong-secret")); code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a bad signature, got %d", code)
+	}
+	if code := postWebhook(t, s, left, signWebhook(t, `{"event":"room_finished"}`, "secret")); code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a body hash mismatch, got %d", code)
+	}
+	if _, ok := memoryOf(store).targetByUserID["usr_1"]; !ok {
+		t.Fatal("expected rejected webhooks to leave state untouched")
+	}
+
+	if code := postWebhook(t, s, left, signWebhook(t, left, "secret")); code != http.StatusOK {
+		t.Fatalf("participant_left: expected 200, got %d", code)
+	}
+	if _, ok := memoryOf(store).targetByUserID["usr_1"]; ok {
+		t.Fatal("expected participant_left to remove the participant")
+	}
+	if _, ok := memoryOf(store).targetByUserID["usr_2"]; !ok {
+		t.Fatal("expected other participants to remain")
+	}
+
+	finished := `{"event":"room_finished","room":{"name":"mango_direct_thread_dth_1"}}`
+	if code := postWebhook(t, s, finished, signWebhook(t, finished, "secret")); code != http.StatusOK {
+		t.Fatalf("room_finished: expected 200, got %d", code)
+	}
+	if _, ok := memoryOf(store).sessionsByTarget[targetKey(targetDirectThread, "dth_1")]; ok {
+		t.Fatal("expected room_finished to delete the session")
+	}
+	if _, ok := memoryOf(store).targetByUserID["usr_3"]; ok {
+		t.Fatal("expected room_finished to clear participant targets")
+	}
+}
+
+func TestRegionSignalingURL(t *testing.T) {
+	regionURLs, err := parseRegionURLs(`{"EU": "wss://eu.livekit.test", "us": "wss://us.livekit.test"}`)
+	if err != nil {
+		t.Fatalf("parseRegionURLs: %v", err)
+	}
+	cfg := testVoiceStoreConfig(noopPublisher{})
+	cfg.RegionURLs = regionURLs
+	store := newVoiceStore(cfg)
+
+	session, err := store.Join(targetChannel, "chn_1", "usr_1", nil, true, joinVoiceRequest{Region: "eu"})
+	if err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	if session.Signaling.URL != "wss://eu.livekit.test" {
+		t.Fatalf("expected the EU SFU, got %q", session.Signaling.URL)
+	}
+
+	// Later joiners share the room's SFU whatever their own region.
+	session, err = store.Join(targetChannel, "chn_1", "usr_2", nil, true, joinVoiceRequest{Region: "us"})
+	if err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	if session.Signaling.URL != "wss://eu.livekit.test" {
+		t.Fatalf("expected the room to stay on the EU SFU, got %q", session.Signaling.URL)
+	}
+	if signaling, err := store.RefreshToken(targetChannel, "chn_1", "usr_2", ""); err != nil || signaling.URL != "wss://eu.livekit.test" {
+		t.Fatalf("expected refreshes to keep the room's SFU, got %+v (%v)", signaling, err)
+	}
+
+	session, err = store.Join(targetChannel, "chn_2", "usr_3", nil, true, joinVoiceRequest{Region: "mars"})
+	if err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	if session.Signaling.URL != "ws://livekit.test" {
+		t.Fatalf("expected unknown regions to fall back to the default, got %q", session.Signaling.URL)
+	}
+
+	if _, err := parseRegionURLs(`{"eu": ""}`); err == nil {
+		t.Fatal("expected an empty URL to be rejected")
+	}
+}
@@ -2,19 +2,25 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"mime"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"sort"
 	"strconv"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -27,13 +33,74 @@ const (
 	targetDirectThread voiceTargetKind = "direct_thread"
 )
 
+// voiceJoinMode is how a participant takes part. Spectators can send data,
+// such as reactions, but never publish audio or video, and are capped
+// separately from speakers.
+type voiceJoinMode string
+
+const (
+	joinModeSpeaker   voiceJoinMode = "speaker"
+	joinModeSpectator voiceJoinMode = "spectator"
+)
+
+// Clock abstracts time.Now so reconnect grace, speaking timeouts, and token
+// expiry can be tested without sleeping.
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// tokenRefreshWindow is how close to expiry a cached participant token may
+// get before reads re-sign it.
+const tokenRefreshWindow = 60 * time.Second
+
+// The server-sessions listing returns defaultSessionsPageLimit sessions per
+// page unless asked for more, and never more than maxSessionsPageLimit.
+const (
+	defaultSessionsPageLimit = 50
+	maxSessionsPageLimit     = 200
+)
+
+// Session metadata is mirrored into every participant token, so it is kept
+// small: maxSessionMetadataBytes counts keys and values together.
+const (
+	maxSessionMetadataBytes = 2048
+	maxSessionMetadataKeys  = 16
+)
+
+// maxParticipantMetadataBytes caps X-Voice-Participant-Metadata, which is
+// copied into each of the participant's tokens.
+const maxParticipantMetadataBytes = 1024
+
+// maxTokenSkewSeconds caps LIVEKIT_TOKEN_NBF_SKEW_SECONDS; drift beyond a few
+// minutes wants fixing rather than tolerating.
+const maxTokenSkewSeconds = 300
+
 var (
 	errVoiceSessionNotFound = errors.New("voice session not found")
 	errVoiceNotConnected    = errors.New("not connected to this voice session")
+	errVoiceModeratorMuted  = errors.New("muted by a moderator")
+	errVoiceSelfModeration  = errors.New("moderators cannot target themselves")
+	errVoiceConflict        = errors.New("voice session changed concurrently, try again")
+	errVoiceSessionLocked   = errors.New("voice session is locked")
+	errVoiceBanned          = errors.New("banned from this voice session")
+	errVoiceSessionFull     = errors.New("voice session is full")
+	errVoiceServerFull      = errors.New("server has too many active voice sessions")
+	errVoiceScreenShareFull = errors.New("too many screen shares in this voice session")
+	errInvalidSessionCursor = errors.New("cursor is not valid")
+	errVoiceMetadataKey     = errors.New("metadata keys must not be empty")
+	errVoiceMetadataKeys    = fmt.Errorf("metadata must have at most %d keys", maxSessionMetadataKeys)
+	errVoiceMetadataSize    = fmt.Errorf("metadata must be at most %d bytes", maxSessionMetadataBytes)
 )
 
 type voiceFeatureFlags struct {
 	ScreenShare bool `json:"screenShare"`
+	Video       bool `json:"video"`
 }
 
 type voiceSignalingInfo struct {
@@ -43,32 +110,126 @@ type voiceSignalingInfo struct {
 }
 
 type voiceParticipantState struct {
-	UserID        string `json:"userId"`
-	Muted         bool   `json:"muted"`
-	Deafened      bool   `json:"deafened"`
-	Speaking      bool   `json:"speaking"`
-	ScreenSharing bool   `json:"screenSharing"`
-	JoinedAt      string `json:"joinedAt"`
-	LastSeenAt    string `json:"lastSeenAt"`
+	UserID           string        `json:"userId"`
+	Muted            bool          `json:"muted"`
+	MutedByModerator bool          `json:"mutedByModerator"`
+	Deafened         bool          `json:"deafened"`
+	Speaking         bool          `json:"speaking"`
+	ScreenSharing    bool          `json:"screenSharing"`
+	ScreenShareAudio bool          `json:"screenShareAudio"`
+	CameraOn         bool          `json:"cameraOn"`
+	PrioritySpeaker  bool          `json:"prioritySpeaker"`
+	CanPublish       bool          `json:"canPublish"`
+	JoinMode         voiceJoinMode `json:"joinMode"`
+	TotalSpeakingMs  int64         `json:"totalSpeakingMs"`
+	HandRaised       bool          `json:"handRaised"`
+	HandRaisedAt     *string       `json:"handRaisedAt"`
+	// ConnectionQuality is the client's own report of its SFU connection.
+	ConnectionQuality voiceConnectionQuality `json:"connectionQuality"`
+	JoinedAt          string                 `json:"joinedAt"`
+	LastSeenAt        string                 `json:"lastSeenAt"`
+	// Connections lists the devices that joined with a connection id.
+	Connections []voiceConnectionState `json:"connections"`
 }
 
 type voiceSession struct {
+	ID                 string                  `json:"id"`
+	TargetKind         voiceTargetKind         `json:"targetKind"`
+	TargetID           string                  `json:"targetId"`
+	ServerID           *string                 `json:"serverId"`
+	StartedAt          string                  `json:"startedAt"`
+	UpdatedAt          string                  `json:"updatedAt"`
+	Seq                int64                   `json:"seq"`
+	CreatedBy          string                  `json:"createdBy"`
+	StartReason        *string                 `json:"startReason"`
+	ReconnectGraceMs   int64                   `json:"reconnectGraceMs"`
+	ParticipantCount   int                     `json:"participantCount"`
+	MaxParticipants    *int                    `json:"maxParticipants"`
+	Locked             bool                    `json:"locked"`
+	Metadata           map[string]string       `json:"metadata"`
+	Recording          bool                    `json:"recording"`
+	RecordingStartedAt *string                 `json:"recordingStartedAt"`
+	Features           voiceFeatureFlags       `json:"features"`
+	Participants       []voiceParticipantState `json:"participants"`
+	RaisedHands        []string                `json:"raisedHands"`
+	Signaling          voiceSignalingInfo      `json:"signaling"`
+}
+
+// voiceSessionEvent is the realtime view of a session. It never carries
+// participant tokens, since every topic subscriber receives it.
+type voiceSessionEvent struct {
+	SessionID    string                  `json:"sessionId"`
+	TargetKind   voiceTargetKind         `json:"targetKind"`
+	TargetID     string                  `json:"targetId"`
+	ServerID     *string                 `json:"serverId"`
+	UpdatedAt    string                  `json:"updatedAt"`
+	Seq          int64                   `json:"seq"`
+	Locked       bool                    `json:"locked"`
+	Metadata     map[string]string       `json:"metadata"`
+	Participants []voiceParticipantState `json:"participants"`
+	RaisedHands  []string                `json:"raisedHands"`
+}
+
+// voiceSessionSummary is the token-free overview used by server-wide
+// listings.
+type voiceSessionSummary struct {
 	ID               string                  `json:"id"`
 	TargetKind       voiceTargetKind         `json:"targetKind"`
 	TargetID         string                  `json:"targetId"`
-	ServerID         *string                 `json:"serverId"`
+	ParticipantCount int                     `json:"participantCount"`
 	StartedAt        string                  `json:"startedAt"`
-	UpdatedAt        string                  `json:"updatedAt"`
-	ReconnectGraceMs int64                   `json:"reconnectGraceMs"`
-	Features         voiceFeatureFlags       `json:"features"`
-	Participants     []voiceParticipantState `json:"participants"`
-	Signaling        voiceSignalingInfo      `json:"signaling"`
+	Participants     []voiceParticipantState `json:"participants,omitempty"`
+}
+
+type voiceKickEvent struct {
+	SessionID  string          `json:"sessionId"`
+	TargetKind voiceTargetKind `json:"targetKind"`
+	TargetID   string          `json:"targetId"`
+	UserID     string          `json:"userId"`
+	KickedBy   string          `json:"kickedBy"`
+	Banned     bool            `json:"banned"`
+}
+
+type voiceEndEvent struct {
+	SessionID    string          `json:"sessionId"`
+	TargetKind   voiceTargetKind `json:"targetKind"`
+	TargetID     string          `json:"targetId"`
+	EndedBy      string          `json:"endedBy"`
+	Participants []string        `json:"participants"`
+}
+
+type voiceMoveEvent struct {
+	SessionID    string          `json:"sessionId"`
+	TargetKind   voiceTargetKind `json:"targetKind"`
+	TargetID     string          `json:"targetId"`
+	UserID       string          `json:"userId"`
+	MovedBy      string          `json:"movedBy"`
+	ToSessionID  string          `json:"toSessionId"`
+	ToTargetKind voiceTargetKind `json:"toTargetKind"`
+	ToTargetID   string          `json:"toTargetId"`
 }
 
 type joinVoiceRequest struct {
 	Muted    *bool `json:"muted"`
 	Deafened *bool `json:"deafened"`
 	Speaking *bool `json:"speaking"`
+	// ReconnectGrace comes from the X-Voice-Reconnect-Grace-Ms header rather
+	// than the body; zero leaves the session's grace as it is.
+	ReconnectGrace time.Duration `json:"-"`
+	// Region comes from the X-Voice-Region header and picks the SFU for a
+	// session the join creates.
+	Region string `json:"-"`
+	// Mode comes from the X-Voice-Join-Mode header; empty means speaker.
+	Mode voiceJoinMode `json:"-"`
+	// StartReason comes from the X-Voice-Start-Reason header and is only
+	// recorded when the join starts the session.
+	StartReason string `json:"-"`
+	// ParticipantMetadata comes from the X-Voice-Participant-Metadata
+	// header; empty keeps what the participant joined with before.
+	ParticipantMetadata string `json:"-"`
+	// ConnectionID comes from the X-Voice-Connection-Id header and joins a
+	// device of its own; empty uses the participant's own identity.
+	ConnectionID string `json:"-"`
 }
 
 type updateVoiceStateRequest struct {
@@ -79,20 +240,147 @@ type updateVoiceStateRequest struct {
 
 type updateScreenShareRequest struct {
 	ScreenSharing *bool `json:"screenSharing"`
+	ShareAudio    *bool `json:"shareAudio"`
+}
+
+type updateCameraRequest struct {
+	CameraOn *bool `json:"cameraOn"`
+}
+
+type raiseHandRequest struct {
+	Raised *bool `json:"raised"`
+}
+
+type reactRequest struct {
+	Emoji string `json:"emoji"`
+}
+
+type moderatorMuteRequest struct {
+	Muted *bool `json:"muted"`
+}
+
+type prioritySpeakerRequest struct {
+	PrioritySpeaker *bool `json:"prioritySpeaker"`
+}
+
+type lockSessionRequest struct {
+	Locked *bool `json:"locked"`
+}
+
+type sessionMetadataRequest struct {
+	Metadata map[string]string `json:"metadata"`
+}
+
+type moveParticipantRequest struct {
+	TargetKind voiceTargetKind `json:"targetKind"`
+	TargetID   string          `json:"targetId"`
 }
 
 type heartbeatRequest struct {
 	Speaking *bool `json:"speaking"`
+	// ConnectionID comes from the X-Voice-Connection-Id header.
+	ConnectionID string `json:"-"`
 }
 
 type participantRecord struct {
-	UserID        string
-	Muted         bool
-	Deafened      bool
-	Speaking      bool
-	ScreenSharing bool
-	JoinedAt      time.Time
-	LastSeenAt    time.Time
+	UserID           string
+	Muted            bool
+	MutedByModerator bool
+	Deafened         bool
+	Speaking         bool
+	ScreenSharing    bool
+	ScreenShareAudio bool
+	CameraOn         bool
+	PrioritySpeaker  bool
+	CanPublish       bool
+	HandRaised       bool
+	HandRaisedAt     *time.Time
+	IdentitySuffix   string
+	Token            string
+	TokenExpiresAt   time.Time
+	JoinedAt         time.Time
+	LastSeenAt       time.Time
+	LastSpokeAt      time.Time
+	// TotalSpeaking sums closed speaking intervals; SpeakingSince marks the
+	// start of the open one, if any.
+	TotalSpeaking time.Duration
+	SpeakingSince *time.Time
+	// MutedBeforeDeafen is the mute the client asked for while deafened,
+	// restored when they undeafen. Only used when deafening implies mute.
+	MutedBeforeDeafen bool
+	// JoinMode is empty for speakers, including records written before
+	// spectators existed.
+	JoinMode voiceJoinMode
+	// Metadata is the JSON object the client joined with, such as a display
+	// name and avatar. It is passed to LiveKit in every token.
+	Metadata string
+	// Connections holds the devices that joined with X-Voice-Connection-Id,
+	// keyed by that id. When there are any, the participant leaves once the
+	// last of them does.
+	Connections map[string]*participantConnection
+	// ConnectionQuality is the last quality the client reported and
+	// ConnectionQualityAt when; stale reports read as good.
+	ConnectionQuality   voiceConnectionQuality
+	ConnectionQualityAt *time.Time
+}
+
+// livekitMetadata is the token metadata for the participant's main
+// connection.
+func (p *participantRecord) livekitMetadata() livekitParticipantMetadata {
+	return livekitParticipantMetadata{
+		PrioritySpeaker: p.PrioritySpeaker,
+		Profile:         participantProfile(p.Metadata),
+	}
+}
+
+// participantProfile returns the stored metadata as raw JSON, or nil when
+// the participant joined without any.
+func participantProfile(metadata string) json.RawMessage {
+	if metadata == "" {
+		return nil
+	}
+	return json.RawMessage(metadata)
+}
+
+func (p *participantRecord) joinMode() voiceJoinMode {
+	if p.JoinMode == joinModeSpectator {
+		return joinModeSpectator
+	}
+	return joinModeSpeaker
+}
+
+// markSpeaking records a client's speaking report. Each report of speaking
+// refreshes LastSpokeAt so ClearStaleSpeaking can tell a live flag from one
+// left behind by a client that crashed mid-sentence. Transitions open and
+// close the interval counted towards TotalSpeaking. Spectators and other
+// listen-only participants have nothing to speak with, so their reports
+// always count as silence.
+func (p *participantRecord) markSpeaking(speaking bool, now time.Time) {
+	p.Speaking = speaking && p.CanPublish
+	if p.Speaking {
+		p.LastSpokeAt = now
+		if p.SpeakingSince == nil {
+			since := now
+			p.SpeakingSince = &since
+		}
+		return
+	}
+
+	if p.SpeakingSince != nil {
+		if now.After(*p.SpeakingSince) {
+			p.TotalSpeaking += now.Sub(*p.SpeakingSince)
+		}
+		p.SpeakingSince = nil
+	}
+}
+
+// speakingTotal is TotalSpeaking plus the interval still open at now.
+func (p *participantRecord) speakingTotal(now time.Time) time.Duration {
+	total := p.TotalSpeaking
+	if p.SpeakingSince != nil && now.After(*p.SpeakingSince) {
+		total += now.Sub(*p.SpeakingSince)
+	}
+	return total
 }
 
 type sessionRecord struct {
@@ -103,37 +391,144 @@ type sessionRecord struct {
 	StartedAt    time.Time
 	UpdatedAt    time.Time
 	Participants map[string]*participantRecord
+	// CreatedBy is the user whose join opened the session and StartReason
+	// the X-Voice-Start-Reason that join sent. StartReason never changes;
+	// CreatedBy moves on when the creator leaves or hands it over.
+	CreatedBy   string
+	StartReason string
+	// Locked rooms keep their current participants but admit nobody new.
+	Locked bool
+	// ReconnectGrace overrides the store's default when non-zero.
+	ReconnectGrace time.Duration
+	// SignalingURL is the SFU chosen when the session started, so everyone
+	// in the room connects to the same one. Empty means the default.
+	SignalingURL string
+	// Metadata is free-form key/value data set by moderators, such as a
+	// topic. It is sent to LiveKit as the room metadata.
+	Metadata map[string]string
+	// Recording is set while a moderator-started recording runs; RecordingID
+	// is the recorder's handle for stopping it.
+	Recording          bool
+	RecordingStartedAt *time.Time
+	RecordingID        string
+	// Seq counts the updates published for the session, so clients can put
+	// events in order and notice ones they missed.
+	Seq int64
+	// creatorChange is set by setCreator and published by the next
+	// publishSession, after the update that explains it. It is never stored.
+	creatorChange *voiceCreatorEvent
 }
 
 type voiceStore struct {
-	mu                sync.RWMutex
-	sessionsByTarget  map[string]*sessionRecord
-	targetByUserID    map[string]string
+	backend           voiceBackend
 	reconnectGrace    time.Duration
+	minReconnectGrace time.Duration
+	maxReconnectGrace time.Duration
+	speakingTimeout   time.Duration
+	idleTimeout       time.Duration
+	banDuration       time.Duration
 	enableScreenShare bool
+	enableVideo       bool
+	deafenImpliesMute bool
+	maxSpeakers       int
+	maxSpectators     int
+	maxServerSessions int
+	maxScreenShares   int
 	signalingURL      string
-	livekitAPIKey     string
-	livekitAPISecret  string
+	regionURLs        map[string]string
+	signer            *livekitSigner
 	tokenTTL          time.Duration
+	tokenAudience     string
+	tokenSkew         time.Duration
+	publisher         publisher
+	recorder          recorder
+	metrics           *voiceMetrics
+	reactions         *reactionLimiter
+	churn             *churnLimiter
+	joinReplays       *joinReplayCache
+	holds             *joinHolds
+	clock             Clock
+}
+
+type voiceStoreConfig struct {
+	ReconnectGrace    time.Duration
+	MinReconnectGrace time.Duration
+	MaxReconnectGrace time.Duration
+	SpeakingTimeout   time.Duration
+	IdleTimeout       time.Duration
+	BanDuration       time.Duration
+	EnableScreenShare bool
+	EnableVideo       bool
+	DeafenImpliesMute bool
+	SignalingURL      string
+	RegionURLs        map[string]string
+	Signer            *livekitSigner
+	TokenTTL          time.Duration
+	Backend           voiceBackend
+	Publisher         publisher
+	Recorder          recorder
+	Metrics           *voiceMetrics
+	Clock             Clock
+	// MaxSpeakers and MaxSpectators cap each join mode per session; zero
+	// means no limit.
+	MaxSpeakers   int
+	MaxSpectators int
+	// MaxServerSessions caps a server's concurrent sessions; zero means no
+	// limit.
+	MaxServerSessions int
+	// MaxScreenShares caps how many participants of a session may share
+	// their screen at once; zero means no limit.
+	MaxScreenShares int
+	// ChurnBurst joins and leaves are allowed per user every ChurnWindow;
+	// zero disables the limit.
+	ChurnBurst  int
+	ChurnWindow time.Duration
+	// TokenAudience is set as the aud claim of participant tokens when
+	// non-empty, for SFUs that validate it.
+	TokenAudience string
+	// TokenSkew backdates the nbf claim of participant tokens, so an SFU
+	// whose clock runs behind ours still accepts them.
+	TokenSkew time.Duration
+	// JoinReplayWindow is how long a join response is replayed for a
+	// repeated Idempotency-Key; zero disables replays.
+	JoinReplayWindow time.Duration
+	// JoinHoldTTL is how long a preflight's hold keeps a slot for the join
+	// that follows; zero disables holds.
+	JoinHoldTTL time.Duration
 }
 
-func newVoiceStore(
-	reconnectGrace time.Duration,
-	enableScreenShare bool,
-	signalingURL string,
-	livekitAPIKey string,
-	livekitAPISecret string,
-	tokenTTL time.Duration,
-) *voiceStore {
+func newVoiceStore(cfg voiceStoreConfig) *voiceStore {
+	cfg.Metrics.watchBackend(cfg.Backend)
+
 	return &voiceStore{
-		sessionsByTarget:  map[string]*sessionRecord{},
-		targetByUserID:    map[string]string{},
-		reconnectGrace:    reconnectGrace,
-		enableScreenShare: enableScreenShare,
-		signalingURL:      signalingURL,
-		livekitAPIKey:     strings.TrimSpace(livekitAPIKey),
-		livekitAPISecret:  strings.TrimSpace(livekitAPISecret),
-		tokenTTL:          tokenTTL,
+		backend:           cfg.Backend,
+		reconnectGrace:    cfg.ReconnectGrace,
+		minReconnectGrace: cfg.MinReconnectGrace,
+		maxReconnectGrace: cfg.MaxReconnectGrace,
+		speakingTimeout:   cfg.SpeakingTimeout,
+		idleTimeout:       cfg.IdleTimeout,
+		banDuration:       cfg.BanDuration,
+		enableScreenShare: cfg.EnableScreenShare,
+		enableVideo:       cfg.EnableVideo,
+		deafenImpliesMute: cfg.DeafenImpliesMute,
+		maxSpeakers:       cfg.MaxSpeakers,
+		maxSpectators:     cfg.MaxSpectators,
+		maxServerSessions: cfg.MaxServerSessions,
+		maxScreenShares:   cfg.MaxScreenShares,
+		signalingURL:      cfg.SignalingURL,
+		regionURLs:        cfg.RegionURLs,
+		signer:            cfg.Signer,
+		tokenTTL:          cfg.TokenTTL,
+		tokenAudience:     cfg.TokenAudience,
+		tokenSkew:         cfg.TokenSkew,
+		publisher:         cfg.Publisher,
+		recorder:          cfg.Recorder,
+		metrics:           cfg.Metrics,
+		reactions:         newReactionLimiter(reactionInterval),
+		churn:             newChurnLimiter(cfg.ChurnBurst, cfg.ChurnWindow),
+		joinReplays:       newJoinReplayCache(cfg.JoinReplayWindow),
+		holds:             newJoinHolds(cfg.JoinHoldTTL),
+		clock:             cfg.Clock,
 	}
 }
 
@@ -167,6 +562,10 @@ func roomName(kind voiceTargetKind, targetID string) string {
 	return "mango_" + string(kind) + "_" + targetID
 }
 
+func sessionTopic(kind voiceTargetKind, targetID string) string {
+	return "voice:" + string(kind) + ":" + targetID
+}
+
 type livekitVideoGrant struct {
 	RoomJoin       bool   `json:"roomJoin"`
 	Room           string `json:"room"`
@@ -175,144 +574,378 @@ type livekitVideoGrant struct {
 	CanPublishData bool   `json:"canPublishData"`
 }
 
+// livekitParticipantMetadata is carried in the token's metadata claim so the
+// SFU can act on it, e.g. ducking other audio under a priority speaker.
+type livekitParticipantMetadata struct {
+	PrioritySpeaker bool `json:"prioritySpeaker"`
+	// WhisperTo marks a whisper connection: only these users should
+	// subscribe to its audio.
+	WhisperTo []string `json:"whisperTo,omitempty"`
+	// Profile is the participant's own metadata, from the
+	// X-Voice-Participant-Metadata header they joined with.
+	Profile json.RawMessage `json:"profile,omitempty"`
+}
+
+// livekitRoomConfig is applied by LiveKit when a join creates the room.
+type livekitRoomConfig struct {
+	Metadata string `json:"metadata,omitempty"`
+}
+
 type livekitTokenClaims struct {
-	Video livekitVideoGrant `json:"video"`
-	Name  string            `json:"name"`
+	Video      livekitVideoGrant  `json:"video"`
+	Name       string             `json:"name"`
+	Metadata   string             `json:"metadata,omitempty"`
+	RoomConfig *livekitRoomConfig `json:"roomConfig,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func (s *voiceStore) participantToken(userID string, kind voiceTargetKind, targetID string) (string, error) {
-	if s.livekitAPIKey == "" || s.livekitAPISecret == "" {
-		return "", errors.New("LiveKit API credentials are not configured")
+func (s *voiceStore) participantToken(userID, identitySuffix string, kind voiceTargetKind, targetID string, canPublish, canPublishData bool, participantMetadata livekitParticipantMetadata, roomMetadata string) (string, time.Time, error) {
+	identity := userID + "_" + identitySuffix
+	now := s.clock.Now().UTC()
+	expiresAt := now.Add(s.tokenTTL)
+
+	var metadata string
+	if participantMetadata.PrioritySpeaker || len(participantMetadata.WhisperTo) > 0 || len(participantMetadata.Profile) > 0 {
+		encoded, err := json.Marshal(participantMetadata)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		metadata = string(encoded)
+	}
+
+	var roomConfig *livekitRoomConfig
+	if roomMetadata != "" {
+		roomConfig = &livekitRoomConfig{Metadata: roomMetadata}
 	}
 
-	identity := userID + "_" + randomSuffix(6)
-	now := time.Now().UTC()
 	claims := livekitTokenClaims{
 		Video: livekitVideoGrant{
 			RoomJoin:       true,
 			Room:           roomName(kind, targetID),
-			CanPublish:     true,
+			CanPublish:     canPublish,
 			CanSubscribe:   true,
-			CanPublishData: true,
+			CanPublishData: canPublishData,
 		},
-		Name: userID,
+		Name:       userID,
+		Metadata:   metadata,
+		RoomConfig: roomConfig,
 		RegisteredClaims: jwt.RegisteredClaims{
-			Issuer:    s.livekitAPIKey,
+			Issuer:    s.signer.apiKey,
 			Subject:   identity,
 			IssuedAt:  jwt.NewNumericDate(now),
-			NotBefore: jwt.NewNumericDate(now.Add(-30 * time.Second)),
-			ExpiresAt: jwt.NewNumericDate(now.Add(s.tokenTTL)),
+			NotBefore: jwt.NewNumericDate(now.Add(-s.tokenSkew)),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
 		},
 	}
+	if s.tokenAudience != "" {
+		claims.Audience = jwt.ClaimStrings{s.tokenAudience}
+	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signedToken, err := token.SignedString([]byte(s.livekitAPISecret))
+	started := time.Now()
+	signedToken, err := s.signer.sign(claims)
+	s.metrics.tokenSignDuration.Observe(time.Since(started).Seconds())
 	if err != nil {
-		return "", fmt.Errorf("failed to sign LiveKit participant token: %w", err)
+		return "", time.Time{}, err
 	}
 
+	return signedToken, expiresAt, nil
+}
+
+// cachedToken returns the current token of one of the participant's
+// connections, re-signing only once it is within tokenRefreshWindow of
+// expiring. It must run inside an update since the cache lives on the
+// participant record. An empty or unknown connectionID means the
+// participant's own identity.
+func (s *voiceStore) cachedToken(participant *participantRecord, record *sessionRecord, connectionID string) (string, error) {
+	token, expiresAt := participant.Token, participant.TokenExpiresAt
+	if connection, ok := participant.Connections[connectionID]; ok {
+		token, expiresAt = connection.Token, connection.TokenExpiresAt
+	}
+	if token != "" && s.clock.Now().Add(tokenRefreshWindow).Before(expiresAt) {
+		return token, nil
+	}
+
+	return s.resignToken(participant, record, connectionID)
+}
+
+func (s *voiceStore) resignToken(participant *participantRecord, record *sessionRecord, connectionID string) (string, error) {
+	connection, ok := participant.Connections[connectionID]
+	identitySuffix := participant.IdentitySuffix
+	if ok {
+		identitySuffix = connection.IdentitySuffix
+	}
+
+	signedToken, expiresAt, err := s.participantToken(participant.UserID, identitySuffix, record.TargetKind, record.TargetID, participant.CanPublish, participant.CanPublish || participant.joinMode() == joinModeSpectator, participant.livekitMetadata(), roomMetadata(record))
+	if err != nil {
+		return "", err
+	}
+
+	if ok {
+		connection.Token, connection.TokenExpiresAt = signedToken, expiresAt
+	} else {
+		participant.Token, participant.TokenExpiresAt = signedToken, expiresAt
+	}
 	return signedToken, nil
 }
 
-func (s *voiceStore) buildSession(record *sessionRecord, userID string) (voiceSession, error) {
+// roomMetadata encodes the session metadata for the LiveKit room, or returns
+// "" when there is none.
+func roomMetadata(record *sessionRecord) string {
+	if len(record.Metadata) == 0 {
+		return ""
+	}
+
+	encoded, err := json.Marshal(record.Metadata)
+	if err != nil {
+		return ""
+	}
+	return string(encoded)
+}
+
+// sessionMetadata copies the record's metadata for a response, so an unset
+// map still serializes as {}.
+func sessionMetadata(record *sessionRecord) map[string]string {
+	metadata := make(map[string]string, len(record.Metadata))
+	for key, value := range record.Metadata {
+		metadata[key] = value
+	}
+	return metadata
+}
+
+// validateSessionMetadata enforces the key count and size caps.
+func validateSessionMetadata(metadata map[string]string) error {
+	if len(metadata) > maxSessionMetadataKeys {
+		return errVoiceMetadataKeys
+	}
+
+	size := 0
+	for key, value := range metadata {
+		if strings.TrimSpace(key) == "" {
+			return errVoiceMetadataKey
+		}
+		size += len(key) + len(value)
+	}
+	if size > maxSessionMetadataBytes {
+		return errVoiceMetadataSize
+	}
+
+	return nil
+}
+
+func participantStates(record *sessionRecord, now time.Time) []voiceParticipantState {
 	participants := make([]voiceParticipantState, 0, len(record.Participants))
 	for _, participant := range record.Participants {
+		var handRaisedAt *string
+		if participant.HandRaisedAt != nil {
+			formatted := participant.HandRaisedAt.UTC().Format(time.RFC3339Nano)
+			handRaisedAt = &formatted
+		}
+
 		participants = append(participants, voiceParticipantState{
-			UserID:        participant.UserID,
-			Muted:         participant.Muted,
-			Deafened:      participant.Deafened,
-			Speaking:      participant.Speaking,
-			ScreenSharing: participant.ScreenSharing,
-			JoinedAt:      participant.JoinedAt.UTC().Format(time.RFC3339Nano),
-			LastSeenAt:    participant.LastSeenAt.UTC().Format(time.RFC3339Nano),
+			UserID:            participant.UserID,
+			Muted:             participant.Muted,
+			MutedByModerator:  participant.MutedByModerator,
+			Deafened:          participant.Deafened,
+			Speaking:          participant.Speaking,
+			ScreenSharing:     participant.ScreenSharing,
+			ScreenShareAudio:  participant.ScreenShareAudio,
+			CameraOn:          participant.CameraOn,
+			PrioritySpeaker:   participant.PrioritySpeaker,
+			CanPublish:        participant.CanPublish,
+			JoinMode:          participant.joinMode(),
+			TotalSpeakingMs:   participant.speakingTotal(now).Milliseconds(),
+			HandRaised:        participant.HandRaised,
+			HandRaisedAt:      handRaisedAt,
+			ConnectionQuality: participant.connectionQuality(now),
+			JoinedAt:          participant.JoinedAt.UTC().Format(time.RFC3339Nano),
+			LastSeenAt:        participant.LastSeenAt.UTC().Format(time.RFC3339Nano),
+			Connections:       connectionStates(participant),
 		})
 	}
 
-	participantToken, err := s.participantToken(userID, record.TargetKind, record.TargetID)
-	if err != nil {
-		return voiceSession{}, err
+	return participants
+}
+
+// raisedHands is the speaking queue: users with a raised hand, earliest
+// first.
+func raisedHands(record *sessionRecord) []string {
+	raised := make([]*participantRecord, 0)
+	for _, participant := range record.Participants {
+		if participant.HandRaised && participant.HandRaisedAt != nil {
+			raised = append(raised, participant)
+		}
+	}
+
+	sort.Slice(raised, func(i, j int) bool {
+		if !raised[i].HandRaisedAt.Equal(*raised[j].HandRaisedAt) {
+			return raised[i].HandRaisedAt.Before(*raised[j].HandRaisedAt)
+		}
+		return raised[i].UserID < raised[j].UserID
+	})
+
+	userIDs := make([]string, len(raised))
+	for i, participant := range raised {
+		userIDs[i] = participant.UserID
+	}
+	return userIDs
+}
+
+// publishSession bumps the record's Seq, snapshots it and publishes it once
+// the update commits; delivery itself happens asynchronously in the
+// publisher.
+func (s *voiceStore) publishSession(st voiceState, record *sessionRecord) {
+	record.Seq++
+	topic := sessionTopic(record.TargetKind, record.TargetID)
+	event := voiceSessionEvent{
+		SessionID:    record.ID,
+		TargetKind:   record.TargetKind,
+		TargetID:     record.TargetID,
+		ServerID:     record.ServerID,
+		UpdatedAt:    record.UpdatedAt.UTC().Format(time.RFC3339Nano),
+		Seq:          record.Seq,
+		Locked:       record.Locked,
+		Metadata:     sessionMetadata(record),
+		Participants: participantStates(record, s.clock.Now().UTC()),
+		RaisedHands:  raisedHands(record),
+	}
+
+	st.afterCommit(func() {
+		s.publisher.Publish(topic, "voice.participants.updated", event)
+	})
+
+	if record.creatorChange != nil {
+		changed := *record.creatorChange
+		record.creatorChange = nil
+		st.afterCommit(func() {
+			s.publisher.Publish(topic, "voice.session.creator_changed", changed)
+		})
+	}
+}
+
+func (s *voiceStore) buildSession(record *sessionRecord, userID string) (voiceSession, error) {
+	return s.buildConnectionSession(record, userID, "")
+}
+
+// buildConnectionSession is buildSession answering one of the user's
+// connections, with that connection's token.
+func (s *voiceStore) buildConnectionSession(record *sessionRecord, userID, connectionID string) (voiceSession, error) {
+	participants := participantStates(record, s.clock.Now().UTC())
+
+	// Participants keep the identity and token they joined with. Anyone else
+	// (a viewer, or a user who just left) gets none: a token is what lets a
+	// client into the room, so it only comes from passing Join's gates.
+	var participantToken string
+	if participant, ok := record.Participants[userID]; ok {
+		token, err := s.cachedToken(participant, record, connectionID)
+		if err != nil {
+			return voiceSession{}, err
+		}
+		participantToken = token
 	}
 
 	return voiceSession{
-		ID:               record.ID,
-		TargetKind:       record.TargetKind,
-		TargetID:         record.TargetID,
-		ServerID:         record.ServerID,
-		StartedAt:        record.StartedAt.UTC().Format(time.RFC3339Nano),
-		UpdatedAt:        record.UpdatedAt.UTC().Format(time.RFC3339Nano),
-		ReconnectGraceMs: s.reconnectGrace.Milliseconds(),
+		ID:                 record.ID,
+		TargetKind:         record.TargetKind,
+		TargetID:           record.TargetID,
+		ServerID:           record.ServerID,
+		StartedAt:          record.StartedAt.UTC().Format(time.RFC3339Nano),
+		UpdatedAt:          record.UpdatedAt.UTC().Format(time.RFC3339Nano),
+		Seq:                record.Seq,
+		CreatedBy:          record.CreatedBy,
+		StartReason:        copyStringPtr(record.StartReason),
+		ReconnectGraceMs:   s.sessionReconnectGrace(record).Milliseconds(),
+		ParticipantCount:   len(record.Participants),
+		MaxParticipants:    s.sessionMaxParticipants(),
+		Locked:             record.Locked,
+		Metadata:           sessionMetadata(record),
+		Recording:          record.Recording,
+		RecordingStartedAt: recordingStartedAt(record),
 		Features: voiceFeatureFlags{
 			ScreenShare: s.enableScreenShare,
+			Video:       s.enableVideo,
 		},
 		Participants: participants,
+		RaisedHands:  raisedHands(record),
 		Signaling: voiceSignalingInfo{
-			URL:              s.signalingURL,
+			URL:              s.sessionSignalingURL(record),
 			RoomName:         roomName(record.TargetKind, record.TargetID),
 			ParticipantToken: participantToken,
 		},
 	}, nil
 }
 
-func (s *voiceStore) leaveByKeyLocked(key string, userID string, now time.Time) (*sessionRecord, error) {
-	record, ok := s.sessionsByTarget[key]
+// connectedParticipant looks up userID in the session at key.
+func connectedParticipant(st voiceState, key, userID string) (*sessionRecord, *participantRecord, error) {
+	record, err := st.session(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if record == nil {
+		return nil, nil, errVoiceSessionNotFound
+	}
+
+	participant, ok := record.Participants[userID]
 	if !ok {
-		return nil, errVoiceSessionNotFound
+		return nil, nil, errVoiceNotConnected
 	}
 
-	if _, exists := record.Participants[userID]; !exists {
-		return nil, errVoiceNotConnected
+	return record, participant, nil
+}
+
+func (s *voiceStore) leaveByKey(st voiceState, key string, userID string, now time.Time) (*sessionRecord, error) {
+	record, _, err := connectedParticipant(st, key, userID)
+	if err != nil {
+		return nil, err
 	}
 
 	delete(record.Participants, userID)
-	delete(s.targetByUserID, userID)
+	st.deleteUserTarget(userID)
 	record.UpdatedAt = now
+	st.afterCommit(s.metrics.leaves.Inc)
 
 	if len(record.Participants) == 0 {
-		delete(s.sessionsByTarget, key)
+		st.deleteSession(key)
 	}
+	reassignCreator(record)
 
 	return record, nil
 }
 
-func (s *voiceStore) removeUserFromPriorSessionLocked(userID, keepKey string, now time.Time) {
-	existingKey := s.targetByUserID[userID]
+func (s *voiceStore) removeUserFromPriorSession(st voiceState, userID, keepKey string, now time.Time) (*sessionRecord, error) {
+	existingKey, err := st.userTarget(userID)
+	if err != nil {
+		return nil, err
+	}
 	if existingKey == "" || existingKey == keepKey {
-		return
+		return nil, nil
 	}
 
-	record := s.sessionsByTarget[existingKey]
+	record, err := st.session(existingKey)
+	if err != nil {
+		return nil, err
+	}
 	if record == nil {
-		delete(s.targetByUserID, userID)
-		return
+		st.deleteUserTarget(userID)
+		return nil, nil
 	}
 
 	delete(record.Participants, userID)
 	record.UpdatedAt = now
-	delete(s.targetByUserID, userID)
+	st.deleteUserTarget(userID)
+	st.afterCommit(s.metrics.leaves.Inc)
 
 	if len(record.Participants) == 0 {
-		delete(s.sessionsByTarget, existingKey)
+		st.deleteSession(existingKey)
 	}
-}
-
-func (s *voiceStore) Join(
-	kind voiceTargetKind,
-	targetID,
-	userID string,
-	serverID *string,
-	body joinVoiceRequest,
-) (voiceSession, error) {
-	now := time.Now().UTC()
-	key := targetKey(kind, targetID)
+	reassignCreator(record)
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	s.removeUserFromPriorSessionLocked(userID, key, now)
+	return record, nil
+}
 
-	record, exists := s.sessionsByTarget[key]
-	if !exists {
+// openSession returns record, or a new session started by userID for
+// startReason when there is none.
+func (s *voiceStore) openSession(st voiceState, record *sessionRecord, kind voiceTargetKind, targetID string, serverID *string, userID, startReason string, now time.Time) *sessionRecord {
+	if record == nil {
 		record = &sessionRecord{
 			ID:           "vsn_" + randomSuffix(8),
 			TargetKind:   kind,
@@ -321,404 +954,2338 @@ func (s *voiceStore) Join(
 			StartedAt:    now,
 			UpdatedAt:    now,
 			Participants: map[string]*participantRecord{},
+			CreatedBy:    userID,
+			StartReason:  startReason,
 		}
-		s.sessionsByTarget[key] = record
-	} else {
-		record.ServerID = serverID
-		record.UpdatedAt = now
-	}
-
-	participant, exists := record.Participants[userID]
-	if !exists {
-		participant = &participantRecord{
-			UserID:     userID,
-			Muted:      false,
-			Deafened:   false,
-			Speaking:   false,
-			JoinedAt:   now,
-			LastSeenAt: now,
-		}
-		record.Participants[userID] = participant
-	}
-
-	if body.Muted != nil {
-		participant.Muted = *body.Muted
-	}
-	if body.Deafened != nil {
-		participant.Deafened = *body.Deafened
-	}
-	if body.Speaking != nil {
-		participant.Speaking = *body.Speaking
-	}
-
-	if participant.Deafened {
-		participant.Speaking = false
-	}
-
-	if !s.enableScreenShare {
-		participant.ScreenSharing = false
+		st.saveSession(record)
+		return record
 	}
 
-	participant.LastSeenAt = now
+	record.ServerID = serverID
 	record.UpdatedAt = now
-	s.targetByUserID[userID] = key
-
-	return s.buildSession(record, userID)
+	return record
 }
 
-func (s *voiceStore) Leave(kind voiceTargetKind, targetID, userID string) (voiceSession, error) {
-	now := time.Now().UTC()
+func (s *voiceStore) Join(
+	kind voiceTargetKind,
+	targetID,
+	userID string,
+	serverID *string,
+	canPublish bool,
+	body joinVoiceRequest,
+) (voiceSession, error) {
+	now := s.clock.Now().UTC()
 	key := targetKey(kind, targetID)
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	record, err := s.leaveByKeyLocked(key, userID, now)
+	banned, err := s.backend.banned(key, userID, now)
 	if err != nil {
 		return voiceSession{}, err
 	}
+	if banned {
+		return voiceSession{}, errVoiceBanned
+	}
 
-	return s.buildSession(record, userID)
-}
+	var session voiceSession
+	err = s.backend.update(func(st voiceState) error {
+		record, err := st.session(key)
+		if err != nil {
+			return err
+		}
+		if record != nil && record.Locked {
+			if _, ok := record.Participants[userID]; !ok {
+				return errVoiceSessionLocked
+			}
+		}
 
-func (s *voiceStore) UpdateState(kind voiceTargetKind, targetID, userID string, body updateVoiceStateRequest) (voiceSession, error) {
-	now := time.Now().UTC()
-	key := targetKey(kind, targetID)
+		prior, err := s.removeUserFromPriorSession(st, userID, key, now)
+		if err != nil {
+			return err
+		}
+		if prior != nil {
+			s.publishSession(st, prior)
+		}
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
+		if record == nil {
+			if err := s.checkServerSessions(st, serverID); err != nil {
+				return err
+			}
+		}
+		record = s.openSession(st, record, kind, targetID, serverID, userID, body.StartReason, now)
+		if record.SignalingURL == "" {
+			record.SignalingURL = s.regionSignalingURL(body.Region)
+		}
+		if body.ReconnectGrace > 0 {
+			record.ReconnectGrace = s.clampReconnectGrace(body.ReconnectGrace)
+		}
 
-	record, ok := s.sessionsByTarget[key]
-	if !ok {
-		return voiceSession{}, errVoiceSessionNotFound
-	}
+		mode := joinModeSpeaker
+		if body.Mode == joinModeSpectator {
+			mode = joinModeSpectator
+			canPublish = false
+		}
 
-	participant, ok := record.Participants[userID]
-	if !ok {
-		return voiceSession{}, errVoiceNotConnected
-	}
+		participant, exists := record.Participants[userID]
+		if !exists || participant.joinMode() != mode {
+			if err := s.checkJoinCapacity(record, mode, userID, now); err != nil {
+				return err
+			}
+		}
+		st.afterCommit(func() { s.holds.Release(key, userID) })
 
-	if body.Muted != nil {
-		participant.Muted = *body.Muted
-	}
-	if body.Deafened != nil {
-		participant.Deafened = *body.Deafened
-	}
-	if body.Speaking != nil {
-		participant.Speaking = *body.Speaking
-	}
+		storedMode := mode
+		if mode == joinModeSpeaker {
+			storedMode = ""
+		}
+		if !exists {
+			participant = &participantRecord{
+				UserID:         userID,
+				Muted:          false,
+				Deafened:       false,
+				Speaking:       false,
+				CanPublish:     canPublish,
+				JoinMode:       storedMode,
+				IdentitySuffix: randomSuffix(6),
+				JoinedAt:       now,
+				LastSeenAt:     now,
+				Metadata:       body.ParticipantMetadata,
+			}
+			record.Participants[userID] = participant
+			st.afterCommit(s.metrics.joins.Inc)
+		} else if participant.CanPublish != canPublish || participant.JoinMode != storedMode {
+			// The cached token carries the old grants.
+			participant.CanPublish = canPublish
+			participant.JoinMode = storedMode
+			participant.clearTokens()
+		}
+		if exists && body.ParticipantMetadata != "" && body.ParticipantMetadata != participant.Metadata {
+			participant.Metadata = body.ParticipantMetadata
+			participant.clearTokens()
+		}
+		participant.touchConnection(body.ConnectionID, now)
+		if !participant.CanPublish {
+			participant.markSpeaking(false, now)
+			participant.ScreenSharing = false
+			participant.ScreenShareAudio = false
+			participant.CameraOn = false
+		}
 
-	if participant.Deafened {
-		participant.Speaking = false
-	}
+		s.applyMuteDeafen(participant, body.Muted, body.Deafened)
+		if body.Speaking != nil {
+			participant.markSpeaking(*body.Speaking, now)
+		}
 
-	participant.LastSeenAt = now
-	record.UpdatedAt = now
+		if participant.Deafened {
+			participant.markSpeaking(false, now)
+		}
 
-	return s.buildSession(record, userID)
-}
+		if !s.enableScreenShare {
+			participant.ScreenSharing = false
+			participant.ScreenShareAudio = false
+		}
+		if !s.enableVideo {
+			participant.CameraOn = false
+		}
 
-func (s *voiceStore) UpdateScreenShare(kind voiceTargetKind, targetID, userID string, screenSharing bool) (voiceSession, error) {
-	now := time.Now().UTC()
-	key := targetKey(kind, targetID)
+		participant.LastSeenAt = now
+		record.UpdatedAt = now
+		st.setUserTarget(userID, key)
+		s.publishSession(st, record)
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
+		session, err = s.buildConnectionSession(record, userID, body.ConnectionID)
+		return err
+	})
 
-	record, ok := s.sessionsByTarget[key]
-	if !ok {
-		return voiceSession{}, errVoiceSessionNotFound
+	return session, err
+}
+
+// checkServerSessions reports whether serverID may start another session.
+// It runs after the joiner has left any prior session, so moving between a
+// server's channels never counts the session being left.
+func (s *voiceStore) checkServerSessions(st voiceState, serverID *string) error {
+	if s.maxServerSessions <= 0 || serverID == nil {
+		return nil
 	}
 
-	participant, ok := record.Participants[userID]
-	if !ok {
-		return voiceSession{}, errVoiceNotConnected
+	records, err := st.sessions()
+	if err != nil {
+		return err
 	}
 
-	if !s.enableScreenShare {
-		participant.ScreenSharing = false
-	} else {
-		participant.ScreenSharing = screenSharing
+	count := 0
+	for _, record := range records {
+		if record.ServerID != nil && *record.ServerID == *serverID {
+			count++
+		}
+	}
+	if count >= s.maxServerSessions {
+		return errVoiceServerFull
 	}
 
-	participant.LastSeenAt = now
-	record.UpdatedAt = now
+	return nil
+}
+
+// checkJoinCapacity reports whether userID fits the session as one more
+// participant in mode. Speakers and spectators are counted against separate
+// caps, and other users' preflight holds take up slots too.
+func (s *voiceStore) checkJoinCapacity(record *sessionRecord, mode voiceJoinMode, userID string, now time.Time) error {
+	limit := s.maxSpeakers
+	if mode == joinModeSpectator {
+		limit = s.maxSpectators
+	}
+	if limit <= 0 {
+		return nil
+	}
+
+	count := s.holds.Count(record, mode, userID, now)
+	for _, participant := range record.Participants {
+		if participant.joinMode() == mode {
+			count++
+		}
+	}
+	if count >= limit {
+		return errVoiceSessionFull
+	}
+
+	return nil
+}
+
+// Leave takes the user out of the session along with all their
+// connections.
+func (s *voiceStore) Leave(kind voiceTargetKind, targetID, userID string) (voiceSession, error) {
+	return s.LeaveConnection(kind, targetID, userID, "")
+}
+
+func (s *voiceStore) UpdateState(kind voiceTargetKind, targetID, userID string, body updateVoiceStateRequest) (voiceSession, error) {
+	now := s.clock.Now().UTC()
+	key := targetKey(kind, targetID)
+
+	var session voiceSession
+	err := s.backend.update(func(st voiceState) error {
+		record, participant, err := connectedParticipant(st, key, userID)
+		if err != nil {
+			return err
+		}
+
+		if err := s.applyVoiceState(participant, body, now); err != nil {
+			return err
+		}
+
+		participant.LastSeenAt = now
+		record.UpdatedAt = now
+		s.publishSession(st, record)
+
+		session, err = s.buildSession(record, userID)
+		return err
+	})
+
+	return session, err
+}
+
+func (s *voiceStore) UpdateScreenShare(kind voiceTargetKind, targetID, userID string, screenSharing bool, shareAudio *bool) (voiceSession, error) {
+	now := s.clock.Now().UTC()
+	key := targetKey(kind, targetID)
+
+	var session voiceSession
+	err := s.backend.update(func(st voiceState) error {
+		record, participant, err := connectedParticipant(st, key, userID)
+		if err != nil {
+			return err
+		}
+
+		if err := s.checkScreenShareCapacity(record, participant, screenSharing); err != nil {
+			return err
+		}
+		s.applyScreenShare(participant, screenSharing, shareAudio)
+
+		participant.LastSeenAt = now
+		record.UpdatedAt = now
+		s.publishSession(st, record)
+
+		session, err = s.buildSession(record, userID)
+		return err
+	})
+
+	return session, err
+}
+
+// UpdateCamera turns the user's webcam on or off. Video is independent of
+// screen sharing; a participant may have both.
+func (s *voiceStore) UpdateCamera(kind voiceTargetKind, targetID, userID string, cameraOn bool) (voiceSession, error) {
+	now := s.clock.Now().UTC()
+	key := targetKey(kind, targetID)
+
+	var session voiceSession
+	err := s.backend.update(func(st voiceState) error {
+		record, participant, err := connectedParticipant(st, key, userID)
+		if err != nil {
+			return err
+		}
+
+		s.applyCamera(participant, cameraOn)
+
+		participant.LastSeenAt = now
+		record.UpdatedAt = now
+		s.publishSession(st, record)
+
+		session, err = s.buildSession(record, userID)
+		return err
+	})
+
+	return session, err
+}
+
+// RaiseHand puts the user in (or takes them out of) the session's speaking
+// queue. Raising an already raised hand keeps its place in the queue.
+func (s *voiceStore) RaiseHand(kind voiceTargetKind, targetID, userID string, raised bool) (voiceSession, error) {
+	now := s.clock.Now().UTC()
+	key := targetKey(kind, targetID)
+
+	var session voiceSession
+	err := s.backend.update(func(st voiceState) error {
+		record, participant, err := connectedParticipant(st, key, userID)
+		if err != nil {
+			return err
+		}
+
+		if raised && !participant.HandRaised {
+			raisedAt := now
+			participant.HandRaisedAt = &raisedAt
+		}
+		if !raised {
+			participant.HandRaisedAt = nil
+		}
+		participant.HandRaised = raised
 
-	return s.buildSession(record, userID)
+		participant.LastSeenAt = now
+		record.UpdatedAt = now
+		s.publishSession(st, record)
+
+		session, err = s.buildSession(record, userID)
+		return err
+	})
+
+	return session, err
 }
 
 func (s *voiceStore) Heartbeat(kind voiceTargetKind, targetID, userID string, body heartbeatRequest) (voiceSession, error) {
-	now := time.Now().UTC()
+	now := s.clock.Now().UTC()
 	key := targetKey(kind, targetID)
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	var session voiceSession
+	err := s.backend.update(func(st voiceState) error {
+		record, participant, err := connectedParticipant(st, key, userID)
+		if err != nil {
+			return err
+		}
 
-	record, ok := s.sessionsByTarget[key]
-	if !ok {
-		return voiceSession{}, errVoiceSessionNotFound
-	}
+		wasSpeaking, wasMuted := participant.Speaking, participant.Muted
+		if body.Speaking != nil {
+			participant.markSpeaking(*body.Speaking, now)
+			if participant.Deafened {
+				participant.markSpeaking(false, now)
+			}
+		}
+		s.applyMuteDeafen(participant, nil, nil)
 
-	participant, ok := record.Participants[userID]
-	if !ok {
-		return voiceSession{}, errVoiceNotConnected
+		participant.LastSeenAt = now
+		if connection, ok := participant.Connections[body.ConnectionID]; ok {
+			connection.LastSeenAt = now
+		}
+		record.UpdatedAt = now
+
+		// Plain keep-alives only move LastSeenAt; publishing them would flood
+		// subscribers at the heartbeat rate.
+		if participant.Speaking != wasSpeaking || participant.Muted != wasMuted {
+			s.publishSession(st, record)
+		}
+
+		session, err = s.buildConnectionSession(record, userID, body.ConnectionID)
+		return err
+	})
+
+	return session, err
+}
+
+// applyVoiceState applies a mute, deafen and speaking update to the
+// participant. A user muted by a moderator can't unmute themselves.
+func (s *voiceStore) applyVoiceState(participant *participantRecord, body updateVoiceStateRequest, now time.Time) error {
+	if body.Muted != nil && !*body.Muted && participant.MutedByModerator {
+		return errVoiceModeratorMuted
 	}
 
+	s.applyMuteDeafen(participant, body.Muted, body.Deafened)
 	if body.Speaking != nil {
-		participant.Speaking = *body.Speaking
-		if participant.Deafened {
-			participant.Speaking = false
+		participant.markSpeaking(*body.Speaking, now)
+	}
+
+	if participant.Deafened {
+		participant.markSpeaking(false, now)
+	}
+	return nil
+}
+
+// checkScreenShareCapacity reports whether the participant may turn on
+// screenSharing. Only a new share counts against the cap: anyone already
+// sharing can keep sharing and change their audio, even when the cap was
+// lowered below the current count.
+func (s *voiceStore) checkScreenShareCapacity(record *sessionRecord, participant *participantRecord, screenSharing bool) error {
+	if s.maxScreenShares <= 0 || !s.enableScreenShare || !participant.CanPublish || !screenSharing || participant.ScreenSharing {
+		return nil
+	}
+
+	count := 0
+	for _, other := range record.Participants {
+		if other.ScreenSharing {
+			count++
 		}
 	}
+	if count >= s.maxScreenShares {
+		return errVoiceScreenShareFull
+	}
 
-	participant.LastSeenAt = now
-	record.UpdatedAt = now
+	return nil
+}
 
-	return s.buildSession(record, userID)
+// applyScreenShare starts or stops the participant's screen share, which
+// stays off while screen sharing is disabled and for participants who can't
+// publish.
+func (s *voiceStore) applyScreenShare(participant *participantRecord, screenSharing bool, shareAudio *bool) {
+	participant.ScreenSharing = s.enableScreenShare && participant.CanPublish && screenSharing
+
+	// System audio rides along with the screen share: it keeps its
+	// setting while sharing continues and is cleared when it stops.
+	if shareAudio != nil {
+		participant.ScreenShareAudio = *shareAudio
+	}
+	if !participant.ScreenSharing {
+		participant.ScreenShareAudio = false
+	}
 }
 
-func (s *voiceStore) Get(kind voiceTargetKind, targetID, userID string) (*voiceSession, error) {
+// applyCamera turns the participant's webcam on or off. Like a screen share it
+// stays off while video is disabled and for participants who can't publish.
+func (s *voiceStore) applyCamera(participant *participantRecord, cameraOn bool) {
+	participant.CameraOn = s.enableVideo && participant.CanPublish && cameraOn
+}
+
+// applyMuteDeafen applies a client's mute and deafen choices; nil leaves a
+// setting as it is. When deafening implies mute, a deafened participant is
+// held muted and the mute they asked for waits in MutedBeforeDeafen until
+// they undeafen.
+func (s *voiceStore) applyMuteDeafen(participant *participantRecord, muted, deafened *bool) {
+	if !s.deafenImpliesMute {
+		if muted != nil {
+			participant.Muted = *muted || participant.MutedByModerator
+		}
+		if deafened != nil {
+			participant.Deafened = *deafened
+		}
+		return
+	}
+
+	intent := participant.Muted
+	if participant.Deafened {
+		intent = participant.MutedBeforeDeafen
+	}
+	if muted != nil {
+		intent = *muted
+	}
+	if deafened != nil {
+		participant.Deafened = *deafened
+	}
+
+	if participant.Deafened {
+		participant.MutedBeforeDeafen = intent
+		participant.Muted = true
+		return
+	}
+	participant.MutedBeforeDeafen = false
+	participant.Muted = intent || participant.MutedByModerator
+}
+
+// ModeratorMute mutes (or releases) another participant on a moderator's
+// behalf. While held, the participant cannot unmute themselves.
+func (s *voiceStore) ModeratorMute(kind voiceTargetKind, targetID, moderatorID, userID string, muted bool) (voiceSession, error) {
+	if moderatorID == userID {
+		return voiceSession{}, errVoiceSelfModeration
+	}
+
+	now := s.clock.Now().UTC()
 	key := targetKey(kind, targetID)
 
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	var session voiceSession
+	err := s.backend.update(func(st voiceState) error {
+		record, participant, err := connectedParticipant(st, key, userID)
+		if err != nil {
+			return err
+		}
 
-	record := s.sessionsByTarget[key]
-	if record == nil {
-		return nil, nil
+		participant.MutedByModerator = muted
+		if muted {
+			participant.Muted = true
+			participant.markSpeaking(false, now)
+		}
+
+		record.UpdatedAt = now
+		s.publishSession(st, record)
+
+		session, err = s.buildSession(record, moderatorID)
+		return err
+	})
+
+	return session, err
+}
+
+// SetPrioritySpeaker makes userID the session's priority speaker, or clears
+// the role. There is at most one per session, so choosing a new one demotes
+// the previous holder. Tokens are re-signed for everyone whose role changed
+// so the metadata the SFU sees stays current.
+func (s *voiceStore) SetPrioritySpeaker(kind voiceTargetKind, targetID, moderatorID, userID string, prioritySpeaker bool) (voiceSession, error) {
+	now := s.clock.Now().UTC()
+	key := targetKey(kind, targetID)
+
+	var session voiceSession
+	err := s.backend.update(func(st voiceState) error {
+		record, participant, err := connectedParticipant(st, key, userID)
+		if err != nil {
+			return err
+		}
+
+		if prioritySpeaker {
+			for _, other := range record.Participants {
+				if other.UserID == userID || !other.PrioritySpeaker {
+					continue
+				}
+				other.PrioritySpeaker = false
+				other.clearTokens()
+				if _, err := s.resignToken(other, record, ""); err != nil {
+					return err
+				}
+			}
+		}
+
+		if participant.PrioritySpeaker != prioritySpeaker {
+			participant.PrioritySpeaker = prioritySpeaker
+			participant.clearTokens()
+			if _, err := s.resignToken(participant, record, ""); err != nil {
+				return err
+			}
+		}
+
+		record.UpdatedAt = now
+		s.publishSession(st, record)
+
+		session, err = s.buildSession(record, moderatorID)
+		return err
+	})
+
+	return session, err
+}
+
+// SetLocked locks or unlocks a session on a moderator's behalf. Locking only
+// gates Join for users who are not already in the room.
+func (s *voiceStore) SetLocked(kind voiceTargetKind, targetID, moderatorID string, locked bool) (voiceSession, error) {
+	now := s.clock.Now().UTC()
+	key := targetKey(kind, targetID)
+
+	var session voiceSession
+	err := s.backend.update(func(st voiceState) error {
+		record, err := st.session(key)
+		if err != nil {
+			return err
+		}
+		if record == nil {
+			return errVoiceSessionNotFound
+		}
+
+		record.Locked = locked
+		record.UpdatedAt = now
+		s.publishSession(st, record)
+
+		session, err = s.buildSession(record, moderatorID)
+		return err
+	})
+
+	return session, err
+}
+
+// SetMetadata replaces a session's metadata on a moderator's behalf; an
+// empty map clears it. Cached participant tokens are dropped so every token
+// issued from now on carries the new room metadata.
+func (s *voiceStore) SetMetadata(kind voiceTargetKind, targetID, moderatorID string, metadata map[string]string) (voiceSession, error) {
+	if err := validateSessionMetadata(metadata); err != nil {
+		return voiceSession{}, err
+	}
+
+	now := s.clock.Now().UTC()
+	key := targetKey(kind, targetID)
+
+	var session voiceSession
+	err := s.backend.update(func(st voiceState) error {
+		record, err := st.session(key)
+		if err != nil {
+			return err
+		}
+		if record == nil {
+			return errVoiceSessionNotFound
+		}
+
+		record.Metadata = nil
+		if len(metadata) > 0 {
+			record.Metadata = make(map[string]string, len(metadata))
+			for metadataKey, value := range metadata {
+				record.Metadata[metadataKey] = value
+			}
+		}
+		for _, participant := range record.Participants {
+			participant.clearTokens()
+		}
+		record.UpdatedAt = now
+		s.publishSession(st, record)
+
+		session, err = s.buildSession(record, moderatorID)
+		return err
+	})
+
+	return session, err
+}
+
+// Kick removes another participant on a moderator's behalf. The kicked
+// client learns about it from the voice.participant.kicked event and should
+// tear down its LiveKit connection.
+func (s *voiceStore) Kick(kind voiceTargetKind, targetID, moderatorID, userID string) (voiceSession, error) {
+	if moderatorID == userID {
+		return voiceSession{}, errVoiceSelfModeration
+	}
+
+	now := s.clock.Now().UTC()
+	key := targetKey(kind, targetID)
+
+	var session voiceSession
+	err := s.backend.update(func(st voiceState) error {
+		record, err := s.kickByKey(st, key, moderatorID, userID, false, now)
+		if err != nil {
+			return err
+		}
+
+		session, err = s.buildSession(record, moderatorID)
+		return err
+	})
+
+	return session, err
+}
+
+// Move takes a participant out of their session and puts them into another
+// one on a moderator's behalf, in a single update. Mute, deafen, publish
+// permission and join mode carry over, as does the LiveKit identity; per-room
+// state such as a raised hand or screen share does not. The moved client
+// learns where to reconnect from the voice.participant.moved event.
+func (s *voiceStore) Move(kind voiceTargetKind, targetID, moderatorID, userID string, toKind voiceTargetKind, toTargetID string) (voiceSession, error) {
+	now := s.clock.Now().UTC()
+	key := targetKey(kind, targetID)
+	toKey := targetKey(toKind, toTargetID)
+
+	banned, err := s.backend.banned(toKey, userID, now)
+	if err != nil {
+		return voiceSession{}, err
+	}
+	if banned {
+		return voiceSession{}, errVoiceBanned
 	}
 
-	session, err := s.buildSession(record, userID)
+	var session voiceSession
+	err = s.backend.update(func(st voiceState) error {
+		record, participant, err := connectedParticipant(st, key, userID)
+		if err != nil {
+			return err
+		}
+		if key == toKey {
+			session, err = s.buildSession(record, moderatorID)
+			return err
+		}
+
+		if _, err := s.leaveByKey(st, key, userID, now); err != nil {
+			return err
+		}
+
+		// The destination gets the same gates as a join. A new one is taken
+		// to be in the source's server, since moves stay within a server.
+		destination, err := st.session(toKey)
+		if err != nil {
+			return err
+		}
+		serverID := record.ServerID
+		if destination == nil {
+			if err := s.checkServerSessions(st, serverID); err != nil {
+				return err
+			}
+		} else {
+			if destination.Locked {
+				return errVoiceSessionLocked
+			}
+			serverID = destination.ServerID
+		}
+		destination = s.openSession(st, destination, toKind, toTargetID, serverID, userID, "", now)
+		if err := s.checkJoinCapacity(destination, participant.joinMode(), userID, now); err != nil {
+			return err
+		}
+		if destination.SignalingURL == "" {
+			destination.SignalingURL = record.SignalingURL
+		}
+		destination.Participants[userID] = &participantRecord{
+			UserID:            userID,
+			Muted:             participant.Muted,
+			MutedByModerator:  participant.MutedByModerator,
+			MutedBeforeDeafen: participant.MutedBeforeDeafen,
+			Deafened:          participant.Deafened,
+			CanPublish:        participant.CanPublish,
+			JoinMode:          participant.JoinMode,
+			IdentitySuffix:    participant.IdentitySuffix,
+			Metadata:          participant.Metadata,
+			Connections:       movedConnections(participant, now),
+			JoinedAt:          now,
+			LastSeenAt:        now,
+		}
+		st.setUserTarget(userID, toKey)
+		st.afterCommit(s.metrics.joins.Inc)
+
+		moved := voiceMoveEvent{
+			SessionID:    record.ID,
+			TargetKind:   record.TargetKind,
+			TargetID:     record.TargetID,
+			UserID:       userID,
+			MovedBy:      moderatorID,
+			ToSessionID:  destination.ID,
+			ToTargetKind: toKind,
+			ToTargetID:   toTargetID,
+		}
+		topic := sessionTopic(kind, targetID)
+		st.afterCommit(func() {
+			s.publisher.Publish(topic, "voice.participant.moved", moved)
+		})
+		s.publishSession(st, record)
+		s.publishSession(st, destination)
+
+		session, err = s.buildSession(record, moderatorID)
+		return err
+	})
+
+	return session, err
+}
+
+// kickByKey removes userID from the session at key and tells the room, and
+// the kicked client, why.
+func (s *voiceStore) kickByKey(st voiceState, key, moderatorID, userID string, banned bool, now time.Time) (*sessionRecord, error) {
+	record, err := s.leaveByKey(st, key, userID, now)
 	if err != nil {
 		return nil, err
 	}
 
-	return &session, nil
+	kicked := voiceKickEvent{
+		SessionID:  record.ID,
+		TargetKind: record.TargetKind,
+		TargetID:   record.TargetID,
+		UserID:     userID,
+		KickedBy:   moderatorID,
+		Banned:     banned,
+	}
+	topic := sessionTopic(record.TargetKind, record.TargetID)
+	st.afterCommit(func() {
+		s.publisher.Publish(topic, "voice.participant.kicked", kicked)
+	})
+	s.publishSession(st, record)
+
+	return record, nil
+}
+
+// Ban keeps a user out of a session for banDuration, kicking them first if
+// they are in it. The ban outlives the session, so it still applies if the
+// room empties and is started again. The session is nil when there is none.
+func (s *voiceStore) Ban(kind voiceTargetKind, targetID, moderatorID, userID string) (*voiceSession, error) {
+	if moderatorID == userID {
+		return nil, errVoiceSelfModeration
+	}
+
+	now := s.clock.Now().UTC()
+	key := targetKey(kind, targetID)
+
+	if err := s.backend.ban(key, userID, now.Add(s.banDuration), now); err != nil {
+		return nil, err
+	}
+
+	var session *voiceSession
+	err := s.backend.update(func(st voiceState) error {
+		record, err := st.session(key)
+		if err != nil || record == nil {
+			return err
+		}
+
+		if _, ok := record.Participants[userID]; ok {
+			if record, err = s.kickByKey(st, key, moderatorID, userID, true, now); err != nil {
+				return err
+			}
+		}
+
+		built, err := s.buildSession(record, moderatorID)
+		if err != nil {
+			return err
+		}
+		session = &built
+		return nil
+	})
+
+	return session, err
 }
 
-func (s *voiceStore) CleanupExpired() {
-	now := time.Now().UTC()
+// RefreshToken issues a fresh participant token for a connected user
+// without touching session state. The identity suffix from join is reused
+// so LiveKit treats the new token as the same participant. A non-empty
+// connectionID refreshes that connection's token instead.
+func (s *voiceStore) RefreshToken(kind voiceTargetKind, targetID, userID, connectionID string) (voiceSignalingInfo, error) {
+	key := targetKey(kind, targetID)
+
+	var info voiceSignalingInfo
+	err := s.backend.update(func(st voiceState) error {
+		record, err := st.session(key)
+		if err != nil {
+			return err
+		}
+		if record == nil {
+			return errVoiceNotConnected
+		}
+
+		participant, ok := record.Participants[userID]
+		if !ok {
+			return errVoiceNotConnected
+		}
+		if _, ok := participant.Connections[connectionID]; connectionID != "" && !ok {
+			return errVoiceNotConnected
+		}
+
+		participantToken, err := s.resignToken(participant, record, connectionID)
+		if err != nil {
+			return err
+		}
+
+		info = voiceSignalingInfo{
+			URL:              s.sessionSignalingURL(record),
+			RoomName:         roomName(kind, targetID),
+			ParticipantToken: participantToken,
+		}
+		return nil
+	})
+
+	return info, err
+}
+
+func (s *voiceStore) Get(kind voiceTargetKind, targetID, userID string) (*voiceSession, error) {
+	key := targetKey(kind, targetID)
+
+	// buildSession may refresh the caller's cached token, so this is an
+	// update rather than a view.
+	var session *voiceSession
+	err := s.backend.update(func(st voiceState) error {
+		record, err := st.session(key)
+		if err != nil || record == nil {
+			return err
+		}
+
+		built, err := s.buildSession(record, userID)
+		if err != nil {
+			return err
+		}
+
+		session = &built
+		return nil
+	})
+
+	return session, err
+}
+
+// Speaking returns the sorted ids of the session's participants who are
+// speaking. Unlike Get it is a view, as no token is involved.
+func (s *voiceStore) Speaking(kind voiceTargetKind, targetID string) ([]string, error) {
+	key := targetKey(kind, targetID)
+
+	speaking := make([]string, 0)
+	err := s.backend.view(func(st voiceState) error {
+		record, err := st.session(key)
+		if err != nil {
+			return err
+		}
+		if record == nil {
+			return errVoiceSessionNotFound
+		}
+
+		for userID, participant := range record.Participants {
+			if participant.Speaking {
+				speaking = append(speaking, userID)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(speaking)
+	return speaking, nil
+}
+
+// ParticipantLeft removes a participant LiveKit reports as disconnected. The
+// identity must match the one the participant currently holds, so a late
+// event from an earlier connection cannot evict a user who has rejoined. An
+// identity belonging to one of several connections only removes that one.
+func (s *voiceStore) ParticipantLeft(kind voiceTargetKind, targetID, identity string) (bool, error) {
+	now := s.clock.Now().UTC()
+	key := targetKey(kind, targetID)
+
+	handled := false
+	err := s.backend.update(func(st voiceState) error {
+		handled = false
+		record, err := st.session(key)
+		if err != nil || record == nil {
+			return err
+		}
+
+		for userID, participant := range record.Participants {
+			connectionID, ok := participantConnectionID(participant, identity)
+			if !ok {
+				continue
+			}
+
+			delete(participant.Connections, connectionID)
+			if connectionID != "" && len(participant.Connections) > 0 {
+				record.UpdatedAt = now
+			} else if record, err = s.leaveByKey(st, key, userID, now); err != nil {
+				return err
+			}
+
+			s.publishSession(st, record)
+			handled = true
+			return nil
+		}
+
+		return nil
+	})
+
+	return handled, err
+}
+
+// endSession removes a whole session, publishing it with no participants.
+func (s *voiceStore) endSession(st voiceState, key string, record *sessionRecord, now time.Time) error {
+	for userID := range record.Participants {
+		target, err := st.userTarget(userID)
+		if err != nil {
+			return err
+		}
+		if target == key {
+			st.deleteUserTarget(userID)
+		}
+	}
+
+	st.deleteSession(key)
+	removed := float64(len(record.Participants))
+	st.afterCommit(func() { s.metrics.leaves.Add(removed) })
+	record.Participants = map[string]*participantRecord{}
+	record.UpdatedAt = now
+	s.publishSession(st, record)
+	return nil
+}
+
+// EndSession ends a session for everyone on a moderator's behalf. Ending a
+// session that does not exist is a no-op, so retries are safe. Clients learn
+// about it from voice.session.ended and should leave the LiveKit room.
+func (s *voiceStore) EndSession(kind voiceTargetKind, targetID, moderatorID string) error {
+	now := s.clock.Now().UTC()
+	key := targetKey(kind, targetID)
+
+	var recordingID string
+	err := s.backend.update(func(st voiceState) error {
+		recordingID = ""
+		record, err := st.session(key)
+		if err != nil || record == nil {
+			return err
+		}
+
+		participants := make([]string, 0, len(record.Participants))
+		for userID := range record.Participants {
+			participants = append(participants, userID)
+		}
+		sort.Strings(participants)
+
+		if record.Recording {
+			recordingID = record.RecordingID
+		}
+		if err := s.endSession(st, key, record, now); err != nil {
+			return err
+		}
+
+		topic := sessionTopic(kind, targetID)
+		ended := voiceEndEvent{
+			SessionID:    record.ID,
+			TargetKind:   kind,
+			TargetID:     targetID,
+			EndedBy:      moderatorID,
+			Participants: participants,
+		}
+		st.afterCommit(func() {
+			s.publisher.Publish(topic, "voice.session.ended", ended)
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if recordingID != "" {
+		s.stopRecorder(recordingID)
+	}
+	return nil
+}
+
+// RoomFinished drops the session backing a LiveKit room that has closed.
+func (s *voiceStore) RoomFinished(kind voiceTargetKind, targetID string) (bool, error) {
+	now := s.clock.Now().UTC()
+	key := targetKey(kind, targetID)
+
+	handled := false
+	err := s.backend.update(func(st voiceState) error {
+		handled = false
+		record, err := st.session(key)
+		if err != nil || record == nil {
+			return err
+		}
+
+		if err := s.endSession(st, key, record, now); err != nil {
+			return err
+		}
+		handled = true
+		return nil
+	})
+
+	return handled, err
+}
+
+// sessionCursor is a position in the server-sessions listing: the last
+// session returned, in StartedAt then ID order.
+type sessionCursor struct {
+	StartedAt time.Time
+	ID        string
+}
+
+func (c sessionCursor) encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(c.StartedAt.UnixNano(), 10) + ":" + c.ID))
+}
+
+func decodeSessionCursor(raw string) (sessionCursor, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return sessionCursor{}, errInvalidSessionCursor
+	}
+	startedAt, id, ok := strings.Cut(string(decoded), ":")
+	if !ok || id == "" {
+		return sessionCursor{}, errInvalidSessionCursor
+	}
+	nanos, err := strconv.ParseInt(startedAt, 10, 64)
+	if err != nil {
+		return sessionCursor{}, errInvalidSessionCursor
+	}
+
+	return sessionCursor{StartedAt: time.Unix(0, nanos).UTC(), ID: id}, nil
+}
+
+func (c sessionCursor) before(record *sessionRecord) bool {
+	if !c.StartedAt.Equal(record.StartedAt) {
+		return c.StartedAt.Before(record.StartedAt)
+	}
+	return c.ID < record.ID
+}
+
+// ListServerSessions returns up to limit of a server's sessions, oldest
+// first, starting after cursor (empty for the first page). The returned
+// cursor is empty once there is nothing more to read.
+func (s *voiceStore) ListServerSessions(serverID string, includeParticipants bool, limit int, cursor string) ([]voiceSessionSummary, string, error) {
+	after := sessionCursor{}
+	if cursor != "" {
+		decoded, err := decodeSessionCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		after = decoded
+	}
+	if limit <= 0 || limit > maxSessionsPageLimit {
+		limit = maxSessionsPageLimit
+	}
+
+	now := s.clock.Now().UTC()
+	summaries := make([]voiceSessionSummary, 0)
+	var nextCursor string
+	err := s.backend.view(func(st voiceState) error {
+		records, err := st.sessions()
+		if err != nil {
+			return err
+		}
+
+		matching := make([]*sessionRecord, 0)
+		for _, record := range records {
+			if record.ServerID == nil || *record.ServerID != serverID {
+				continue
+			}
+			if cursor != "" && !after.before(record) {
+				continue
+			}
+			matching = append(matching, record)
+		}
+
+		sort.Slice(matching, func(i, j int) bool {
+			if !matching[i].StartedAt.Equal(matching[j].StartedAt) {
+				return matching[i].StartedAt.Before(matching[j].StartedAt)
+			}
+			return matching[i].ID < matching[j].ID
+		})
+		if len(matching) > limit {
+			matching = matching[:limit]
+			last := matching[limit-1]
+			nextCursor = sessionCursor{StartedAt: last.StartedAt, ID: last.ID}.encode()
+		}
+
+		for _, record := range matching {
+			summary := voiceSessionSummary{
+				ID:               record.ID,
+				TargetKind:       record.TargetKind,
+				TargetID:         record.TargetID,
+				ParticipantCount: len(record.Participants),
+				StartedAt:        record.StartedAt.UTC().Format(time.RFC3339Nano),
+			}
+			if includeParticipants {
+				summary.Participants = participantStates(record, now)
+			}
+			summaries = append(summaries, summary)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return summaries, nextCursor, nil
+}
+
+// ClearStaleSpeaking drops speaking flags that have not been re-reported
+// within speakingTimeout. It runs far more often than CleanupExpired since a
+// stuck speaking indicator is visible long before the reconnect grace ends.
+func (s *voiceStore) ClearStaleSpeaking() error {
+	now := s.clock.Now().UTC()
+
+	return s.backend.update(func(st voiceState) error {
+		records, err := st.sessions()
+		if err != nil {
+			return err
+		}
+
+		for _, listed := range records {
+			stale := false
+			for _, participant := range listed.Participants {
+				stale = stale || s.speakingStale(participant, now)
+			}
+			if !stale {
+				continue
+			}
+			// The listed copy may be out of date, so the flags are checked
+			// again on the record that is changed.
+			record, err := st.session(targetKey(listed.TargetKind, listed.TargetID))
+			if err != nil {
+				return err
+			}
+			if record == nil {
+				continue
+			}
+
+			cleared := false
+			for _, participant := range record.Participants {
+				if s.speakingStale(participant, now) {
+					// The client stopped reporting, so count only up to its
+					// last report.
+					participant.markSpeaking(false, participant.LastSpokeAt)
+					cleared = true
+				}
+			}
+
+			if cleared {
+				record.UpdatedAt = now
+				s.publishSession(st, record)
+			}
+		}
+		return nil
+	})
+}
+
+// speakingStale reports whether participant still shows as speaking past
+// speakingTimeout.
+func (s *voiceStore) speakingStale(participant *participantRecord, now time.Time) bool {
+	return participant.Speaking && now.Sub(participant.LastSpokeAt) > s.speakingTimeout
+}
+
+// regionSignalingURL maps a client region to its SFU, falling back to the
+// default URL for regions without one.
+func (s *voiceStore) regionSignalingURL(region string) string {
+	if wsURL, ok := s.regionURLs[strings.ToLower(strings.TrimSpace(region))]; ok {
+		return wsURL
+	}
+	return s.signalingURL
+}
+
+func (s *voiceStore) sessionSignalingURL(record *sessionRecord) string {
+	if record.SignalingURL != "" {
+		return record.SignalingURL
+	}
+	return s.signalingURL
+}
+
+// sessionReconnectGrace is how long a participant of record may go without a
+// heartbeat before cleanup removes them.
+func (s *voiceStore) sessionReconnectGrace(record *sessionRecord) time.Duration {
+	if record.ReconnectGrace > 0 {
+		return record.ReconnectGrace
+	}
+	return s.reconnectGrace
+}
+
+// sessionMaxParticipants is the cap clients show next to participantCount,
+// or nil when there is none. Spectators have their own cap and are left out.
+func (s *voiceStore) sessionMaxParticipants() *int {
+	limit := s.maxSpeakers
+	if limit <= 0 {
+		return nil
+	}
+	return &limit
+}
+
+// clampReconnectGrace keeps a requested grace within the configured bounds.
+func (s *voiceStore) clampReconnectGrace(grace time.Duration) time.Duration {
+	return min(max(grace, s.minReconnectGrace), s.maxReconnectGrace)
+}
+
+// idle reports whether a connected participant has sat muted and silent for
+// longer than the idle timeout. Anyone speaking or unmuted is never idle, and
+// nobody is while VOICE_SIGNALING_IDLE_TIMEOUT_MS leaves the timeout off.
+func (s *voiceStore) idle(participant *participantRecord, now time.Time) bool {
+	if s.idleTimeout <= 0 || !participant.Muted || participant.Speaking {
+		return false
+	}
+
+	lastActive := participant.JoinedAt
+	if participant.LastSpokeAt.After(lastActive) {
+		lastActive = participant.LastSpokeAt
+	}
+	return now.Sub(lastActive) > s.idleTimeout
+}
+
+// cleanupDue reports whether CleanupExpired has anything to remove from
+// record, without changing it.
+func (s *voiceStore) cleanupDue(record *sessionRecord, now time.Time) bool {
+	if len(record.Participants) == 0 {
+		return true
+	}
+
+	grace := s.sessionReconnectGrace(record)
+	for _, participant := range record.Participants {
+		if now.Sub(participant.LastSeenAt) > grace || s.idle(participant, now) {
+			return true
+		}
+		for _, connection := range participant.Connections {
+			if now.Sub(connection.LastSeenAt) > grace {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *voiceStore) CleanupExpired() error {
+	now := s.clock.Now().UTC()
+
+	return s.backend.update(func(st voiceState) error {
+		records, err := st.sessions()
+		if err != nil {
+			return err
+		}
+
+		for _, listed := range records {
+			if !s.cleanupDue(listed, now) {
+				continue
+			}
+			key := targetKey(listed.TargetKind, listed.TargetID)
+			record, err := st.session(key)
+			if err != nil {
+				return err
+			}
+			if record == nil {
+				continue
+			}
+
+			grace := s.sessionReconnectGrace(record)
+			removed := false
+			for userID, participant := range record.Participants {
+				pruned, gone := participant.pruneConnections(now, grace)
+				removed = removed || pruned
+				expired := gone || now.Sub(participant.LastSeenAt) > grace
+				if !expired && !s.idle(participant, now) {
+					continue
+				}
+
+				delete(record.Participants, userID)
+				target, err := st.userTarget(userID)
+				if err != nil {
+					return err
+				}
+				if target == key {
+					st.deleteUserTarget(userID)
+				}
+				if expired {
+					st.afterCommit(s.metrics.cleanupRemoved.Inc)
+				} else {
+					st.afterCommit(s.metrics.leaves.Inc)
+				}
+				removed = true
+			}
+
+			if len(record.Participants) == 0 {
+				st.deleteSession(key)
+				s.publishSession(st, record)
+				continue
+			}
+
+			if removed {
+				record.UpdatedAt = now
+				reassignCreator(record)
+				s.publishSession(st, record)
+			}
+		}
+		return nil
+	})
+}
+
+// ReleaseAll ends every session. It runs on shutdown when sessions live only
+// in this process, so clients stop showing rooms nobody tracks anymore.
+func (s *voiceStore) ReleaseAll() error {
+	now := s.clock.Now().UTC()
+
+	return s.backend.update(func(st voiceState) error {
+		records, err := st.sessions()
+		if err != nil {
+			return err
+		}
+
+		for _, listed := range records {
+			key := targetKey(listed.TargetKind, listed.TargetID)
+			record, err := st.session(key)
+			if err != nil {
+				return err
+			}
+			if record == nil {
+				continue
+			}
+			if err := s.endSession(st, key, record, now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// runSweep calls sweep every interval on whichever instance holds the
+// backend's sweep lock for that tick, until ctx is cancelled.
+func (s *voiceStore) runSweep(ctx context.Context, name string, interval time.Duration, sweep func() error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		acquired, err := s.backend.acquireSweep(name, interval)
+		if err != nil {
+			slog.Error("failed to acquire sweep", "sweep", name, "error", err)
+			continue
+		}
+		if !acquired {
+			continue
+		}
+
+		if err := sweep(); err != nil {
+			slog.Error("sweep failed", "sweep", name, "error", err)
+		}
+	}
+}
+
+type server struct {
+	store *voiceStore
+	// adminSecret is the X-Voice-Admin-Secret the admin routes require;
+	// empty closes them.
+	adminSecret string
+	// maxBodyBytes caps request bodies; zero means defaultMaxBodyBytes.
+	maxBodyBytes int64
+	// port, maxInFlight and requestTimeout are only reported by /info;
+	// main applies them.
+	port           string
+	maxInFlight    int
+	requestTimeout time.Duration
+}
+
+const defaultMaxBodyBytes = 1 << 20
+
+func (s *server) bodyLimit() int64 {
+	if s.maxBodyBytes > 0 {
+		return s.maxBodyBytes
+	}
+	return defaultMaxBodyBytes
+}
+
+func main() {
+	logger := newLogger(os.Stderr, "voice-signaling")
+	slog.SetDefault(logger)
+	if err := validateConfig(os.Getenv); err != nil {
+		fatal("invalid configuration", err)
+	}
+
+	port := getEnv("VOICE_SIGNALING_PORT", "4003")
+	corsOrigins := parseCORSOrigins(getEnv("CORS_ORIGINS", getEnv("CORS_ORIGIN", "*")))
+	signalingURL := getEnv("LIVEKIT_WS_URL", "ws://localhost:7880")
+	regionURLs, err := parseRegionURLs(getEnv("LIVEKIT_REGION_URLS", ""))
+	if err != nil {
+		fatal("invalid LIVEKIT_REGION_URLS", err)
+	}
+	livekitAPIKey := getEnv("LIVEKIT_API_KEY", "devkey")
+	livekitAPISecret := getEnv("LIVEKIT_API_SECRET", "secret")
+	livekitPrivateKeyPEM := getEnv("LIVEKIT_API_KEY_PRIVATE_PEM", "")
+	tokenAudience := strings.TrimSpace(getEnv("LIVEKIT_TOKEN_AUDIENCE", ""))
+	tokenSkewSeconds := getIntEnv("LIVEKIT_TOKEN_NBF_SKEW_SECONDS", 30)
+	reconnectGraceMs := getIntEnv("VOICE_SIGNALING_RECONNECT_GRACE_MS", 30000)
+	minReconnectGraceMs := getIntEnv("VOICE_SIGNALING_RECONNECT_GRACE_MIN_MS", 5000)
+	maxReconnectGraceMs := getIntEnv("VOICE_SIGNALING_RECONNECT_GRACE_MAX_MS", 300000)
+	speakingTimeoutMs := getIntEnv("VOICE_SIGNALING_SPEAKING_TIMEOUT_MS", 2000)
+	idleTimeoutMs := getIntEnv("VOICE_SIGNALING_IDLE_TIMEOUT_MS", 0)
+	banDurationSeconds := getIntEnv("VOICE_SIGNALING_BAN_DURATION_SECONDS", 3600)
+	tokenTTLSeconds := getIntEnv("VOICE_SIGNALING_TOKEN_TTL_SECONDS", 3600)
+	shutdownGrace := time.Duration(getIntEnv("VOICE_SIGNALING_SHUTDOWN_GRACE_MS", 10000)) * time.Millisecond
+	if minReconnectGraceMs < 5000 {
+		minReconnectGraceMs = 5000
+	}
+	if maxReconnectGraceMs < minReconnectGraceMs {
+		maxReconnectGraceMs = minReconnectGraceMs
+	}
+	reconnectGraceMs = min(max(reconnectGraceMs, minReconnectGraceMs), maxReconnectGraceMs)
+	if speakingTimeoutMs < 500 {
+		speakingTimeoutMs = 500
+	}
+	if tokenTTLSeconds < 60 {
+		tokenTTLSeconds = 60
+	}
+	if banDurationSeconds < 60 {
+		banDurationSeconds = 60
+	}
+	tokenSkewSeconds = min(max(tokenSkewSeconds, 0), maxTokenSkewSeconds)
+
+	enableScreenShare := strings.EqualFold(getEnv("VOICE_SIGNALING_ENABLE_SCREEN_SHARE", "false"), "true")
+	enableVideo := strings.EqualFold(getEnv("VOICE_SIGNALING_ENABLE_VIDEO", "false"), "true")
+	deafenImpliesMute := !strings.EqualFold(getEnv("VOICE_SIGNALING_DEAFEN_IMPLIES_MUTE", "true"), "false")
+	maxSpeakers := getIntEnv("VOICE_SIGNALING_MAX_SPEAKERS", 0)
+	maxSpectators := getIntEnv("VOICE_SIGNALING_MAX_SPECTATORS", 0)
+	maxServerSessions := getIntEnv("VOICE_SIGNALING_MAX_SESSIONS_PER_SERVER", 0)
+	maxScreenShares := getIntEnv("VOICE_SIGNALING_MAX_SCREEN_SHARES", 0)
+	churnBurst := getIntEnv("VOICE_SIGNALING_CHURN_LIMIT_BURST", 10)
+	churnWindowSeconds := getIntEnv("VOICE_SIGNALING_CHURN_LIMIT_WINDOW_SECONDS", 30)
+	joinReplayWindowSeconds := getIntEnv("VOICE_SIGNALING_IDEMPOTENCY_WINDOW_SECONDS", 10)
+	joinHoldSeconds := getIntEnv("VOICE_SIGNALING_JOIN_HOLD_SECONDS", 10)
+	realtimeGatewayURL := getEnv("REALTIME_GATEWAY_URL", "http://localhost:4001")
+	realtimeGatewayInternalAPIKey := getEnv("REALTIME_GATEWAY_INTERNAL_API_KEY", "")
+	adminSecret := getEnv("VOICE_SIGNALING_ADMIN_SECRET", "")
+	maxBodyBytes := max(getIntEnv("VOICE_SIGNALING_MAX_BODY_BYTES", defaultMaxBodyBytes), 1024)
+	maxInFlight := getIntEnv("VOICE_SIGNALING_MAX_IN_FLIGHT", 1000)
+	requestTimeout := time.Duration(max(getIntEnv("VOICE_SIGNALING_REQUEST_TIMEOUT_MS", 10000), 0)) * time.Millisecond
+	publishQueueSize := getIntEnv("VOICE_SIGNALING_PUBLISH_QUEUE_SIZE", 1024)
+
+	signer, err := newLivekitSigner(livekitAPIKey, livekitAPISecret, livekitPrivateKeyPEM)
+	if err != nil {
+		fatal("invalid LiveKit credentials", err)
+	}
+
+	var backend voiceBackend = newMemoryVoiceBackend()
+	sharedBackend := false
+	if redisURL := getEnv("REDIS_URL", ""); redisURL != "" {
+		redisBackend, err := newRedisVoiceBackend(redisURL)
+		if err != nil {
+			fatal("failed to connect voice backend", err)
+		}
+		backend = redisBackend
+		sharedBackend = true
+	}
+	metrics := newVoiceMetrics()
+
+	s := &server{
+		store: newVoiceStore(voiceStoreConfig{
+			ReconnectGrace:    time.Duration(reconnectGraceMs) * time.Millisecond,
+			MinReconnectGrace: time.Duration(minReconnectGraceMs) * time.Millisecond,
+			MaxReconnectGrace: time.Duration(maxReconnectGraceMs) * time.Millisecond,
+			SpeakingTimeout:   time.Duration(speakingTimeoutMs) * time.Millisecond,
+			IdleTimeout:       time.Duration(idleTimeoutMs) * time.Millisecond,
+			BanDuration:       time.Duration(banDurationSeconds) * time.Second,
+			EnableScreenShare: enableScreenShare,
+			EnableVideo:       enableVideo,
+			DeafenImpliesMute: deafenImpliesMute,
+			MaxSpeakers:       maxSpeakers,
+			MaxSpectators:     maxSpectators,
+			MaxServerSessions: maxServerSessions,
+			MaxScreenShares:   maxScreenShares,
+			ChurnBurst:        churnBurst,
+			ChurnWindow:       time.Duration(churnWindowSeconds) * time.Second,
+			JoinReplayWindow:  time.Duration(joinReplayWindowSeconds) * time.Second,
+			JoinHoldTTL:       time.Duration(joinHoldSeconds) * time.Second,
+			SignalingURL:      signalingURL,
+			RegionURLs:        regionURLs,
+			Signer:            signer,
+			TokenTTL:          time.Duration(tokenTTLSeconds) * time.Second,
+			TokenAudience:     tokenAudience,
+			TokenSkew:         time.Duration(tokenSkewSeconds) * time.Second,
+			Backend:           backend,
+			Publisher:         newGatewayPublisher(realtimeGatewayURL, realtimeGatewayInternalAPIKey, time.Second, publishQueueSize, metrics.publishFailures),
+			Recorder:          noopRecorder{},
+			Metrics:           metrics,
+			Clock:             realClock{},
+		}),
+		adminSecret:    adminSecret,
+		maxBodyBytes:   int64(maxBodyBytes),
+		port:           port,
+		maxInFlight:    maxInFlight,
+		requestTimeout: requestTimeout,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go s.store.runSweep(ctx, "cleanup", 5*time.Second, s.store.CleanupExpired)
+	go s.store.runSweep(ctx, "speaking", time.Second, s.store.ClearStaleSpeaking)
+	go s.store.joinReplays.runEviction(ctx, 5*time.Second, s.store.clock)
+	go s.store.holds.runExpiry(ctx, time.Second, s.store.clock)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", s.handleHealth)
+	mux.HandleFunc("/ready", s.handleReady)
+	mux.HandleFunc("/info", s.handleInfo)
+	mux.Handle("/metrics", s.store.metrics.handler())
+	mux.HandleFunc("/v1/voice/channels/", s.handleVoiceChannels)
+	mux.HandleFunc("/v1/voice/direct-threads/", s.handleVoiceDirectThreads)
+	mux.HandleFunc("/v1/voice/servers/", s.handleVoiceServers)
+	mux.HandleFunc("/v1/voice/sessions/bulk", s.handleVoiceSessionsBulk)
+	mux.HandleFunc("/v1/voice/users/", s.handleVoiceUsers)
+	mux.HandleFunc(adminSessionsPath, s.handleAdminSessions)
+	mux.HandleFunc("/v1/voice/livekit/webhook", s.handleLivekitWebhook)
+	mux.HandleFunc("/", s.handleRoot)
+
+	addr := ":" + port
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		fatal("failed to listen", err)
+	}
+
+	slog.Info("listening", "addr", addr)
+	if err := serve(ctx, &http.Server{Handler: withRequestLogging(logger, withCORS(corsOrigins, withInFlightLimit(maxInFlight, nil, withRequestTimeout(requestTimeout, nil, mux))))}, ln, shutdownGrace); err != nil {
+		fatal("server failed", err)
+	}
+
+	if err := s.store.CleanupExpired(); err != nil {
+		slog.Error("final cleanup failed", "error", err)
+	}
+	// Shared sessions outlive this instance; only in-memory ones end with it.
+	if !sharedBackend {
+		if err := s.store.ReleaseAll(); err != nil {
+			slog.Error("failed to release sessions", "error", err)
+		}
+	}
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), shutdownGrace)
+	defer cancel()
+	drainPublisher(drainCtx, s.store.publisher)
+	slog.Info("shut down")
+}
+
+func (s *server) handleHealth(w http.ResponseWriter, _ *http.Request) {
+	s.respondJSON(w, http.StatusOK, map[string]any{
+		"service":   "voice-signaling",
+		"status":    "ok",
+		"timestamp": time.Now().UTC().Format(time.RFC3339Nano),
+	})
+}
+
+// handleReady reports whether LiveKit tokens can be minted with the configured
+// credentials and the session backend is reachable. /health stays a cheap
+// liveness probe.
+func (s *server) handleReady(w http.ResponseWriter, r *http.Request) {
+	statuses, ready := runReadinessChecks(r.Context(), []readinessCheck{
+		{name: "livekit", probe: func(context.Context) error {
+			_, _, err := s.store.participantToken("readiness", "probe", targetChannel, "readiness", false, false, livekitParticipantMetadata{}, "")
+			return err
+		}},
+		{name: "backend", probe: func(context.Context) error {
+			return s.store.backend.view(func(st voiceState) error {
+				_, err := st.session(targetKey(targetChannel, "readiness"))
+				return err
+			})
+		}},
+	})
+
+	code, body := readinessResponse("voice-signaling", statuses, ready)
+	s.respondJSON(w, code, body)
+}
+
+func (s *server) handleRoot(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		s.respondOptions(w)
+		return
+	}
+
+	s.respondJSON(w, http.StatusOK, map[string]any{
+		"service": "voice-signaling",
+		"routes": []string{
+			"GET /health",
+			"GET /ready",
+			"GET /info",
+			"GET /metrics",
+			"GET /v1/voice/channels/:channelId",
+			"GET /v1/voice/channels/:channelId/speaking",
+			"POST /v1/voice/channels/:channelId/join",
+			"POST /v1/voice/channels/:channelId/preflight",
+			"POST /v1/voice/channels/:channelId/leave",
+			"POST /v1/voice/channels/:channelId/state",
+			"POST /v1/voice/channels/:channelId/state/batch",
+			"POST /v1/voice/channels/:channelId/heartbeat",
+			"POST /v1/voice/channels/:channelId/screen-share",
+			"POST /v1/voice/channels/:channelId/camera",
+			"POST /v1/voice/channels/:channelId/hand",
+			"POST /v1/voice/channels/:channelId/react",
+			"POST /v1/voice/channels/:channelId/quality",
+			"POST /v1/voice/channels/:channelId/token/refresh",
+			"POST /v1/voice/channels/:channelId/transfer",
+			"POST /v1/voice/channels/:channelId/lock",
+			"POST /v1/voice/channels/:channelId/metadata",
+			"POST /v1/voice/channels/:channelId/recording",
+			"POST /v1/voice/channels/:channelId/whisper",
+			"POST /v1/voice/channels/:channelId/end",
+			"POST /v1/voice/channels/:channelId/participants/:userId/mute",
+			"POST /v1/voice/channels/:channelId/participants/:userId/kick",
+			"POST /v1/voice/channels/:channelId/participants/:userId/ban",
+			"POST /v1/voice/channels/:channelId/participants/:userId/move",
+			"POST /v1/voice/channels/:channelId/participants/:userId/priority",
+			"GET /v1/voice/direct-threads/:threadId",
+			"GET /v1/voice/direct-threads/:threadId/speaking",
+			"POST /v1/voice/direct-threads/:threadId/join",
+			"POST /v1/voice/direct-threads/:threadId/leave",
+			"POST /v1/voice/direct-threads/:threadId/state",
+			"POST /v1/voice/direct-threads/:threadId/state/batch",
+			"POST /v1/voice/direct-threads/:threadId/heartbeat",
+			"POST /v1/voice/direct-threads/:threadId/screen-share",
+			"POST /v1/voice/direct-threads/:threadId/camera",
+			"POST /v1/voice/direct-threads/:threadId/hand",
+			"POST /v1/voice/direct-threads/:threadId/react",
+			"POST /v1/voice/direct-threads/:threadId/quality",
+			"POST /v1/voice/direct-threads/:threadId/token/refresh",
+			"POST /v1/voice/direct-threads/:threadId/transfer",
+			"GET /v1/voice/servers/:serverId/sessions",
+			"POST /v1/voice/sessions/bulk",
+			"GET /v1/voice/users/:userId",
+			"GET /v1/voice/admin/sessions/:kind/:id",
+			"POST /v1/voice/livekit/webhook",
+		},
+	})
+}
+
+func (s *server) handleVoiceChannels(w http.ResponseWriter, r *http.Request) {
+	s.handleVoiceTarget(w, r, targetChannel, "/v1/voice/channels/")
+}
+
+func (s *server) handleVoiceDirectThreads(w http.ResponseWriter, r *http.Request) {
+	s.handleVoiceTarget(w, r, targetDirectThread, "/v1/voice/direct-threads/")
+}
+
+func (s *server) handleVoiceServers(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		s.respondOptions(w)
+		return
+	}
+
+	route, err := parseTargetPath(r.URL.Path, "/v1/voice/servers/")
+	if errors.Is(err, errInvalidTargetID) {
+		s.respondError(w, http.StatusBadRequest, "Invalid server id.")
+		return
+	}
+	if err != nil || route.Action != "sessions" || r.Method != http.MethodGet {
+		s.respondError(w, http.StatusNotFound, "Route not found.")
+		return
+	}
+
+	if _, ok := s.requestUserID(w, r); !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	limit := defaultSessionsPageLimit
+	if raw := strings.TrimSpace(query.Get("limit")); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 {
+			s.respondError(w, http.StatusBadRequest, "limit must be a positive integer.")
+			return
+		}
+		limit = min(parsed, maxSessionsPageLimit)
+	}
+
+	includeParticipants := strings.EqualFold(query.Get("includeParticipants"), "true")
+	sessions, nextCursor, err := s.store.ListServerSessions(route.TargetID, includeParticipants, limit, strings.TrimSpace(query.Get("cursor")))
+	if err != nil {
+		s.respondSessionError(w, err)
+		return
+	}
+
+	s.respondJSON(w, http.StatusOK, map[string]any{
+		"sessions":   sessions,
+		"nextCursor": nextCursor,
+	})
+}
+
+func sessionErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errVoiceSessionNotFound), errors.Is(err, errVoiceNotConnected):
+		return http.StatusNotFound
+	case errors.Is(err, errVoiceModeratorMuted), errors.Is(err, errVoiceBanned), errors.Is(err, errVoiceNotCreator):
+		return http.StatusForbidden
+	case errors.Is(err, errVoiceSelfModeration), errors.Is(err, errVoiceWhisperTarget), errors.Is(err, errVoiceTransferTarget), errors.Is(err, errInvalidSessionCursor),
+		errors.Is(err, errVoiceMetadataKey), errors.Is(err, errVoiceMetadataKeys), errors.Is(err, errVoiceMetadataSize):
+		return http.StatusBadRequest
+	case errors.Is(err, errVoiceConflict), errors.Is(err, errVoiceSessionLocked), errors.Is(err, errVoiceSessionFull), errors.Is(err, errVoiceServerFull),
+		errors.Is(err, errVoiceScreenShareFull), errors.Is(err, errVoiceAlreadyRecording), errors.Is(err, errVoiceNotRecording):
+		return http.StatusConflict
+	case errors.Is(err, errVoiceRecordingUnavailable):
+		return http.StatusBadGateway
+	case errors.Is(err, errVoiceReactionRateLimited):
+		return http.StatusTooManyRequests
+	}
+
+	return http.StatusInternalServerError
+}
+
+// handleLivekitWebhook reconciles sessions with LiveKit's view of the room,
+// covering clients that disconnect without calling leave. Events for rooms
+// we do not track are acknowledged and ignored so LiveKit does not retry.
+func (s *server) handleLivekitWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.respondError(w, http.StatusNotFound, "Route not found.")
+		return
+	}
+
+	defer r.Body.Close()
+	payload, err := readBody(r.Body, s.bodyLimit())
+	if err != nil {
+		s.respondDecodeError(w, err)
+		return
+	}
+
+	if err := s.store.signer.verifyWebhook(r.Header.Get("Authorization"), payload); err != nil {
+		s.respondErrorCode(w, http.StatusUnauthorized, codeVoiceInvalidSignature, "Invalid webhook signature.")
+		return
+	}
+
+	var event livekitWebhookEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		s.respondError(w, http.StatusBadRequest, "Invalid webhook payload.")
+		return
+	}
+
+	handled := false
+	if event.Room != nil {
+		if kind, targetID, ok := parseRoomName(event.Room.Name); ok {
+			switch event.Event {
+			case "participant_left":
+				if event.Participant != nil {
+					handled, err = s.store.ParticipantLeft(kind, targetID, event.Participant.Identity)
+				}
+			case "room_finished":
+				handled, err = s.store.RoomFinished(kind, targetID)
+			}
+		}
+	}
+	if err != nil {
+		// A non-2xx makes LiveKit retry the event.
+		s.respondSessionError(w, err)
+		return
+	}
+
+	s.respondJSON(w, http.StatusOK, map[string]bool{
+		"handled": handled,
+	})
+}
+
+func (s *server) handleVoiceTarget(w http.ResponseWriter, r *http.Request, kind voiceTargetKind, prefix string) {
+	if r.Method == http.MethodOptions {
+		s.respondOptions(w)
+		return
+	}
+
+	route, err := parseTargetPath(r.URL.Path, prefix)
+	switch {
+	case errors.Is(err, errInvalidTargetID):
+		s.respondError(w, http.StatusBadRequest, "Invalid target id.")
+		return
+	case errors.Is(err, errInvalidParticipantID):
+		s.respondError(w, http.StatusBadRequest, "Invalid participant id.")
+		return
+	case err != nil:
+		s.respondError(w, http.StatusNotFound, "Route not found.")
+		return
+	}
+
+	targetID, action := route.TargetID, route.Action
+	if (strings.HasPrefix(action, "participants/") || action == "lock" || action == "metadata" || action == "recording" || action == "whisper" || action == "end" || action == "preflight") && kind != targetChannel {
+		s.respondError(w, http.StatusNotFound, "Route not found.")
+		return
+	}
+
+	userID, ok := s.requestUserID(w, r)
+	if !ok {
+		return
+	}
+
+	serverID := copyStringPtr(r.Header.Get("X-Voice-Server-Id"))
+	screenShareEnabled := strings.EqualFold(strings.TrimSpace(r.Header.Get("X-Screen-Share-Enabled")), "true")
+	moderator := strings.EqualFold(strings.TrimSpace(r.Header.Get("X-Voice-Moderator")), "true")
+	connectionID := strings.TrimSpace(r.Header.Get("X-Voice-Connection-Id"))
+	if connectionID != "" && !validID(connectionID) {
+		s.respondError(w, http.StatusBadRequest, "Invalid X-Voice-Connection-Id.")
+		return
+	}
+	canPublish := !strings.EqualFold(strings.TrimSpace(r.Header.Get("X-Voice-Can-Publish")), "false")
+	if !screenShareEnabled && action == "screen-share" {
+		s.respondErrorCode(w, http.StatusNotFound, codeVoiceScreenShareDisabled, "Screen sharing is disabled.")
+		return
+	}
+
+	// A replayed join is answered before the churn limit, as it changes
+	// nothing.
+	var replayKey string
+	if action == "join" && r.Method == http.MethodPost {
+		if key := strings.TrimSpace(r.Header.Get("Idempotency-Key")); key != "" {
+			if len(key) > maxIdempotencyKeyLength {
+				s.respondError(w, http.StatusBadRequest, fmt.Sprintf("Idempotency-Key must be at most %d characters.", maxIdempotencyKeyLength))
+				return
+			}
+			replayKey = joinReplayKey(userID, kind, targetID, connectionID, key)
+			if session, ok := s.store.joinReplays.Get(replayKey, s.store.clock.Now()); ok {
+				s.respondJSON(w, http.StatusOK, session)
+				return
+			}
+		}
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		session, err := s.store.Get(kind, targetID, userID)
+		if err != nil {
+			s.respondSessionError(w, err)
+			return
+		}
+
+		if session == nil {
+			s.respondJSON(w, http.StatusOK, nil)
+			return
+		}
+
+		s.respondJSON(w, http.StatusOK, session)
+		return
+
+	case action == "speaking" && r.Method == http.MethodGet:
+		speaking, err := s.store.Speaking(kind, targetID)
+		if err != nil {
+			s.respondSessionError(w, err)
+			return
+		}
+
+		s.respondJSON(w, http.StatusOK, speaking)
+		return
+
+	case action == "join" && r.Method == http.MethodPost:
+		var body joinVoiceRequest
+		if err := decodeJSONBody(r, s.bodyLimit(), &body); err != nil {
+			s.respondDecodeError(w, err)
+			return
+		}
+
+		if raw := strings.TrimSpace(r.Header.Get("X-Voice-Reconnect-Grace-Ms")); raw != "" {
+			graceMs, err := strconv.Atoi(raw)
+			if err != nil || graceMs <= 0 {
+				s.respondError(w, http.StatusBadRequest, "X-Voice-Reconnect-Grace-Ms must be a positive integer.")
+				return
+			}
+			body.ReconnectGrace = time.Duration(graceMs) * time.Millisecond
+		}
+		body.Region = r.Header.Get("X-Voice-Region")
+		if raw := strings.TrimSpace(r.Header.Get("X-Voice-Participant-Metadata")); raw != "" {
+			if len(raw) > maxParticipantMetadataBytes || !json.Valid([]byte(raw)) || raw[0] != '{' {
+				s.respondError(w, http.StatusBadRequest, fmt.Sprintf("X-Voice-Participant-Metadata must be a JSON object of at most %d bytes.", maxParticipantMetadataBytes))
+				return
+			}
+			body.ParticipantMetadata = raw
+		}
+		if raw := strings.TrimSpace(r.Header.Get("X-Voice-Start-Reason")); raw != "" {
+			if !validID(raw) {
+				s.respondError(w, http.StatusBadRequest, "X-Voice-Start-Reason must be a short identifier such as manual or scheduled.")
+				return
+			}
+			body.StartReason = raw
+		}
+		mode, ok := parseJoinMode(r.Header.Get("X-Voice-Join-Mode"))
+		if !ok {
+			s.respondError(w, http.StatusBadRequest, "X-Voice-Join-Mode must be speaker or spectator.")
+			return
+		}
+		body.Mode = mode
+
+		body.ConnectionID = connectionID
+		if !s.allowChurn(w, userID) {
+			return
+		}
+		session, err := s.store.Join(kind, targetID, userID, serverID, canPublish, body)
+		if err != nil {
+			s.respondSessionError(w, err)
+			return
+		}
+		if replayKey != "" {
+			s.store.joinReplays.Put(replayKey, session, s.store.clock.Now())
+		}
+
+		s.respondJSON(w, http.StatusOK, session)
+		return
+
+	case action == "preflight" && r.Method == http.MethodPost:
+		var body preflightRequest
+		if err := decodeJSONBody(r, s.bodyLimit(), &body); err != nil {
+			s.respondDecodeError(w, err)
+			return
+		}
+
+		mode, ok := parseJoinMode(r.Header.Get("X-Voice-Join-Mode"))
+		if !ok {
+			s.respondError(w, http.StatusBadRequest, "X-Voice-Join-Mode must be speaker or spectator.")
+			return
+		}
+
+		preflight, err := s.store.Preflight(kind, targetID, userID, serverID, mode, body.Hold)
+		if err != nil {
+			s.respondSessionError(w, err)
+			return
+		}
+
+		s.respondJSON(w, http.StatusOK, preflight)
+		return
+
+	case action == "leave" && r.Method == http.MethodPost:
+		if !s.allowChurn(w, userID) {
+			return
+		}
+		session, err := s.store.LeaveConnection(kind, targetID, userID, connectionID)
+		if err != nil {
+			s.respondSessionError(w, err)
+			return
+		}
+
+		s.respondJSON(w, http.StatusOK, session)
+		return
+
+	case action == "state" && r.Method == http.MethodPost:
+		var body updateVoiceStateRequest
+		if err := decodeJSONBody(r, s.bodyLimit(), &body); err != nil {
+			s.respondDecodeError(w, err)
+			return
+		}
+
+		session, err := s.store.UpdateState(kind, targetID, userID, body)
+		if err != nil {
+			s.respondSessionError(w, err)
+			return
+		}
+
+		s.respondJSON(w, http.StatusOK, session)
+		return
+
+	case action == "state/batch" && r.Method == http.MethodPost:
+		var body batchVoiceStateRequest
+		if err := decodeJSONBody(r, s.bodyLimit(), &body); err != nil {
+			s.respondDecodeError(w, err)
+			return
+		}
+
+		if body.ScreenSharing != nil && *body.ScreenSharing && !(screenShareEnabled && s.store.enableScreenShare) {
+			s.respondErrorCode(w, http.StatusBadRequest, codeVoiceScreenShareDisabled, "Screen sharing is disabled.")
+			return
+		}
+		if body.CameraOn != nil && *body.CameraOn && !s.store.enableVideo {
+			s.respondErrorCode(w, http.StatusBadRequest, codeVoiceVideoDisabled, "Video is disabled.")
+			return
+		}
+		if body.ShareAudio != nil && *body.ShareAudio && body.ScreenSharing != nil && !*body.ScreenSharing {
+			s.respondError(w, http.StatusBadRequest, "shareAudio requires screenSharing.")
+			return
+		}
+
+		session, err := s.store.UpdateStateBatch(kind, targetID, userID, body)
+		if err != nil {
+			s.respondSessionError(w, err)
+			return
+		}
+
+		s.respondJSON(w, http.StatusOK, session)
+		return
+
+	case action == "heartbeat" && r.Method == http.MethodPost:
+		var body heartbeatRequest
+		if err := decodeJSONBody(r, s.bodyLimit(), &body); err != nil {
+			s.respondDecodeError(w, err)
+			return
+		}
+
+		body.ConnectionID = connectionID
+		session, err := s.store.Heartbeat(kind, targetID, userID, body)
+		if err != nil {
+			s.respondSessionError(w, err)
+			return
+		}
+
+		s.respondJSON(w, http.StatusOK, session)
+		return
+
+	case action == "hand" && r.Method == http.MethodPost:
+		var body raiseHandRequest
+		if err := decodeJSONBody(r, s.bodyLimit(), &body); err != nil {
+			s.respondDecodeError(w, err)
+			return
+		}
+
+		if body.Raised == nil {
+			s.respondError(w, http.StatusBadRequest, "raised must be a boolean.")
+			return
+		}
+
+		session, err := s.store.RaiseHand(kind, targetID, userID, *body.Raised)
+		if err != nil {
+			s.respondSessionError(w, err)
+			return
+		}
+
+		s.respondJSON(w, http.StatusOK, session)
+		return
+
+	case action == "quality" && r.Method == http.MethodPost:
+		var body connectionQualityRequest
+		if err := decodeJSONBody(r, s.bodyLimit(), &body); err != nil {
+			s.respondDecodeError(w, err)
+			return
+		}
+
+		if !validConnectionQuality(body.Quality) {
+			s.respondError(w, http.StatusBadRequest, `quality must be "good", "poor" or "lost".`)
+			return
+		}
+
+		session, err := s.store.ReportConnectionQuality(kind, targetID, userID, body.Quality)
+		if err != nil {
+			s.respondSessionError(w, err)
+			return
+		}
+
+		s.respondJSON(w, http.StatusOK, session)
+		return
+
+	case action == "react" && r.Method == http.MethodPost:
+		var body reactRequest
+		if err := decodeJSONBody(r, s.bodyLimit(), &body); err != nil {
+			s.respondDecodeError(w, err)
+			return
+		}
+
+		if !allowedReactions[body.Emoji] {
+			s.respondError(w, http.StatusBadRequest, "emoji is not a supported reaction.")
+			return
+		}
+
+		reaction, err := s.store.React(kind, targetID, userID, body.Emoji)
+		if err != nil {
+			if errors.Is(err, errVoiceReactionRateLimited) {
+				w.Header().Set("Retry-After", strconv.Itoa(int(reactionInterval/time.Second)))
+			}
+			s.respondSessionError(w, err)
+			return
+		}
+
+		s.respondJSON(w, http.StatusOK, reaction)
+		return
+
+	case action == "token/refresh" && r.Method == http.MethodPost:
+		signaling, err := s.store.RefreshToken(kind, targetID, userID, connectionID)
+		if err != nil {
+			s.respondSessionError(w, err)
+			return
+		}
+
+		s.respondJSON(w, http.StatusOK, signaling)
+		return
+
+	case action == "whisper" && r.Method == http.MethodPost:
+		var body whisperRequest
+		if err := decodeJSONBody(r, s.bodyLimit(), &body); err != nil {
+			s.respondDecodeError(w, err)
+			return
+		}
+
+		if len(body.TargetUserIDs) == 0 {
+			s.respondError(w, http.StatusBadRequest, "targetUserIds is required.")
+			return
+		}
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
+		grant, err := s.store.Whisper(kind, targetID, userID, body.TargetUserIDs)
+		if err != nil {
+			s.respondSessionError(w, err)
+			return
+		}
 
-	for key, record := range s.sessionsByTarget {
-		for userID, participant := range record.Participants {
-			if now.Sub(participant.LastSeenAt) <= s.reconnectGrace {
-				continue
-			}
+		s.respondJSON(w, http.StatusOK, grant)
+		return
 
-			delete(record.Participants, userID)
-			if s.targetByUserID[userID] == key {
-				delete(s.targetByUserID, userID)
-			}
+	case action == "transfer" && r.Method == http.MethodPost:
+		var body transferSessionRequest
+		if err := decodeJSONBody(r, s.bodyLimit(), &body); err != nil {
+			s.respondDecodeError(w, err)
+			return
 		}
 
-		if len(record.Participants) == 0 {
-			delete(s.sessionsByTarget, key)
-			continue
+		if strings.TrimSpace(body.UserID) == "" {
+			s.respondError(w, http.StatusBadRequest, "userId is required.")
+			return
 		}
 
-		record.UpdatedAt = now
-	}
-}
+		session, err := s.store.TransferCreator(kind, targetID, userID, body.UserID)
+		if err != nil {
+			s.respondSessionError(w, err)
+			return
+		}
 
-type server struct {
-	corsOrigin string
-	store      *voiceStore
-}
+		s.respondJSON(w, http.StatusOK, session)
+		return
 
-func main() {
-	port := getEnv("VOICE_SIGNALING_PORT", "4003")
-	corsOrigin := getEnv("CORS_ORIGIN", "*")
-	signalingURL := getEnv("LIVEKIT_WS_URL", "ws://localhost:7880")
-	livekitAPIKey := getEnv("LIVEKIT_API_KEY", "devkey")
-	livekitAPISecret := getEnv("LIVEKIT_API_SECRET", "secret")
-	reconnectGraceMs := getIntEnv("VOICE_SIGNALING_RECONNECT_GRACE_MS", 30000)
-	tokenTTLSeconds := getIntEnv("VOICE_SIGNALING_TOKEN_TTL_SECONDS", 3600)
-	if reconnectGraceMs < 5000 {
-		reconnectGraceMs = 5000
-	}
-	if tokenTTLSeconds < 60 {
-		tokenTTLSeconds = 60
-	}
+	case action == "lock" && r.Method == http.MethodPost:
+		if !moderator {
+			s.respondErrorCode(w, http.StatusForbidden, codeVoiceModeratorRequired, "Moderator permission required.")
+			return
+		}
 
-	enableScreenShare := strings.EqualFold(getEnv("VOICE_SIGNALING_ENABLE_SCREEN_SHARE", "false"), "true")
+		var body lockSessionRequest
+		if err := decodeJSONBody(r, s.bodyLimit(), &body); err != nil {
+			s.respondDecodeError(w, err)
+			return
+		}
 
-	s := &server{
-		corsOrigin: corsOrigin,
-		store: newVoiceStore(
-			time.Duration(reconnectGraceMs)*time.Millisecond,
-			enableScreenShare,
-			signalingURL,
-			livekitAPIKey,
-			livekitAPISecret,
-			time.Duration(tokenTTLSeconds)*time.Second,
-		),
-	}
+		if body.Locked == nil {
+			s.respondError(w, http.StatusBadRequest, "locked must be a boolean.")
+			return
+		}
 
-	go func() {
-		ticker := time.NewTicker(5 * time.Second)
-		defer ticker.Stop()
-		for range ticker.C {
-			s.store.CleanupExpired()
+		session, err := s.store.SetLocked(kind, targetID, userID, *body.Locked)
+		if err != nil {
+			s.respondSessionError(w, err)
+			return
 		}
-	}()
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/health", s.handleHealth)
-	mux.HandleFunc("/v1/voice/channels/", s.handleVoiceChannels)
-	mux.HandleFunc("/v1/voice/direct-threads/", s.handleVoiceDirectThreads)
-	mux.HandleFunc("/", s.handleRoot)
+		s.respondJSON(w, http.StatusOK, session)
+		return
 
-	addr := ":" + port
-	log.Printf("voice-signaling listening on http://localhost%s", addr)
-	log.Fatal(http.ListenAndServe(addr, mux))
-}
+	case action == "end" && r.Method == http.MethodPost:
+		if !moderator {
+			s.respondErrorCode(w, http.StatusForbidden, codeVoiceModeratorRequired, "Moderator permission required.")
+			return
+		}
 
-func (s *server) handleHealth(w http.ResponseWriter, _ *http.Request) {
-	s.respondJSON(w, http.StatusOK, map[string]any{
-		"service":   "voice-signaling",
-		"status":    "ok",
-		"timestamp": time.Now().UTC().Format(time.RFC3339Nano),
-	})
-}
+		if err := s.store.EndSession(kind, targetID, userID); err != nil {
+			s.respondSessionError(w, err)
+			return
+		}
 
-func (s *server) handleRoot(w http.ResponseWriter, r *http.Request) {
-	if r.Method == http.MethodOptions {
-		s.respondOptions(w)
+		s.respondNoContent(w)
 		return
-	}
-
-	s.respondJSON(w, http.StatusOK, map[string]any{
-		"service": "voice-signaling",
-		"routes": []string{
-			"GET /health",
-			"GET /v1/voice/channels/:channelId",
-			"POST /v1/voice/channels/:channelId/join",
-			"POST /v1/voice/channels/:channelId/leave",
-			"POST /v1/voice/channels/:channelId/state",
-			"POST /v1/voice/channels/:channelId/heartbeat",
-			"POST /v1/voice/channels/:channelId/screen-share",
-			"GET /v1/voice/direct-threads/:threadId",
-			"POST /v1/voice/direct-threads/:threadId/join",
-			"POST /v1/voice/direct-threads/:threadId/leave",
-			"POST /v1/voice/direct-threads/:threadId/state",
-			"POST /v1/voice/direct-threads/:threadId/heartbeat",
-			"POST /v1/voice/direct-threads/:threadId/screen-share",
-		},
-	})
-}
 
-func (s *server) handleVoiceChannels(w http.ResponseWriter, r *http.Request) {
-	s.handleVoiceTarget(w, r, targetChannel, "/v1/voice/channels/")
-}
+	case action == "metadata" && r.Method == http.MethodPost:
+		if !moderator {
+			s.respondErrorCode(w, http.StatusForbidden, codeVoiceModeratorRequired, "Moderator permission required.")
+			return
+		}
 
-func (s *server) handleVoiceDirectThreads(w http.ResponseWriter, r *http.Request) {
-	s.handleVoiceTarget(w, r, targetDirectThread, "/v1/voice/direct-threads/")
-}
+		var body sessionMetadataRequest
+		if err := decodeJSONBody(r, s.bodyLimit(), &body); err != nil {
+			s.respondDecodeError(w, err)
+			return
+		}
 
-func sessionErrorStatus(err error) int {
-	if errors.Is(err, errVoiceSessionNotFound) || errors.Is(err, errVoiceNotConnected) {
-		return http.StatusNotFound
-	}
+		if body.Metadata == nil {
+			s.respondError(w, http.StatusBadRequest, "metadata must be an object.")
+			return
+		}
 
-	return http.StatusInternalServerError
-}
+		session, err := s.store.SetMetadata(kind, targetID, userID, body.Metadata)
+		if err != nil {
+			s.respondSessionError(w, err)
+			return
+		}
 
-func (s *server) handleVoiceTarget(w http.ResponseWriter, r *http.Request, kind voiceTargetKind, prefix string) {
-	if r.Method == http.MethodOptions {
-		s.respondOptions(w)
+		s.respondJSON(w, http.StatusOK, session)
 		return
-	}
 
-	targetID, action, err := parseTargetPath(r.URL.Path, prefix)
-	if err != nil {
-		s.respondError(w, http.StatusNotFound, "Route not found.")
-		return
-	}
+	case action == "recording" && r.Method == http.MethodPost:
+		if !moderator {
+			s.respondErrorCode(w, http.StatusForbidden, codeVoiceModeratorRequired, "Moderator permission required.")
+			return
+		}
 
-	userID := strings.TrimSpace(r.Header.Get("X-Voice-User-Id"))
-	if userID == "" {
-		s.respondError(w, http.StatusUnauthorized, "Missing X-Voice-User-Id.")
-		return
-	}
+		var body recordingRequest
+		if err := decodeJSONBody(r, s.bodyLimit(), &body); err != nil {
+			s.respondDecodeError(w, err)
+			return
+		}
 
-	serverID := copyStringPtr(r.Header.Get("X-Voice-Server-Id"))
-	screenShareEnabled := strings.EqualFold(strings.TrimSpace(r.Header.Get("X-Screen-Share-Enabled")), "true")
-	if !screenShareEnabled && action == "screen-share" {
-		s.respondError(w, http.StatusNotFound, "Screen sharing is disabled.")
+		var session voiceSession
+		switch body.Action {
+		case "start":
+			session, err = s.store.StartRecording(kind, targetID, userID)
+		case "stop":
+			session, err = s.store.StopRecording(kind, targetID, userID)
+		default:
+			s.respondError(w, http.StatusBadRequest, `action must be "start" or "stop".`)
+			return
+		}
+		if err != nil {
+			s.respondSessionError(w, err)
+			return
+		}
+
+		s.respondJSON(w, http.StatusOK, session)
 		return
-	}
 
-	switch {
-	case action == "" && r.Method == http.MethodGet:
-		session, err := s.store.Get(kind, targetID, userID)
-		if err != nil {
-			s.respondError(w, sessionErrorStatus(err), err.Error())
+	case action == "participants/:userId/mute" && r.Method == http.MethodPost:
+		if !moderator {
+			s.respondErrorCode(w, http.StatusForbidden, codeVoiceModeratorRequired, "Moderator permission required.")
 			return
 		}
 
-		if session == nil {
-			s.respondJSON(w, http.StatusOK, nil)
+		var body moderatorMuteRequest
+		if err := decodeJSONBody(r, s.bodyLimit(), &body); err != nil {
+			s.respondDecodeError(w, err)
+			return
+		}
+
+		muted := true
+		if body.Muted != nil {
+			muted = *body.Muted
+		}
+
+		session, err := s.store.ModeratorMute(kind, targetID, userID, route.ParticipantID, muted)
+		if err != nil {
+			s.respondSessionError(w, err)
 			return
 		}
 
 		s.respondJSON(w, http.StatusOK, session)
 		return
 
-	case action == "join" && r.Method == http.MethodPost:
-		var body joinVoiceRequest
-		if err := decodeJSONBody(r.Body, &body); err != nil {
-			s.respondError(w, http.StatusBadRequest, err.Error())
+	case action == "participants/:userId/kick" && r.Method == http.MethodPost:
+		if !moderator {
+			s.respondErrorCode(w, http.StatusForbidden, codeVoiceModeratorRequired, "Moderator permission required.")
 			return
 		}
 
-		session, err := s.store.Join(kind, targetID, userID, serverID, body)
+		session, err := s.store.Kick(kind, targetID, userID, route.ParticipantID)
 		if err != nil {
-			s.respondError(w, sessionErrorStatus(err), err.Error())
+			s.respondSessionError(w, err)
 			return
 		}
 
 		s.respondJSON(w, http.StatusOK, session)
 		return
 
-	case action == "leave" && r.Method == http.MethodPost:
-		session, err := s.store.Leave(kind, targetID, userID)
+	case action == "participants/:userId/ban" && r.Method == http.MethodPost:
+		if !moderator {
+			s.respondErrorCode(w, http.StatusForbidden, codeVoiceModeratorRequired, "Moderator permission required.")
+			return
+		}
+
+		session, err := s.store.Ban(kind, targetID, userID, route.ParticipantID)
 		if err != nil {
-			s.respondError(w, sessionErrorStatus(err), err.Error())
+			s.respondSessionError(w, err)
 			return
 		}
 
 		s.respondJSON(w, http.StatusOK, session)
 		return
 
-	case action == "state" && r.Method == http.MethodPost:
-		var body updateVoiceStateRequest
-		if err := decodeJSONBody(r.Body, &body); err != nil {
-			s.respondError(w, http.StatusBadRequest, err.Error())
+	case action == "participants/:userId/move" && r.Method == http.MethodPost:
+		if !moderator {
+			s.respondErrorCode(w, http.StatusForbidden, codeVoiceModeratorRequired, "Moderator permission required.")
 			return
 		}
 
-		session, err := s.store.UpdateState(kind, targetID, userID, body)
+		var body moveParticipantRequest
+		if err := decodeJSONBody(r, s.bodyLimit(), &body); err != nil {
+			s.respondDecodeError(w, err)
+			return
+		}
+
+		// Moderators act within a server, so they can only move people
+		// between its channels.
+		if body.TargetKind != targetChannel {
+			s.respondError(w, http.StatusBadRequest, "targetKind must be \"channel\".")
+			return
+		}
+		if !validID(body.TargetID) {
+			s.respondError(w, http.StatusBadRequest, "targetId must be a valid channel id.")
+			return
+		}
+
+		session, err := s.store.Move(kind, targetID, userID, route.ParticipantID, body.TargetKind, body.TargetID)
 		if err != nil {
-			s.respondError(w, sessionErrorStatus(err), err.Error())
+			s.respondSessionError(w, err)
 			return
 		}
 
 		s.respondJSON(w, http.StatusOK, session)
 		return
 
-	case action == "heartbeat" && r.Method == http.MethodPost:
-		var body heartbeatRequest
-		if err := decodeJSONBody(r.Body, &body); err != nil {
-			s.respondError(w, http.StatusBadRequest, err.Error())
+	case action == "participants/:userId/priority" && r.Method == http.MethodPost:
+		if !moderator {
+			s.respondErrorCode(w, http.StatusForbidden, codeVoiceModeratorRequired, "Moderator permission required.")
 			return
 		}
 
-		session, err := s.store.Heartbeat(kind, targetID, userID, body)
+		var body prioritySpeakerRequest
+		if err := decodeJSONBody(r, s.bodyLimit(), &body); err != nil {
+			s.respondDecodeError(w, err)
+			return
+		}
+
+		prioritySpeaker := true
+		if body.PrioritySpeaker != nil {
+			prioritySpeaker = *body.PrioritySpeaker
+		}
+
+		session, err := s.store.SetPrioritySpeaker(kind, targetID, userID, route.ParticipantID, prioritySpeaker)
 		if err != nil {
-			s.respondError(w, sessionErrorStatus(err), err.Error())
+			s.respondSessionError(w, err)
 			return
 		}
 
@@ -727,13 +3294,13 @@ func (s *server) handleVoiceTarget(w http.ResponseWriter, r *http.Request, kind
 
 	case action == "screen-share" && r.Method == http.MethodPost:
 		if !s.store.enableScreenShare {
-			s.respondError(w, http.StatusNotFound, "Screen sharing is disabled.")
+			s.respondErrorCode(w, http.StatusNotFound, codeVoiceScreenShareDisabled, "Screen sharing is disabled.")
 			return
 		}
 
 		var body updateScreenShareRequest
-		if err := decodeJSONBody(r.Body, &body); err != nil {
-			s.respondError(w, http.StatusBadRequest, err.Error())
+		if err := decodeJSONBody(r, s.bodyLimit(), &body); err != nil {
+			s.respondDecodeError(w, err)
 			return
 		}
 
@@ -742,9 +3309,40 @@ func (s *server) handleVoiceTarget(w http.ResponseWriter, r *http.Request, kind
 			return
 		}
 
-		session, err := s.store.UpdateScreenShare(kind, targetID, userID, *body.ScreenSharing)
+		if body.ShareAudio != nil && *body.ShareAudio && !*body.ScreenSharing {
+			s.respondError(w, http.StatusBadRequest, "shareAudio requires screenSharing.")
+			return
+		}
+
+		session, err := s.store.UpdateScreenShare(kind, targetID, userID, *body.ScreenSharing, body.ShareAudio)
+		if err != nil {
+			s.respondSessionError(w, err)
+			return
+		}
+
+		s.respondJSON(w, http.StatusOK, session)
+		return
+
+	case action == "camera" && r.Method == http.MethodPost:
+		if !s.store.enableVideo {
+			s.respondErrorCode(w, http.StatusNotFound, codeVoiceVideoDisabled, "Video is disabled.")
+			return
+		}
+
+		var body updateCameraRequest
+		if err := decodeJSONBody(r, s.bodyLimit(), &body); err != nil {
+			s.respondDecodeError(w, err)
+			return
+		}
+
+		if body.CameraOn == nil {
+			s.respondError(w, http.StatusBadRequest, "cameraOn must be a boolean.")
+			return
+		}
+
+		session, err := s.store.UpdateCamera(kind, targetID, userID, *body.CameraOn)
 		if err != nil {
-			s.respondError(w, sessionErrorStatus(err), err.Error())
+			s.respondSessionError(w, err)
 			return
 		}
 
@@ -755,53 +3353,194 @@ func (s *server) handleVoiceTarget(w http.ResponseWriter, r *http.Request, kind
 	s.respondError(w, http.StatusMethodNotAllowed, "Method not allowed.")
 }
 
-func parseTargetPath(path, prefix string) (string, string, error) {
+// requestUserID reads X-Voice-User-Id, responding with an error and
+// returning false when it is missing or not a valid id.
+func (s *server) requestUserID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	userID := strings.TrimSpace(r.Header.Get("X-Voice-User-Id"))
+	if userID == "" {
+		s.respondError(w, http.StatusUnauthorized, "Missing X-Voice-User-Id.")
+		return "", false
+	}
+	if !validID(userID) {
+		s.respondError(w, http.StatusBadRequest, "Invalid X-Voice-User-Id.")
+		return "", false
+	}
+	return userID, true
+}
+
+// targetRoute is a parsed /v1/voice/<kind>/:id[/...] path. Action is the
+// remainder of the path with the participant id replaced by ":userId", e.g.
+// "participants/:userId/mute".
+type targetRoute struct {
+	TargetID      string
+	Action        string
+	ParticipantID string
+}
+
+// maxIDLength bounds target, participant and user ids, which end up in
+// LiveKit room names and storage keys.
+const maxIDLength = 64
+
+var (
+	errInvalidTargetID      = errors.New("invalid target id")
+	errInvalidParticipantID = errors.New("invalid participant id")
+)
+
+// validID reports whether id is non-empty, at most maxIDLength bytes, and
+// made only of ASCII letters, digits, dashes and underscores.
+func validID(id string) bool {
+	if id == "" || len(id) > maxIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// parseJoinMode reads an X-Voice-Join-Mode header; empty means the default,
+// speaker.
+func parseJoinMode(header string) (voiceJoinMode, bool) {
+	switch mode := voiceJoinMode(strings.ToLower(strings.TrimSpace(header))); mode {
+	case "", joinModeSpeaker, joinModeSpectator:
+		return mode, true
+	}
+
+	return "", false
+}
+
+func parseTargetPath(path, prefix string) (targetRoute, error) {
 	trimmed := strings.TrimPrefix(path, prefix)
 	parts := strings.Split(strings.Trim(trimmed, "/"), "/")
 	if len(parts) == 0 || strings.TrimSpace(parts[0]) == "" {
-		return "", "", errors.New("missing target id")
+		return targetRoute{}, errors.New("missing target id")
 	}
 
 	decodedID, err := url.PathUnescape(parts[0])
-	if err != nil {
-		return "", "", errors.New("invalid target id")
+	if err != nil || !validID(decodedID) {
+		return targetRoute{}, errInvalidTargetID
 	}
 
-	action := ""
-	if len(parts) > 1 {
-		action = strings.TrimSpace(parts[1])
+	route := targetRoute{TargetID: decodedID}
+	if len(parts) > 4 {
+		return targetRoute{}, errors.New("invalid route")
 	}
 
-	if len(parts) > 2 {
-		return "", "", errors.New("invalid route")
+	actionParts := make([]string, 0, len(parts)-1)
+	for _, part := range parts[1:] {
+		actionParts = append(actionParts, strings.TrimSpace(part))
+	}
+
+	if len(actionParts) >= 2 && actionParts[0] == "participants" {
+		participantID, err := url.PathUnescape(actionParts[1])
+		if err != nil || !validID(participantID) {
+			return targetRoute{}, errInvalidParticipantID
+		}
+
+		route.ParticipantID = participantID
+		actionParts[1] = ":userId"
+	}
+
+	route.Action = strings.Join(actionParts, "/")
+	return route, nil
+}
+
+// errBodyTooLarge is wrapped by readBody for bodies over the limit, which
+// respondDecodeError answers with 413.
+var errBodyTooLarge = errors.New("Payload too large.")
+
+// readBody reads at most maxBytes of body, failing rather than truncating
+// when there is more.
+func readBody(body io.Reader, maxBytes int64) ([]byte, error) {
+	payload, err := io.ReadAll(io.LimitReader(body, maxBytes+1))
+	if err != nil {
+		return nil, errors.New("Failed to read request body.")
+	}
+	if int64(len(payload)) > maxBytes {
+		return nil, fmt.Errorf("%w Request body must be at most %d bytes.", errBodyTooLarge, maxBytes)
 	}
 
-	return decodedID, action, nil
+	return payload, nil
 }
 
-func decodeJSONBody[T any](body io.ReadCloser, out *T) error {
-	if body == nil {
+// decodeJSONBody decodes the request's JSON body into out. An empty body
+// leaves out alone and needs no Content-Type.
+func decodeJSONBody[T any](r *http.Request, maxBytes int64, out *T) error {
+	if r.Body == nil {
 		return nil
 	}
-	defer body.Close()
+	defer r.Body.Close()
 
-	payload, err := io.ReadAll(io.LimitReader(body, 1<<20))
+	payload, err := readBody(r.Body, maxBytes)
 	if err != nil {
-		return errors.New("Failed to read request body.")
+		return err
 	}
 
 	if len(bytes.TrimSpace(payload)) == 0 {
 		return nil
 	}
+	if err := checkJSONContentType(r.Header); err != nil {
+		return err
+	}
+
+	return unmarshalStrict(payload, out)
+}
+
+// errUnsupportedMediaType is wrapped by checkJSONContentType, which
+// respondDecodeError answers with 415.
+var errUnsupportedMediaType = errors.New("Unsupported Media Type.")
+
+// checkJSONContentType requires a JSON Content-Type, ignoring parameters
+// such as charset.
+func checkJSONContentType(header http.Header) error {
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		return fmt.Errorf("%w Content-Type must be application/json.", errUnsupportedMediaType)
+	}
+	return nil
+}
 
-	if err := json.Unmarshal(payload, out); err != nil {
+// unmarshalStrict decodes payload into out, refusing fields out doesn't
+// have so a misspelled field fails loudly instead of being ignored.
+func unmarshalStrict[T any](payload []byte, out *T) error {
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(out); err != nil {
+		if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			return fmt.Errorf("Unknown field %s.", field)
+		}
+		return errors.New("Invalid JSON body.")
+	}
+	if _, err := decoder.Token(); err != io.EOF {
 		return errors.New("Invalid JSON body.")
 	}
 
 	return nil
 }
 
+// respondDecodeError answers a failed decodeJSONBody or readBody: 413 for a
+// body over the limit, 415 for one that isn't JSON, 400 for anything else.
+func (s *server) respondDecodeError(w http.ResponseWriter, err error) {
+	status := http.StatusBadRequest
+	switch {
+	case errors.Is(err, errBodyTooLarge):
+		status = http.StatusRequestEntityTooLarge
+	case errors.Is(err, errUnsupportedMediaType):
+		status = http.StatusUnsupportedMediaType
+	}
+	s.respondError(w, status, err.Error())
+}
+
 func (s *server) respondOptions(w http.ResponseWriter) {
+	s.respondNoContent(w)
+}
+
+func (s *server) respondNoContent(w http.ResponseWriter) {
 	headers := s.corsHeaders()
 	for key, value := range headers {
 		w.Header().Set(key, value)
@@ -819,21 +3558,54 @@ func (s *server) respondJSON(w http.ResponseWriter, status int, payload any) {
 	_ = json.NewEncoder(w).Encode(payload)
 }
 
+// respondError answers with message and the generic code for status.
 func (s *server) respondError(w http.ResponseWriter, status int, message string) {
+	s.respondErrorCode(w, status, statusErrorCode(status), message)
+}
+
+func (s *server) respondErrorCode(w http.ResponseWriter, status int, code, message string) {
 	s.respondJSON(w, status, map[string]string{
+		"code":  code,
 		"error": message,
 	})
 }
 
+// respondSessionError answers a store error with its status and code.
+func (s *server) respondSessionError(w http.ResponseWriter, err error) {
+	s.respondErrorCode(w, sessionErrorStatus(err), sessionErrorCode(err), err.Error())
+}
+
 func (s *server) corsHeaders() map[string]string {
 	return map[string]string{
-		"Access-Control-Allow-Origin":  s.corsOrigin,
 		"Access-Control-Allow-Methods": "GET,POST,OPTIONS",
-		"Access-Control-Allow-Headers": "Content-Type, Authorization, Cookie, X-Voice-User-Id, X-Voice-Server-Id, X-Voice-Target-Kind, X-Voice-Target-Id, X-Screen-Share-Enabled",
+		"Access-Control-Allow-Headers": "Content-Type, Authorization, Cookie, X-Voice-User-Id, X-Voice-Server-Id, X-Voice-Target-Kind, X-Voice-Target-Id, X-Voice-Moderator, X-Voice-Can-Publish, X-Screen-Share-Enabled, X-Voice-Reconnect-Grace-Ms, X-Voice-Region, X-Voice-Join-Mode, X-Voice-Start-Reason, X-Voice-Participant-Metadata, X-Voice-Connection-Id, Idempotency-Key, X-Request-Id",
 		"Access-Control-Max-Age":       "86400",
 	}
 }
 
+// parseRegionURLs reads LIVEKIT_REGION_URLS, a JSON object of region to
+// LiveKit WebSocket URL. Regions are matched case-insensitively.
+func parseRegionURLs(raw string) (map[string]string, error) {
+	regionURLs := map[string]string{}
+	if strings.TrimSpace(raw) == "" {
+		return regionURLs, nil
+	}
+
+	var parsed map[string]string
+	if err := json.Unmarshal([]byte(raw), &parsed); err != nil {
+		return nil, err
+	}
+	for region, wsURL := range parsed {
+		region = strings.ToLower(strings.TrimSpace(region))
+		wsURL = strings.TrimSpace(wsURL)
+		if region == "" || wsURL == "" {
+			return nil, fmt.Errorf("region %q has no URL", region)
+		}
+		regionURLs[region] = wsURL
+	}
+	return regionURLs, nil
+}
+
 func getEnv(key, fallback string) string {
 	if value, ok := os.LookupEnv(key); ok && value != "" {
 		return value
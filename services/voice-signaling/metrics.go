@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// voiceMetrics uses its own registry rather than the global default so each
+// store (and each test) gets an isolated set of series.
+type voiceMetrics struct {
+	registry          *prometheus.Registry
+	joins             prometheus.Counter
+	leaves            prometheus.Counter
+	cleanupRemoved    prometheus.Counter
+	tokenSignDuration prometheus.Histogram
+	// publishFailures counts realtime events that never reached the
+	// gateway, by reason: error or dropped.
+	publishFailures *prometheus.CounterVec
+}
+
+func newVoiceMetrics() *voiceMetrics {
+	m := &voiceMetrics{
+		registry: prometheus.NewRegistry(),
+		joins: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "voice_joins_total",
+			Help: "Participants newly added to a voice session.",
+		}),
+		leaves: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "voice_leaves_total",
+			Help: "Participants removed by leave, kick, channel switch, idle timeout, or LiveKit webhook.",
+		}),
+		cleanupRemoved: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "voice_cleanup_removed_total",
+			Help: "Participants removed after their reconnect grace expired.",
+		}),
+		tokenSignDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "voice_token_sign_duration_seconds",
+			Help:    "Time spent signing LiveKit participant tokens.",
+			Buckets: prometheus.ExponentialBuckets(0.00005, 2, 12),
+		}),
+		publishFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "voice_publish_failures_total",
+			Help: "Realtime events not delivered to the gateway, by reason: error or dropped when the queue was full.",
+		}, []string{"reason"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.joins,
+		m.leaves,
+		m.cleanupRemoved,
+		m.tokenSignDuration,
+		m.publishFailures,
+	)
+
+	return m
+}
+
+func (m *voiceMetrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+var (
+	voiceSessionsActiveDesc = prometheus.NewDesc(
+		"voice_sessions_active",
+		"Voice sessions with at least one participant.",
+		nil, nil,
+	)
+	voiceParticipantsActiveDesc = prometheus.NewDesc(
+		"voice_participants_active",
+		"Participants across all voice sessions.",
+		nil, nil,
+	)
+)
+
+// watchBackend registers the scrape-time session and participant counts for
+// backend. Counting at scrape time keeps the gauges right when other
+// instances share the backend.
+func (m *voiceMetrics) watchBackend(backend voiceBackend) {
+	m.registry.MustRegister(voiceSessionsCollector{backend: backend})
+}
+
+type voiceSessionsCollector struct {
+	backend voiceBackend
+}
+
+func (c voiceSessionsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- voiceSessionsActiveDesc
+	ch <- voiceParticipantsActiveDesc
+}
+
+func (c voiceSessionsCollector) Collect(ch chan<- prometheus.Metric) {
+	sessions, participants := 0, 0
+	err := c.backend.view(func(st voiceState) error {
+		records, err := st.sessions()
+		if err != nil {
+			return err
+		}
+
+		sessions = len(records)
+		for _, record := range records {
+			participants += len(record.Participants)
+		}
+		return nil
+	})
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(voiceSessionsActiveDesc, err)
+		ch <- prometheus.NewInvalidMetric(voiceParticipantsActiveDesc, err)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(voiceSessionsActiveDesc, prometheus.GaugeValue, float64(sessions))
+	ch <- prometheus.MustNewConstMetric(voiceParticipantsActiveDesc, prometheus.GaugeValue, float64(participants))
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestVoiceMetricsScrape(t *testing.T) {
+	store := newTestVoiceStore(noopPublisher{})
+	if _, err := store.Join(targetChannel, "chn_1", "usr_1", nil, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	if _, err := store.Join(targetChannel, "chn_1", "usr_2", nil, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	if _, err := store.Leave(targetChannel, "chn_1", "usr_2"); err != nil {
+		t.Fatalf("leave: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	store.metrics.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+
+	body, _ := io.ReadAll(rec.Body)
+	for _, line := range []string{
+		"voice_joins_total 2",
+		"voice_leaves_total 1",
+		"voice_sessions_active 1",
+		"voice_participants_active 1",
+		"voice_cleanup_removed_total 0",
+		"voice_token_sign_duration_seconds_count",
+	} {
+		if !strings.Contains(string(body), line) {
+			t.Fatalf("expected %q in scrape output:\n%s", line, body)
+		}
+	}
+}
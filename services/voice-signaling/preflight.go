@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+type preflightRequest struct {
+	// Hold reserves the slot for the join that follows.
+	Hold bool `json:"hold"`
+}
+
+// joinPreflight answers whether a join would be let in right now. Reason is
+// set when it wouldn't: banned, locked, full or server_full.
+type joinPreflight struct {
+	CanJoin       bool    `json:"canJoin"`
+	Reason        *string `json:"reason"`
+	Message       *string `json:"message"`
+	HoldExpiresAt *string `json:"holdExpiresAt"`
+}
+
+// preflightReason names the join gate err comes from, or reports false for
+// errors that aren't a refusal.
+func preflightReason(err error) (string, bool) {
+	switch {
+	case errors.Is(err, errVoiceBanned):
+		return "banned", true
+	case errors.Is(err, errVoiceSessionLocked):
+		return "locked", true
+	case errors.Is(err, errVoiceSessionFull):
+		return "full", true
+	case errors.Is(err, errVoiceServerFull):
+		return "server_full", true
+	}
+
+	return "", false
+}
+
+func refusedPreflight(reason string, err error) joinPreflight {
+	message := err.Error()
+	return joinPreflight{Reason: &reason, Message: &message}
+}
+
+type joinHold struct {
+	mode      voiceJoinMode
+	expiresAt time.Time
+}
+
+// joinHolds keeps the slots reserved by preflights, counted against the
+// session's capacity until the user joins or the hold runs out. Like the join
+// replay cache it is per instance: a join landing on another replica doesn't
+// see holds taken here.
+type joinHolds struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	byTarget map[string]map[string]joinHold
+}
+
+// newJoinHolds returns holds lasting ttl; a non-positive ttl disables them.
+func newJoinHolds(ttl time.Duration) *joinHolds {
+	return &joinHolds{
+		ttl:      ttl,
+		byTarget: map[string]map[string]joinHold{},
+	}
+}
+
+// Hold reserves a slot in mode at key for userID, replacing any earlier hold
+// of theirs, and returns when it runs out.
+func (h *joinHolds) Hold(key, userID string, mode voiceJoinMode, now time.Time) (time.Time, bool) {
+	if h.ttl <= 0 {
+		return time.Time{}, false
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	holds, ok := h.byTarget[key]
+	if !ok {
+		holds = map[string]joinHold{}
+		h.byTarget[key] = holds
+	}
+	expiresAt := now.Add(h.ttl)
+	holds[userID] = joinHold{mode: mode, expiresAt: expiresAt}
+	return expiresAt, true
+}
+
+// Release drops userID's hold at key, once they have joined.
+func (h *joinHolds) Release(key, userID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.byTarget[key], userID)
+	if len(h.byTarget[key]) == 0 {
+		delete(h.byTarget, key)
+	}
+}
+
+// Count returns the live holds in mode on record's session, leaving out
+// userID's own and those of users already in the session.
+func (h *joinHolds) Count(record *sessionRecord, mode voiceJoinMode, userID string, now time.Time) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	count := 0
+	for holderID, hold := range h.byTarget[targetKey(record.TargetKind, record.TargetID)] {
+		if _, joined := record.Participants[holderID]; joined || holderID == userID {
+			continue
+		}
+		if hold.mode == mode && now.Before(hold.expiresAt) {
+			count++
+		}
+	}
+	return count
+}
+
+// Expire drops every hold that has run out.
+func (h *joinHolds) Expire(now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for key, holds := range h.byTarget {
+		for userID, hold := range holds {
+			if !now.Before(hold.expiresAt) {
+				delete(holds, userID)
+			}
+		}
+		if len(holds) == 0 {
+			delete(h.byTarget, key)
+		}
+	}
+}
+
+// runExpiry calls Expire every interval until ctx is cancelled. Every
+// instance runs it, as each keeps its own holds.
+func (h *joinHolds) runExpiry(ctx context.Context, interval time.Duration, clock Clock) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		h.Expire(clock.Now())
+	}
+}
+
+// Preflight runs the gates a join in mode would go through without joining,
+// and with hold reserves the slot for the join that follows. The server
+// session cap is checked as if the user weren't in any session yet.
+func (s *voiceStore) Preflight(kind voiceTargetKind, targetID, userID string, serverID *string, mode voiceJoinMode, hold bool) (joinPreflight, error) {
+	now := s.clock.Now().UTC()
+	key := targetKey(kind, targetID)
+	if mode != joinModeSpectator {
+		mode = joinModeSpeaker
+	}
+
+	banned, err := s.backend.banned(key, userID, now)
+	if err != nil {
+		return joinPreflight{}, err
+	}
+	if banned {
+		return refusedPreflight("banned", errVoiceBanned), nil
+	}
+
+	var result joinPreflight
+	// Run as an update, with nothing to write, so two preflights can't both
+	// take the last slot. Holding is idempotent, so a retried attempt may
+	// repeat it.
+	err = s.backend.update(func(st voiceState) error {
+		record, err := st.session(key)
+		if err != nil {
+			return err
+		}
+
+		err = s.checkPreflight(st, record, kind, targetID, userID, serverID, mode, now)
+		if reason, refused := preflightReason(err); refused {
+			result = refusedPreflight(reason, err)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		result = joinPreflight{CanJoin: true}
+		if hold {
+			if expiresAt, ok := s.holds.Hold(key, userID, mode, now); ok {
+				formatted := expiresAt.Format(time.RFC3339Nano)
+				result.HoldExpiresAt = &formatted
+			}
+		}
+		return nil
+	})
+
+	return result, err
+}
+
+// checkPreflight mirrors the gates in Join.
+func (s *voiceStore) checkPreflight(st voiceState, record *sessionRecord, kind voiceTargetKind, targetID, userID string, serverID *string, mode voiceJoinMode, now time.Time) error {
+	if record == nil {
+		if err := s.checkServerSessions(st, serverID); err != nil {
+			return err
+		}
+		record = &sessionRecord{TargetKind: kind, TargetID: targetID, Participants: map[string]*participantRecord{}}
+	}
+
+	participant, exists := record.Participants[userID]
+	if record.Locked && !exists {
+		return errVoiceSessionLocked
+	}
+	if exists && participant.joinMode() == mode {
+		return nil
+	}
+
+	return s.checkJoinCapacity(record, mode, userID, now)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func preflight(t *testing.T, s *server, channelID, userID, body string) joinPreflight {
+	t.Helper()
+
+	rec := postVoiceAction(t, s, channelID+"/preflight", userID, body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("preflight: expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var result joinPreflight
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	return result
+}
+
+func TestVoicePreflightAllowsJoin(t *testing.T) {
+	store := newTestVoiceStore(noopPublisher{})
+	s := &server{store: store}
+
+	result := preflight(t, s, "chn_1", "usr_1", "")
+	if !result.CanJoin || result.Reason != nil || result.HoldExpiresAt != nil {
+		t.Fatalf("expected an empty channel to be joinable without a hold, got %+v", result)
+	}
+	if session, err := store.Get(targetChannel, "chn_1", "usr_1"); err != nil || session != nil {
+		t.Fatalf("expected the preflight not to start a session, got %+v (%v)", session, err)
+	}
+	if rec := postVoiceAction(t, s, "chn_1/preflight", "usr_1", "{}"); rec.Code != http.StatusOK {
+		t.Fatalf("expected an empty object to be accepted, got %d", rec.Code)
+	}
+}
+
+func TestVoicePreflightRefusals(t *testing.T) {
+	cfg := testVoiceStoreConfig(noopPublisher{})
+	cfg.MaxSpeakers = 2
+	cfg.MaxServerSessions = 1
+	store := newVoiceStore(cfg)
+	s := &server{store: store}
+
+	serverID := "srv_1"
+	for _, userID := range []string{"usr_mod", "usr_1"} {
+		if _, err := store.Join(targetChannel, "chn_1", userID, &serverID, true, joinVoiceRequest{}); err != nil {
+			t.Fatalf("join %s: %v", userID, err)
+		}
+	}
+
+	check := func(userID, channelID, want string) {
+		t.Helper()
+		result := preflight(t, s, channelID, userID, `{"hold":true}`)
+		if result.CanJoin || result.Reason == nil || *result.Reason != want || result.Message == nil || result.HoldExpiresAt != nil {
+			t.Fatalf("expected %s to be refused as %s without a hold, got %+v", userID, want, result)
+		}
+	}
+
+	check("usr_2", "chn_1", "full")
+	if result := preflight(t, s, "chn_1", "usr_1", ""); !result.CanJoin {
+		t.Fatalf("expected a participant to pass their own preflight, got %+v", result)
+	}
+
+	if _, err := store.Join(targetChannel, "chn_2", "usr_3", &serverID, true, joinVoiceRequest{}); !errors.Is(err, errVoiceServerFull) {
+		t.Fatalf("expected the server cap to be reached, got %v", err)
+	}
+	result, err := store.Preflight(targetChannel, "chn_2", "usr_3", &serverID, joinModeSpeaker, false)
+	if err != nil || result.Reason == nil || *result.Reason != "server_full" {
+		t.Fatalf("expected server_full, got %+v (%v)", result, err)
+	}
+
+	if _, err := store.SetLocked(targetChannel, "chn_1", "usr_mod", true); err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+	check("usr_2", "chn_1", "locked")
+
+	if _, err := store.Ban(targetChannel, "chn_1", "usr_mod", "usr_1"); err != nil {
+		t.Fatalf("ban: %v", err)
+	}
+	check("usr_1", "chn_1", "banned")
+}
+
+func TestVoicePreflightHoldCountsTowardCapacity(t *testing.T) {
+	cfg := testVoiceStoreConfig(noopPublisher{})
+	cfg.MaxSpeakers = 2
+	cfg.JoinHoldTTL = 10 * time.Second
+	store := newVoiceStore(cfg)
+	s := &server{store: store}
+	clock := store.clock.(*fakeClock)
+
+	if _, err := store.Join(targetChannel, "chn_1", "usr_1", nil, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	held := preflight(t, s, "chn_1", "usr_2", `{"hold":true}`)
+	if !held.CanJoin || held.HoldExpiresAt == nil {
+		t.Fatalf("expected a hold, got %+v", held)
+	}
+
+	// The held slot is the last one: nobody else gets it, by preflight or
+	// by joining, while the hold lasts.
+	if result := preflight(t, s, "chn_1", "usr_3", ""); result.CanJoin || *result.Reason != "full" {
+		t.Fatalf("expected the hold to fill the session, got %+v", result)
+	}
+	if _, err := store.Join(targetChannel, "chn_1", "usr_3", nil, true, joinVoiceRequest{}); !errors.Is(err, errVoiceSessionFull) {
+		t.Fatalf("expected a join to be refused while the hold lasts, got %v", err)
+	}
+	if result := preflight(t, s, "chn_1", "usr_2", ""); !result.CanJoin {
+		t.Fatalf("expected the holder to pass again, got %+v", result)
+	}
+
+	clock.Advance(10 * time.Second)
+	store.holds.Expire(clock.Now())
+	if len(store.holds.byTarget) != 0 {
+		t.Fatalf("expected the expired hold to be dropped, got %+v", store.holds.byTarget)
+	}
+	if _, err := store.Join(targetChannel, "chn_1", "usr_3", nil, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("expected the slot back once the hold expired: %v", err)
+	}
+}
+
+func TestVoicePreflightHoldReleasedOnJoin(t *testing.T) {
+	cfg := testVoiceStoreConfig(noopPublisher{})
+	cfg.MaxSpeakers = 1
+	cfg.JoinHoldTTL = 10 * time.Second
+	store := newVoiceStore(cfg)
+	s := &server{store: store}
+
+	if held := preflight(t, s, "chn_1", "usr_1", `{"hold":true}`); held.HoldExpiresAt == nil {
+		t.Fatalf("expected a hold, got %+v", held)
+	}
+	if _, err := store.Join(targetChannel, "chn_1", "usr_1", nil, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("expected the holder to join: %v", err)
+	}
+	if len(store.holds.byTarget) != 0 {
+		t.Fatalf("expected the join to use up the hold, got %+v", store.holds.byTarget)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// publishWorkers is how many deliveries to the gateway run at once.
+const publishWorkers = 4
+
+// publisher pushes events to realtime-gateway topics. Implementations must not
+// block the caller; voice requests never wait on event delivery.
+type publisher interface {
+	Publish(topic, eventType string, payload any)
+}
+
+type noopPublisher struct{}
+
+func (noopPublisher) Publish(string, string, any) {}
+
+type gatewayEvent struct {
+	eventType string
+	body      []byte
+}
+
+// gatewayPublisher delivers events from a bounded queue, so a slow or
+// unreachable gateway costs dropped events rather than goroutines piling up
+// behind request handlers. Failed and dropped deliveries are logged and
+// counted in failures, by reason.
+type gatewayPublisher struct {
+	endpoint    string
+	internalKey string
+	client      *http.Client
+	queue       chan gatewayEvent
+	failures    *prometheus.CounterVec
+	inFlight    sync.WaitGroup
+}
+
+// newGatewayPublisher returns a publisher queueing up to queueSize events
+// for the gateway at gatewayURL; with no URL it returns a no-op.
+func newGatewayPublisher(gatewayURL, internalKey string, timeout time.Duration, queueSize int, failures *prometheus.CounterVec) publisher {
+	baseURL := strings.TrimRight(strings.TrimSpace(gatewayURL), "/")
+	if baseURL == "" {
+		return noopPublisher{}
+	}
+
+	p := &gatewayPublisher{
+		endpoint:    baseURL + "/internal/publish",
+		internalKey: strings.TrimSpace(internalKey),
+		client:      &http.Client{Timeout: timeout},
+		queue:       make(chan gatewayEvent, max(queueSize, 1)),
+		failures:    failures,
+	}
+	for range publishWorkers {
+		go p.deliver()
+	}
+	return p
+}
+
+func (p *gatewayPublisher) Publish(topic, eventType string, payload any) {
+	encoded, err := json.Marshal(map[string]any{
+		"topic":   topic,
+		"type":    eventType,
+		"payload": payload,
+	})
+	if err != nil {
+		slog.Error("failed to encode realtime event", "type", eventType, "error", err)
+		return
+	}
+
+	p.inFlight.Add(1)
+	select {
+	case p.queue <- gatewayEvent{eventType: eventType, body: encoded}:
+	default:
+		p.inFlight.Done()
+		p.failures.WithLabelValues("dropped").Inc()
+		slog.Warn("realtime event queue full, dropping event", "type", eventType)
+	}
+}
+
+func (p *gatewayPublisher) deliver() {
+	for event := range p.queue {
+		if !p.send(event.eventType, event.body) {
+			p.failures.WithLabelValues("error").Inc()
+		}
+		p.inFlight.Done()
+	}
+}
+
+// Drain waits for deliveries already handed to the publisher, giving up when
+// ctx ends.
+func (p *gatewayPublisher) Drain(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		p.inFlight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
+// drainPublisher drains p if it delivers in the background.
+func drainPublisher(ctx context.Context, p publisher) {
+	if drainer, ok := p.(interface{ Drain(context.Context) }); ok {
+		drainer.Drain(ctx)
+	}
+}
+
+// send posts one event to the gateway and reports whether it was accepted.
+func (p *gatewayPublisher) send(eventType string, body []byte) bool {
+	req, err := http.NewRequest(http.MethodPost, p.endpoint, bytes.NewReader(body))
+	if err != nil {
+		slog.Error("failed to build realtime event", "type", eventType, "error", err)
+		return false
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if p.internalKey != "" {
+		req.Header.Set("X-Realtime-Internal-Key", p.internalKey)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		slog.Error("failed to publish realtime event", "type", eventType, "error", err)
+		return false
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		slog.Error("failed to publish realtime event", "type", eventType, "status", resp.StatusCode)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestVoiceSurvivesGatewayOutage(t *testing.T) {
+	var hits atomic.Int64
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer gateway.Close()
+
+	cfg := testVoiceStoreConfig(noopPublisher{})
+	cfg.Publisher = newGatewayPublisher(gateway.URL, "", time.Second, 8, cfg.Metrics.publishFailures)
+	s := &server{store: newVoiceStore(cfg)}
+
+	if rec := postVoiceAction(t, s, "chn_1/join", "usr_1", "{}"); rec.Code != http.StatusOK {
+		t.Fatalf("expected the join to succeed while the gateway is down, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	drainPublisher(context.Background(), cfg.Publisher)
+	if hits.Load() == 0 {
+		t.Fatal("expected the join to be published")
+	}
+	if got := testutil.ToFloat64(cfg.Metrics.publishFailures.WithLabelValues("error")); got != float64(hits.Load()) {
+		t.Fatalf("expected %d failed publishes, got %v", hits.Load(), got)
+	}
+}
+
+func TestGatewayPublisherDropsWhenQueueFull(t *testing.T) {
+	received := make(chan struct{}, publishWorkers)
+	release := make(chan struct{})
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		received <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer gateway.Close()
+
+	metrics := newVoiceMetrics()
+	pub := newGatewayPublisher(gateway.URL, "", time.Second, 1, metrics.publishFailures)
+
+	// Tie up every worker, fill the one queue slot, then overflow it.
+	for range publishWorkers {
+		pub.Publish("voice:channel:chn_1", "voice.participants.updated", nil)
+		<-received
+	}
+	pub.Publish("voice:channel:chn_1", "voice.participants.updated", nil)
+	pub.Publish("voice:channel:chn_1", "voice.participants.updated", nil)
+	if got := testutil.ToFloat64(metrics.publishFailures.WithLabelValues("dropped")); got != 1 {
+		t.Fatalf("expected one dropped event, got %v", got)
+	}
+
+	close(release)
+	drainPublisher(context.Background(), pub)
+	if got := testutil.ToFloat64(metrics.publishFailures.WithLabelValues("error")); got != 0 {
+		t.Fatalf("expected the queued events to be delivered, got %v failures", got)
+	}
+}
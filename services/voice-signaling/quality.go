@@ -0,0 +1,72 @@
+package main
+
+import "time"
+
+// connectionQualityFreshness is how long a client's quality report holds.
+// Without a newer one the participant reads as good again, so a client that
+// stopped reporting after a rough patch isn't shown as weak forever.
+const connectionQualityFreshness = 30 * time.Second
+
+type voiceConnectionQuality string
+
+const (
+	connectionQualityGood voiceConnectionQuality = "good"
+	connectionQualityPoor voiceConnectionQuality = "poor"
+	connectionQualityLost voiceConnectionQuality = "lost"
+)
+
+func validConnectionQuality(quality voiceConnectionQuality) bool {
+	switch quality {
+	case connectionQualityGood, connectionQualityPoor, connectionQualityLost:
+		return true
+	}
+	return false
+}
+
+type connectionQualityRequest struct {
+	Quality voiceConnectionQuality `json:"quality"`
+}
+
+// connectionQuality is the participant's last reported quality, or good when
+// they never reported one or the report is older than the freshness window.
+func (p *participantRecord) connectionQuality(now time.Time) voiceConnectionQuality {
+	if p.ConnectionQuality == "" || p.ConnectionQualityAt == nil {
+		return connectionQualityGood
+	}
+	if now.Sub(*p.ConnectionQualityAt) >= connectionQualityFreshness {
+		return connectionQualityGood
+	}
+	return p.ConnectionQuality
+}
+
+// ReportConnectionQuality stores the quality the user's client measured on
+// its own SFU connection. Repeating the current quality only refreshes the
+// report, so steady clients don't publish an update every time.
+func (s *voiceStore) ReportConnectionQuality(kind voiceTargetKind, targetID, userID string, quality voiceConnectionQuality) (voiceSession, error) {
+	now := s.clock.Now().UTC()
+	key := targetKey(kind, targetID)
+
+	var session voiceSession
+	err := s.backend.update(func(st voiceState) error {
+		record, participant, err := connectedParticipant(st, key, userID)
+		if err != nil {
+			return err
+		}
+
+		previous := participant.connectionQuality(now)
+		participant.ConnectionQuality = quality
+		reportedAt := now
+		participant.ConnectionQualityAt = &reportedAt
+
+		participant.LastSeenAt = now
+		record.UpdatedAt = now
+		if quality != previous {
+			s.publishSession(st, record)
+		}
+
+		session, err = s.buildSession(record, userID)
+		return err
+	})
+
+	return session, err
+}
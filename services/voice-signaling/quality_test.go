@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestVoiceConnectionQualityReport(t *testing.T) {
+	pub := &recordingPublisher{}
+	store := newTestVoiceStore(pub)
+	s := &server{store: store}
+	for _, userID := range []string{"usr_1", "usr_2"} {
+		if _, err := store.Join(targetChannel, "chn_1", userID, nil, true, joinVoiceRequest{}); err != nil {
+			t.Fatalf("join %s: %v", userID, err)
+		}
+	}
+	pub.take()
+
+	session, err := store.Get(targetChannel, "chn_1", "usr_1")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if participant := findParticipant(t, session.Participants, "usr_1"); participant.ConnectionQuality != connectionQualityGood {
+		t.Fatalf("expected participants to start out good, got %q", participant.ConnectionQuality)
+	}
+
+	if rec := postVoiceAction(t, s, "chn_1/quality", "usr_1", `{"quality":"awful"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected an unknown quality to be rejected, got %d", rec.Code)
+	}
+	if rec := postVoiceAction(t, s, "chn_1/quality", "usr_1", `{"quality":"poor"}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected the report to be accepted, got %d", rec.Code)
+	}
+
+	events := pub.take()
+	if len(events) != 1 {
+		t.Fatalf("expected the change to publish once, got %d events", len(events))
+	}
+	event := events[0].Payload.(voiceSessionEvent)
+	if participant := findParticipant(t, event.Participants, "usr_1"); participant.ConnectionQuality != connectionQualityPoor {
+		t.Fatalf("expected the event to carry the poor quality, got %q", participant.ConnectionQuality)
+	}
+	if participant := findParticipant(t, event.Participants, "usr_2"); participant.ConnectionQuality != connectionQualityGood {
+		t.Fatalf("expected other participants to stay good, got %q", participant.ConnectionQuality)
+	}
+
+	if _, err := store.ReportConnectionQuality(targetChannel, "chn_1", "usr_1", connectionQualityPoor); err != nil {
+		t.Fatalf("repeat report: %v", err)
+	}
+	if events := pub.take(); len(events) != 0 {
+		t.Fatalf("expected an unchanged quality not to publish, got %d events", len(events))
+	}
+
+	if _, err := store.ReportConnectionQuality(targetChannel, "chn_1", "usr_9", connectionQualityLost); err != errVoiceNotConnected {
+		t.Fatalf("expected non-participants to be rejected, got %v", err)
+	}
+}
+
+func TestVoiceConnectionQualityResetsWhenStale(t *testing.T) {
+	store := newTestVoiceStore(noopPublisher{})
+	clock := store.clock.(*fakeClock)
+	if _, err := store.Join(targetChannel, "chn_1", "usr_1", nil, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+
+	if _, err := store.ReportConnectionQuality(targetChannel, "chn_1", "usr_1", connectionQualityLost); err != nil {
+		t.Fatalf("report: %v", err)
+	}
+
+	clock.Advance(connectionQualityFreshness - time.Second)
+	session, err := store.Get(targetChannel, "chn_1", "usr_1")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if participant := findParticipant(t, session.Participants, "usr_1"); participant.ConnectionQuality != connectionQualityLost {
+		t.Fatalf("expected a fresh report to hold, got %q", participant.ConnectionQuality)
+	}
+
+	clock.Advance(time.Second)
+	session, err = store.Get(targetChannel, "chn_1", "usr_1")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if participant := findParticipant(t, session.Participants, "usr_1"); participant.ConnectionQuality != connectionQualityGood {
+		t.Fatalf("expected a stale report to read as good, got %q", participant.ConnectionQuality)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// reactionInterval is the minimum gap between two reactions from one user.
+const reactionInterval = time.Second
+
+var errVoiceReactionRateLimited = errors.New("reacting too quickly, slow down")
+
+// allowedReactions is the fixed set of emoji clients can send; anything else
+// is rejected rather than relayed to the room.
+var allowedReactions = map[string]bool{
+	"👍":  true,
+	"👎":  true,
+	"❤️": true,
+	"😂":  true,
+	"😮":  true,
+	"🎉":  true,
+	"👏":  true,
+}
+
+type voiceReactionEvent struct {
+	SessionID  string          `json:"sessionId"`
+	TargetKind voiceTargetKind `json:"targetKind"`
+	TargetID   string          `json:"targetId"`
+	UserID     string          `json:"userId"`
+	Emoji      string          `json:"emoji"`
+}
+
+// reactionLimiter remembers when each user last reacted. Reactions are
+// transient, so the limit is per instance and never stored in the backend.
+type reactionLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	lastAt   map[string]time.Time
+	prunedAt time.Time
+}
+
+func newReactionLimiter(interval time.Duration) *reactionLimiter {
+	return &reactionLimiter{
+		interval: interval,
+		lastAt:   map[string]time.Time{},
+	}
+}
+
+// Allow records a reaction from userID at now, unless their previous one was
+// less than the interval ago.
+func (l *reactionLimiter) Allow(userID string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Entries older than the interval no longer limit anyone, so drop them
+	// every so often rather than letting the map grow with every reactor.
+	if now.Sub(l.prunedAt) >= time.Minute {
+		for id, at := range l.lastAt {
+			if now.Sub(at) >= l.interval {
+				delete(l.lastAt, id)
+			}
+		}
+		l.prunedAt = now
+	}
+
+	if last, ok := l.lastAt[userID]; ok && now.Sub(last) < l.interval {
+		return false
+	}
+
+	l.lastAt[userID] = now
+	return true
+}
+
+// React relays an emoji reaction from a connected participant to the room.
+// Nothing about the session changes.
+func (s *voiceStore) React(kind voiceTargetKind, targetID, userID, emoji string) (voiceReactionEvent, error) {
+	key := targetKey(kind, targetID)
+
+	var event voiceReactionEvent
+	err := s.backend.view(func(st voiceState) error {
+		record, _, err := connectedParticipant(st, key, userID)
+		if err != nil {
+			return err
+		}
+
+		event = voiceReactionEvent{
+			SessionID:  record.ID,
+			TargetKind: record.TargetKind,
+			TargetID:   record.TargetID,
+			UserID:     userID,
+			Emoji:      emoji,
+		}
+		return nil
+	})
+	if err != nil {
+		return voiceReactionEvent{}, err
+	}
+
+	if !s.reactions.Allow(userID, s.clock.Now()) {
+		return voiceReactionEvent{}, errVoiceReactionRateLimited
+	}
+
+	s.publisher.Publish(sessionTopic(kind, targetID), "voice.reaction", event)
+	return event, nil
+}
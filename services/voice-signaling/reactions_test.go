@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestVoiceReactionPublishesWithoutChangingSession(t *testing.T) {
+	pub := &recordingPublisher{}
+	store := newTestVoiceStore(pub)
+	if _, err := store.Join(targetChannel, "chn_1", "usr_1", nil, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	pub.take()
+	updatedAt := memoryOf(store).sessionsByTarget[targetKey(targetChannel, "chn_1")].UpdatedAt
+
+	if _, err := store.React(targetChannel, "chn_1", "usr_1", "👍"); err != nil {
+		t.Fatalf("react: %v", err)
+	}
+
+	events := pub.take()
+	if len(events) != 1 || events[0].Topic != "voice:channel:chn_1" || events[0].EventType != "voice.reaction" {
+		t.Fatalf("expected one reaction event on the session topic, got %+v", events)
+	}
+	event, ok := events[0].Payload.(voiceReactionEvent)
+	if !ok || event.UserID != "usr_1" || event.Emoji != "👍" || event.TargetID != "chn_1" {
+		t.Fatalf("unexpected reaction payload %+v", events[0].Payload)
+	}
+	if got := memoryOf(store).sessionsByTarget[targetKey(targetChannel, "chn_1")].UpdatedAt; !got.Equal(updatedAt) {
+		t.Fatal("expected reactions to leave the session untouched")
+	}
+
+	if _, err := store.React(targetChannel, "chn_1", "usr_9", "👍"); err != errVoiceNotConnected {
+		t.Fatalf("expected non-participants to be rejected, got %v", err)
+	}
+}
+
+func TestVoiceReactionRateLimit(t *testing.T) {
+	pub := &recordingPublisher{}
+	store := newTestVoiceStore(pub)
+	clock := store.clock.(*fakeClock)
+	s := &server{store: store}
+	for _, userID := range []string{"usr_1", "usr_2"} {
+		if _, err := store.Join(targetChannel, "chn_1", userID, nil, true, joinVoiceRequest{}); err != nil {
+			t.Fatalf("join %s: %v", userID, err)
+		}
+	}
+	pub.take()
+
+	if rec := postVoiceAction(t, s, "chn_1/react", "usr_1", `{"emoji":"🐍"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected emoji outside the allow-list to be rejected, got %d", rec.Code)
+	}
+	if rec := postVoiceAction(t, s, "chn_1/react", "usr_1", `{"emoji":"❤️"}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected the first reaction to pass, got %d", rec.Code)
+	}
+
+	clock.Advance(500 * time.Millisecond)
+	rec := postVoiceAction(t, s, "chn_1/react", "usr_1", `{"emoji":"❤️"}`)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "1" {
+		t.Fatalf("expected a second reaction within a second to be limited, got %d (Retry-After %q)", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := postVoiceAction(t, s, "chn_1/react", "usr_2", `{"emoji":"❤️"}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected the limit to be per user, got %d", rec.Code)
+	}
+
+	clock.Advance(500 * time.Millisecond)
+	if rec := postVoiceAction(t, s, "chn_1/react", "usr_1", `{"emoji":"🎉"}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected reactions to resume after a second, got %d", rec.Code)
+	}
+	if events := pub.take(); len(events) != 3 {
+		t.Fatalf("expected only admitted reactions to publish, got %d", len(events))
+	}
+}
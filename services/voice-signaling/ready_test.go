@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadyChecksLivekitCredentials(t *testing.T) {
+	ready := func(s *server) (int, map[string]string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		s.handleReady(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+
+		var body struct {
+			Checks map[string]string `json:"checks"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return rec.Code, body.Checks
+	}
+
+	s := &server{store: newTestVoiceStore(noopPublisher{})}
+	if code, checks := ready(s); code != http.StatusOK || checks["livekit"] != "ok" || checks["backend"] != "ok" {
+		t.Fatalf("expected ready, got %d %v", code, checks)
+	}
+
+	unconfigured, err := newLivekitSigner("", "", "")
+	if err != nil {
+		t.Fatalf("newLivekitSigner: %v", err)
+	}
+	cfg := testVoiceStoreConfig(noopPublisher{})
+	cfg.Signer = unconfigured
+	s = &server{store: newVoiceStore(cfg)}
+	if code, checks := ready(s); code != http.StatusServiceUnavailable || checks["livekit"] == "ok" || checks["backend"] != "ok" {
+		t.Fatalf("expected missing credentials to be reported, got %d %v", code, checks)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+var (
+	errVoiceAlreadyRecording     = errors.New("voice session is already being recorded")
+	errVoiceNotRecording         = errors.New("voice session is not being recorded")
+	errVoiceRecordingUnavailable = errors.New("recording service unavailable")
+)
+
+// recorder starts and stops room recordings. LiveKit egress is the intended
+// implementation; until it is wired up the service runs with noopRecorder.
+type recorder interface {
+	// StartRecording begins recording room and returns the id that stops it.
+	StartRecording(room string) (string, error)
+	StopRecording(recordingID string) error
+}
+
+// noopRecorder accepts every request without recording anything, so the
+// session state and indicators can be exercised before egress exists.
+type noopRecorder struct{}
+
+func (noopRecorder) StartRecording(string) (string, error) {
+	return "rec_" + randomSuffix(12), nil
+}
+
+func (noopRecorder) StopRecording(string) error {
+	return nil
+}
+
+type recordingRequest struct {
+	Action string `json:"action"`
+}
+
+type voiceRecordingEvent struct {
+	SessionID          string          `json:"sessionId"`
+	TargetKind         voiceTargetKind `json:"targetKind"`
+	TargetID           string          `json:"targetId"`
+	Recording          bool            `json:"recording"`
+	RecordingStartedAt *string         `json:"recordingStartedAt"`
+	UpdatedBy          string          `json:"updatedBy"`
+	Seq                int64           `json:"seq"`
+}
+
+func recordingStartedAt(record *sessionRecord) *string {
+	if record.RecordingStartedAt == nil {
+		return nil
+	}
+
+	formatted := record.RecordingStartedAt.UTC().Format(time.RFC3339Nano)
+	return &formatted
+}
+
+func (s *voiceStore) publishRecording(st voiceState, record *sessionRecord, moderatorID string) {
+	record.Seq++
+	topic := sessionTopic(record.TargetKind, record.TargetID)
+	event := voiceRecordingEvent{
+		SessionID:          record.ID,
+		TargetKind:         record.TargetKind,
+		TargetID:           record.TargetID,
+		Recording:          record.Recording,
+		RecordingStartedAt: recordingStartedAt(record),
+		UpdatedBy:          moderatorID,
+		Seq:                record.Seq,
+	}
+
+	st.afterCommit(func() {
+		s.publisher.Publish(topic, "voice.recording", event)
+	})
+}
+
+// StartRecording starts recording a session on a moderator's behalf. The
+// recorder is called outside the backend update, since updates may be
+// retried; a recording started for a session that ended or began recording
+// in the meantime is stopped again.
+func (s *voiceStore) StartRecording(kind voiceTargetKind, targetID, moderatorID string) (voiceSession, error) {
+	key := targetKey(kind, targetID)
+
+	err := s.backend.view(func(st voiceState) error {
+		record, err := st.session(key)
+		if err != nil {
+			return err
+		}
+		if record == nil {
+			return errVoiceSessionNotFound
+		}
+		if record.Recording {
+			return errVoiceAlreadyRecording
+		}
+		return nil
+	})
+	if err != nil {
+		return voiceSession{}, err
+	}
+
+	recordingID, err := s.recorder.StartRecording(roomName(kind, targetID))
+	if err != nil {
+		return voiceSession{}, fmt.Errorf("%w: %v", errVoiceRecordingUnavailable, err)
+	}
+
+	now := s.clock.Now().UTC()
+	var session voiceSession
+	err = s.backend.update(func(st voiceState) error {
+		record, err := st.session(key)
+		if err != nil {
+			return err
+		}
+		if record == nil {
+			return errVoiceSessionNotFound
+		}
+		if record.Recording {
+			return errVoiceAlreadyRecording
+		}
+
+		record.Recording = true
+		record.RecordingStartedAt = &now
+		record.RecordingID = recordingID
+		record.UpdatedAt = now
+		s.publishRecording(st, record, moderatorID)
+
+		session, err = s.buildSession(record, moderatorID)
+		return err
+	})
+	if err != nil {
+		s.stopRecorder(recordingID)
+		return voiceSession{}, err
+	}
+
+	return session, nil
+}
+
+// StopRecording stops a session's recording on a moderator's behalf. The
+// session stops showing as recorded even if the recorder fails to stop, as
+// there is nothing the caller could do differently.
+func (s *voiceStore) StopRecording(kind voiceTargetKind, targetID, moderatorID string) (voiceSession, error) {
+	now := s.clock.Now().UTC()
+	key := targetKey(kind, targetID)
+
+	var session voiceSession
+	var recordingID string
+	err := s.backend.update(func(st voiceState) error {
+		record, err := st.session(key)
+		if err != nil {
+			return err
+		}
+		if record == nil {
+			return errVoiceSessionNotFound
+		}
+		if !record.Recording {
+			return errVoiceNotRecording
+		}
+
+		recordingID = record.RecordingID
+		record.Recording = false
+		record.RecordingStartedAt = nil
+		record.RecordingID = ""
+		record.UpdatedAt = now
+		s.publishRecording(st, record, moderatorID)
+
+		session, err = s.buildSession(record, moderatorID)
+		return err
+	})
+	if err != nil {
+		return voiceSession{}, err
+	}
+
+	s.stopRecorder(recordingID)
+	return session, nil
+}
+
+func (s *voiceStore) stopRecorder(recordingID string) {
+	if err := s.recorder.StopRecording(recordingID); err != nil {
+		slog.Error("failed to stop recording", "recordingId", recordingID, "error", err)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeRecorder hands out sequential recording ids and remembers which are
+// still running.
+type fakeRecorder struct {
+	mu       sync.Mutex
+	next     int
+	running  map[string]string
+	startErr error
+}
+
+func (r *fakeRecorder) StartRecording(room string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.startErr != nil {
+		return "", r.startErr
+	}
+	r.next++
+	id := fmt.Sprintf("rec_%d", r.next)
+	r.running[id] = room
+	return id, nil
+}
+
+func (r *fakeRecorder) StopRecording(recordingID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.running, recordingID)
+	return nil
+}
+
+func TestVoiceRecordingStartStop(t *testing.T) {
+	pub := &recordingPublisher{}
+	rec := &fakeRecorder{running: map[string]string{}}
+	cfg := testVoiceStoreConfig(pub)
+	cfg.Recorder = rec
+	store := newVoiceStore(cfg)
+	if _, err := store.Join(targetChannel, "chn_1", "usr_mod", nil, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	pub.take()
+
+	session, err := store.StartRecording(targetChannel, "chn_1", "usr_mod")
+	if err != nil || !session.Recording || session.RecordingStartedAt == nil {
+		t.Fatalf("expected the session to be recording, got %+v (%v)", session, err)
+	}
+	if rec.running["rec_1"] != roomName(targetChannel, "chn_1") {
+		t.Fatalf("expected the recorder to start on the room, got %v", rec.running)
+	}
+
+	events := pub.take()
+	if len(events) != 1 || events[0].Topic != "voice:channel:chn_1" || events[0].EventType != "voice.recording" {
+		t.Fatalf("expected one recording event on the session topic, got %+v", events)
+	}
+	event, ok := events[0].Payload.(voiceRecordingEvent)
+	if !ok || !event.Recording || event.RecordingStartedAt == nil || event.UpdatedBy != "usr_mod" {
+		t.Fatalf("unexpected recording payload %+v", events[0].Payload)
+	}
+
+	if _, err := store.StartRecording(targetChannel, "chn_1", "usr_mod"); !errors.Is(err, errVoiceAlreadyRecording) || sessionErrorStatus(err) != http.StatusConflict {
+		t.Fatalf("expected a second start to conflict, got %v", err)
+	}
+
+	session, err = store.StopRecording(targetChannel, "chn_1", "usr_mod")
+	if err != nil || session.Recording || session.RecordingStartedAt != nil {
+		t.Fatalf("expected the recording to stop, got %+v (%v)", session, err)
+	}
+	if len(rec.running) != 0 {
+		t.Fatalf("expected the recorder to be stopped, got %v", rec.running)
+	}
+	events = pub.take()
+	if len(events) != 1 || events[0].EventType != "voice.recording" || events[0].Payload.(voiceRecordingEvent).Recording {
+		t.Fatalf("expected a stopped recording event, got %+v", events)
+	}
+
+	if _, err := store.StopRecording(targetChannel, "chn_1", "usr_mod"); !errors.Is(err, errVoiceNotRecording) {
+		t.Fatalf("expected stopping twice to fail, got %v", err)
+	}
+	if _, err := store.StartRecording(targetChannel, "chn_2", "usr_mod"); !errors.Is(err, errVoiceSessionNotFound) {
+		t.Fatalf("expected errVoiceSessionNotFound, got %v", err)
+	}
+}
+
+func TestVoiceRecordingRecorderFailure(t *testing.T) {
+	pub := &recordingPublisher{}
+	cfg := testVoiceStoreConfig(pub)
+	cfg.Recorder = &fakeRecorder{running: map[string]string{}, startErr: errors.New("egress down")}
+	store := newVoiceStore(cfg)
+	if _, err := store.Join(targetChannel, "chn_1", "usr_mod", nil, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	pub.take()
+
+	_, err := store.StartRecording(targetChannel, "chn_1", "usr_mod")
+	if !errors.Is(err, errVoiceRecordingUnavailable) || sessionErrorStatus(err) != http.StatusBadGateway {
+		t.Fatalf("expected a 502 error, got %v", err)
+	}
+	if events := pub.take(); len(events) != 0 {
+		t.Fatalf("expected no events, got %+v", events)
+	}
+	if memoryOf(store).sessionsByTarget[targetKey(targetChannel, "chn_1")].Recording {
+		t.Fatal("expected the session not to be recording")
+	}
+}
+
+func TestVoiceRecordingRoute(t *testing.T) {
+	s := &server{store: newTestVoiceStore(noopPublisher{})}
+	if _, err := s.store.Join(targetChannel, "chn_1", "usr_mod", nil, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+
+	if rec := postVoiceAction(t, s, "chn_1/recording", "usr_mod", `{"action":"start"}`); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected non-moderators to be refused, got %d", rec.Code)
+	}
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/voice/channels/chn_1/recording", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Voice-User-Id", "usr_mod")
+		req.Header.Set("X-Voice-Moderator", "true")
+		rec := httptest.NewRecorder()
+		s.handleVoiceChannels(rec, req)
+		return rec
+	}
+	if rec := post(`{"action":"pause"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected unknown actions to be rejected, got %d", rec.Code)
+	}
+	if rec := post(`{"action":"start"}`); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"recording":true`) {
+		t.Fatalf("expected recording to start, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := post(`{"action":"stop"}`); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"recording":false`) {
+		t.Fatalf("expected recording to stop, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	redisVoiceSessionPrefix  = "voice:session:"
+	redisVoiceUserTargetsKey = "voice:user-targets"
+	redisVoiceSweepPrefix    = "voice:sweep:"
+	redisVoiceBanPrefix      = "voice:ban:"
+	redisVoiceOpTimeout      = 2 * time.Second
+	redisVoiceCommitAttempts = 8
+)
+
+// redisVoiceCommitScript applies an operation's writes only if nothing it
+// read has changed since, which makes a join that also moves the user out of
+// their previous session atomic across instances.
+//
+// KEYS[1] is the user-target hash, KEYS[2..] the session keys. ARGV[1] is the
+// session count, followed by (expected, action, value) per session, then the
+// user count and (field, expected, action, value) per user. Expected values
+// are "0" for a missing key, "1"..value for a present one, or "*" to skip the
+// check.
+var redisVoiceCommitScript = redis.NewScript(`
+local function current(value)
+  if value then
+    return '1' .. value
+  end
+  return '0'
+end
+
+local sessionCount = tonumber(ARGV[1])
+local userCountIndex = 2 + sessionCount * 3
+local userCount = tonumber(ARGV[userCountIndex])
+
+for i = 1, sessionCount do
+  local expected = ARGV[2 + (i - 1) * 3]
+  if expected ~= '*' and current(redis.call('GET', KEYS[i + 1])) ~= expected then
+    return 0
+  end
+end
+
+for i = 1, userCount do
+  local base = userCountIndex + 1 + (i - 1) * 4
+  local expected = ARGV[base + 1]
+  if expected ~= '*' and current(redis.call('HGET', KEYS[1], ARGV[base])) ~= expected then
+    return 0
+  end
+end
+
+for i = 1, sessionCount do
+  local base = 2 + (i - 1) * 3
+  local action = ARGV[base + 1]
+  if action == 'set' then
+    redis.call('SET', KEYS[i + 1], ARGV[base + 2])
+  elseif action == 'del' then
+    redis.call('DEL', KEYS[i + 1])
+  end
+end
+
+for i = 1, userCount do
+  local base = userCountIndex + 1 + (i - 1) * 4
+  local action = ARGV[base + 2]
+  if action == 'set' then
+    redis.call('HSET', KEYS[1], ARGV[base], ARGV[base + 3])
+  elseif action == 'del' then
+    redis.call('HDEL', KEYS[1], ARGV[base])
+  end
+end
+
+return 1
+`)
+
+// redisVoiceBackend stores each session as a JSON document plus one hash
+// mapping users to the session they are in. Operations are optimistic: they
+// read what they need, then commit through redisVoiceCommitScript and retry
+// from scratch if another instance got there first.
+type redisVoiceBackend struct {
+	client     *redis.Client
+	instanceID string
+}
+
+func newRedisVoiceBackend(redisURL string) (*redisVoiceBackend, error) {
+	options, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+	}
+
+	client := redis.NewClient(options)
+	ctx, cancel := context.WithTimeout(context.Background(), redisVoiceOpTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("failed to reach Redis: %w", err)
+	}
+
+	return &redisVoiceBackend{
+		client:     client,
+		instanceID: "vsi_" + randomSuffix(8),
+	}, nil
+}
+
+func (b *redisVoiceBackend) update(fn func(voiceState) error) error {
+	for attempt := 0; attempt < redisVoiceCommitAttempts; attempt++ {
+		committed, pending, err := b.attempt(fn)
+		if err != nil {
+			return err
+		}
+		if committed {
+			for _, fn := range pending {
+				fn()
+			}
+			return nil
+		}
+	}
+
+	return errVoiceConflict
+}
+
+func (b *redisVoiceBackend) attempt(fn func(voiceState) error) (bool, []func(), error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisVoiceOpTimeout)
+	defer cancel()
+
+	state := newRedisVoiceState(ctx, b.client)
+	if err := fn(state); err != nil {
+		return false, nil, err
+	}
+
+	committed, err := state.commit()
+	return committed, state.pending, err
+}
+
+func (b *redisVoiceBackend) view(fn func(voiceState) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisVoiceOpTimeout)
+	defer cancel()
+
+	return fn(newRedisVoiceState(ctx, b.client))
+}
+
+// acquireSweep hands the sweep to whichever instance sets the key first; the
+// key lapses just before the next tick so the sweep is not skipped twice.
+func (b *redisVoiceBackend) acquireSweep(name string, interval time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisVoiceOpTimeout)
+	defer cancel()
+
+	return b.client.SetNX(ctx, redisVoiceSweepPrefix+name, b.instanceID, interval-interval/10).Result()
+}
+
+func redisVoiceBanKey(key, userID string) string {
+	return redisVoiceBanPrefix + key + ":" + userID
+}
+
+// ban stores the expiry as the value and lets Redis expire the key at the
+// same time; banned compares against the value so it agrees with the store's
+// clock rather than Redis's.
+func (b *redisVoiceBackend) ban(key, userID string, until, now time.Time) error {
+	ttl := until.Sub(now)
+	if ttl <= 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisVoiceOpTimeout)
+	defer cancel()
+
+	return b.client.Set(ctx, redisVoiceBanKey(key, userID), until.UnixMilli(), ttl).Err()
+}
+
+func (b *redisVoiceBackend) banned(key, userID string, now time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisVoiceOpTimeout)
+	defer cancel()
+
+	untilMs, err := b.client.Get(ctx, redisVoiceBanKey(key, userID)).Int64()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return now.UnixMilli() < untilMs, nil
+}
+
+type redisSessionEntry struct {
+	raw       string
+	exists    bool
+	unchecked bool
+	deleted   bool
+	record    *sessionRecord
+}
+
+type redisUserEntry struct {
+	raw       string
+	exists    bool
+	unchecked bool
+	value     string
+}
+
+type redisVoiceState struct {
+	ctx            context.Context
+	client         *redis.Client
+	sessionEntries map[string]*redisSessionEntry
+	userEntries    map[string]*redisUserEntry
+	pending        []func()
+}
+
+func newRedisVoiceState(ctx context.Context, client *redis.Client) *redisVoiceState {
+	return &redisVoiceState{
+		ctx:            ctx,
+		client:         client,
+		sessionEntries: map[string]*redisSessionEntry{},
+		userEntries:    map[string]*redisUserEntry{},
+	}
+}
+
+func redisVoiceSessionKey(key string) string {
+	return redisVoiceSessionPrefix + key
+}
+
+func decodeRedisSession(raw string) (*sessionRecord, error) {
+	record := &sessionRecord{}
+	if err := json.Unmarshal([]byte(raw), record); err != nil {
+		return nil, fmt.Errorf("corrupt voice session: %w", err)
+	}
+	if record.Participants == nil {
+		record.Participants = map[string]*participantRecord{}
+	}
+	return record, nil
+}
+
+func (s *redisVoiceState) loadSession(key, raw string, exists bool) (*redisSessionEntry, error) {
+	entry := &redisSessionEntry{raw: raw, exists: exists}
+	if exists {
+		record, err := decodeRedisSession(raw)
+		if err != nil {
+			return nil, err
+		}
+		entry.record = record
+	}
+	s.sessionEntries[key] = entry
+	return entry, nil
+}
+
+func (s *redisVoiceState) session(key string) (*sessionRecord, error) {
+	if entry, ok := s.sessionEntries[key]; ok {
+		if entry.deleted {
+			return nil, nil
+		}
+		return entry.record, nil
+	}
+
+	raw, err := s.client.Get(s.ctx, redisVoiceSessionKey(key)).Result()
+	if errors.Is(err, redis.Nil) {
+		_, err = s.loadSession(key, "", false)
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := s.loadSession(key, raw, true)
+	if err != nil {
+		return nil, err
+	}
+	return entry.record, nil
+}
+
+// sessions returns the sessions this operation already holds plus decoded
+// copies of the rest. The copies are not tracked, so listing adds nothing to
+// the commit's compare-and-set; an operation that changes one fetches it
+// through session, which does.
+func (s *redisVoiceState) sessions() ([]*sessionRecord, error) {
+	var missing []string
+	iter := s.client.Scan(s.ctx, 0, redisVoiceSessionPrefix+"*", 500).Iterator()
+	for iter.Next(s.ctx) {
+		key := strings.TrimPrefix(iter.Val(), redisVoiceSessionPrefix)
+		if _, ok := s.sessionEntries[key]; !ok {
+			missing = append(missing, key)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	records := make([]*sessionRecord, 0, len(s.sessionEntries)+len(missing))
+	for _, entry := range s.sessionEntries {
+		if !entry.deleted && entry.record != nil {
+			records = append(records, entry.record)
+		}
+	}
+
+	if len(missing) > 0 {
+		redisKeys := make([]string, len(missing))
+		for i, key := range missing {
+			redisKeys[i] = redisVoiceSessionKey(key)
+		}
+		values, err := s.client.MGet(s.ctx, redisKeys...).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, value := range values {
+			raw, exists := value.(string)
+			if !exists {
+				continue
+			}
+			record, err := decodeRedisSession(raw)
+			if err != nil {
+				return nil, err
+			}
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+func (s *redisVoiceState) saveSession(record *sessionRecord) {
+	key := targetKey(record.TargetKind, record.TargetID)
+	entry, ok := s.sessionEntries[key]
+	if !ok {
+		entry = &redisSessionEntry{unchecked: true}
+		s.sessionEntries[key] = entry
+	}
+	entry.deleted = false
+	entry.record = record
+}
+
+func (s *redisVoiceState) deleteSession(key string) {
+	entry, ok := s.sessionEntries[key]
+	if !ok {
+		entry = &redisSessionEntry{unchecked: true}
+		s.sessionEntries[key] = entry
+	}
+	entry.deleted = true
+	entry.record = nil
+}
+
+func (s *redisVoiceState) userTarget(userID string) (string, error) {
+	if entry, ok := s.userEntries[userID]; ok {
+		return entry.value, nil
+	}
+
+	raw, err := s.client.HGet(s.ctx, redisVoiceUserTargetsKey, userID).Result()
+	if errors.Is(err, redis.Nil) {
+		s.userEntries[userID] = &redisUserEntry{}
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	s.userEntries[userID] = &redisUserEntry{raw: raw, exists: true, value: raw}
+	return raw, nil
+}
+
+func (s *redisVoiceState) setUserTarget(userID, key string) {
+	entry, ok := s.userEntries[userID]
+	if !ok {
+		entry = &redisUserEntry{unchecked: true}
+		s.userEntries[userID] = entry
+	}
+	entry.value = key
+}
+
+func (s *redisVoiceState) deleteUserTarget(userID string) {
+	s.setUserTarget(userID, "")
+}
+
+func (s *redisVoiceState) afterCommit(fn func()) {
+	s.pending = append(s.pending, fn)
+}
+
+func redisExpected(raw string, exists, unchecked bool) string {
+	switch {
+	case unchecked:
+		return "*"
+	case exists:
+		return "1" + raw
+	default:
+		return "0"
+	}
+}
+
+// commit writes back every record that changed during the operation. It
+// reports false when something it read was modified in the meantime.
+func (s *redisVoiceState) commit() (bool, error) {
+	keys := []string{redisVoiceUserTargetsKey}
+	sessionArgs := []any{}
+	writes := 0
+
+	for key, entry := range s.sessionEntries {
+		action, value := "keep", ""
+		switch {
+		case entry.deleted:
+			if entry.exists || entry.unchecked {
+				action = "del"
+			}
+		case entry.record != nil:
+			encoded, err := json.Marshal(entry.record)
+			if err != nil {
+				return false, err
+			}
+			if !entry.exists || string(encoded) != entry.raw {
+				action, value = "set", string(encoded)
+			}
+		}
+		if action != "keep" {
+			writes++
+		}
+
+		keys = append(keys, redisVoiceSessionKey(key))
+		sessionArgs = append(sessionArgs, redisExpected(entry.raw, entry.exists, entry.unchecked), action, value)
+	}
+
+	userArgs := []any{}
+	for userID, entry := range s.userEntries {
+		action := "keep"
+		switch {
+		case entry.value == "" && (entry.exists || entry.unchecked):
+			action = "del"
+		case entry.value != "" && entry.value != entry.raw:
+			action = "set"
+		}
+		if action != "keep" {
+			writes++
+		}
+
+		userArgs = append(userArgs, userID, redisExpected(entry.raw, entry.exists, entry.unchecked), action, entry.value)
+	}
+
+	if writes == 0 {
+		return true, nil
+	}
+
+	args := append([]any{len(s.sessionEntries)}, sessionArgs...)
+	args = append(args, len(s.userEntries))
+	args = append(args, userArgs...)
+
+	result, err := redisVoiceCommitScript.Run(s.ctx, s.client, keys, args...).Int()
+	if err != nil {
+		return false, err
+	}
+	return result == 1, nil
+}
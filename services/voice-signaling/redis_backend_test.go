@@ -0,0 +1,264 @@
+//go:build redis
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// Run with: go test -tags redis ./...
+
+func newRedisTestStore(t *testing.T, mr *miniredis.Miniredis, pub publisher) *voiceStore {
+	t.Helper()
+
+	backend, err := newRedisVoiceBackend("redis://" + mr.Addr())
+	if err != nil {
+		t.Fatalf("newRedisVoiceBackend: %v", err)
+	}
+	t.Cleanup(func() { _ = backend.client.Close() })
+
+	cfg := testVoiceStoreConfig(pub)
+	cfg.Backend = backend
+	return newVoiceStore(cfg)
+}
+
+func TestRedisVoiceJoinAndLeave(t *testing.T) {
+	mr := miniredis.RunT(t)
+	pub := &recordingPublisher{}
+	store := newRedisTestStore(t, mr, pub)
+
+	for _, userID := range []string{"usr_1", "usr_2"} {
+		if _, err := store.Join(targetChannel, "chn_1", userID, nil, true, joinVoiceRequest{Muted: boolPtr(true)}); err != nil {
+			t.Fatalf("join %s: %v", userID, err)
+		}
+	}
+	if events := pub.take(); len(events) != 2 {
+		t.Fatalf("expected one publish per join, got %d", len(events))
+	}
+
+	session, err := store.Get(targetChannel, "chn_1", "usr_1")
+	if err != nil || session == nil || len(session.Participants) != 2 {
+		t.Fatalf("expected both participants, got %+v (%v)", session, err)
+	}
+	if participant := findParticipant(t, session.Participants, "usr_2"); !participant.Muted {
+		t.Fatalf("expected state to round-trip through Redis, got %+v", participant)
+	}
+
+	for _, userID := range []string{"usr_1", "usr_2"} {
+		if _, err := store.Leave(targetChannel, "chn_1", userID); err != nil {
+			t.Fatalf("leave %s: %v", userID, err)
+		}
+	}
+	if mr.Exists(redisVoiceSessionKey(targetKey(targetChannel, "chn_1"))) || mr.Exists(redisVoiceUserTargetsKey) {
+		t.Fatalf("expected the empty session and index to be removed, got keys %v", mr.Keys())
+	}
+	if _, err := store.Leave(targetChannel, "chn_1", "usr_1"); err != errVoiceSessionNotFound {
+		t.Fatalf("expected a second leave to miss, got %v", err)
+	}
+}
+
+func TestRedisVoiceSharedAcrossInstances(t *testing.T) {
+	mr := miniredis.RunT(t)
+	firstPub, secondPub := &recordingPublisher{}, &recordingPublisher{}
+	first := newRedisTestStore(t, mr, firstPub)
+	second := newRedisTestStore(t, mr, secondPub)
+
+	if _, err := first.Join(targetChannel, "chn_1", "usr_1", nil, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	if _, err := second.Join(targetChannel, "chn_1", "usr_2", nil, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+
+	session, err := first.Get(targetChannel, "chn_1", "usr_1")
+	if err != nil || session == nil || len(session.Participants) != 2 {
+		t.Fatalf("expected the first instance to see both participants, got %+v (%v)", session, err)
+	}
+
+	// Moving channels through the other instance still leaves the old one.
+	firstPub.take()
+	secondPub.take()
+	if _, err := second.Join(targetChannel, "chn_2", "usr_1", nil, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("move: %v", err)
+	}
+	// usr_1 created chn_1, so leaving it hands the role to usr_2 once the
+	// participants update is out.
+	events := secondPub.take()
+	if len(events) != 3 {
+		t.Fatalf("expected updates for both channels and a creator change, got %+v", events)
+	}
+	event := sessionEventFor(t, events[:1], "voice:channel:chn_1")
+	if len(event.Participants) != 1 || event.Participants[0].UserID != "usr_2" {
+		t.Fatalf("expected usr_1 to leave chn_1, got %+v", event.Participants)
+	}
+	if events[1].Topic != "voice:channel:chn_1" || events[1].EventType != "voice.session.creator_changed" {
+		t.Fatalf("expected the creator change after the chn_1 update, got %s on %s", events[1].EventType, events[1].Topic)
+	}
+	if changed := events[1].Payload.(voiceCreatorEvent); changed.CreatedBy != "usr_2" || changed.PreviousCreatedBy != "usr_1" {
+		t.Fatalf("expected usr_2 to take over from usr_1, got %+v", changed)
+	}
+	sessionEventFor(t, events[2:], "voice:channel:chn_2")
+
+	session, err = first.Get(targetChannel, "chn_1", "usr_2")
+	if err != nil || session == nil || len(session.Participants) != 1 {
+		t.Fatalf("expected chn_1 to keep only usr_2, got %+v (%v)", session, err)
+	}
+	if _, err := first.Leave(targetChannel, "chn_2", "usr_1"); err != nil {
+		t.Fatalf("expected the first instance to see usr_1 in chn_2: %v", err)
+	}
+}
+
+func TestRedisVoiceRetriesOnConflict(t *testing.T) {
+	mr := miniredis.RunT(t)
+	store := newRedisTestStore(t, mr, noopPublisher{})
+	other := newRedisTestStore(t, mr, noopPublisher{})
+	if _, err := store.Join(targetChannel, "chn_1", "usr_1", nil, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+
+	key := targetKey(targetChannel, "chn_1")
+	attempts := 0
+	err := store.backend.update(func(st voiceState) error {
+		attempts++
+		record, err := st.session(key)
+		if err != nil {
+			return err
+		}
+		if attempts == 1 {
+			if _, err := other.Join(targetChannel, "chn_1", "usr_2", nil, true, joinVoiceRequest{}); err != nil {
+				return err
+			}
+		}
+		record.Participants["usr_1"].Muted = true
+		return nil
+	})
+	if err != nil || attempts != 2 {
+		t.Fatalf("expected one retry after the concurrent join, got %d attempts (%v)", attempts, err)
+	}
+
+	session, err := other.Get(targetChannel, "chn_1", "usr_2")
+	if err != nil || session == nil || len(session.Participants) != 2 {
+		t.Fatalf("expected the concurrent join to survive, got %+v (%v)", session, err)
+	}
+	if !findParticipant(t, session.Participants, "usr_1").Muted {
+		t.Fatal("expected the retried update to apply")
+	}
+}
+
+// interleavingBackend runs during once, after the first attempt of an
+// update has done its reads and writes but before it commits.
+type interleavingBackend struct {
+	voiceBackend
+	during   func()
+	attempts int
+}
+
+func (b *interleavingBackend) update(fn func(voiceState) error) error {
+	return b.voiceBackend.update(func(st voiceState) error {
+		b.attempts++
+		if err := fn(st); err != nil {
+			return err
+		}
+		if b.during != nil {
+			b.during()
+			b.during = nil
+		}
+		return nil
+	})
+}
+
+func TestRedisVoiceSweepIgnoresUnrelatedWrites(t *testing.T) {
+	mr := miniredis.RunT(t)
+	store := newRedisTestStore(t, mr, noopPublisher{})
+	other := newRedisTestStore(t, mr, noopPublisher{})
+	if _, err := store.Join(targetChannel, "chn_1", "usr_1", nil, true, joinVoiceRequest{Speaking: boolPtr(true)}); err != nil {
+		t.Fatalf("join usr_1: %v", err)
+	}
+	if _, err := other.Join(targetChannel, "chn_2", "usr_2", nil, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("join usr_2: %v", err)
+	}
+
+	backend := &interleavingBackend{voiceBackend: store.backend}
+	backend.during = func() {
+		if _, err := other.Heartbeat(targetChannel, "chn_2", "usr_2", heartbeatRequest{Speaking: boolPtr(true)}); err != nil {
+			t.Fatalf("heartbeat: %v", err)
+		}
+	}
+	store.backend = backend
+
+	store.clock.(*fakeClock).Advance(5 * time.Second)
+	if err := store.ClearStaleSpeaking(); err != nil {
+		t.Fatalf("sweep: %v", err)
+	}
+	if backend.attempts != 1 {
+		t.Fatalf("expected a heartbeat in another session not to force a retry, got %d attempts", backend.attempts)
+	}
+
+	session, err := other.Get(targetChannel, "chn_1", "usr_2")
+	if err != nil || session == nil || findParticipant(t, session.Participants, "usr_1").Speaking {
+		t.Fatalf("expected the sweep to clear usr_1's speaking flag, got %+v (%v)", session, err)
+	}
+	session, err = other.Get(targetChannel, "chn_2", "usr_2")
+	if err != nil || session == nil || !findParticipant(t, session.Participants, "usr_2").Speaking {
+		t.Fatalf("expected the heartbeat to survive the sweep, got %+v (%v)", session, err)
+	}
+}
+
+func TestRedisVoiceSweepRunsOnOneInstance(t *testing.T) {
+	mr := miniredis.RunT(t)
+	pub := &recordingPublisher{}
+	first := newRedisTestStore(t, mr, pub)
+	second := newRedisTestStore(t, mr, noopPublisher{})
+
+	if acquired, err := first.backend.acquireSweep("cleanup", 5*time.Second); err != nil || !acquired {
+		t.Fatalf("expected the first instance to take the sweep, got %v (%v)", acquired, err)
+	}
+	if acquired, err := second.backend.acquireSweep("cleanup", 5*time.Second); err != nil || acquired {
+		t.Fatalf("expected the second instance to skip the sweep, got %v (%v)", acquired, err)
+	}
+	mr.FastForward(5 * time.Second)
+	if acquired, err := second.backend.acquireSweep("cleanup", 5*time.Second); err != nil || !acquired {
+		t.Fatalf("expected the lock to lapse by the next tick, got %v (%v)", acquired, err)
+	}
+
+	if _, err := first.Join(targetChannel, "chn_1", "usr_1", nil, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	pub.take()
+
+	first.clock.(*fakeClock).Advance(time.Minute)
+	if err := first.CleanupExpired(); err != nil {
+		t.Fatalf("cleanup: %v", err)
+	}
+	event := sessionEventFor(t, pub.take(), "voice:channel:chn_1")
+	if len(event.Participants) != 0 {
+		t.Fatalf("expected the expired participant to be removed, got %+v", event.Participants)
+	}
+	if session, err := second.Get(targetChannel, "chn_1", "usr_1"); err != nil || session != nil {
+		t.Fatalf("expected the session to be gone everywhere, got %+v (%v)", session, err)
+	}
+}
+
+func TestRedisVoiceBanSharedAcrossInstances(t *testing.T) {
+	mr := miniredis.RunT(t)
+	first := newRedisTestStore(t, mr, noopPublisher{})
+	second := newRedisTestStore(t, mr, noopPublisher{})
+	if _, err := first.Join(targetChannel, "chn_1", "usr_1", nil, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+
+	if _, err := first.Ban(targetChannel, "chn_1", "usr_mod", "usr_1"); err != nil {
+		t.Fatalf("ban: %v", err)
+	}
+	if _, err := second.Join(targetChannel, "chn_1", "usr_1", nil, true, joinVoiceRequest{}); err != errVoiceBanned {
+		t.Fatalf("expected the other instance to honour the ban, got %v", err)
+	}
+
+	second.clock.(*fakeClock).Advance(10 * time.Minute)
+	if _, err := second.Join(targetChannel, "chn_1", "usr_1", nil, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("expected the ban to lift once expired: %v", err)
+	}
+}
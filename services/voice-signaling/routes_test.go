@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseTargetPathValidatesIDs(t *testing.T) {
+	route, err := parseTargetPath("/v1/voice/channels/chn_A-1/participants/usr_2/mute", "/v1/voice/channels/")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if route.TargetID != "chn_A-1" || route.ParticipantID != "usr_2" || route.Action != "participants/:userId/mute" {
+		t.Fatalf("unexpected route %+v", route)
+	}
+
+	if _, err := parseTargetPath("/v1/voice/channels/"+strings.Repeat("a", maxIDLength)+"/join", "/v1/voice/channels/"); err != nil {
+		t.Fatalf("expected an id of exactly %d bytes to pass: %v", maxIDLength, err)
+	}
+
+	for name, tc := range map[string]struct {
+		path string
+		want error
+	}{
+		"overlong target":      {"/v1/voice/channels/" + strings.Repeat("a", maxIDLength+1) + "/join", errInvalidTargetID},
+		"escaped newline":      {"/v1/voice/channels/chn%0A1/join", errInvalidTargetID},
+		"dot":                  {"/v1/voice/channels/chn.1/join", errInvalidTargetID},
+		"unicode":              {"/v1/voice/channels/chn%C3%A91/join", errInvalidTargetID},
+		"participant slash":    {"/v1/voice/channels/chn_1/participants/usr%2F2/kick", errInvalidParticipantID},
+		"participant overlong": {"/v1/voice/channels/chn_1/participants/" + strings.Repeat("u", maxIDLength+1) + "/kick", errInvalidParticipantID},
+	} {
+		if _, err := parseTargetPath(tc.path, "/v1/voice/channels/"); !errors.Is(err, tc.want) {
+			t.Errorf("%s: expected %v, got %v", name, tc.want, err)
+		}
+	}
+}
+
+func TestVoiceTargetRejectsInvalidIDs(t *testing.T) {
+	s := &server{store: newTestVoiceStore(noopPublisher{})}
+	get := func(path, userID string) int {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("X-Voice-User-Id", userID)
+		rec := httptest.NewRecorder()
+		s.handleVoiceChannels(rec, req)
+		return rec.Code
+	}
+
+	if code := get("/v1/voice/channels/chn_1", "usr_1"); code != http.StatusOK {
+		t.Fatalf("expected valid ids to pass, got %d", code)
+	}
+	if code := get("/v1/voice/channels/"+strings.Repeat("c", maxIDLength+1), "usr_1"); code != http.StatusBadRequest {
+		t.Fatalf("expected an overlong target id to be rejected, got %d", code)
+	}
+	if code := get("/v1/voice/channels/chn_1", "usr 1"); code != http.StatusBadRequest {
+		t.Fatalf("expected an illegal user id to be rejected, got %d", code)
+	}
+	if code := get("/v1/voice/channels/chn_1", strings.Repeat("u", maxIDLength+1)); code != http.StatusBadRequest {
+		t.Fatalf("expected an overlong user id to be rejected, got %d", code)
+	}
+}
+
+func TestVoiceRejectsOversizedBody(t *testing.T) {
+	store := newTestVoiceStore(noopPublisher{})
+	s := &server{store: store, maxBodyBytes: 64}
+
+	body := `{"muted":true,"padding":"` + strings.Repeat("x", 64) + `"}`
+	rec := postVoiceAction(t, s, "chn_1/join", "usr_1", body)
+	if rec.Code != http.StatusRequestEntityTooLarge || !strings.Contains(rec.Body.String(), "at most 64 bytes") {
+		t.Fatalf("expected 413 naming the limit, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if _, ok := memoryOf(store).sessionsByTarget[targetKey(targetChannel, "chn_1")]; ok {
+		t.Fatal("expected an oversized join to be refused before joining")
+	}
+
+	if rec := postVoiceAction(t, s, "chn_1/join", "usr_1", `{"muted":true}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected a body under the limit to work, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := postVoiceAction(t, s, "chn_1/state", "usr_1", `{"muted":`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected invalid JSON to stay a 400, got %d", rec.Code)
+	}
+}
+
+func TestVoiceRejectsUnknownFields(t *testing.T) {
+	s := &server{store: newTestVoiceStore(noopPublisher{})}
+
+	if rec := postVoiceAction(t, s, "chn_1/join", "usr_1", `{}`); rec.Code != http.StatusOK {
+		t.Fatalf("join: expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec := postVoiceAction(t, s, "chn_1/state", "usr_1", `{"mute":true}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `Unknown field \"mute\"`) {
+		t.Fatalf("expected 400 naming the field, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = postVoiceAction(t, s, "chn_1/state", "usr_1", `{"muted":true}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected a valid body to still work, got %d: %s", rec.Code, rec.Body.String())
+	}
+	// Bodies stay optional where they were.
+	if rec := postVoiceAction(t, s, "chn_2/join", "usr_1", ""); rec.Code != http.StatusOK {
+		t.Fatalf("expected an empty body to still work, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestVoiceRequiresJSONContentType(t *testing.T) {
+	s := &server{store: newTestVoiceStore(noopPublisher{})}
+	join := func(targetID, contentType, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/v1/voice/channels/"+targetID+"/join", strings.NewReader(body))
+		req.Header.Set("X-Voice-User-Id", "usr_1")
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		rec := httptest.NewRecorder()
+		s.handleVoiceChannels(rec, req)
+		return rec
+	}
+
+	if rec := join("chn_1", "application/x-www-form-urlencoded", `{"muted":true}`); rec.Code != http.StatusUnsupportedMediaType || !strings.Contains(rec.Body.String(), "application/json") {
+		t.Fatalf("expected 415 for a form content type, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := join("chn_1", "", `{"muted":true}`); rec.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("expected 415 without a content type, got %d", rec.Code)
+	}
+	if rec := join("chn_1", "Application/JSON; charset=utf-8", `{"muted":true}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected JSON with a charset to be accepted, got %d: %s", rec.Code, rec.Body.String())
+	}
+	// Endpoints whose body is optional still take an empty one without a
+	// content type.
+	if rec := join("chn_2", "", ""); rec.Code != http.StatusOK {
+		t.Fatalf("expected an empty body to be exempt, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
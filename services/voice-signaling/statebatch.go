@@ -0,0 +1,55 @@
+package main
+
+// batchVoiceStateRequest is every self-set toggle at once, for a client that
+// needs to restore its state in one round trip, such as after coming back
+// from the background. Nil fields are left alone.
+type batchVoiceStateRequest struct {
+	updateVoiceStateRequest
+	ScreenSharing *bool `json:"screenSharing"`
+	ShareAudio    *bool `json:"shareAudio"`
+	CameraOn      *bool `json:"cameraOn"`
+}
+
+// UpdateStateBatch applies the whole request in one update, so the
+// participant never shows half of it and one session event covers it all.
+// Screen sharing and video stay off while disabled, participants who can't
+// publish are never shown speaking, sharing or on camera, and the
+// screen-share cap applies, as with their own endpoints.
+func (s *voiceStore) UpdateStateBatch(kind voiceTargetKind, targetID, userID string, body batchVoiceStateRequest) (voiceSession, error) {
+	now := s.clock.Now().UTC()
+	key := targetKey(kind, targetID)
+
+	var session voiceSession
+	err := s.backend.update(func(st voiceState) error {
+		record, participant, err := connectedParticipant(st, key, userID)
+		if err != nil {
+			return err
+		}
+
+		if err := s.applyVoiceState(participant, body.updateVoiceStateRequest, now); err != nil {
+			return err
+		}
+		if body.ScreenSharing != nil || body.ShareAudio != nil {
+			screenSharing := participant.ScreenSharing
+			if body.ScreenSharing != nil {
+				screenSharing = *body.ScreenSharing
+			}
+			if err := s.checkScreenShareCapacity(record, participant, screenSharing); err != nil {
+				return err
+			}
+			s.applyScreenShare(participant, screenSharing, body.ShareAudio)
+		}
+		if body.CameraOn != nil {
+			s.applyCamera(participant, *body.CameraOn)
+		}
+
+		participant.LastSeenAt = now
+		record.UpdatedAt = now
+		s.publishSession(st, record)
+
+		session, err = s.buildSession(record, userID)
+		return err
+	})
+
+	return session, err
+}
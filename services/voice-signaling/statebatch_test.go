@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func postStateBatch(t *testing.T, s *server, targetID, userID, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/voice/channels/"+targetID+"/state/batch", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Voice-User-Id", userID)
+	req.Header.Set("X-Screen-Share-Enabled", "true")
+	rec := httptest.NewRecorder()
+	s.handleVoiceChannels(rec, req)
+	return rec
+}
+
+func TestVoiceStateBatchAppliesTogether(t *testing.T) {
+	pub := &recordingPublisher{}
+	store := newTestVoiceStore(pub)
+	s := &server{store: store}
+	if _, err := store.Join(targetChannel, "chn_1", "usr_1", nil, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	pub.take()
+
+	rec := postStateBatch(t, s, "chn_1", "usr_1", `{"muted":true,"deafened":false,"speaking":true,"screenSharing":true,"shareAudio":true,"cameraOn":true}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	event := sessionEventFor(t, pub.take(), "voice:channel:chn_1")
+	participant := findParticipant(t, event.Participants, "usr_1")
+	if !participant.Muted || participant.Deafened || !participant.Speaking || !participant.ScreenSharing || !participant.ScreenShareAudio || !participant.CameraOn {
+		t.Fatalf("expected every field in the one event, got %+v", participant)
+	}
+
+	// Fields left out keep their values.
+	if rec := postStateBatch(t, s, "chn_1", "usr_1", `{"cameraOn":false}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	event = sessionEventFor(t, pub.take(), "voice:channel:chn_1")
+	if participant := findParticipant(t, event.Participants, "usr_1"); participant.CameraOn || !participant.Muted || !participant.ScreenSharing {
+		t.Fatalf("expected only the camera to change, got %+v", participant)
+	}
+}
+
+func TestVoiceStateBatchRespectsFeatureFlags(t *testing.T) {
+	pub := &recordingPublisher{}
+	cfg := testVoiceStoreConfig(pub)
+	cfg.EnableVideo = false
+	store := newVoiceStore(cfg)
+	s := &server{store: store}
+	if _, err := store.Join(targetChannel, "chn_1", "usr_1", nil, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	pub.take()
+
+	if rec := postStateBatch(t, s, "chn_1", "usr_1", `{"muted":true,"cameraOn":true}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 with video disabled, got %d", rec.Code)
+	}
+	if rec := postVoiceAction(t, s, "chn_1/state/batch", "usr_1", `{"screenSharing":true}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without X-Screen-Share-Enabled, got %d", rec.Code)
+	}
+	if events := pub.take(); len(events) != 0 {
+		t.Fatalf("expected a rejected batch to change nothing, got %+v", events)
+	}
+
+	session, err := store.Get(targetChannel, "chn_1", "usr_1")
+	if err != nil || session == nil {
+		t.Fatalf("get: %+v (%v)", session, err)
+	}
+	if participant := findParticipant(t, session.Participants, "usr_1"); participant.Muted || participant.CameraOn || participant.ScreenSharing {
+		t.Fatalf("expected no part of a rejected batch to apply, got %+v", participant)
+	}
+}
@@ -0,0 +1,1913 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type publishedEvent struct {
+	Topic     string
+	EventType string
+	Payload   any
+}
+
+type recordingPublisher struct {
+	mu     sync.Mutex
+	events []publishedEvent
+}
+
+func (p *recordingPublisher) Publish(topic, eventType string, payload any) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.events = append(p.events, publishedEvent{Topic: topic, EventType: eventType, Payload: payload})
+}
+
+func (p *recordingPublisher) take() []publishedEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	events := p.events
+	p.events = nil
+	return events
+}
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// newFakeClock starts at the real time so tokens signed against it still
+// validate with the JWT library's own clock.
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Now().UTC()}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+func testVoiceStoreConfig(pub publisher) voiceStoreConfig {
+	return voiceStoreConfig{
+		ReconnectGrace:    30 * time.Second,
+		MinReconnectGrace: 5 * time.Second,
+		MaxReconnectGrace: 5 * time.Minute,
+		SpeakingTimeout:   2 * time.Second,
+		BanDuration:       10 * time.Minute,
+		EnableScreenShare: true,
+		EnableVideo:       true,
+		DeafenImpliesMute: true,
+		SignalingURL:      "ws://livekit.test",
+		Signer:            testSigner(),
+		TokenTTL:          time.Hour,
+		TokenSkew:         30 * time.Second,
+		Backend:           newMemoryVoiceBackend(),
+		Publisher:         pub,
+		Recorder:          noopRecorder{},
+		Metrics:           newVoiceMetrics(),
+		Clock:             newFakeClock(),
+	}
+}
+
+func newTestVoiceStore(pub publisher) *voiceStore {
+	return newVoiceStore(testVoiceStoreConfig(pub))
+}
+
+// memoryOf exposes the in-memory maps behind a test store.
+func memoryOf(store *voiceStore) *memoryVoiceBackend {
+	return store.backend.(*memoryVoiceBackend)
+}
+
+func testSigner() *livekitSigner {
+	signer, _ := newLivekitSigner("devkey", "secret", "")
+	return signer
+}
+
+// postVoiceAction sends a POST to /v1/voice/channels/<path> as userID.
+func postVoiceAction(t *testing.T, s *server, path, userID, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/voice/channels/"+path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Voice-User-Id", userID)
+	rec := httptest.NewRecorder()
+	s.handleVoiceChannels(rec, req)
+	return rec
+}
+
+// postModeratorAction is postVoiceAction with the moderator header set.
+func postModeratorAction(t *testing.T, s *server, path, userID, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/voice/channels/"+path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Voice-User-Id", userID)
+	req.Header.Set("X-Voice-Moderator", "true")
+	rec := httptest.NewRecorder()
+	s.handleVoiceChannels(rec, req)
+	return rec
+}
+
+func boolPtr(value bool) *bool {
+	return &value
+}
+
+func sessionEventFor(t *testing.T, events []publishedEvent, topic string) voiceSessionEvent {
+	t.Helper()
+
+	if len(events) != 1 {
+		t.Fatalf("expected 1 event, got %d: %+v", len(events), events)
+	}
+	if events[0].Topic != topic || events[0].EventType != "voice.participants.updated" {
+		t.Fatalf("unexpected event %s on %s", events[0].EventType, events[0].Topic)
+	}
+
+	payload, ok := events[0].Payload.(voiceSessionEvent)
+	if !ok {
+		t.Fatalf("unexpected payload type %T", events[0].Payload)
+	}
+
+	return payload
+}
+
+func TestVoiceStorePublishesOnMutations(t *testing.T) {
+	pub := &recordingPublisher{}
+	store := newTestVoiceStore(pub)
+	topic := "voice:channel:chn_1"
+
+	if _, err := store.Join(targetChannel, "chn_1", "usr_1", nil, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	joined := sessionEventFor(t, pub.take(), topic)
+	if len(joined.Participants) != 1 || joined.Participants[0].UserID != "usr_1" {
+		t.Fatalf("unexpected participants after join: %+v", joined.Participants)
+	}
+
+	if _, err := store.UpdateState(targetChannel, "chn_1", "usr_1", updateVoiceStateRequest{Muted: boolPtr(true)}); err != nil {
+		t.Fatalf("update state: %v", err)
+	}
+	if updated := sessionEventFor(t, pub.take(), topic); !updated.Participants[0].Muted {
+		t.Fatalf("expected muted participant in event: %+v", updated.Participants)
+	}
+
+	if _, err := store.UpdateScreenShare(targetChannel, "chn_1", "usr_1", true, nil); err != nil {
+		t.Fatalf("screen share: %v", err)
+	}
+	if shared := sessionEventFor(t, pub.take(), topic); !shared.Participants[0].ScreenSharing {
+		t.Fatalf("expected screen sharing participant in event: %+v", shared.Participants)
+	}
+
+	if _, err := store.Heartbeat(targetChannel, "chn_1", "usr_1", heartbeatRequest{}); err != nil {
+		t.Fatalf("heartbeat: %v", err)
+	}
+	if events := pub.take(); len(events) != 0 {
+		t.Fatalf("expected keep-alive heartbeat to publish nothing, got %+v", events)
+	}
+
+	if _, err := store.Heartbeat(targetChannel, "chn_1", "usr_1", heartbeatRequest{Speaking: boolPtr(true)}); err != nil {
+		t.Fatalf("heartbeat: %v", err)
+	}
+	if speaking := sessionEventFor(t, pub.take(), topic); !speaking.Participants[0].Speaking {
+		t.Fatalf("expected speaking participant in event: %+v", speaking.Participants)
+	}
+
+	if _, err := store.Leave(targetChannel, "chn_1", "usr_1"); err != nil {
+		t.Fatalf("leave: %v", err)
+	}
+	if left := sessionEventFor(t, pub.take(), topic); len(left.Participants) != 0 {
+		t.Fatalf("expected empty participants after leave: %+v", left.Participants)
+	}
+}
+
+func TestVoiceSessionSeq(t *testing.T) {
+	pub := &recordingPublisher{}
+	store := newTestVoiceStore(pub)
+	topic := "voice:channel:chn_1"
+
+	mutations := []func() (voiceSession, error){
+		func() (voiceSession, error) {
+			return store.Join(targetChannel, "chn_1", "usr_1", nil, true, joinVoiceRequest{})
+		},
+		func() (voiceSession, error) {
+			return store.UpdateState(targetChannel, "chn_1", "usr_1", updateVoiceStateRequest{Muted: boolPtr(true)})
+		},
+		func() (voiceSession, error) {
+			return store.UpdateCamera(targetChannel, "chn_1", "usr_1", true)
+		},
+		func() (voiceSession, error) {
+			return store.Join(targetChannel, "chn_1", "usr_2", nil, true, joinVoiceRequest{})
+		},
+	}
+	for i, mutate := range mutations {
+		session, err := mutate()
+		if err != nil {
+			t.Fatalf("mutation %d: %v", i, err)
+		}
+		want := int64(i + 1)
+		if session.Seq != want {
+			t.Fatalf("expected seq %d after mutation %d, got %d", want, i, session.Seq)
+		}
+		if event := sessionEventFor(t, pub.take(), topic); event.Seq != want {
+			t.Fatalf("expected event seq %d after mutation %d, got %d", want, i, event.Seq)
+		}
+	}
+
+	// Keep-alives change nothing anyone sees, so they don't move it.
+	session, err := store.Heartbeat(targetChannel, "chn_1", "usr_1", heartbeatRequest{})
+	if err != nil {
+		t.Fatalf("heartbeat: %v", err)
+	}
+	if session.Seq != 4 {
+		t.Fatalf("expected a keep-alive to leave seq at 4, got %d", session.Seq)
+	}
+
+	// A failed update leaves it alone too.
+	if _, err := store.UpdateState(targetChannel, "chn_1", "usr_3", updateVoiceStateRequest{Muted: boolPtr(true)}); !errors.Is(err, errVoiceNotConnected) {
+		t.Fatalf("expected not connected, got %v", err)
+	}
+	fetched, err := store.Get(targetChannel, "chn_1", "usr_2")
+	if err != nil || fetched == nil {
+		t.Fatalf("get: %v", err)
+	}
+	if fetched.Seq != 4 {
+		t.Fatalf("expected seq 4 to persist, got %d", fetched.Seq)
+	}
+}
+
+func TestVoiceStoreJoinPublishesPriorSession(t *testing.T) {
+	pub := &recordingPublisher{}
+	store := newTestVoiceStore(pub)
+
+	if _, err := store.Join(targetChannel, "chn_1", "usr_1", nil, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	pub.take()
+
+	if _, err := store.Join(targetDirectThread, "dm_1", "usr_1", nil, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+
+	events := pub.take()
+	if len(events) != 2 || events[0].Topic != "voice:channel:chn_1" || events[1].Topic != "voice:direct_thread:dm_1" {
+		t.Fatalf("expected prior then new session events, got %+v", events)
+	}
+	for _, event := range events {
+		if payload, ok := event.Payload.(voiceSessionEvent); !ok || payload.SessionID == "" {
+			t.Fatalf("unexpected payload %+v", event.Payload)
+		}
+	}
+}
+
+func TestVoiceStoreModeratorMuteLocksSelfUnmute(t *testing.T) {
+	pub := &recordingPublisher{}
+	store := newTestVoiceStore(pub)
+	for _, userID := range []string{"usr_mod", "usr_1"} {
+		if _, err := store.Join(targetChannel, "chn_1", userID, nil, true, joinVoiceRequest{}); err != nil {
+			t.Fatalf("join %s: %v", userID, err)
+		}
+	}
+	pub.take()
+
+	if _, err := store.ModeratorMute(targetChannel, "chn_1", "usr_mod", "usr_mod", true); !errors.Is(err, errVoiceSelfModeration) {
+		t.Fatalf("expected self-moderation rejection, got %v", err)
+	}
+
+	session, err := store.ModeratorMute(targetChannel, "chn_1", "usr_mod", "usr_1", true)
+	if err != nil {
+		t.Fatalf("moderator mute: %v", err)
+	}
+	if state := findParticipant(t, session.Participants, "usr_1"); !state.Muted || !state.MutedByModerator {
+		t.Fatalf("expected moderator-muted participant, got %+v", state)
+	}
+	if events := pub.take(); len(events) != 1 {
+		t.Fatalf("expected a publish after moderator mute, got %d", len(events))
+	}
+
+	if _, err := store.UpdateState(targetChannel, "chn_1", "usr_1", updateVoiceStateRequest{Muted: boolPtr(false)}); !errors.Is(err, errVoiceModeratorMuted) {
+		t.Fatalf("expected self-unmute to be refused, got %v", err)
+	}
+
+	if _, err := store.ModeratorMute(targetChannel, "chn_1", "usr_mod", "usr_1", false); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	session, err = store.UpdateState(targetChannel, "chn_1", "usr_1", updateVoiceStateRequest{Muted: boolPtr(false)})
+	if err != nil {
+		t.Fatalf("self-unmute after release: %v", err)
+	}
+	if state := findParticipant(t, session.Participants, "usr_1"); state.Muted {
+		t.Fatalf("expected participant to be unmuted after release, got %+v", state)
+	}
+}
+
+func findParticipant(t *testing.T, participants []voiceParticipantState, userID string) voiceParticipantState {
+	t.Helper()
+
+	for _, participant := range participants {
+		if participant.UserID == userID {
+			return participant
+		}
+	}
+
+	t.Fatalf("participant %s not found in %+v", userID, participants)
+	return voiceParticipantState{}
+}
+
+func TestVoiceStoreKick(t *testing.T) {
+	pub := &recordingPublisher{}
+	store := newTestVoiceStore(pub)
+	for _, userID := range []string{"usr_mod", "usr_1"} {
+		if _, err := store.Join(targetChannel, "chn_1", userID, nil, true, joinVoiceRequest{}); err != nil {
+			t.Fatalf("join %s: %v", userID, err)
+		}
+	}
+	pub.take()
+
+	if _, err := store.Kick(targetChannel, "chn_1", "usr_mod", "usr_2"); !errors.Is(err, errVoiceNotConnected) || sessionErrorStatus(err) != 404 {
+		t.Fatalf("expected 404 for kicking a non-member, got %v", err)
+	}
+
+	session, err := store.Kick(targetChannel, "chn_1", "usr_mod", "usr_1")
+	if err != nil {
+		t.Fatalf("kick: %v", err)
+	}
+	if len(session.Participants) != 1 || session.Participants[0].UserID != "usr_mod" {
+		t.Fatalf("expected only the moderator to remain, got %+v", session.Participants)
+	}
+	if _, ok := memoryOf(store).targetByUserID["usr_1"]; ok {
+		t.Fatal("expected kicked user's target index to be cleared")
+	}
+
+	events := pub.take()
+	if len(events) != 2 || events[0].EventType != "voice.participant.kicked" {
+		t.Fatalf("expected kick then update events, got %+v", events)
+	}
+	if kick := events[0].Payload.(voiceKickEvent); kick.UserID != "usr_1" || kick.KickedBy != "usr_mod" {
+		t.Fatalf("unexpected kick payload %+v", kick)
+	}
+
+	if _, err := store.Join(targetChannel, "chn_2", "usr_3", nil, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	if _, err := store.Kick(targetChannel, "chn_2", "usr_mod", "usr_3"); err != nil {
+		t.Fatalf("kick last participant: %v", err)
+	}
+	if _, ok := memoryOf(store).sessionsByTarget[targetKey(targetChannel, "chn_2")]; ok {
+		t.Fatal("expected kicking the last participant to delete the session")
+	}
+}
+
+func TestVoiceStoreListServerSessions(t *testing.T) {
+	store := newTestVoiceStore(noopPublisher{})
+	srv1, srv2 := "srv_1", "srv_2"
+	joins := []struct {
+		targetID string
+		userID   string
+		serverID *string
+	}{
+		{"chn_1", "usr_1", &srv1},
+		{"chn_1", "usr_2", &srv1},
+		{"chn_2", "usr_3", &srv1},
+		{"chn_3", "usr_4", &srv2},
+		{"chn_4", "usr_5", nil},
+	}
+	for _, join := range joins {
+		if _, err := store.Join(targetChannel, join.targetID, join.userID, join.serverID, true, joinVoiceRequest{}); err != nil {
+			t.Fatalf("join %s: %v", join.userID, err)
+		}
+	}
+
+	summaries, nextCursor, err := store.ListServerSessions("srv_1", false, 0, "")
+	if err != nil || nextCursor != "" {
+		t.Fatalf("list: %v", err)
+	}
+	if len(summaries) != 2 {
+		t.Fatalf("expected 2 sessions for srv_1, got %+v", summaries)
+	}
+	counts := map[string]int{}
+	for _, summary := range summaries {
+		counts[summary.TargetID] = summary.ParticipantCount
+		if summary.Participants != nil {
+			t.Fatalf("expected participants to be omitted, got %+v", summary.Participants)
+		}
+	}
+	if counts["chn_1"] != 2 || counts["chn_2"] != 1 {
+		t.Fatalf("unexpected participant counts %v", counts)
+	}
+
+	expanded, _, err := store.ListServerSessions("srv_2", true, 0, "")
+	if err != nil || len(expanded) != 1 || expanded[0].TargetID != "chn_3" || len(expanded[0].Participants) != 1 {
+		t.Fatalf("unexpected srv_2 sessions %+v", expanded)
+	}
+
+	if summaries, _, err := store.ListServerSessions("srv_3", false, 0, ""); err != nil || len(summaries) != 0 {
+		t.Fatalf("expected no sessions for an unknown server, got %+v", summaries)
+	}
+}
+
+func TestVoiceServerSessionsPagination(t *testing.T) {
+	store := newTestVoiceStore(noopPublisher{})
+	clock := store.clock.(*fakeClock)
+	s := &server{store: store}
+	serverID := "srv_1"
+	for i := 1; i <= 5; i++ {
+		if _, err := store.Join(targetChannel, fmt.Sprintf("chn_%d", i), fmt.Sprintf("usr_%d", i), &serverID, true, joinVoiceRequest{}); err != nil {
+			t.Fatalf("join: %v", err)
+		}
+		clock.Advance(time.Second)
+	}
+
+	type page struct {
+		Sessions   []voiceSessionSummary `json:"sessions"`
+		NextCursor string                `json:"nextCursor"`
+	}
+	list := func(query string) page {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/v1/voice/servers/srv_1/sessions"+query, nil)
+		req.Header.Set("X-Voice-User-Id", "usr_admin")
+		rec := httptest.NewRecorder()
+		s.handleVoiceServers(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("list %s: expected 200, got %d: %s", query, rec.Code, rec.Body.String())
+		}
+		var body page
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return body
+	}
+	targets := func(p page) []string {
+		ids := make([]string, len(p.Sessions))
+		for i, summary := range p.Sessions {
+			ids[i] = summary.TargetID
+		}
+		return ids
+	}
+
+	first := list("?limit=2")
+	if !slices.Equal(targets(first), []string{"chn_1", "chn_2"}) || first.NextCursor == "" {
+		t.Fatalf("unexpected first page %v (cursor %q)", targets(first), first.NextCursor)
+	}
+	second := list("?limit=2&cursor=" + first.NextCursor)
+	if !slices.Equal(targets(second), []string{"chn_3", "chn_4"}) || second.NextCursor == "" {
+		t.Fatalf("unexpected second page %v (cursor %q)", targets(second), second.NextCursor)
+	}
+
+	// A session ending between pages doesn't shift what comes next.
+	if _, err := store.Leave(targetChannel, "chn_1", "usr_1"); err != nil {
+		t.Fatalf("leave: %v", err)
+	}
+	last := list("?limit=2&cursor=" + second.NextCursor)
+	if !slices.Equal(targets(last), []string{"chn_5"}) || last.NextCursor != "" {
+		t.Fatalf("unexpected final page %v (cursor %q)", targets(last), last.NextCursor)
+	}
+
+	if all := list(""); len(all.Sessions) != 4 || all.NextCursor != "" {
+		t.Fatalf("expected one page without a limit, got %d (cursor %q)", len(all.Sessions), all.NextCursor)
+	}
+
+	for _, query := range []string{"?limit=0", "?limit=ten", "?cursor=not-a-cursor"} {
+		req := httptest.NewRequest(http.MethodGet, "/v1/voice/servers/srv_1/sessions"+query, nil)
+		req.Header.Set("X-Voice-User-Id", "usr_admin")
+		rec := httptest.NewRecorder()
+		s.handleVoiceServers(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", query, rec.Code)
+		}
+	}
+}
+
+func TestVoiceServerSessionsLimitIsCapped(t *testing.T) {
+	store := newTestVoiceStore(noopPublisher{})
+	serverID := "srv_1"
+	for i := range maxSessionsPageLimit + 1 {
+		if _, err := store.Join(targetChannel, fmt.Sprintf("chn_%d", i), fmt.Sprintf("usr_%d", i), &serverID, true, joinVoiceRequest{}); err != nil {
+			t.Fatalf("join: %v", err)
+		}
+	}
+
+	summaries, nextCursor, err := store.ListServerSessions("srv_1", false, maxSessionsPageLimit*5, "")
+	if err != nil || len(summaries) != maxSessionsPageLimit || nextCursor == "" {
+		t.Fatalf("expected a capped page of %d with a cursor, got %d (%q, %v)", maxSessionsPageLimit, len(summaries), nextCursor, err)
+	}
+	rest, nextCursor, err := store.ListServerSessions("srv_1", false, maxSessionsPageLimit, nextCursor)
+	if err != nil || len(rest) != 1 || nextCursor != "" {
+		t.Fatalf("expected the last session on its own page, got %d (%q, %v)", len(rest), nextCursor, err)
+	}
+}
+
+func TestVoiceStoreClearsStaleSpeaking(t *testing.T) {
+	pub := &recordingPublisher{}
+	store := newTestVoiceStore(pub)
+	clock := store.clock.(*fakeClock)
+	if _, err := store.Join(targetChannel, "chn_1", "usr_1", nil, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	if _, err := store.Heartbeat(targetChannel, "chn_1", "usr_1", heartbeatRequest{Speaking: boolPtr(true)}); err != nil {
+		t.Fatalf("heartbeat: %v", err)
+	}
+	pub.take()
+
+	clock.Advance(time.Second)
+	if err := store.ClearStaleSpeaking(); err != nil {
+		t.Fatalf("sweep: %v", err)
+	}
+	participant := memoryOf(store).sessionsByTarget[targetKey(targetChannel, "chn_1")].Participants["usr_1"]
+	if !participant.Speaking || len(pub.take()) != 0 {
+		t.Fatal("expected a fresh speaking flag to be left alone")
+	}
+
+	clock.Advance(2 * time.Second)
+	if err := store.ClearStaleSpeaking(); err != nil {
+		t.Fatalf("sweep: %v", err)
+	}
+	if participant.Speaking {
+		t.Fatal("expected the stale speaking flag to clear")
+	}
+	event := sessionEventFor(t, pub.take(), "voice:channel:chn_1")
+	if event.Participants[0].Speaking {
+		t.Fatalf("expected the published state to show not speaking, got %+v", event.Participants[0])
+	}
+}
+
+func TestVoiceStoreCleanupExpired(t *testing.T) {
+	pub := &recordingPublisher{}
+	store := newTestVoiceStore(pub)
+	clock := store.clock.(*fakeClock)
+	for _, userID := range []string{"usr_1", "usr_2"} {
+		if _, err := store.Join(targetChannel, "chn_1", userID, nil, true, joinVoiceRequest{}); err != nil {
+			t.Fatalf("join %s: %v", userID, err)
+		}
+	}
+
+	clock.Advance(20 * time.Second)
+	if _, err := store.Heartbeat(targetChannel, "chn_1", "usr_2", heartbeatRequest{}); err != nil {
+		t.Fatalf("heartbeat: %v", err)
+	}
+	pub.take()
+
+	clock.Advance(15 * time.Second)
+	if err := store.CleanupExpired(); err != nil {
+		t.Fatalf("sweep: %v", err)
+	}
+	events := pub.take()
+	if changes := creatorEvents(events); len(changes) != 1 || changes[0].CreatedBy != "usr_2" {
+		t.Fatalf("expected the expired creator's role to pass to usr_2, got %+v", changes)
+	}
+	event := sessionEventFor(t, events[:1], "voice:channel:chn_1")
+	if len(event.Participants) != 1 || event.Participants[0].UserID != "usr_2" {
+		t.Fatalf("expected only the user who heartbeated to survive the grace, got %+v", event.Participants)
+	}
+
+	clock.Advance(time.Minute)
+	if err := store.CleanupExpired(); err != nil {
+		t.Fatalf("sweep: %v", err)
+	}
+	if _, ok := memoryOf(store).sessionsByTarget[targetKey(targetChannel, "chn_1")]; ok {
+		t.Fatal("expected the session to be removed once every participant expired")
+	}
+	if len(memoryOf(store).targetByUserID) != 0 {
+		t.Fatalf("expected target index to be empty, got %v", memoryOf(store).targetByUserID)
+	}
+}
+
+func TestVoiceStoreReleaseAllEndsEverySession(t *testing.T) {
+	pub := &recordingPublisher{}
+	store := newTestVoiceStore(pub)
+	for _, target := range []string{"chn_1", "chn_2"} {
+		if _, err := store.Join(targetChannel, target, "usr_"+target, nil, true, joinVoiceRequest{}); err != nil {
+			t.Fatalf("join %s: %v", target, err)
+		}
+	}
+	pub.take()
+
+	if err := store.ReleaseAll(); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	events := pub.take()
+	if len(events) != 2 {
+		t.Fatalf("expected one update per session, got %d", len(events))
+	}
+	for _, event := range events {
+		if payload := event.Payload.(voiceSessionEvent); len(payload.Participants) != 0 {
+			t.Fatalf("expected %s to be published empty, got %+v", event.Topic, payload.Participants)
+		}
+	}
+	if len(memoryOf(store).sessionsByTarget) != 0 || len(memoryOf(store).targetByUserID) != 0 {
+		t.Fatal("expected every session and index entry to be removed")
+	}
+}
+
+func TestVoiceStoreRaiseHandQueue(t *testing.T) {
+	store := newTestVoiceStore(noopPublisher{})
+	clock := store.clock.(*fakeClock)
+	for _, userID := range []string{"usr_1", "usr_2", "usr_3"} {
+		if _, err := store.Join(targetChannel, "chn_1", userID, nil, true, joinVoiceRequest{}); err != nil {
+			t.Fatalf("join %s: %v", userID, err)
+		}
+	}
+
+	for _, userID := range []string{"usr_3", "usr_1"} {
+		if _, err := store.RaiseHand(targetChannel, "chn_1", userID, true); err != nil {
+			t.Fatalf("raise %s: %v", userID, err)
+		}
+		clock.Advance(time.Second)
+	}
+
+	// Raising again keeps usr_3 at the front of the queue.
+	session, err := store.RaiseHand(targetChannel, "chn_1", "usr_3", true)
+	if err != nil {
+		t.Fatalf("raise again: %v", err)
+	}
+	if !slices.Equal(session.RaisedHands, []string{"usr_3", "usr_1"}) {
+		t.Fatalf("expected the queue ordered by raise time, got %v", session.RaisedHands)
+	}
+	if participant := findParticipant(t, session.Participants, "usr_3"); !participant.HandRaised || participant.HandRaisedAt == nil {
+		t.Fatalf("expected the raise to be visible on the participant, got %+v", participant)
+	}
+
+	session, err = store.RaiseHand(targetChannel, "chn_1", "usr_3", false)
+	if err != nil {
+		t.Fatalf("lower: %v", err)
+	}
+	if !slices.Equal(session.RaisedHands, []string{"usr_1"}) {
+		t.Fatalf("expected only usr_1 left in the queue, got %v", session.RaisedHands)
+	}
+	if participant := findParticipant(t, session.Participants, "usr_3"); participant.HandRaised || participant.HandRaisedAt != nil {
+		t.Fatalf("expected lowering to clear the timestamp, got %+v", participant)
+	}
+
+	if _, err := store.RaiseHand(targetChannel, "chn_1", "usr_9", true); err != errVoiceNotConnected {
+		t.Fatalf("expected non-participants to be rejected, got %v", err)
+	}
+}
+
+func TestVoiceStoreCameraToggle(t *testing.T) {
+	pub := &recordingPublisher{}
+	store := newTestVoiceStore(pub)
+	if _, err := store.Join(targetChannel, "chn_1", "usr_1", nil, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	if _, err := store.UpdateScreenShare(targetChannel, "chn_1", "usr_1", true, nil); err != nil {
+		t.Fatalf("screen share: %v", err)
+	}
+	pub.take()
+
+	session, err := store.UpdateCamera(targetChannel, "chn_1", "usr_1", true)
+	if err != nil {
+		t.Fatalf("camera on: %v", err)
+	}
+	if participant := findParticipant(t, session.Participants, "usr_1"); !participant.CameraOn || !participant.ScreenSharing {
+		t.Fatalf("expected camera and screen share together, got %+v", participant)
+	}
+	if !session.Features.Video {
+		t.Fatal("expected the video feature to be advertised")
+	}
+	if event := sessionEventFor(t, pub.take(), "voice:channel:chn_1"); !event.Participants[0].CameraOn {
+		t.Fatal("expected the camera change to be published")
+	}
+
+	session, err = store.UpdateCamera(targetChannel, "chn_1", "usr_1", false)
+	if err != nil {
+		t.Fatalf("camera off: %v", err)
+	}
+	if participant := findParticipant(t, session.Participants, "usr_1"); participant.CameraOn || !participant.ScreenSharing {
+		t.Fatalf("expected only the camera to turn off, got %+v", participant)
+	}
+}
+
+func TestVoiceCameraDisabled(t *testing.T) {
+	cfg := testVoiceStoreConfig(noopPublisher{})
+	cfg.EnableVideo = false
+	store := newVoiceStore(cfg)
+	s := &server{store: store}
+	if _, err := store.Join(targetChannel, "chn_1", "usr_1", nil, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+
+	if rec := postVoiceAction(t, s, "chn_1/camera", "usr_1", `{"cameraOn":true}`); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 with video disabled, got %d", rec.Code)
+	}
+	session, err := store.Get(targetChannel, "chn_1", "usr_1")
+	if err != nil || session == nil {
+		t.Fatalf("get: %+v (%v)", session, err)
+	}
+	if session.Features.Video || session.Participants[0].CameraOn {
+		t.Fatalf("expected no video state, got %+v", session)
+	}
+}
+
+func TestVoicePriorityRequiresModerator(t *testing.T) {
+	store := newTestVoiceStore(noopPublisher{})
+	s := &server{store: store}
+	for _, userID := range []string{"usr_1", "usr_2"} {
+		if _, err := store.Join(targetChannel, "chn_1", userID, nil, true, joinVoiceRequest{}); err != nil {
+			t.Fatalf("join %s: %v", userID, err)
+		}
+	}
+
+	if rec := postVoiceAction(t, s, "chn_1/participants/usr_2/priority", "usr_1", `{}`); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected non-moderators to be refused, got %d", rec.Code)
+	}
+	session, err := store.Get(targetChannel, "chn_1", "usr_1")
+	if err != nil || session == nil || findParticipant(t, session.Participants, "usr_2").PrioritySpeaker {
+		t.Fatalf("expected no priority speaker, got %+v (%v)", session, err)
+	}
+}
+
+func TestVoiceStoreLockedSessionAdmitsOnlyMembers(t *testing.T) {
+	store := newTestVoiceStore(noopPublisher{})
+	s := &server{store: store}
+	for _, userID := range []string{"usr_mod", "usr_1"} {
+		if _, err := store.Join(targetChannel, "chn_1", userID, nil, true, joinVoiceRequest{}); err != nil {
+			t.Fatalf("join %s: %v", userID, err)
+		}
+	}
+
+	if rec := postVoiceAction(t, s, "chn_1/lock", "usr_1", `{"locked":true}`); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected non-moderators to be refused, got %d", rec.Code)
+	}
+	session, err := store.SetLocked(targetChannel, "chn_1", "usr_mod", true)
+	if err != nil || !session.Locked {
+		t.Fatalf("expected the session to lock, got %+v (%v)", session, err)
+	}
+
+	_, err = store.Join(targetChannel, "chn_1", "usr_2", nil, true, joinVoiceRequest{})
+	if !errors.Is(err, errVoiceSessionLocked) || sessionErrorStatus(err) != http.StatusConflict {
+		t.Fatalf("expected a new join to be refused with 409, got %v", err)
+	}
+
+	if _, err := store.Join(targetChannel, "chn_1", "usr_1", nil, true, joinVoiceRequest{Muted: boolPtr(true)}); err != nil {
+		t.Fatalf("expected a member to rejoin a locked room: %v", err)
+	}
+	if _, err := store.Heartbeat(targetChannel, "chn_1", "usr_1", heartbeatRequest{Speaking: boolPtr(true)}); err != nil {
+		t.Fatalf("expected a member to heartbeat in a locked room: %v", err)
+	}
+	if _, err := store.UpdateState(targetChannel, "chn_1", "usr_1", updateVoiceStateRequest{Muted: boolPtr(false)}); err != nil {
+		t.Fatalf("expected a member to update state in a locked room: %v", err)
+	}
+
+	if _, err := store.SetLocked(targetChannel, "chn_1", "usr_mod", false); err != nil {
+		t.Fatalf("unlock: %v", err)
+	}
+	if _, err := store.Join(targetChannel, "chn_1", "usr_2", nil, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("expected joins to resume after unlocking: %v", err)
+	}
+}
+
+func TestVoiceSessionMetadata(t *testing.T) {
+	pub := &recordingPublisher{}
+	store := newTestVoiceStore(pub)
+	s := &server{store: store}
+	for _, userID := range []string{"usr_mod", "usr_1"} {
+		if _, err := store.Join(targetChannel, "chn_1", userID, nil, true, joinVoiceRequest{}); err != nil {
+			t.Fatalf("join %s: %v", userID, err)
+		}
+	}
+	pub.take()
+
+	if rec := postVoiceAction(t, s, "chn_1/metadata", "usr_1", `{"metadata":{"topic":"standup"}}`); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected non-moderators to be refused, got %d", rec.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/voice/channels/chn_1/metadata", strings.NewReader(`{"metadata":{"topic":"standup"}}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Voice-User-Id", "usr_mod")
+	req.Header.Set("X-Voice-Moderator", "true")
+	rec := httptest.NewRecorder()
+	s.handleVoiceChannels(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var session voiceSession
+	if err := json.Unmarshal(rec.Body.Bytes(), &session); err != nil {
+		t.Fatalf("decode session: %v", err)
+	}
+	if session.Metadata["topic"] != "standup" {
+		t.Fatalf("expected metadata in the response, got %+v", session.Metadata)
+	}
+	if event := sessionEventFor(t, pub.take(), sessionTopic(targetChannel, "chn_1")); event.Metadata["topic"] != "standup" {
+		t.Fatalf("expected metadata in the event, got %+v", event.Metadata)
+	}
+
+	claims := parseParticipantToken(t, session.Signaling.ParticipantToken, jwt.SigningMethodHS256, []byte("secret"))
+	if claims.RoomConfig == nil || claims.RoomConfig.Metadata != `{"topic":"standup"}` {
+		t.Fatalf("expected room metadata in the token, got %+v", claims.RoomConfig)
+	}
+	other, err := store.Get(targetChannel, "chn_1", "usr_1")
+	if err != nil || other == nil || other.Metadata["topic"] != "standup" {
+		t.Fatalf("expected other participants to see the metadata, got %+v (%v)", other, err)
+	}
+	claims = parseParticipantToken(t, other.Signaling.ParticipantToken, jwt.SigningMethodHS256, []byte("secret"))
+	if claims.RoomConfig == nil || claims.RoomConfig.Metadata != `{"topic":"standup"}` {
+		t.Fatalf("expected other participants' tokens to be re-signed, got %+v", claims.RoomConfig)
+	}
+
+	session, err = store.SetMetadata(targetChannel, "chn_1", "usr_mod", map[string]string{})
+	if err != nil || session.Metadata == nil || len(session.Metadata) != 0 {
+		t.Fatalf("expected an empty map to clear the metadata, got %+v (%v)", session.Metadata, err)
+	}
+}
+
+func TestVoiceSessionMetadataCaps(t *testing.T) {
+	store := newTestVoiceStore(noopPublisher{})
+	if _, err := store.Join(targetChannel, "chn_1", "usr_mod", nil, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+
+	tooMany := map[string]string{}
+	for i := range maxSessionMetadataKeys + 1 {
+		tooMany[fmt.Sprintf("key%d", i)] = "v"
+	}
+	for name, metadata := range map[string]map[string]string{
+		"too large": {"topic": strings.Repeat("x", maxSessionMetadataBytes)},
+		"too many":  tooMany,
+		"empty key": {" ": "value"},
+	} {
+		_, err := store.SetMetadata(targetChannel, "chn_1", "usr_mod", metadata)
+		if err == nil || sessionErrorStatus(err) != http.StatusBadRequest {
+			t.Fatalf("%s: expected a 400 error, got %v", name, err)
+		}
+	}
+
+	session, err := store.Get(targetChannel, "chn_1", "usr_mod")
+	if err != nil || len(session.Metadata) != 0 {
+		t.Fatalf("expected rejected metadata to leave the session untouched, got %+v (%v)", session, err)
+	}
+
+	if _, err := store.SetMetadata(targetChannel, "chn_2", "usr_mod", map[string]string{"topic": "x"}); !errors.Is(err, errVoiceSessionNotFound) {
+		t.Fatalf("expected errVoiceSessionNotFound, got %v", err)
+	}
+}
+
+func TestVoiceStoreCleanupRemovesIdleMutedParticipants(t *testing.T) {
+	pub := &recordingPublisher{}
+	cfg := testVoiceStoreConfig(pub)
+	cfg.IdleTimeout = 10 * time.Minute
+	store := newVoiceStore(cfg)
+	clock := store.clock.(*fakeClock)
+
+	if _, err := store.Join(targetChannel, "chn_1", "usr_idle", nil, true, joinVoiceRequest{Muted: boolPtr(true)}); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	if _, err := store.Join(targetChannel, "chn_1", "usr_speaker", nil, true, joinVoiceRequest{Muted: boolPtr(true)}); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	if _, err := store.Join(targetChannel, "chn_1", "usr_listener", nil, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+
+	// Everyone keeps heartbeating, so only the idle rule can remove anyone.
+	for range 11 {
+		clock.Advance(time.Minute)
+		for _, userID := range []string{"usr_idle", "usr_speaker", "usr_listener"} {
+			body := heartbeatRequest{}
+			if userID == "usr_speaker" {
+				body.Speaking = boolPtr(true)
+			}
+			if _, err := store.Heartbeat(targetChannel, "chn_1", userID, body); err != nil {
+				t.Fatalf("heartbeat %s: %v", userID, err)
+			}
+		}
+	}
+	pub.take()
+
+	if err := store.CleanupExpired(); err != nil {
+		t.Fatalf("sweep: %v", err)
+	}
+	events := pub.take()
+	if changes := creatorEvents(events); len(changes) != 1 || changes[0].PreviousCreatedBy != "usr_idle" {
+		t.Fatalf("expected the idle creator's role to pass on, got %+v", changes)
+	}
+	event := sessionEventFor(t, events[:1], "voice:channel:chn_1")
+	if len(event.Participants) != 2 {
+		t.Fatalf("expected only the idle muted participant to leave, got %+v", event.Participants)
+	}
+	for _, participant := range event.Participants {
+		if participant.UserID == "usr_idle" {
+			t.Fatalf("expected usr_idle to be removed, got %+v", event.Participants)
+		}
+	}
+	if _, ok := memoryOf(store).targetByUserID["usr_idle"]; ok {
+		t.Fatal("expected the idle participant's target index to be cleared")
+	}
+}
+
+func TestVoiceStoreAccumulatesSpeakingTime(t *testing.T) {
+	store := newTestVoiceStore(noopPublisher{})
+	clock := store.clock.(*fakeClock)
+	speak := func(speaking bool) voiceSession {
+		t.Helper()
+		session, err := store.UpdateState(targetChannel, "chn_1", "usr_1", updateVoiceStateRequest{Speaking: boolPtr(speaking)})
+		if err != nil {
+			t.Fatalf("update speaking=%v: %v", speaking, err)
+		}
+		return session
+	}
+	totalMs := func(session voiceSession) int64 {
+		t.Helper()
+		return findParticipant(t, session.Participants, "usr_1").TotalSpeakingMs
+	}
+
+	if _, err := store.Join(targetChannel, "chn_1", "usr_1", nil, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	speak(true)
+	clock.Advance(1500 * time.Millisecond)
+	speak(true) // a repeated report does not restart the interval
+	clock.Advance(500 * time.Millisecond)
+	if got := totalMs(speak(false)); got != 2000 {
+		t.Fatalf("expected 2000ms after the first interval, got %d", got)
+	}
+
+	clock.Advance(10 * time.Second)
+	speak(true)
+	clock.Advance(time.Second)
+	session, err := store.Get(targetChannel, "chn_1", "usr_1")
+	if err != nil || session == nil {
+		t.Fatalf("get: %+v (%v)", session, err)
+	}
+	if got := totalMs(*session); got != 3000 {
+		t.Fatalf("expected the open interval to be counted, got %d", got)
+	}
+
+	// A stale flag cleared by the sweep only counts up to the last report.
+	clock.Advance(5 * time.Second)
+	if err := store.ClearStaleSpeaking(); err != nil {
+		t.Fatalf("clear stale: %v", err)
+	}
+	if got := totalMs(speak(false)); got != 2000 {
+		t.Fatalf("expected the stale period to be excluded, got %d", got)
+	}
+
+	if _, err := store.Leave(targetChannel, "chn_1", "usr_1"); err != nil {
+		t.Fatalf("leave: %v", err)
+	}
+	rejoined, err := store.Join(targetChannel, "chn_1", "usr_1", nil, true, joinVoiceRequest{})
+	if err != nil {
+		t.Fatalf("rejoin: %v", err)
+	}
+	if got := totalMs(rejoined); got != 0 {
+		t.Fatalf("expected leaving to reset the total, got %d", got)
+	}
+}
+
+func TestVoiceStoreBanOutlivesSessionUntilExpiry(t *testing.T) {
+	pub := &recordingPublisher{}
+	store := newTestVoiceStore(pub)
+	clock := store.clock.(*fakeClock)
+	for _, userID := range []string{"usr_mod", "usr_1"} {
+		if _, err := store.Join(targetChannel, "chn_1", userID, nil, true, joinVoiceRequest{}); err != nil {
+			t.Fatalf("join %s: %v", userID, err)
+		}
+	}
+	pub.take()
+
+	session, err := store.Ban(targetChannel, "chn_1", "usr_mod", "usr_1")
+	if err != nil || session == nil || len(session.Participants) != 1 {
+		t.Fatalf("expected the banned user to be kicked, got %+v (%v)", session, err)
+	}
+	var kicked *voiceKickEvent
+	for _, event := range pub.take() {
+		if event.EventType == "voice.participant.kicked" {
+			payload := event.Payload.(voiceKickEvent)
+			kicked = &payload
+		}
+	}
+	if kicked == nil || !kicked.Banned || kicked.UserID != "usr_1" {
+		t.Fatalf("expected a kicked event marked as a ban, got %+v", kicked)
+	}
+
+	_, err = store.Join(targetChannel, "chn_1", "usr_1", nil, true, joinVoiceRequest{})
+	if !errors.Is(err, errVoiceBanned) || sessionErrorStatus(err) != http.StatusForbidden {
+		t.Fatalf("expected the rejoin to be refused with 403, got %v", err)
+	}
+
+	// The room empties and is started again; the ban still holds.
+	if _, err := store.Leave(targetChannel, "chn_1", "usr_mod"); err != nil {
+		t.Fatalf("leave: %v", err)
+	}
+	if _, err := store.Join(targetChannel, "chn_1", "usr_mod", nil, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("rejoin moderator: %v", err)
+	}
+	if _, err := store.Join(targetChannel, "chn_1", "usr_1", nil, true, joinVoiceRequest{}); !errors.Is(err, errVoiceBanned) {
+		t.Fatalf("expected the ban to survive the session ending, got %v", err)
+	}
+	if _, err := store.Join(targetChannel, "chn_2", "usr_1", nil, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("expected the ban to apply to chn_1 only: %v", err)
+	}
+
+	clock.Advance(10 * time.Minute)
+	if _, err := store.Join(targetChannel, "chn_1", "usr_1", nil, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("expected the ban to lift once expired: %v", err)
+	}
+}
+
+func TestVoiceSessionWithholdsTokenFromNonParticipants(t *testing.T) {
+	store := newTestVoiceStore(noopPublisher{})
+	s := &server{store: store}
+	for _, userID := range []string{"usr_mod", "usr_1"} {
+		if _, err := store.Join(targetChannel, "chn_1", userID, nil, true, joinVoiceRequest{}); err != nil {
+			t.Fatalf("join %s: %v", userID, err)
+		}
+	}
+	if _, err := store.Ban(targetChannel, "chn_1", "usr_mod", "usr_1"); err != nil {
+		t.Fatalf("ban: %v", err)
+	}
+
+	get := func(userID string) voiceSession {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/v1/voice/channels/chn_1", nil)
+		req.Header.Set("X-Voice-User-Id", userID)
+		rec := httptest.NewRecorder()
+		s.handleVoiceChannels(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("get as %s: expected 200, got %d: %s", userID, rec.Code, rec.Body.String())
+		}
+		var session voiceSession
+		if err := json.Unmarshal(rec.Body.Bytes(), &session); err != nil {
+			t.Fatalf("decode %s: %v", rec.Body.String(), err)
+		}
+		return session
+	}
+
+	if session := get("usr_1"); session.Signaling.ParticipantToken != "" {
+		t.Fatal("expected a banned viewer to get no LiveKit token")
+	}
+	if session := get("usr_9"); session.Signaling.ParticipantToken != "" || session.Signaling.URL == "" {
+		t.Fatalf("expected a viewer to see the room but get no token, got %+v", session.Signaling)
+	}
+	if session := get("usr_mod"); session.Signaling.ParticipantToken == "" {
+		t.Fatal("expected a participant to keep their token")
+	}
+}
+
+func TestVoiceStoreScreenShareAudio(t *testing.T) {
+	store := newTestVoiceStore(noopPublisher{})
+	s := &server{store: store}
+	if _, err := store.Join(targetChannel, "chn_1", "usr_1", nil, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+
+	session, err := store.UpdateScreenShare(targetChannel, "chn_1", "usr_1", true, boolPtr(true))
+	if err != nil {
+		t.Fatalf("share with audio: %v", err)
+	}
+	if participant := findParticipant(t, session.Participants, "usr_1"); !participant.ScreenSharing || !participant.ScreenShareAudio {
+		t.Fatalf("expected screen share with audio, got %+v", participant)
+	}
+
+	// Omitting shareAudio while still sharing keeps the current setting.
+	session, err = store.UpdateScreenShare(targetChannel, "chn_1", "usr_1", true, nil)
+	if err != nil {
+		t.Fatalf("share again: %v", err)
+	}
+	if !findParticipant(t, session.Participants, "usr_1").ScreenShareAudio {
+		t.Fatal("expected audio to stay on while sharing continues")
+	}
+
+	session, err = store.UpdateScreenShare(targetChannel, "chn_1", "usr_1", false, nil)
+	if err != nil {
+		t.Fatalf("stop sharing: %v", err)
+	}
+	if participant := findParticipant(t, session.Participants, "usr_1"); participant.ScreenSharing || participant.ScreenShareAudio {
+		t.Fatalf("expected stopping the share to clear audio, got %+v", participant)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/voice/channels/chn_1/screen-share", strings.NewReader(`{"screenSharing":false,"shareAudio":true}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Voice-User-Id", "usr_1")
+	req.Header.Set("X-Screen-Share-Enabled", "true")
+	rec := httptest.NewRecorder()
+	s.handleVoiceChannels(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected shareAudio without screenSharing to be rejected, got %d", rec.Code)
+	}
+}
+
+func TestVoiceStorePerSessionReconnectGrace(t *testing.T) {
+	pub := &recordingPublisher{}
+	store := newTestVoiceStore(pub)
+	clock := store.clock.(*fakeClock)
+
+	session, err := store.Join(targetChannel, "chn_long", "usr_1", nil, true, joinVoiceRequest{ReconnectGrace: 2 * time.Minute})
+	if err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	if session.ReconnectGraceMs != 120000 {
+		t.Fatalf("expected the custom grace to be reported, got %d", session.ReconnectGraceMs)
+	}
+	if _, err := store.Join(targetChannel, "chn_default", "usr_2", nil, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+
+	clock.Advance(time.Minute)
+	if err := store.CleanupExpired(); err != nil {
+		t.Fatalf("sweep: %v", err)
+	}
+	if _, ok := memoryOf(store).sessionsByTarget[targetKey(targetChannel, "chn_default")]; ok {
+		t.Fatal("expected the default 30s grace to expire chn_default")
+	}
+	if _, ok := memoryOf(store).sessionsByTarget[targetKey(targetChannel, "chn_long")]; !ok {
+		t.Fatal("expected the 2m grace to keep chn_long")
+	}
+
+	clock.Advance(90 * time.Second)
+	if err := store.CleanupExpired(); err != nil {
+		t.Fatalf("sweep: %v", err)
+	}
+	if _, ok := memoryOf(store).sessionsByTarget[targetKey(targetChannel, "chn_long")]; ok {
+		t.Fatal("expected chn_long to expire after its own grace")
+	}
+}
+
+func TestVoiceStoreClampsReconnectGrace(t *testing.T) {
+	store := newTestVoiceStore(noopPublisher{})
+	s := &server{store: store}
+	join := func(userID, grace string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/v1/voice/channels/chn_1/join", strings.NewReader(`{}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Voice-User-Id", userID)
+		req.Header.Set("X-Voice-Reconnect-Grace-Ms", grace)
+		rec := httptest.NewRecorder()
+		s.handleVoiceChannels(rec, req)
+		return rec
+	}
+
+	for _, tc := range []struct {
+		header string
+		want   int64
+	}{
+		{"1000", 5000},
+		{"3600000", 300000},
+		{"45000", 45000},
+	} {
+		rec := join("usr_1", tc.header)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("join with %s: status %d", tc.header, rec.Code)
+		}
+		session, err := store.Get(targetChannel, "chn_1", "usr_1")
+		if err != nil || session == nil || session.ReconnectGraceMs != tc.want {
+			t.Fatalf("expected %s to resolve to %d, got %+v (%v)", tc.header, tc.want, session, err)
+		}
+	}
+
+	if rec := join("usr_1", "soon"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected a malformed header to be rejected, got %d", rec.Code)
+	}
+}
+
+func TestVoiceStoreMoveParticipant(t *testing.T) {
+	pub := &recordingPublisher{}
+	store := newTestVoiceStore(pub)
+	for _, userID := range []string{"usr_mod", "usr_1"} {
+		if _, err := store.Join(targetChannel, "chn_1", userID, copyStringPtr("srv_1"), true, joinVoiceRequest{Muted: boolPtr(true), Deafened: boolPtr(true)}); err != nil {
+			t.Fatalf("join %s: %v", userID, err)
+		}
+	}
+	if _, err := store.RaiseHand(targetChannel, "chn_1", "usr_1", true); err != nil {
+		t.Fatalf("raise hand: %v", err)
+	}
+	suffix := memoryOf(store).sessionsByTarget[targetKey(targetChannel, "chn_1")].Participants["usr_1"].IdentitySuffix
+	pub.take()
+
+	session, err := store.Move(targetChannel, "chn_1", "usr_mod", "usr_1", targetChannel, "chn_2")
+	if err != nil {
+		t.Fatalf("move: %v", err)
+	}
+	if len(session.Participants) != 1 || session.Participants[0].UserID != "usr_mod" {
+		t.Fatalf("expected usr_1 to leave chn_1, got %+v", session.Participants)
+	}
+
+	events := pub.take()
+	if from := sessionEventFor(t, filterTopic(events, "voice:channel:chn_1", "voice.participants.updated"), "voice:channel:chn_1"); len(from.Participants) != 1 {
+		t.Fatalf("expected a leave update for chn_1, got %+v", from.Participants)
+	}
+	to := sessionEventFor(t, filterTopic(events, "voice:channel:chn_2", "voice.participants.updated"), "voice:channel:chn_2")
+	if len(to.Participants) != 1 || to.Participants[0].UserID != "usr_1" {
+		t.Fatalf("expected a join update for chn_2, got %+v", to.Participants)
+	}
+	if moved := filterTopic(events, "voice:channel:chn_1", "voice.participant.moved"); len(moved) != 1 || moved[0].Payload.(voiceMoveEvent).ToTargetID != "chn_2" {
+		t.Fatalf("expected a moved event pointing at chn_2, got %+v", moved)
+	}
+
+	moved, err := store.Get(targetChannel, "chn_2", "usr_1")
+	if err != nil || moved == nil {
+		t.Fatalf("get chn_2: %+v (%v)", moved, err)
+	}
+	participant := findParticipant(t, moved.Participants, "usr_1")
+	if !participant.Muted || !participant.Deafened || participant.HandRaised {
+		t.Fatalf("expected mute/deafen to carry over and the raised hand to reset, got %+v", participant)
+	}
+	if moved.ServerID == nil || *moved.ServerID != "srv_1" {
+		t.Fatalf("expected the destination to belong to the same server, got %v", moved.ServerID)
+	}
+	record := memoryOf(store).sessionsByTarget[targetKey(targetChannel, "chn_2")]
+	if record.Participants["usr_1"].IdentitySuffix != suffix {
+		t.Fatal("expected the LiveKit identity to be kept")
+	}
+	if claims := parseParticipantToken(t, moved.Signaling.ParticipantToken, jwt.SigningMethodHS256, []byte("secret")); claims.Video.Room != roomName(targetChannel, "chn_2") {
+		t.Fatalf("expected a token for the new room, got %+v", claims.Video)
+	}
+	if memoryOf(store).targetByUserID["usr_1"] != targetKey(targetChannel, "chn_2") {
+		t.Fatal("expected the user index to follow the move")
+	}
+
+	if _, err := store.Move(targetChannel, "chn_1", "usr_mod", "usr_9", targetChannel, "chn_2"); !errors.Is(err, errVoiceNotConnected) || sessionErrorStatus(err) != http.StatusNotFound {
+		t.Fatalf("expected moving a non-member to 404, got %v", err)
+	}
+}
+
+func TestVoiceStoreMoveChecksDestination(t *testing.T) {
+	store := newTestVoiceStore(noopPublisher{})
+	srv1, srv2 := "srv_1", "srv_2"
+	joins := []struct {
+		targetID string
+		userID   string
+		serverID *string
+	}{
+		{"chn_1", "usr_mod", &srv1},
+		{"chn_1", "usr_1", &srv1},
+		{"chn_1", "usr_2", &srv1},
+		{"chn_2", "usr_3", &srv2},
+		{"chn_3", "usr_4", &srv1},
+	}
+	for _, join := range joins {
+		if _, err := store.Join(targetChannel, join.targetID, join.userID, join.serverID, true, joinVoiceRequest{}); err != nil {
+			t.Fatalf("join %s: %v", join.userID, err)
+		}
+	}
+
+	// An existing destination keeps its own server.
+	if _, err := store.Move(targetChannel, "chn_1", "usr_mod", "usr_1", targetChannel, "chn_2"); err != nil {
+		t.Fatalf("move: %v", err)
+	}
+	if record := memoryOf(store).sessionsByTarget[targetKey(targetChannel, "chn_2")]; record.ServerID == nil || *record.ServerID != srv2 {
+		t.Fatalf("expected chn_2 to stay in srv_2, got %v", record.ServerID)
+	}
+
+	store.maxSpeakers = 2
+	if _, err := store.Move(targetChannel, "chn_1", "usr_mod", "usr_2", targetChannel, "chn_2"); !errors.Is(err, errVoiceSessionFull) {
+		t.Fatalf("expected a full destination to refuse the move, got %v", err)
+	}
+	store.maxSpeakers = 0
+
+	if _, err := store.SetLocked(targetChannel, "chn_3", "usr_4", true); err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+	if _, err := store.Move(targetChannel, "chn_1", "usr_mod", "usr_2", targetChannel, "chn_3"); !errors.Is(err, errVoiceSessionLocked) {
+		t.Fatalf("expected a locked destination to refuse the move, got %v", err)
+	}
+
+	store.maxServerSessions = 2
+	if _, err := store.Move(targetChannel, "chn_1", "usr_mod", "usr_2", targetChannel, "chn_4"); !errors.Is(err, errVoiceServerFull) {
+		t.Fatalf("expected a new destination to count against the server, got %v", err)
+	}
+
+	// A refused move leaves the participant where they were.
+	session, err := store.Get(targetChannel, "chn_1", "usr_mod")
+	if err != nil {
+		t.Fatalf("get chn_1: %v", err)
+	}
+	findParticipant(t, session.Participants, "usr_2")
+	if memoryOf(store).targetByUserID["usr_2"] != targetKey(targetChannel, "chn_1") {
+		t.Fatal("expected the user index to be rolled back")
+	}
+}
+
+func filterTopic(events []publishedEvent, topic, eventType string) []publishedEvent {
+	var matched []publishedEvent
+	for _, event := range events {
+		if event.Topic == topic && event.EventType == eventType {
+			matched = append(matched, event)
+		}
+	}
+	return matched
+}
+
+func TestVoiceStoreDeafenImpliesMute(t *testing.T) {
+	store := newTestVoiceStore(noopPublisher{})
+	session, err := store.Join(targetChannel, "chn_1", "usr_1", nil, true, joinVoiceRequest{Deafened: boolPtr(true)})
+	if err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	if state := findParticipant(t, session.Participants, "usr_1"); !state.Muted || !state.Deafened {
+		t.Fatalf("expected joining deafened to mute, got %+v", state)
+	}
+
+	session, err = store.UpdateState(targetChannel, "chn_1", "usr_1", updateVoiceStateRequest{Muted: boolPtr(false)})
+	if err != nil {
+		t.Fatalf("unmute while deafened: %v", err)
+	}
+	if state := findParticipant(t, session.Participants, "usr_1"); !state.Muted {
+		t.Fatalf("expected a deafened participant to stay muted, got %+v", state)
+	}
+
+	session, err = store.UpdateState(targetChannel, "chn_1", "usr_1", updateVoiceStateRequest{Deafened: boolPtr(false)})
+	if err != nil {
+		t.Fatalf("undeafen: %v", err)
+	}
+	if state := findParticipant(t, session.Participants, "usr_1"); state.Muted || state.Deafened {
+		t.Fatalf("expected undeafen to restore the requested unmute, got %+v", state)
+	}
+
+	// Someone who was muted before deafening is still muted afterwards.
+	if _, err := store.UpdateState(targetChannel, "chn_1", "usr_1", updateVoiceStateRequest{Muted: boolPtr(true)}); err != nil {
+		t.Fatalf("mute: %v", err)
+	}
+	if _, err := store.UpdateState(targetChannel, "chn_1", "usr_1", updateVoiceStateRequest{Deafened: boolPtr(true)}); err != nil {
+		t.Fatalf("deafen: %v", err)
+	}
+	session, err = store.UpdateState(targetChannel, "chn_1", "usr_1", updateVoiceStateRequest{Deafened: boolPtr(false)})
+	if err != nil {
+		t.Fatalf("undeafen: %v", err)
+	}
+	if state := findParticipant(t, session.Participants, "usr_1"); !state.Muted || state.Deafened {
+		t.Fatalf("expected undeafen to keep the prior mute, got %+v", state)
+	}
+}
+
+func TestVoiceStoreMoveKeepsMuteUnderDeafen(t *testing.T) {
+	store := newTestVoiceStore(noopPublisher{})
+	for _, userID := range []string{"usr_mod", "usr_1"} {
+		if _, err := store.Join(targetChannel, "chn_1", userID, nil, true, joinVoiceRequest{}); err != nil {
+			t.Fatalf("join %s: %v", userID, err)
+		}
+	}
+	if _, err := store.UpdateState(targetChannel, "chn_1", "usr_1", updateVoiceStateRequest{Muted: boolPtr(true)}); err != nil {
+		t.Fatalf("mute: %v", err)
+	}
+	if _, err := store.UpdateState(targetChannel, "chn_1", "usr_1", updateVoiceStateRequest{Deafened: boolPtr(true)}); err != nil {
+		t.Fatalf("deafen: %v", err)
+	}
+	if _, err := store.Move(targetChannel, "chn_1", "usr_mod", "usr_1", targetChannel, "chn_2"); err != nil {
+		t.Fatalf("move: %v", err)
+	}
+
+	session, err := store.UpdateState(targetChannel, "chn_2", "usr_1", updateVoiceStateRequest{Deafened: boolPtr(false)})
+	if err != nil {
+		t.Fatalf("undeafen: %v", err)
+	}
+	if state := findParticipant(t, session.Participants, "usr_1"); !state.Muted || state.Deafened {
+		t.Fatalf("expected the mute from before the move to survive undeafening, got %+v", state)
+	}
+}
+
+func TestVoiceStoreDeafenIndependentOfMuteWhenDisabled(t *testing.T) {
+	cfg := testVoiceStoreConfig(noopPublisher{})
+	cfg.DeafenImpliesMute = false
+	store := newVoiceStore(cfg)
+
+	session, err := store.Join(targetChannel, "chn_1", "usr_1", nil, true, joinVoiceRequest{Deafened: boolPtr(true)})
+	if err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	if state := findParticipant(t, session.Participants, "usr_1"); state.Muted || !state.Deafened {
+		t.Fatalf("expected deafen alone when the policy is off, got %+v", state)
+	}
+
+	session, err = store.Heartbeat(targetChannel, "chn_1", "usr_1", heartbeatRequest{})
+	if err != nil {
+		t.Fatalf("heartbeat: %v", err)
+	}
+	if state := findParticipant(t, session.Participants, "usr_1"); state.Muted {
+		t.Fatalf("expected heartbeats not to mute when the policy is off, got %+v", state)
+	}
+}
+
+func TestVoiceStoreSpectatorGrants(t *testing.T) {
+	store := newTestVoiceStore(noopPublisher{})
+
+	session, err := store.Join(targetChannel, "chn_1", "usr_1", nil, true, joinVoiceRequest{Mode: joinModeSpectator})
+	if err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	if len(session.Participants) != 1 || session.Participants[0].JoinMode != joinModeSpectator || session.Participants[0].CanPublish {
+		t.Fatalf("expected a spectator who cannot publish, got %+v", session.Participants)
+	}
+	claims := parseParticipantToken(t, session.Signaling.ParticipantToken, jwt.SigningMethodHS256, []byte("secret"))
+	if claims.Video.CanPublish || !claims.Video.CanPublishData || !claims.Video.CanSubscribe {
+		t.Fatalf("expected data-only publish grants, got %+v", claims.Video)
+	}
+	if _, err := store.React(targetChannel, "chn_1", "usr_1", "👍"); err != nil {
+		t.Fatalf("expected spectators to react: %v", err)
+	}
+
+	// Rejoining as a speaker switches mode and re-signs the token.
+	session, err = store.Join(targetChannel, "chn_1", "usr_1", nil, true, joinVoiceRequest{})
+	if err != nil || session.Participants[0].JoinMode != joinModeSpeaker || !session.Participants[0].CanPublish {
+		t.Fatalf("expected usr_1 to become a speaker, got %+v (%v)", session.Participants, err)
+	}
+	claims = parseParticipantToken(t, session.Signaling.ParticipantToken, jwt.SigningMethodHS256, []byte("secret"))
+	if !claims.Video.CanPublish || !claims.Video.CanPublishData {
+		t.Fatalf("expected full publish grants, got %+v", claims.Video)
+	}
+
+	s := &server{store: store}
+	for mode, want := range map[string]int{"Spectator": http.StatusOK, "stage": http.StatusBadRequest} {
+		req := httptest.NewRequest(http.MethodPost, "/v1/voice/channels/chn_1/join", strings.NewReader(`{}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Voice-User-Id", "usr_2")
+		req.Header.Set("X-Voice-Join-Mode", mode)
+		rec := httptest.NewRecorder()
+		s.handleVoiceChannels(rec, req)
+		if rec.Code != want {
+			t.Fatalf("X-Voice-Join-Mode %q: expected %d, got %d: %s", mode, want, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestVoiceStoreListenersStayOffAir(t *testing.T) {
+	store := newTestVoiceStore(noopPublisher{})
+	if _, err := store.Join(targetChannel, "chn_1", "usr_spectator", nil, true, joinVoiceRequest{Mode: joinModeSpectator}); err != nil {
+		t.Fatalf("join spectator: %v", err)
+	}
+	if _, err := store.Join(targetChannel, "chn_1", "usr_listener", nil, false, joinVoiceRequest{}); err != nil {
+		t.Fatalf("join listener: %v", err)
+	}
+
+	paths := map[string]func(userID string) (voiceSession, error){
+		"screen share": func(userID string) (voiceSession, error) {
+			return store.UpdateScreenShare(targetChannel, "chn_1", userID, true, boolPtr(true))
+		},
+		"camera": func(userID string) (voiceSession, error) {
+			return store.UpdateCamera(targetChannel, "chn_1", userID, true)
+		},
+		"state": func(userID string) (voiceSession, error) {
+			return store.UpdateState(targetChannel, "chn_1", userID, updateVoiceStateRequest{Speaking: boolPtr(true)})
+		},
+		"state batch": func(userID string) (voiceSession, error) {
+			return store.UpdateStateBatch(targetChannel, "chn_1", userID, batchVoiceStateRequest{
+				updateVoiceStateRequest: updateVoiceStateRequest{Speaking: boolPtr(true)},
+				ScreenSharing:           boolPtr(true),
+				ShareAudio:              boolPtr(true),
+				CameraOn:                boolPtr(true),
+			})
+		},
+		"heartbeat": func(userID string) (voiceSession, error) {
+			return store.Heartbeat(targetChannel, "chn_1", userID, heartbeatRequest{Speaking: boolPtr(true)})
+		},
+	}
+	for name, update := range paths {
+		for _, userID := range []string{"usr_spectator", "usr_listener"} {
+			session, err := update(userID)
+			if err != nil {
+				t.Fatalf("%s for %s: %v", name, userID, err)
+			}
+			state := findParticipant(t, session.Participants, userID)
+			if state.Speaking || state.ScreenSharing || state.ScreenShareAudio || state.CameraOn {
+				t.Fatalf("%s: expected %s to stay off air, got %+v", name, userID, state)
+			}
+		}
+	}
+}
+
+func TestVoiceStoreSpectatorCapacity(t *testing.T) {
+	cfg := testVoiceStoreConfig(noopPublisher{})
+	cfg.MaxSpeakers = 1
+	cfg.MaxSpectators = 2
+	store := newVoiceStore(cfg)
+
+	if _, err := store.Join(targetChannel, "chn_1", "usr_speaker", nil, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("join speaker: %v", err)
+	}
+	for _, userID := range []string{"usr_spec1", "usr_spec2"} {
+		if _, err := store.Join(targetChannel, "chn_1", userID, nil, true, joinVoiceRequest{Mode: joinModeSpectator}); err != nil {
+			t.Fatalf("expected spectators not to count against the speaker cap: %v", err)
+		}
+	}
+
+	_, err := store.Join(targetChannel, "chn_1", "usr_spec3", nil, true, joinVoiceRequest{Mode: joinModeSpectator})
+	if !errors.Is(err, errVoiceSessionFull) || sessionErrorStatus(err) != http.StatusConflict {
+		t.Fatalf("expected the spectator cap to apply, got %v", err)
+	}
+	if _, err := store.Join(targetChannel, "chn_1", "usr_speaker2", nil, true, joinVoiceRequest{}); !errors.Is(err, errVoiceSessionFull) {
+		t.Fatalf("expected the speaker cap to apply, got %v", err)
+	}
+	if _, err := store.Join(targetChannel, "chn_1", "usr_spec1", nil, true, joinVoiceRequest{}); !errors.Is(err, errVoiceSessionFull) {
+		t.Fatalf("expected promoting a spectator to respect the speaker cap, got %v", err)
+	}
+	if _, err := store.Join(targetChannel, "chn_1", "usr_speaker", nil, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("expected existing speakers to rejoin at capacity: %v", err)
+	}
+
+	if _, err := store.Leave(targetChannel, "chn_1", "usr_speaker"); err != nil {
+		t.Fatalf("leave: %v", err)
+	}
+	if _, err := store.Join(targetChannel, "chn_1", "usr_spec1", nil, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("expected a spectator to take the free speaker slot: %v", err)
+	}
+	if _, err := store.Join(targetChannel, "chn_1", "usr_spec3", nil, true, joinVoiceRequest{Mode: joinModeSpectator}); err != nil {
+		t.Fatalf("expected the freed spectator slot to be usable: %v", err)
+	}
+}
+
+func TestVoiceStoreJoinRollsBackOnTokenError(t *testing.T) {
+	pub := &recordingPublisher{}
+	store := newTestVoiceStore(pub)
+	if _, err := store.Join(targetChannel, "chn_1", "usr_1", nil, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	if _, err := store.Join(targetChannel, "chn_1", "usr_2", nil, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	pub.take()
+
+	// Without credentials the move to chn_2 fails once the token is signed,
+	// after usr_1 has already been taken out of chn_1.
+	store.signer = &livekitSigner{method: jwt.SigningMethodHS256, key: []byte{}}
+	if _, err := store.Join(targetChannel, "chn_2", "usr_1", nil, true, joinVoiceRequest{}); err == nil {
+		t.Fatal("expected the join to fail without LiveKit credentials")
+	}
+	if _, err := store.Join(targetChannel, "chn_3", "usr_3", nil, true, joinVoiceRequest{}); err == nil {
+		t.Fatal("expected a join creating a session to fail without LiveKit credentials")
+	}
+
+	memory := memoryOf(store)
+	if _, ok := memory.sessionsByTarget[targetKey(targetChannel, "chn_2")]; ok {
+		t.Fatal("expected the failed join not to create chn_2")
+	}
+	if _, ok := memory.sessionsByTarget[targetKey(targetChannel, "chn_3")]; ok {
+		t.Fatal("expected the failed join not to create chn_3")
+	}
+	if _, ok := memory.targetByUserID["usr_3"]; ok {
+		t.Fatal("expected usr_3 not to be tracked in any session")
+	}
+	record := memory.sessionsByTarget[targetKey(targetChannel, "chn_1")]
+	if record == nil || len(record.Participants) != 2 || record.Participants["usr_1"] == nil {
+		t.Fatalf("expected usr_1 to stay in chn_1, got %+v", record)
+	}
+	if got := memory.targetByUserID["usr_1"]; got != targetKey(targetChannel, "chn_1") {
+		t.Fatalf("expected usr_1 to still map to chn_1, got %q", got)
+	}
+	if events := pub.take(); len(events) != 0 {
+		t.Fatalf("expected no events from failed joins, got %+v", events)
+	}
+
+	store.signer = testSigner()
+	if _, err := store.Join(targetChannel, "chn_2", "usr_1", nil, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("expected the join to succeed once credentials are back: %v", err)
+	}
+	if record := memory.sessionsByTarget[targetKey(targetChannel, "chn_1")]; len(record.Participants) != 1 {
+		t.Fatalf("expected usr_1 to have left chn_1, got %+v", record.Participants)
+	}
+}
+
+func TestMemoryVoiceStateCopiesOnlyFetchedSessions(t *testing.T) {
+	store := newTestVoiceStore(noopPublisher{})
+	for _, targetID := range []string{"chn_1", "chn_2", "chn_3"} {
+		if _, err := store.Join(targetChannel, targetID, "usr_"+targetID, nil, true, joinVoiceRequest{}); err != nil {
+			t.Fatalf("join %s: %v", targetID, err)
+		}
+	}
+
+	memory := memoryOf(store)
+	key := targetKey(targetChannel, "chn_1")
+	errStop := errors.New("stop")
+	err := memory.update(func(st voiceState) error {
+		state := st.(*memoryVoiceState)
+		records, err := st.sessions()
+		if err != nil || len(records) != 3 {
+			t.Fatalf("expected three sessions, got %d (%v)", len(records), err)
+		}
+		if len(state.sessionsBefore) != 0 {
+			t.Fatalf("expected listing not to copy sessions, got %d copies", len(state.sessionsBefore))
+		}
+
+		record, err := st.session(key)
+		if err != nil {
+			return err
+		}
+		if len(state.sessionsBefore) != 1 {
+			t.Fatalf("expected only the fetched session to be copied, got %d copies", len(state.sessionsBefore))
+		}
+		delete(record.Participants, "usr_chn_1")
+		return errStop
+	})
+	if !errors.Is(err, errStop) {
+		t.Fatalf("expected the update to fail, got %v", err)
+	}
+	if record := memory.sessionsByTarget[key]; len(record.Participants) != 1 {
+		t.Fatalf("expected the change to chn_1 to be undone, got %+v", record.Participants)
+	}
+}
+
+func TestVoiceSessionCreatorAndStartReason(t *testing.T) {
+	store := newTestVoiceStore(noopPublisher{})
+	session, err := store.Join(targetChannel, "chn_1", "usr_1", nil, true, joinVoiceRequest{StartReason: "scheduled"})
+	if err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	if session.CreatedBy != "usr_1" || session.StartReason == nil || *session.StartReason != "scheduled" {
+		t.Fatalf("expected usr_1 to start the session as scheduled, got %q/%v", session.CreatedBy, session.StartReason)
+	}
+
+	// Later joins, even with a reason of their own, change neither.
+	session, err = store.Join(targetChannel, "chn_1", "usr_2", nil, true, joinVoiceRequest{StartReason: "manual"})
+	if err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	if session.CreatedBy != "usr_1" || *session.StartReason != "scheduled" {
+		t.Fatalf("expected the creator and reason to be kept, got %q/%v", session.CreatedBy, *session.StartReason)
+	}
+	if _, err := store.Leave(targetChannel, "chn_1", "usr_1"); err != nil {
+		t.Fatalf("leave: %v", err)
+	}
+	// The creator leaving hands the role on; coming back doesn't reclaim it.
+	session, err = store.Join(targetChannel, "chn_1", "usr_1", nil, true, joinVoiceRequest{})
+	if err != nil || session.CreatedBy != "usr_2" || *session.StartReason != "scheduled" {
+		t.Fatalf("expected rejoining not to reset the session, got %+v (%v)", session, err)
+	}
+
+	s := &server{store: store}
+	join := func(targetID, reason string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/voice/channels/"+targetID+"/join", strings.NewReader(`{}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Voice-User-Id", "usr_3")
+		req.Header.Set("X-Voice-Start-Reason", reason)
+		rec := httptest.NewRecorder()
+		s.handleVoiceChannels(rec, req)
+		return rec
+	}
+	if rec := join("chn_2", "by schedule!"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected a malformed reason to be rejected, got %d", rec.Code)
+	}
+	rec := join("chn_2", "manual")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"createdBy":"usr_3","startReason":"manual"`) {
+		t.Fatalf("expected the route to record the reason, got %d: %s", rec.Code, rec.Body.String())
+	}
+	session, err = store.Join(targetChannel, "chn_3", "usr_3", nil, true, joinVoiceRequest{})
+	if err != nil || session.StartReason != nil {
+		t.Fatalf("expected no reason without the header, got %+v (%v)", session.StartReason, err)
+	}
+}
+
+func TestVoiceSpeakingRoute(t *testing.T) {
+	store := newTestVoiceStore(noopPublisher{})
+	s := &server{store: store}
+
+	getSpeaking := func(targetID string) (int, []string) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/v1/voice/channels/"+targetID+"/speaking", nil)
+		req.Header.Set("X-Voice-User-Id", "usr_1")
+		rec := httptest.NewRecorder()
+		s.handleVoiceChannels(rec, req)
+		if rec.Code != http.StatusOK {
+			return rec.Code, nil
+		}
+		var userIDs []string
+		if err := json.Unmarshal(rec.Body.Bytes(), &userIDs); err != nil {
+			t.Fatalf("decode %s: %v", rec.Body.String(), err)
+		}
+		return rec.Code, userIDs
+	}
+
+	if code, _ := getSpeaking("chn_1"); code != http.StatusNotFound {
+		t.Fatalf("expected 404 without a session, got %d", code)
+	}
+
+	for _, userID := range []string{"usr_1", "usr_2", "usr_3"} {
+		if _, err := store.Join(targetChannel, "chn_1", userID, nil, true, joinVoiceRequest{}); err != nil {
+			t.Fatalf("join %s: %v", userID, err)
+		}
+	}
+	if code, userIDs := getSpeaking("chn_1"); code != http.StatusOK || userIDs == nil || len(userIDs) != 0 {
+		t.Fatalf("expected an empty array while nobody speaks, got %d %v", code, userIDs)
+	}
+
+	setSpeaking := func(userID string, speaking bool) {
+		t.Helper()
+		if _, err := store.UpdateState(targetChannel, "chn_1", userID, updateVoiceStateRequest{Speaking: boolPtr(speaking)}); err != nil {
+			t.Fatalf("state %s: %v", userID, err)
+		}
+	}
+	setSpeaking("usr_3", true)
+	setSpeaking("usr_1", true)
+	if _, userIDs := getSpeaking("chn_1"); !slices.Equal(userIDs, []string{"usr_1", "usr_3"}) {
+		t.Fatalf("expected usr_1 and usr_3 sorted, got %v", userIDs)
+	}
+	setSpeaking("usr_3", false)
+	if _, userIDs := getSpeaking("chn_1"); !slices.Equal(userIDs, []string{"usr_1"}) {
+		t.Fatalf("expected only usr_1, got %v", userIDs)
+	}
+}
+
+func TestVoiceMaxSessionsPerServer(t *testing.T) {
+	cfg := testVoiceStoreConfig(noopPublisher{})
+	cfg.MaxServerSessions = 2
+	store := newVoiceStore(cfg)
+	server1, server2 := "srv_1", "srv_2"
+
+	for _, join := range []struct{ channelID, userID string }{{"chn_1", "usr_1"}, {"chn_2", "usr_2"}} {
+		if _, err := store.Join(targetChannel, join.channelID, join.userID, &server1, true, joinVoiceRequest{}); err != nil {
+			t.Fatalf("join %s: %v", join.channelID, err)
+		}
+	}
+
+	_, err := store.Join(targetChannel, "chn_3", "usr_3", &server1, true, joinVoiceRequest{})
+	if !errors.Is(err, errVoiceServerFull) || sessionErrorStatus(err) != http.StatusConflict {
+		t.Fatalf("expected a third session to be refused with 409, got %v", err)
+	}
+	if _, ok := memoryOf(store).sessionsByTarget[targetKey(targetChannel, "chn_3")]; ok {
+		t.Fatal("expected no session to be created")
+	}
+
+	// Existing sessions stay joinable, other servers are unaffected, and a
+	// user moving out of the only seat in a session frees its slot.
+	if _, err := store.Join(targetChannel, "chn_1", "usr_3", &server1, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("expected joining an existing session to work, got %v", err)
+	}
+	if _, err := store.Join(targetChannel, "chn_9", "usr_4", &server2, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("expected another server to be unaffected, got %v", err)
+	}
+	if _, err := store.Join(targetChannel, "chn_3", "usr_2", &server1, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("expected moving from chn_2 to reuse its slot, got %v", err)
+	}
+}
+
+func TestVoiceMaxScreenShares(t *testing.T) {
+	cfg := testVoiceStoreConfig(noopPublisher{})
+	cfg.MaxScreenShares = 2
+	store := newVoiceStore(cfg)
+
+	for _, userID := range []string{"usr_1", "usr_2", "usr_3"} {
+		if _, err := store.Join(targetChannel, "chn_1", userID, nil, true, joinVoiceRequest{}); err != nil {
+			t.Fatalf("join %s: %v", userID, err)
+		}
+	}
+	for _, userID := range []string{"usr_1", "usr_2"} {
+		if _, err := store.UpdateScreenShare(targetChannel, "chn_1", userID, true, nil); err != nil {
+			t.Fatalf("share %s: %v", userID, err)
+		}
+	}
+
+	_, err := store.UpdateScreenShare(targetChannel, "chn_1", "usr_3", true, nil)
+	if !errors.Is(err, errVoiceScreenShareFull) || sessionErrorStatus(err) != http.StatusConflict {
+		t.Fatalf("expected a third share to be refused with 409, got %v", err)
+	}
+	_, err = store.UpdateStateBatch(targetChannel, "chn_1", "usr_3", batchVoiceStateRequest{
+		updateVoiceStateRequest: updateVoiceStateRequest{Muted: boolPtr(true)},
+		ScreenSharing:           boolPtr(true),
+	})
+	if !errors.Is(err, errVoiceScreenShareFull) {
+		t.Fatalf("expected a batch to hit the same cap, got %v", err)
+	}
+	record := memoryOf(store).sessionsByTarget[targetKey(targetChannel, "chn_1")]
+	if participant := record.Participants["usr_3"]; participant.ScreenSharing || participant.Muted {
+		t.Fatalf("expected the refused batch to apply nothing, got %+v", participant)
+	}
+
+	// Stopping frees a slot for someone else.
+	if _, err := store.UpdateScreenShare(targetChannel, "chn_1", "usr_2", false, nil); err != nil {
+		t.Fatalf("stop sharing: %v", err)
+	}
+	if _, err := store.UpdateScreenShare(targetChannel, "chn_1", "usr_3", true, nil); err != nil {
+		t.Fatalf("expected a freed slot to be usable, got %v", err)
+	}
+}
+
+func TestVoiceMaxScreenSharesAllowsCurrentSharers(t *testing.T) {
+	cfg := testVoiceStoreConfig(noopPublisher{})
+	cfg.MaxScreenShares = 1
+	store := newVoiceStore(cfg)
+
+	for _, userID := range []string{"usr_1", "usr_2"} {
+		if _, err := store.Join(targetChannel, "chn_1", userID, nil, true, joinVoiceRequest{}); err != nil {
+			t.Fatalf("join %s: %v", userID, err)
+		}
+	}
+	if _, err := store.UpdateScreenShare(targetChannel, "chn_1", "usr_1", true, nil); err != nil {
+		t.Fatalf("share: %v", err)
+	}
+
+	// The sharer at the cap can repeat the request and toggle audio.
+	session, err := store.UpdateScreenShare(targetChannel, "chn_1", "usr_1", true, boolPtr(true))
+	if err != nil {
+		t.Fatalf("expected the current sharer not to be blocked, got %v", err)
+	}
+	if sharer := findParticipant(t, session.Participants, "usr_1"); !sharer.ScreenSharing || !sharer.ScreenShareAudio {
+		t.Fatalf("expected the share to continue with audio, got %+v", sharer)
+	}
+	if _, err := store.UpdateStateBatch(targetChannel, "chn_1", "usr_1", batchVoiceStateRequest{ShareAudio: boolPtr(false)}); err != nil {
+		t.Fatalf("expected a batch from the current sharer to work, got %v", err)
+	}
+
+	// Turning a share off never counts against the cap.
+	if _, err := store.UpdateScreenShare(targetChannel, "chn_1", "usr_2", false, nil); err != nil {
+		t.Fatalf("expected stopping a share to work at the cap, got %v", err)
+	}
+}
+
+func TestVoiceSessionReportsCountAndCapacity(t *testing.T) {
+	store := newTestVoiceStore(noopPublisher{})
+	session, err := store.Join(targetChannel, "chn_1", "usr_1", nil, true, joinVoiceRequest{})
+	if err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	if session.ParticipantCount != 1 || session.MaxParticipants != nil {
+		t.Fatalf("expected 1 participant and no cap, got %d/%v", session.ParticipantCount, session.MaxParticipants)
+	}
+
+	store.maxSpeakers = 50
+	session, err = store.Join(targetChannel, "chn_1", "usr_2", nil, true, joinVoiceRequest{})
+	if err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	record := memoryOf(store).sessionsByTarget[targetKey(targetChannel, "chn_1")]
+	if session.ParticipantCount != len(record.Participants) || session.ParticipantCount != 2 {
+		t.Fatalf("expected the count to match the participants, got %d for %d", session.ParticipantCount, len(record.Participants))
+	}
+	if session.MaxParticipants == nil || *session.MaxParticipants != 50 {
+		t.Fatalf("expected the configured cap, got %v", session.MaxParticipants)
+	}
+}
+
+func TestVoiceStoreEndSession(t *testing.T) {
+	pub := &recordingPublisher{}
+	cfg := testVoiceStoreConfig(pub)
+	recorder := &fakeRecorder{running: map[string]string{}}
+	cfg.Recorder = recorder
+	store := newVoiceStore(cfg)
+	s := &server{store: store}
+	for _, userID := range []string{"usr_mod", "usr_1", "usr_2"} {
+		if _, err := store.Join(targetChannel, "chn_1", userID, nil, true, joinVoiceRequest{}); err != nil {
+			t.Fatalf("join %s: %v", userID, err)
+		}
+	}
+	if _, err := store.Join(targetChannel, "chn_2", "usr_3", nil, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	if _, err := store.StartRecording(targetChannel, "chn_1", "usr_mod"); err != nil {
+		t.Fatalf("start recording: %v", err)
+	}
+	pub.take()
+
+	if rec := postVoiceAction(t, s, "chn_1/end", "usr_1", ""); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected non-moderators to be refused, got %d", rec.Code)
+	}
+	if rec := postModeratorAction(t, s, "chn_1/end", "usr_mod", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	memory := memoryOf(store)
+	if _, ok := memory.sessionsByTarget[targetKey(targetChannel, "chn_1")]; ok {
+		t.Fatal("expected the session to be deleted")
+	}
+	for _, userID := range []string{"usr_mod", "usr_1", "usr_2"} {
+		if _, ok := memory.targetByUserID[userID]; ok {
+			t.Fatalf("expected %s to be untracked", userID)
+		}
+	}
+	if memory.targetByUserID["usr_3"] != targetKey(targetChannel, "chn_2") {
+		t.Fatal("expected other sessions to be left alone")
+	}
+	if len(recorder.running) != 0 {
+		t.Fatalf("expected the recording to stop with the session, got %v", recorder.running)
+	}
+
+	events := pub.take()
+	if len(events) != 2 {
+		t.Fatalf("expected an update and an ended event, got %+v", events)
+	}
+	if update := events[0].Payload.(voiceSessionEvent); events[0].EventType != "voice.participants.updated" || len(update.Participants) != 0 {
+		t.Fatalf("expected an empty participants update first, got %+v", events[0])
+	}
+	ended, ok := events[1].Payload.(voiceEndEvent)
+	if events[1].EventType != "voice.session.ended" || events[1].Topic != "voice:channel:chn_1" || !ok {
+		t.Fatalf("expected voice.session.ended on the session topic, got %+v", events[1])
+	}
+	if ended.EndedBy != "usr_mod" || !slices.Equal(ended.Participants, []string{"usr_1", "usr_2", "usr_mod"}) {
+		t.Fatalf("unexpected ended payload %+v", ended)
+	}
+
+	// Ending again is a no-op.
+	if rec := postModeratorAction(t, s, "chn_1/end", "usr_mod", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected ending a missing session to return 204, got %d", rec.Code)
+	}
+	if events := pub.take(); len(events) != 0 {
+		t.Fatalf("expected no events for a missing session, got %+v", events)
+	}
+	if rec := postModeratorAction(t, s, "chn_1/end", "usr_mod", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected repeated ends to stay 204, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRequestTimeout(t *testing.T) {
+	canceled := make(chan struct{}, 1)
+	stream := func(r *http.Request) bool { return r.URL.Path == "/stream" }
+	handler := withRequestTimeout(20*time.Millisecond, stream, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/fast" {
+			select {
+			case <-r.Context().Done():
+				canceled <- struct{}{}
+				return
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	serve := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	rec := serve("/slow")
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Content-Type") != "application/json" || rec.Body.String() != `{"code":"REQUEST_TIMEOUT","error":"Request timed out."}`+"\n" {
+		t.Fatalf("expected a JSON 503 once the deadline passed, got %d %q %s", rec.Code, rec.Header().Get("Content-Type"), rec.Body.String())
+	}
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("expected the handler's context to be canceled")
+	}
+
+	if rec := serve("/fast"); rec.Code != http.StatusNoContent || rec.Header().Get("Content-Type") != "text/plain" {
+		t.Fatalf("expected a handler finishing in time to answer itself, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if rec := serve("/stream"); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected an exempt path to run past the deadline, got %d", rec.Code)
+	}
+}
+
+func TestRequestTimeoutDisabled(t *testing.T) {
+	handler := withRequestTimeout(0, nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Deadline(); ok {
+			t.Error("expected no deadline with the timeout disabled")
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected the handler's answer, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+// userVoiceState is whether a user is in a call and where, for profile
+// cards. A direct thread's id is only shown to others in the same call, so
+// the card can't reveal who a user is calling privately.
+type userVoiceState struct {
+	InCall     bool             `json:"inCall"`
+	TargetKind *voiceTargetKind `json:"targetKind"`
+	TargetID   *string          `json:"targetId"`
+}
+
+// UserVoiceState reports the session userID is connected to, as seen by
+// viewerID. It knows nothing of presence visibility or channel access, so
+// realtime-gateway hides the state of users the viewer sees as offline and
+// drops channel ids the viewer can't read before passing it on.
+func (s *voiceStore) UserVoiceState(userID, viewerID string) (userVoiceState, error) {
+	var state userVoiceState
+	err := s.backend.view(func(st voiceState) error {
+		key, err := st.userTarget(userID)
+		if err != nil || key == "" {
+			return err
+		}
+
+		record, err := st.session(key)
+		if err != nil || record == nil {
+			return err
+		}
+		if _, ok := record.Participants[userID]; !ok {
+			return nil
+		}
+
+		kind := record.TargetKind
+		state.InCall = true
+		state.TargetKind = &kind
+		if _, sameCall := record.Participants[viewerID]; kind == targetChannel || sameCall {
+			targetID := record.TargetID
+			state.TargetID = &targetID
+		}
+		return nil
+	})
+
+	return state, err
+}
+
+func (s *server) handleVoiceUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		s.respondOptions(w)
+		return
+	}
+
+	route, err := parseTargetPath(r.URL.Path, "/v1/voice/users/")
+	if errors.Is(err, errInvalidTargetID) {
+		s.respondError(w, http.StatusBadRequest, "Invalid user id.")
+		return
+	}
+	if err != nil || route.Action != "" || r.Method != http.MethodGet {
+		s.respondError(w, http.StatusNotFound, "Route not found.")
+		return
+	}
+
+	viewerID, ok := s.requestUserID(w, r)
+	if !ok {
+		return
+	}
+
+	state, err := s.store.UserVoiceState(route.TargetID, viewerID)
+	if err != nil {
+		s.respondSessionError(w, err)
+		return
+	}
+
+	s.respondJSON(w, http.StatusOK, state)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func getUserVoiceState(t *testing.T, s *server, userID, viewerID string) userVoiceState {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/voice/users/"+userID, nil)
+	req.Header.Set("X-Voice-User-Id", viewerID)
+	rec := httptest.NewRecorder()
+	s.handleVoiceUsers(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var state userVoiceState
+	if err := json.Unmarshal(rec.Body.Bytes(), &state); err != nil {
+		t.Fatalf("decode %s: %v", rec.Body.String(), err)
+	}
+	return state
+}
+
+func TestUserVoiceState(t *testing.T) {
+	store := newTestVoiceStore(noopPublisher{})
+	s := &server{store: store}
+
+	if state := getUserVoiceState(t, s, "usr_1", "usr_viewer"); state.InCall || state.TargetKind != nil || state.TargetID != nil {
+		t.Fatalf("expected no call, got %+v", state)
+	}
+
+	if _, err := store.Join(targetChannel, "chn_1", "usr_1", nil, true, joinVoiceRequest{}); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	state := getUserVoiceState(t, s, "usr_1", "usr_viewer")
+	if !state.InCall || state.TargetKind == nil || *state.TargetKind != targetChannel || state.TargetID == nil || *state.TargetID != "chn_1" {
+		t.Fatalf("expected the channel call, got %+v", state)
+	}
+
+	// A direct call shows as a call, but only its members see which one.
+	for _, userID := range []string{"usr_1", "usr_2"} {
+		if _, err := store.Join(targetDirectThread, "dm_1", userID, nil, true, joinVoiceRequest{}); err != nil {
+			t.Fatalf("join %s: %v", userID, err)
+		}
+	}
+	if state := getUserVoiceState(t, s, "usr_1", "usr_viewer"); !state.InCall || *state.TargetKind != targetDirectThread || state.TargetID != nil {
+		t.Fatalf("expected the direct thread to stay hidden, got %+v", state)
+	}
+	if state := getUserVoiceState(t, s, "usr_1", "usr_2"); state.TargetID == nil || *state.TargetID != "dm_1" {
+		t.Fatalf("expected the other member to see the thread, got %+v", state)
+	}
+}
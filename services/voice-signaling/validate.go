@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// allowInsecureDefaultsEnv lets a local setup start with settings no
+// deployment should keep, such as the LiveKit dev credentials.
+const allowInsecureDefaultsEnv = "ALLOW_INSECURE_DEFAULTS"
+
+// configCheck collects everything wrong with the environment, so a failed
+// start names every problem instead of the first. Values are read raw:
+// main's fallbacks and clamps would otherwise hide a typo.
+type configCheck struct {
+	getenv   func(string) string
+	invalid  []string
+	insecure []string
+}
+
+func (c *configCheck) value(key string) string {
+	return strings.TrimSpace(c.getenv(key))
+}
+
+func (c *configCheck) fail(key, format string, args ...any) {
+	c.invalid = append(c.invalid, key+": "+fmt.Sprintf(format, args...))
+}
+
+// int checks that key, when set, is an integer no lower than least.
+func (c *configCheck) int(key string, least int) (int, bool) {
+	raw := c.value(key)
+	if raw == "" {
+		return 0, false
+	}
+
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		c.fail(key, "%q is not an integer", raw)
+		return 0, false
+	}
+	if value < least {
+		c.fail(key, "%d is below the minimum of %d", value, least)
+		return 0, false
+	}
+	return value, true
+}
+
+func (c *configCheck) ints(least int, keys ...string) {
+	for _, key := range keys {
+		c.int(key, least)
+	}
+}
+
+func (c *configCheck) port(key string) {
+	if raw := c.value(key); raw != "" {
+		if port, err := strconv.Atoi(raw); err != nil || port < 1 || port > 65535 {
+			c.fail(key, "%q is not a port number", raw)
+		}
+	}
+}
+
+func (c *configCheck) bool(key string) {
+	if raw := c.value(key); raw != "" && !strings.EqualFold(raw, "true") && !strings.EqualFold(raw, "false") {
+		c.fail(key, "%q is neither true nor false", raw)
+	}
+}
+
+// url checks that key, when set, is an absolute URL with one of schemes.
+func (c *configCheck) url(key string, schemes ...string) {
+	if raw := c.value(key); raw != "" {
+		c.urlValue(key, raw, schemes...)
+	}
+}
+
+func (c *configCheck) urlValue(key, raw string, schemes ...string) {
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.Host == "" || !slices.Contains(schemes, strings.ToLower(parsed.Scheme)) {
+		c.fail(key, "%q is not a %s URL", raw, strings.Join(schemes, "/"))
+	}
+}
+
+func (c *configCheck) insecureDefault(key, reason string) {
+	c.insecure = append(c.insecure, key+": "+reason)
+}
+
+// err reports the invalid settings, and the insecure ones unless the
+// environment allows them, in which case they are only logged.
+func (c *configCheck) err() error {
+	problems := c.invalid
+	if strings.EqualFold(c.value(allowInsecureDefaultsEnv), "true") {
+		if len(c.insecure) > 0 {
+			slog.Warn("starting with insecure defaults", "problems", c.insecure)
+		}
+	} else {
+		problems = append(problems, c.insecure...)
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d problem(s): %s", len(problems), strings.Join(problems, "; "))
+}
+
+// validateConfig checks the environment main reads before anything starts.
+// Insecure defaults only pass with ALLOW_INSECURE_DEFAULTS=true.
+func validateConfig(getenv func(string) string) error {
+	c := &configCheck{getenv: getenv}
+
+	c.port("VOICE_SIGNALING_PORT")
+	c.url("LIVEKIT_WS_URL", "ws", "wss", "http", "https")
+	c.url("REALTIME_GATEWAY_URL", "http", "https")
+	c.url("REDIS_URL", "redis", "rediss", "unix")
+	if regionURLs, err := parseRegionURLs(c.value("LIVEKIT_REGION_URLS")); err != nil {
+		c.fail("LIVEKIT_REGION_URLS", "%v", err)
+	} else {
+		for _, wsURL := range regionURLs {
+			c.urlValue("LIVEKIT_REGION_URLS", wsURL, "ws", "wss", "http", "https")
+		}
+	}
+
+	c.ints(1, "VOICE_SIGNALING_TOKEN_TTL_SECONDS", "VOICE_SIGNALING_SPEAKING_TIMEOUT_MS", "VOICE_SIGNALING_BAN_DURATION_SECONDS")
+	c.ints(0,
+		"LIVEKIT_TOKEN_NBF_SKEW_SECONDS",
+		"VOICE_SIGNALING_RECONNECT_GRACE_MS",
+		"VOICE_SIGNALING_IDLE_TIMEOUT_MS",
+		"VOICE_SIGNALING_SHUTDOWN_GRACE_MS",
+		"VOICE_SIGNALING_MAX_SPEAKERS",
+		"VOICE_SIGNALING_MAX_SPECTATORS",
+		"VOICE_SIGNALING_MAX_SESSIONS_PER_SERVER",
+		"VOICE_SIGNALING_MAX_SCREEN_SHARES",
+		"VOICE_SIGNALING_CHURN_LIMIT_BURST",
+		"VOICE_SIGNALING_CHURN_LIMIT_WINDOW_SECONDS",
+		"VOICE_SIGNALING_IDEMPOTENCY_WINDOW_SECONDS",
+		"VOICE_SIGNALING_JOIN_HOLD_SECONDS",
+		"VOICE_SIGNALING_MAX_BODY_BYTES",
+		"VOICE_SIGNALING_MAX_IN_FLIGHT",
+		"VOICE_SIGNALING_REQUEST_TIMEOUT_MS",
+		"VOICE_SIGNALING_PUBLISH_QUEUE_SIZE",
+	)
+	minGrace, minSet := c.int("VOICE_SIGNALING_RECONNECT_GRACE_MIN_MS", 0)
+	maxGrace, maxSet := c.int("VOICE_SIGNALING_RECONNECT_GRACE_MAX_MS", 0)
+	if minSet && maxSet && maxGrace < minGrace {
+		c.fail("VOICE_SIGNALING_RECONNECT_GRACE_MAX_MS", "%d is below VOICE_SIGNALING_RECONNECT_GRACE_MIN_MS (%d)", maxGrace, minGrace)
+	}
+
+	c.bool("VOICE_SIGNALING_ENABLE_SCREEN_SHARE")
+	c.bool("VOICE_SIGNALING_ENABLE_VIDEO")
+	c.bool("VOICE_SIGNALING_DEAFEN_IMPLIES_MUTE")
+
+	privateKeyPEM := c.value("LIVEKIT_API_KEY_PRIVATE_PEM")
+	if _, err := newLivekitSigner(c.value("LIVEKIT_API_KEY"), c.value("LIVEKIT_API_SECRET"), privateKeyPEM); err != nil {
+		c.fail("LIVEKIT_API_KEY_PRIVATE_PEM", "%v", err)
+	}
+	if key := c.value("LIVEKIT_API_KEY"); key == "" || key == "devkey" {
+		c.insecureDefault("LIVEKIT_API_KEY", "unset or the dev key")
+	}
+	if secret := c.value("LIVEKIT_API_SECRET"); privateKeyPEM == "" && (secret == "" || secret == "secret") {
+		c.insecureDefault("LIVEKIT_API_SECRET", "unset or the dev secret")
+	}
+
+	return c.err()
+}
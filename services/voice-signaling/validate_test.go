@@ -0,0 +1,81 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// envMap stands in for os.Getenv.
+type envMap map[string]string
+
+func (env envMap) get(key string) string {
+	return env[key]
+}
+
+func TestValidateConfigPasses(t *testing.T) {
+	env := envMap{
+		"VOICE_SIGNALING_PORT":                   "4003",
+		"LIVEKIT_WS_URL":                         "wss://livekit.example.com",
+		"LIVEKIT_REGION_URLS":                    `{"eu":"wss://eu.livekit.example.com"}`,
+		"LIVEKIT_API_KEY":                        "APIprod",
+		"LIVEKIT_API_SECRET":                     "prod-s3cret",
+		"REDIS_URL":                              "rediss://redis.example.com:6380/0",
+		"VOICE_SIGNALING_TOKEN_TTL_SECONDS":      "600",
+		"VOICE_SIGNALING_RECONNECT_GRACE_MIN_MS": "5000",
+		"VOICE_SIGNALING_RECONNECT_GRACE_MAX_MS": "60000",
+		"VOICE_SIGNALING_ENABLE_VIDEO":           "TRUE",
+	}
+	if err := validateConfig(env.get); err != nil {
+		t.Fatalf("expected a valid config, got %v", err)
+	}
+}
+
+func TestValidateConfigInsecureDefaults(t *testing.T) {
+	// Nothing set means the LiveKit dev credentials.
+	err := validateConfig(envMap{}.get)
+	if err == nil || !strings.Contains(err.Error(), "LIVEKIT_API_KEY") || !strings.Contains(err.Error(), "LIVEKIT_API_SECRET") {
+		t.Fatalf("expected the dev credentials to be refused, got %v", err)
+	}
+
+	if err := validateConfig(envMap{allowInsecureDefaultsEnv: "true"}.get); err != nil {
+		t.Fatalf("expected ALLOW_INSECURE_DEFAULTS to let them through, got %v", err)
+	}
+}
+
+func TestValidateConfigNamesEachProblem(t *testing.T) {
+	env := envMap{
+		allowInsecureDefaultsEnv:                 "true",
+		"VOICE_SIGNALING_PORT":                   "http",
+		"LIVEKIT_WS_URL":                         "livekit.example.com",
+		"LIVEKIT_REGION_URLS":                    `{"eu":"eu.livekit.example.com"}`,
+		"VOICE_SIGNALING_TOKEN_TTL_SECONDS":      "-60",
+		"VOICE_SIGNALING_MAX_SPEAKERS":           "ten",
+		"VOICE_SIGNALING_RECONNECT_GRACE_MIN_MS": "10000",
+		"VOICE_SIGNALING_RECONNECT_GRACE_MAX_MS": "5000",
+		"VOICE_SIGNALING_ENABLE_SCREEN_SHARE":    "yes",
+		"LIVEKIT_API_KEY_PRIVATE_PEM":            "not a key",
+	}
+
+	// The escape hatch only covers insecure defaults, not broken values.
+	err := validateConfig(env.get)
+	if err == nil {
+		t.Fatal("expected the config to be refused")
+	}
+	for _, want := range []string{
+		`VOICE_SIGNALING_PORT: "http" is not a port number`,
+		`LIVEKIT_WS_URL: "livekit.example.com" is not a ws/wss/http/https URL`,
+		`LIVEKIT_REGION_URLS: "eu.livekit.example.com"`,
+		"VOICE_SIGNALING_TOKEN_TTL_SECONDS: -60 is below the minimum of 1",
+		`VOICE_SIGNALING_MAX_SPEAKERS: "ten" is not an integer`,
+		"VOICE_SIGNALING_RECONNECT_GRACE_MAX_MS: 5000 is below VOICE_SIGNALING_RECONNECT_GRACE_MIN_MS (10000)",
+		`VOICE_SIGNALING_ENABLE_SCREEN_SHARE: "yes" is neither true nor false`,
+		"LIVEKIT_API_KEY_PRIVATE_PEM: invalid LiveKit RSA private key",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q in %v", want, err)
+		}
+	}
+	if !strings.HasPrefix(err.Error(), "8 problem(s): ") {
+		t.Fatalf("expected eight problems, got %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+var errVoiceWhisperTarget = errors.New("whisper targets must be other participants in this session")
+
+type whisperRequest struct {
+	TargetUserIDs []string `json:"targetUserIds"`
+}
+
+// voiceWhisperGrant is a second LiveKit connection for whispering. The
+// token has its own identity, so joining with it leaves the caller's main
+// connection in place, and its metadata names the only users who should
+// subscribe to it.
+type voiceWhisperGrant struct {
+	TargetUserIDs []string           `json:"targetUserIds"`
+	Signaling     voiceSignalingInfo `json:"signaling"`
+}
+
+// Whisper issues userID a token scoped to speaking to targetUserIDs. Every
+// target must be another participant currently in the session; the session
+// itself is not changed.
+func (s *voiceStore) Whisper(kind voiceTargetKind, targetID, userID string, targetUserIDs []string) (voiceWhisperGrant, error) {
+	key := targetKey(kind, targetID)
+
+	var grant voiceWhisperGrant
+	err := s.backend.view(func(st voiceState) error {
+		record, participant, err := connectedParticipant(st, key, userID)
+		if err != nil {
+			return err
+		}
+		if participant.MutedByModerator {
+			return errVoiceModeratorMuted
+		}
+
+		targets := make([]string, 0, len(targetUserIDs))
+		seen := make(map[string]bool, len(targetUserIDs))
+		for _, targetUserID := range targetUserIDs {
+			targetUserID = strings.TrimSpace(targetUserID)
+			if seen[targetUserID] {
+				continue
+			}
+			if _, ok := record.Participants[targetUserID]; !ok || targetUserID == userID {
+				return errVoiceWhisperTarget
+			}
+			seen[targetUserID] = true
+			targets = append(targets, targetUserID)
+		}
+
+		token, _, err := s.participantToken(userID, participant.IdentitySuffix+"_whisper", kind, targetID, participant.CanPublish, participant.CanPublish, livekitParticipantMetadata{WhisperTo: targets, Profile: participantProfile(participant.Metadata)}, roomMetadata(record))
+		if err != nil {
+			return err
+		}
+
+		grant = voiceWhisperGrant{
+			TargetUserIDs: targets,
+			Signaling: voiceSignalingInfo{
+				URL:              s.sessionSignalingURL(record),
+				RoomName:         roomName(kind, targetID),
+				ParticipantToken: token,
+			},
+		}
+		return nil
+	})
+
+	return grant, err
+}
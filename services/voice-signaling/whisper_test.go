@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestVoiceWhisperScopesTokenToTargets(t *testing.T) {
+	pub := &recordingPublisher{}
+	store := newTestVoiceStore(pub)
+	var mainToken string
+	for _, userID := range []string{"usr_1", "usr_2", "usr_3"} {
+		session, err := store.Join(targetChannel, "chn_1", userID, nil, true, joinVoiceRequest{})
+		if err != nil {
+			t.Fatalf("join %s: %v", userID, err)
+		}
+		if userID == "usr_1" {
+			mainToken = session.Signaling.ParticipantToken
+		}
+	}
+	pub.take()
+
+	grant, err := store.Whisper(targetChannel, "chn_1", "usr_1", []string{"usr_2", " usr_2"})
+	if err != nil {
+		t.Fatalf("whisper: %v", err)
+	}
+	if !slices.Equal(grant.TargetUserIDs, []string{"usr_2"}) || grant.Signaling.RoomName != roomName(targetChannel, "chn_1") {
+		t.Fatalf("unexpected grant %+v", grant)
+	}
+
+	claims := parseParticipantToken(t, grant.Signaling.ParticipantToken, jwt.SigningMethodHS256, []byte("secret"))
+	mainClaims := parseParticipantToken(t, mainToken, jwt.SigningMethodHS256, []byte("secret"))
+	if claims.Subject == mainClaims.Subject || claims.Name != "usr_1" {
+		t.Fatalf("expected a separate identity for usr_1, got %q (main %q)", claims.Subject, mainClaims.Subject)
+	}
+	if claims.Metadata != `{"prioritySpeaker":false,"whisperTo":["usr_2"]}` {
+		t.Fatalf("expected the whisper targets in the metadata, got %q", claims.Metadata)
+	}
+
+	if events := pub.take(); len(events) != 0 {
+		t.Fatalf("expected whispering to leave the session alone, got %+v", events)
+	}
+	session, err := store.Get(targetChannel, "chn_1", "usr_1")
+	if err != nil || session.Signaling.ParticipantToken != mainToken {
+		t.Fatalf("expected the main token to be unchanged, got %v", err)
+	}
+}
+
+func TestVoiceWhisperRejectsUnknownTargets(t *testing.T) {
+	store := newTestVoiceStore(noopPublisher{})
+	s := &server{store: store}
+	for _, userID := range []string{"usr_1", "usr_2"} {
+		if _, err := store.Join(targetChannel, "chn_1", userID, nil, true, joinVoiceRequest{}); err != nil {
+			t.Fatalf("join %s: %v", userID, err)
+		}
+	}
+
+	for _, targets := range [][]string{{"usr_9"}, {"usr_2", "usr_9"}, {"usr_1"}} {
+		_, err := store.Whisper(targetChannel, "chn_1", "usr_1", targets)
+		if !errors.Is(err, errVoiceWhisperTarget) || sessionErrorStatus(err) != http.StatusBadRequest {
+			t.Fatalf("%v: expected errVoiceWhisperTarget, got %v", targets, err)
+		}
+	}
+	if _, err := store.Whisper(targetChannel, "chn_1", "usr_9", []string{"usr_2"}); !errors.Is(err, errVoiceNotConnected) {
+		t.Fatalf("expected non-participants to be refused, got %v", err)
+	}
+
+	if rec := postVoiceAction(t, s, "chn_1/whisper", "usr_1", `{"targetUserIds":[]}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected an empty target list to be rejected, got %d", rec.Code)
+	}
+	if rec := postVoiceAction(t, s, "chn_1/whisper", "usr_1", `{"targetUserIds":["usr_9"]}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown targets, got %d", rec.Code)
+	}
+	if rec := postVoiceAction(t, s, "chn_1/whisper", "usr_1", `{"targetUserIds":["usr_2"]}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+}